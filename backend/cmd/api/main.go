@@ -16,9 +16,13 @@ import (
 	"github.com/joho/godotenv"
 
 	"github.com/gabrielrondon/cowpro/internal/auth"
+	"github.com/gabrielrondon/cowpro/internal/calendar"
 	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/grazing"
+	"github.com/gabrielrondon/cowpro/internal/health"
 	"github.com/gabrielrondon/cowpro/internal/iot"
 	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/internal/zone"
 )
 
 func main() {
@@ -40,6 +44,13 @@ func main() {
 	hub := iot.NewHub()
 	go hub.Run()
 
+	// Pharmacy stock and expiry alerts
+	go health.RunStockMonitor(context.Background(), pool, 6*time.Hour)
+	// Grazing overstay, early re-entry and overstock alerts
+	go grazing.RunMonitor(context.Background(), pool, hub, time.Hour)
+	// Animals without a water-point visit
+	go zone.RunWaterMonitor(context.Background(), pool, hub, 30*time.Minute)
+
 	r := chi.NewRouter()
 
 	r.Use(chimiddleware.RequestID)
@@ -67,15 +78,23 @@ func main() {
 	r.Route("/api/v1", func(r chi.Router) {
 		// Public routes
 		r.Mount("/auth", auth.NewHandler(pool, jwtSecret).Routes())
+		// iCalendar feeds authenticate with the secret token in the URL
+		r.Get("/ical/{token}.ics", calendar.NewHandler(pool).Feed)
 
 		// Protected routes
 		r.Group(func(r chi.Router) {
 			r.Use(authMiddleware.Authenticate)
 			r.Mount("/farms", farmRoutes(pool))
 			r.Mount("/zones", zoneRoutes(pool))
+			r.Mount("/grazing", grazingRoutes(pool))
 			r.Mount("/animals", animalRoutes(pool))
 			r.Mount("/herds", herdRoutes(pool))
 			r.Mount("/health-events", healthRoutes(pool))
+			r.Mount("/health-protocols", protocolRoutes(pool))
+			r.Mount("/health-products", productRoutes(pool))
+			r.Mount("/disease-cases", caseRoutes(pool))
+			r.Mount("/tasks", taskRoutes(pool))
+			r.Mount("/calendar", calendarRoutes(pool))
 			r.Mount("/devices", deviceRoutes(pool, hub))
 			r.Mount("/marketplace", marketplaceRoutes(pool))
 			r.Mount("/subscription", subscriptionRoutes(pool))
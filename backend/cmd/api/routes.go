@@ -7,11 +7,14 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/gabrielrondon/cowpro/internal/animal"
+	"github.com/gabrielrondon/cowpro/internal/calendar"
 	"github.com/gabrielrondon/cowpro/internal/farm"
+	"github.com/gabrielrondon/cowpro/internal/grazing"
 	"github.com/gabrielrondon/cowpro/internal/health"
 	"github.com/gabrielrondon/cowpro/internal/iot"
 	"github.com/gabrielrondon/cowpro/internal/marketplace"
 	"github.com/gabrielrondon/cowpro/internal/subscription"
+	"github.com/gabrielrondon/cowpro/internal/task"
 	"github.com/gabrielrondon/cowpro/internal/zone"
 )
 
@@ -31,20 +34,43 @@ func zoneRoutes(pool *pgxpool.Pool) http.Handler {
 	r := chi.NewRouter()
 	r.Get("/", h.List)
 	r.Post("/", h.Create)
+	r.Get("/geojson", h.GeoJSON)
+	r.Post("/import", h.Import)
+	r.Get("/export", h.Export)
+	r.Get("/usage-stats", h.UsageStats)
+	r.Get("/forage", h.ForageOverview)
+	r.Post("/merge", h.Merge)
 	r.Get("/{id}", h.Get)
 	r.Put("/{id}", h.Update)
 	r.Delete("/{id}", h.Delete)
 	r.Post("/{id}/assign-animals", h.AssignAnimals)
 	r.Post("/{id}/move-animals", h.MoveAnimals)
+	r.Get("/{id}/usage", h.Usage)
+	r.Get("/{id}/forage", h.ListForage)
+	r.Post("/{id}/forage", h.CreateForage)
+	r.Delete("/{id}/forage/{assessmentId}", h.DeleteForage)
+	r.Post("/{id}/split", h.Split)
+	r.Get("/{id}/lineage", h.Lineage)
 	// Groups
 	r.Get("/groups", h.ListGroups)
 	r.Post("/groups", h.CreateGroup)
+	r.Post("/groups/assign", h.AssignGroup)
+	r.Put("/groups/{id}", h.UpdateGroup)
+	r.Delete("/groups/{id}", h.DeleteGroup)
+	r.Get("/groups/{id}/stats", h.GroupStats)
 	// Key points
 	r.Get("/keypoints", h.ListKeyPoints)
 	r.Post("/keypoints", h.CreateKeyPoint)
+	r.Get("/keypoints/water-visits", h.WaterVisits)
+	r.Get("/keypoints/water-settings", h.GetWaterSettings)
+	r.Put("/keypoints/water-settings", h.SaveWaterSettings)
+	r.Put("/keypoints/{id}", h.UpdateKeyPoint)
+	r.Delete("/keypoints/{id}", h.DeleteKeyPoint)
 	// Perimeters
 	r.Get("/perimeters", h.ListPerimeters)
 	r.Post("/perimeters", h.CreatePerimeter)
+	r.Put("/perimeters/{id}", h.UpdatePerimeter)
+	r.Delete("/perimeters/{id}", h.DeletePerimeter)
 	return r
 }
 
@@ -61,7 +87,9 @@ func animalRoutes(pool *pgxpool.Pool) http.Handler {
 	r.Post("/{id}/reproductive-event", h.AddReproductiveEvent)
 	r.Post("/{id}/weight-record", h.AddWeightRecord)
 	r.Post("/bulk-move", h.BulkMove)
-	r.Get("/agenda", animal.NewAgendaHandler(pool).GetAgenda)
+	agenda := animal.NewAgendaHandler(pool)
+	r.Get("/agenda", agenda.GetAgenda)
+	r.Get("/agenda/items", agenda.GetAgendaItems)
 	return r
 }
 
@@ -73,6 +101,9 @@ func herdRoutes(pool *pgxpool.Pool) http.Handler {
 	r.Get("/{id}", h.Get)
 	r.Put("/{id}", h.Update)
 	r.Delete("/{id}", h.Delete)
+	r.Post("/{id}/move", h.Move)
+	r.Get("/{id}/history", h.History)
+	r.Get("/{id}/metrics", h.Metrics)
 	return r
 }
 
@@ -81,8 +112,90 @@ func healthRoutes(pool *pgxpool.Pool) http.Handler {
 	r := chi.NewRouter()
 	r.Get("/", h.List)
 	r.Post("/", h.Create)
+	r.Get("/withdrawals", h.Withdrawals)
 	r.Get("/{id}", h.Get)
+	r.Put("/{id}", h.Update)
+	r.Delete("/{id}", h.Delete)
+	return r
+}
+
+func productRoutes(pool *pgxpool.Pool) http.Handler {
+	h := health.NewProductHandler(pool)
+	r := chi.NewRouter()
+	r.Get("/", h.List)
+	r.Post("/", h.Create)
+	r.Get("/stock", h.Stock)
+	r.Post("/dose-calculator", h.DoseCalculator)
+	r.Get("/{id}", h.Get)
+	r.Put("/{id}", h.Update)
 	r.Delete("/{id}", h.Delete)
+	// Inventory
+	r.Get("/{id}/lots", h.Lots)
+	r.Get("/{id}/movements", h.Movements)
+	r.Post("/{id}/purchases", h.Purchase)
+	r.Post("/{id}/adjustments", h.Adjust)
+	return r
+}
+
+func protocolRoutes(pool *pgxpool.Pool) http.Handler {
+	h := health.NewProtocolHandler(pool)
+	r := chi.NewRouter()
+	r.Get("/", h.List)
+	r.Post("/", h.Create)
+	r.Get("/due", h.Due)
+	r.Get("/{id}", h.Get)
+	r.Put("/{id}", h.Update)
+	r.Delete("/{id}", h.Delete)
+	r.Post("/{id}/execute", h.Execute)
+	return r
+}
+
+func caseRoutes(pool *pgxpool.Pool) http.Handler {
+	h := health.NewCaseHandler(pool)
+	r := chi.NewRouter()
+	r.Get("/", h.List)
+	r.Post("/", h.Create)
+	r.Get("/stats", h.Stats)
+	r.Get("/{id}", h.Get)
+	r.Put("/{id}", h.Update)
+	r.Delete("/{id}", h.Delete)
+	r.Post("/{id}/animals", h.AddAnimals)
+	r.Post("/{id}/outcomes", h.RecordOutcome)
+	r.Post("/{id}/release", h.Release)
+	return r
+}
+
+func grazingRoutes(pool *pgxpool.Pool) http.Handler {
+	h := grazing.NewHandler(pool)
+	r := chi.NewRouter()
+	r.Get("/parameters", h.ListParameters)
+	r.Put("/parameters", h.SaveParameters)
+	r.Delete("/parameters/{grassType}", h.DeleteParameters)
+	r.Get("/plan", h.Plan)
+	r.Get("/schedule", h.Schedule)
+	return r
+}
+
+func taskRoutes(pool *pgxpool.Pool) http.Handler {
+	h := task.NewHandler(pool)
+	r := chi.NewRouter()
+	r.Get("/", h.List)
+	r.Post("/", h.Create)
+	r.Get("/mine", h.Mine)
+	r.Get("/{id}", h.Get)
+	r.Put("/{id}", h.Update)
+	r.Delete("/{id}", h.Delete)
+	r.Post("/{id}/status", h.SetStatus)
+	r.Post("/{id}/complete", h.Complete)
+	return r
+}
+
+func calendarRoutes(pool *pgxpool.Pool) http.Handler {
+	h := calendar.NewHandler(pool)
+	r := chi.NewRouter()
+	r.Get("/feed", h.GetFeed)
+	r.Post("/feed", h.CreateFeed)
+	r.Delete("/feed", h.DeleteFeed)
 	return r
 }
 
@@ -129,6 +242,8 @@ func statsRoutes(pool *pgxpool.Pool) http.Handler {
 	r.Get("/breeding", h.Breeding)
 	r.Get("/fattening", h.Fattening)
 	r.Get("/profitability", h.Profitability)
+	r.Get("/health-costs", h.HealthCosts)
+	r.Get("/stocking", h.Stocking)
 	r.Get("/alerts", h.ListAlerts)
 	r.Post("/alerts/{id}/read", h.MarkAlertRead)
 	return r
@@ -7,9 +7,27 @@ until psql "$DATABASE_URL" -c '\q' 2>/dev/null; do
 done
 echo "Database ready."
 
+# Every migration runs on each start, so each must be idempotent.
 echo "Running migrations..."
 psql "$DATABASE_URL" -f ./migrations/001_initial.sql 2>&1 || true
 psql "$DATABASE_URL" -f ./migrations/002_missing_tables.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/003_herd_history.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/004_tasks.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/005_calendar_feeds.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/006_health_protocols.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/007_health_event_animals.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/008_withdrawals.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/009_inventory.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/010_dose_calculator.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/011_disease_cases.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/012_zone_geometry.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/013_zone_usage.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/014_grazing.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/015_animal_units.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/016_overstock.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/017_forage.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/018_zone_lineage.sql 2>&1 || true
+psql "$DATABASE_URL" -f ./migrations/019_key_points.sql 2>&1 || true
 echo "Migrations done."
 
 exec ./api
@@ -1,11 +1,16 @@
 package animal
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/gabrielrondon/cowpro/internal/db"
 	"github.com/gabrielrondon/cowpro/internal/middleware"
 	"github.com/gabrielrondon/cowpro/pkg/response"
 )
@@ -14,19 +19,52 @@ type AgendaHandler struct{ pool *pgxpool.Pool }
 
 func NewAgendaHandler(pool *pgxpool.Pool) *AgendaHandler { return &AgendaHandler{pool: pool} }
 
+const (
+	// gestationDays is the average bovine gestation length used to derive
+	// the expected calving date from a pregnancy diagnosis.
+	gestationDays = 283
+	// calvingGraceDays is how long after the expected date a calving is
+	// still considered on time before it is reported as delayed.
+	calvingGraceDays = 15
+	// weaningAgeDays is the age at which calves are expected to be weaned.
+	weaningAgeDays = 210
+
+	defaultAgendaMonths = 6
+	maxAgendaMonths     = 24
+)
+
+// agendaTypes lists agenda event types in display order with their labels.
+var agendaTypes = []struct {
+	eventType string
+	label     string
+}{
+	{"imminent_birth", "possíveis partos"},
+	{"delayed_birth", "partos retrasados"},
+	{"weaning", "desmames previstos"},
+	{"vaccination", "vacinações previstas"},
+	{"protocol_due", "doses de protocolo previstas"},
+	{"empty_cow", "vacas vazias"},
+	{"low_activity", "atividade baixa"},
+	{"high_activity", "atividade alta"},
+}
+
 type AgendaEvent struct {
-	Type    string `json:"type"`
-	Count   int    `json:"count"`
-	Label   string `json:"label"`
-	Week    int    `json:"week"`
-	Month   int    `json:"month"`
-	Year    int    `json:"year"`
+	Type     string `json:"type"`
+	Count    int    `json:"count"`
+	Label    string `json:"label"`
+	Week     int    `json:"week"`
+	Month    int    `json:"month"`
+	Year     int    `json:"year"`
+	ItemsURL string `json:"items_url"` // paginated list of the animals behind Count
 }
 
 type AgendaWeek struct {
-	Week   int           `json:"week"`
-	Label  string        `json:"label"` // e.g. "Dias 1-7"
-	Events []AgendaEvent `json:"events"`
+	Week      int           `json:"week"`
+	Label     string        `json:"label"` // e.g. "Dias 1–7"
+	StartDate string        `json:"start_date"`
+	EndDate   string        `json:"end_date"`
+	IsCurrent bool          `json:"is_current"`
+	Events    []AgendaEvent `json:"events"`
 }
 
 type AgendaMonth struct {
@@ -36,117 +74,214 @@ type AgendaMonth struct {
 	Weeks []AgendaWeek `json:"weeks"`
 }
 
+// agendaItemsSQL yields one row per agenda item with its type, the animal it
+// concerns, the source record, the expected date and the day it is shown on.
+//
+//	$1 farm_id, $2 today (farm timezone), $3 farm timezone,
+//	$4 gestation days, $5 calving grace days, $6 weaning age days
+const agendaItemsSQL = `
+	WITH last_repro AS (
+		SELECT DISTINCT ON (re.animal_id) re.id, re.animal_id, re.event_type, re.event_date
+		FROM reproductive_events re
+		JOIN animals a ON a.id = re.animal_id AND a.status = 'active'
+		WHERE re.farm_id = $1 AND re.event_type IN ('pregnancy','birth','abortion')
+		ORDER BY re.animal_id, re.event_date DESC, re.created_at DESC
+	),
+	items AS (
+		SELECT CASE WHEN lr.event_date + $4::int + $5::int >= $2::date
+		            THEN 'imminent_birth' ELSE 'delayed_birth' END AS type,
+		       lr.animal_id, lr.id AS ref_id,
+		       lr.event_date + $4::int AS expected_date
+		FROM last_repro lr
+		WHERE lr.event_type = 'pregnancy'
+
+		UNION ALL
+		SELECT 'weaning', lr.animal_id, lr.id, lr.event_date + $6::int
+		FROM last_repro lr
+		WHERE lr.event_type = 'birth'
+		  AND NOT EXISTS (
+		      SELECT 1 FROM reproductive_events w
+		      WHERE w.animal_id = lr.animal_id AND w.event_type = 'weaning'
+		        AND w.event_date >= lr.event_date
+		  )
+
+		UNION ALL
+		SELECT 'vaccination', hea.animal_id, he.id, he.started_at
+		FROM health_events he
+		JOIN health_event_animals hea ON hea.event_id = he.id
+		JOIN animals va ON va.id = hea.animal_id AND va.status = 'active'
+		WHERE he.farm_id = $1 AND he.event_type = 'vaccine' AND he.started_at >= $2::date
+
+		UNION ALL
+		SELECT 'protocol_due', pd.animal_id, pd.protocol_id, pd.due_date
+		FROM protocol_due pd
+		WHERE pd.farm_id = $1
+
+		UNION ALL
+		SELECT 'empty_cow', a.id, a.id, $2::date
+		FROM animals a
+		WHERE a.farm_id = $1 AND a.sex = 'female' AND a.status = 'active'
+		  AND NOT EXISTS (
+		      SELECT 1 FROM reproductive_events re
+		      WHERE re.animal_id = a.id
+		        AND re.event_type IN ('pregnancy','birth')
+		        AND re.event_date >= $2::date - INTERVAL '1 year'
+		  )
+
+		UNION ALL
+		SELECT al.type, al.animal_id, al.id, (al.created_at AT TIME ZONE $3)::date
+		FROM alerts al
+		WHERE al.farm_id = $1 AND al.type IN ('low_activity','high_activity')
+	)
+	SELECT type, animal_id, ref_id, expected_date,
+	       CASE WHEN type = 'delayed_birth' THEN $2::date
+	            WHEN type = 'protocol_due' THEN GREATEST(expected_date, $2::date)
+	            ELSE expected_date END AS agenda_date
+	FROM items`
+
+// FarmLocation returns the farm's configured timezone, falling back to the
+// default used when farms are created.
+func FarmLocation(ctx context.Context, q db.DBTX, farmID uuid.UUID) *time.Location {
+	var tz string
+	_ = q.QueryRow(ctx, `SELECT timezone FROM farms WHERE id=$1`, farmID).Scan(&tz)
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "" {
+		loc, err = time.LoadLocation("America/Sao_Paulo")
+		if err != nil {
+			loc = time.UTC
+		}
+	}
+	return loc
+}
+
+// farmToday returns the current date in the farm's timezone as a UTC
+// midnight, matching how DATE columns are scanned.
+func farmToday(loc *time.Location) time.Time {
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
 func (h *AgendaHandler) GetAgenda(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
-	now := time.Now()
 
-	months := []AgendaMonth{}
+	horizon := defaultAgendaMonths
+	if m, err := strconv.Atoi(r.URL.Query().Get("months")); err == nil && m > 0 {
+		horizon = min(m, maxAgendaMonths)
+	}
 
-	for i := 0; i < 6; i++ {
-		target := now.AddDate(0, i, 0)
-		m := int(target.Month())
-		yr := target.Year()
+	loc := FarmLocation(r.Context(), h.pool, farmID)
+	today := farmToday(loc)
+	from := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, horizon, -1)
 
-		// Count various events for this month
-		type eventQuery struct {
-			eventType string
-			label     string
-			query     string
+	months := make([]AgendaMonth, horizon)
+	for i := range months {
+		target := from.AddDate(0, i, 0)
+		months[i] = AgendaMonth{
+			Month: int(target.Month()),
+			Year:  target.Year(),
+			Label: target.Format("January 2006"),
+			Weeks: calendarWeeks(target, today),
 		}
+	}
 
-		queries := []eventQuery{
-			{
-				"imminent_birth", "possíveis partos",
-				`SELECT COUNT(*) FROM reproductive_events re
-				 JOIN animals a ON a.id = re.animal_id
-				 WHERE re.farm_id = $1 AND re.event_type = 'pregnancy'
-				   AND re.event_date + INTERVAL '280 days'
-				       BETWEEN date_trunc('month', make_date($2,$3,1))
-				           AND date_trunc('month', make_date($2,$3,1)) + INTERVAL '1 month'`,
-			},
-			{
-				"delayed_birth", "partos retrasados",
-				`SELECT COUNT(*) FROM reproductive_events re
-				 WHERE re.farm_id = $1 AND re.event_type = 'pregnancy'
-				   AND re.event_date + INTERVAL '295 days' < make_date($2,$3,1)
-				   AND NOT EXISTS (
-				       SELECT 1 FROM reproductive_events re2
-				       WHERE re2.animal_id = re.animal_id
-				         AND re2.event_type IN ('birth','abortion')
-				         AND re2.event_date > re.event_date
-				   )`,
-			},
-			{
-				"empty_cow", "vacas vazias",
-				`SELECT COUNT(*) FROM animals a
-				 WHERE a.farm_id = $1 AND a.sex='female' AND a.status='active'
-				   AND NOT EXISTS (
-				       SELECT 1 FROM reproductive_events re
-				       WHERE re.animal_id = a.id
-				         AND re.event_type IN ('pregnancy','birth')
-				         AND re.event_date >= make_date($2,$3,1) - INTERVAL '1 year'
-				   )`,
-			},
-			{
-				"low_activity", "atividade baixa",
-				`SELECT COUNT(*) FROM alerts
-				 WHERE farm_id=$1 AND type='low_activity'
-				   AND EXTRACT(month FROM created_at)=$3
-				   AND EXTRACT(year  FROM created_at)=$2`,
-			},
-			{
-				"high_activity", "atividade alta",
-				`SELECT COUNT(*) FROM alerts
-				 WHERE farm_id=$1 AND type='high_activity'
-				   AND EXTRACT(month FROM created_at)=$3
-				   AND EXTRACT(year  FROM created_at)=$2`,
-			},
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT type, agenda_date, COUNT(*)::int
+		FROM (`+agendaItemsSQL+`) agenda
+		WHERE agenda_date BETWEEN $7::date AND $8::date
+		GROUP BY type, agenda_date`,
+		farmID, today.Format("2006-01-02"), loc.String(),
+		gestationDays, calvingGraceDays, weaningAgeDays,
+		from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+
+	// counts[month index][week index][type]
+	counts := make([][]map[string]int, horizon)
+	for i := range months {
+		counts[i] = make([]map[string]int, len(months[i].Weeks))
+		for j := range counts[i] {
+			counts[i][j] = map[string]int{}
 		}
+	}
+	for rows.Next() {
+		var eventType string
+		var day time.Time
+		var count int
+		if err := rows.Scan(&eventType, &day, &count); err != nil {
+			response.InternalError(w)
+			return
+		}
+		mi := (day.Year()-from.Year())*12 + int(day.Month()) - int(from.Month())
+		if mi < 0 || mi >= horizon {
+			continue
+		}
+		counts[mi][weekOfMonth(day)-1][eventType] += count
+	}
+	if err := rows.Err(); err != nil {
+		response.InternalError(w)
+		return
+	}
 
-		var events []AgendaEvent
-		for _, q := range queries {
-			var count int
-			_ = h.pool.QueryRow(r.Context(), q.query, farmID, yr, m).Scan(&count)
-			if count > 0 {
-				events = append(events, AgendaEvent{
-					Type:  q.eventType,
-					Count: count,
-					Label: q.label,
-					Month: m,
-					Year:  yr,
-				})
+	for i := range months {
+		for j := range months[i].Weeks {
+			wk := &months[i].Weeks[j]
+			for _, t := range agendaTypes {
+				if c := counts[i][j][t.eventType]; c > 0 {
+					wk.Events = append(wk.Events, AgendaEvent{
+						Type:  t.eventType,
+						Count: c,
+						Label: t.label,
+						Week:  wk.Week,
+						Month: months[i].Month,
+						Year:  months[i].Year,
+						ItemsURL: fmt.Sprintf("/animals/agenda/items?type=%s&from=%s&to=%s",
+							t.eventType, wk.StartDate, wk.EndDate),
+					})
+				}
 			}
 		}
-
-		monthLabel := target.Format("January 2006")
-		months = append(months, AgendaMonth{
-			Month: m,
-			Year:  yr,
-			Label: monthLabel,
-			Weeks: groupByWeek(events, m, yr),
-		})
 	}
 
 	response.Ok(w, months)
 }
 
-func groupByWeek(events []AgendaEvent, month, year int) []AgendaWeek {
-	// Simple 4-week grouping
-	weeks := []AgendaWeek{
-		{Week: 1, Label: "Dias 1–7"},
-		{Week: 2, Label: "Dias 8–14"},
-		{Week: 3, Label: "Dias 15–22"},
-		{Week: 4, Label: "Dias 23–31"},
-	}
-	// Put all events in week 3 (current week approx) as placeholder
+// mondayOffset is the number of days between the Monday that starts the
+// first calendar week of the month and the 1st of the month.
+func mondayOffset(first time.Time) int {
+	return (int(first.Weekday()) + 6) % 7
+}
+
+// weekOfMonth returns the 1-based Monday–Sunday calendar week of a day
+// within its month.
+func weekOfMonth(day time.Time) int {
+	first := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return (day.Day()-1+mondayOffset(first))/7 + 1
+}
+
+// calendarWeeks splits a month into Monday–Sunday weeks clipped to the month.
+func calendarWeeks(first, today time.Time) []AgendaWeek {
+	last := first.AddDate(0, 1, -1)
+	offset := mondayOffset(first)
+	n := weekOfMonth(last)
+
+	weeks := make([]AgendaWeek, n)
 	for i := range weeks {
-		if weeks[i].Week == 3 {
-			weeks[i].Events = events
-		} else {
-			weeks[i].Events = []AgendaEvent{}
+		startDay := max(1, i*7-offset+1)
+		endDay := min(last.Day(), (i+1)*7-offset)
+		start := first.AddDate(0, 0, startDay-1)
+		end := first.AddDate(0, 0, endDay-1)
+		weeks[i] = AgendaWeek{
+			Week:      i + 1,
+			Label:     fmt.Sprintf("Dias %d–%d", startDay, endDay),
+			StartDate: start.Format("2006-01-02"),
+			EndDate:   end.Format("2006-01-02"),
+			IsCurrent: !today.Before(start) && !today.After(end),
+			Events:    []AgendaEvent{},
 		}
 	}
 	return weeks
 }
-
-var _ = time.Now
@@ -0,0 +1,243 @@
+package animal
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// AgendaAnimalRef is a short reference to an animal linked to an agenda item.
+type AgendaAnimalRef struct {
+	ID     uuid.UUID `json:"id"`
+	EarTag string    `json:"ear_tag"`
+	Name   *string   `json:"name,omitempty"`
+}
+
+// AgendaDam describes the cow behind a reproductive agenda item.
+type AgendaDam struct {
+	AgendaAnimalRef
+	Breed       *string `json:"breed,omitempty"`
+	AgeMonths   *int    `json:"age_months,omitempty"`
+	Calvings    int     `json:"calvings"`
+	LastCalving *string `json:"last_calving,omitempty"`
+}
+
+type AgendaItem struct {
+	Type          string           `json:"type"`
+	RefID         uuid.UUID        `json:"ref_id"`
+	AnimalID      *uuid.UUID       `json:"animal_id,omitempty"`
+	EarTag        *string          `json:"ear_tag,omitempty"`
+	Name          *string          `json:"name,omitempty"`
+	ExpectedDate  string           `json:"expected_date"`
+	AgendaDate    string           `json:"agenda_date"`
+	DaysRemaining int              `json:"days_remaining"` // negative when overdue
+	ZoneID        *uuid.UUID       `json:"zone_id,omitempty"`
+	ZoneName      *string          `json:"zone_name,omitempty"`
+	HerdID        *uuid.UUID       `json:"herd_id,omitempty"`
+	HerdName      *string          `json:"herd_name,omitempty"`
+	Dam           *AgendaDam       `json:"dam,omitempty"`
+	Offspring     *AgendaAnimalRef `json:"offspring,omitempty"`
+}
+
+// reproductiveAgendaTypes are the item types whose animal is a cow.
+var reproductiveAgendaTypes = map[string]bool{
+	"imminent_birth": true,
+	"delayed_birth":  true,
+	"weaning":        true,
+	"empty_cow":      true,
+}
+
+// GetAgendaItems lists the individual animals behind agenda counts, e.g. the
+// cows expected to calve in a given week. Filters: type, from, to (dates in
+// the farm's timezone, defaulting to the next 30 days).
+func (h *AgendaHandler) GetAgendaItems(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	q := r.URL.Query()
+
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	offset := (page - 1) * limit
+
+	loc := FarmLocation(r.Context(), h.pool, farmID)
+	today := farmToday(loc)
+	from, to := today, today.AddDate(0, 0, 30)
+	if v := q.Get("from"); v != "" {
+		d, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			response.BadRequest(w, "from must be YYYY-MM-DD")
+			return
+		}
+		from = d
+	}
+	if v := q.Get("to"); v != "" {
+		d, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			response.BadRequest(w, "to must be YYYY-MM-DD")
+			return
+		}
+		to = d
+	}
+	if to.Before(from) {
+		response.BadRequest(w, "to must not be before from")
+		return
+	}
+
+	args := []any{
+		farmID, today.Format("2006-01-02"), loc.String(),
+		gestationDays, calvingGraceDays, weaningAgeDays,
+		from.Format("2006-01-02"), to.Format("2006-01-02"), q.Get("type"),
+	}
+	where := `ag.agenda_date BETWEEN $7::date AND $8::date AND ($9 = '' OR ag.type = $9)`
+
+	var total int64
+	if err := h.pool.QueryRow(r.Context(), `
+		SELECT COUNT(*) FROM (`+agendaItemsSQL+`) ag WHERE `+where, args...,
+	).Scan(&total); err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	args = append(args, limit, offset)
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT ag.type, ag.ref_id, ag.animal_id, a.ear_tag, a.name,
+		       to_char(ag.expected_date, 'YYYY-MM-DD'),
+		       to_char(ag.agenda_date, 'YYYY-MM-DD'),
+		       (ag.expected_date - $2::date)::int,
+		       a.zone_id, z.name, a.herd_id, hr.name,
+		       a.sex, a.breed,
+		       CASE WHEN a.birth_date IS NOT NULL
+		            THEN (EXTRACT(year FROM age($2::date, a.birth_date)) * 12
+		                + EXTRACT(month FROM age($2::date, a.birth_date)))::int END,
+		       COALESCE(c.calvings, 0)::int, to_char(c.last_calving, 'YYYY-MM-DD'),
+		       off.id, off.ear_tag, off.name
+		FROM (`+agendaItemsSQL+`) ag
+		LEFT JOIN animals a  ON a.id  = ag.animal_id
+		LEFT JOIN zones   z  ON z.id  = a.zone_id
+		LEFT JOIN herds   hr ON hr.id = a.herd_id
+		LEFT JOIN LATERAL (
+			SELECT COUNT(*) AS calvings, MAX(event_date) AS last_calving
+			FROM reproductive_events
+			WHERE animal_id = a.id AND event_type = 'birth'
+		) c ON a.sex = 'female'
+		LEFT JOIN reproductive_events rb ON ag.type = 'weaning' AND rb.id = ag.ref_id
+		LEFT JOIN animals off ON off.id = rb.offspring_id
+		WHERE `+where+`
+		ORDER BY ag.expected_date, a.ear_tag
+		LIMIT $10 OFFSET $11`, args...)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+
+	items := []AgendaItem{}
+	for rows.Next() {
+		var it AgendaItem
+		var sex, breed *string
+		var ageMonths *int
+		var calvings int
+		var lastCalving *string
+		var offID *uuid.UUID
+		var offTag, offName *string
+		if err := rows.Scan(
+			&it.Type, &it.RefID, &it.AnimalID, &it.EarTag, &it.Name,
+			&it.ExpectedDate, &it.AgendaDate, &it.DaysRemaining,
+			&it.ZoneID, &it.ZoneName, &it.HerdID, &it.HerdName,
+			&sex, &breed, &ageMonths, &calvings, &lastCalving,
+			&offID, &offTag, &offName,
+		); err != nil {
+			response.InternalError(w)
+			return
+		}
+		if it.AnimalID != nil && sex != nil && *sex == "female" && reproductiveAgendaTypes[it.Type] {
+			it.Dam = &AgendaDam{
+				AgendaAnimalRef: AgendaAnimalRef{ID: *it.AnimalID, EarTag: *it.EarTag, Name: it.Name},
+				Breed:           breed,
+				AgeMonths:       ageMonths,
+				Calvings:        calvings,
+				LastCalving:     lastCalving,
+			}
+		}
+		if offID != nil && offTag != nil {
+			it.Offspring = &AgendaAnimalRef{ID: *offID, EarTag: *offTag, Name: offName}
+		}
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Paginated(w, items, total, page, limit)
+}
+
+// AgendaEntry groups the animals of one agenda source record (a pregnancy,
+// a birth, a scheduled vaccination, a protocol dose date) for consumers
+// outside the HTTP API such as calendar feeds.
+type AgendaEntry struct {
+	Type         string
+	Label        string
+	RefID        uuid.UUID
+	ExpectedDate time.Time
+	Animals      []string // ear tags
+	Zones        []string
+	// AnimalID and Dose identify a protocol dose, which is one entry per
+	// animal rather than one per protocol and day.
+	AnimalID *uuid.UUID
+	Dose     int
+}
+
+// LoadAgendaEntries returns dated agenda entries (calvings, weanings,
+// vaccinations and protocol doses) with an expected date between from and to.
+func LoadAgendaEntries(ctx context.Context, q db.DBTX, farmID uuid.UUID, from, to time.Time) ([]AgendaEntry, error) {
+	loc := FarmLocation(ctx, q, farmID)
+	rows, err := q.Query(ctx, `
+		SELECT ag.type, ag.ref_id, ag.expected_date,
+		       array_remove(array_agg(DISTINCT a.ear_tag), NULL),
+		       array_remove(array_agg(DISTINCT z.name), NULL),
+		       pd.animal_id, COALESCE(pd.dose_number, 0)
+		FROM (`+agendaItemsSQL+`) ag
+		LEFT JOIN animals a ON a.id = ag.animal_id
+		LEFT JOIN zones   z ON z.id = a.zone_id
+		LEFT JOIN protocol_due pd ON ag.type = 'protocol_due'
+		     AND pd.protocol_id = ag.ref_id AND pd.animal_id = ag.animal_id
+		WHERE ag.type IN ('imminent_birth','delayed_birth','weaning','vaccination','protocol_due')
+		  AND ag.expected_date BETWEEN $7::date AND $8::date
+		GROUP BY ag.type, ag.ref_id, ag.expected_date, pd.animal_id, pd.dose_number
+		ORDER BY ag.expected_date`,
+		farmID, farmToday(loc).Format("2006-01-02"), loc.String(),
+		gestationDays, calvingGraceDays, weaningAgeDays,
+		from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := map[string]string{}
+	for _, t := range agendaTypes {
+		labels[t.eventType] = t.label
+	}
+	entries := []AgendaEntry{}
+	for rows.Next() {
+		var e AgendaEntry
+		if err := rows.Scan(&e.Type, &e.RefID, &e.ExpectedDate, &e.Animals, &e.Zones,
+			&e.AnimalID, &e.Dose); err != nil {
+			return nil, err
+		}
+		e.Label = labels[e.Type]
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
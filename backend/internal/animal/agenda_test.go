@@ -0,0 +1,107 @@
+package animal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func day(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestWeekOfMonth(t *testing.T) {
+	tests := []struct {
+		day  string
+		want int
+	}{
+		{"2026-06-01", 1}, // month starts on a Monday
+		{"2026-06-07", 1},
+		{"2026-06-08", 2},
+		{"2026-06-30", 5},
+		{"2026-03-01", 1}, // month starts on a Sunday: a one-day first week
+		{"2026-03-02", 2},
+		{"2026-03-31", 6},
+		{"2021-02-28", 4}, // February filling exactly four weeks
+	}
+	for _, tt := range tests {
+		if got := weekOfMonth(day(tt.day)); got != tt.want {
+			t.Errorf("weekOfMonth(%s) = %d, want %d", tt.day, got, tt.want)
+		}
+	}
+}
+
+func TestCalendarWeeks(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   string
+		today   string
+		labels  []string
+		current int // week flagged as current, 0 for none
+	}{
+		{
+			name:    "starts on a Sunday",
+			first:   "2026-03-01",
+			today:   "2026-03-10",
+			labels:  []string{"Dias 1–1", "Dias 2–8", "Dias 9–15", "Dias 16–22", "Dias 23–29", "Dias 30–31"},
+			current: 3,
+		},
+		{
+			name:    "starts on a Monday",
+			first:   "2026-06-01",
+			today:   "2026-06-30",
+			labels:  []string{"Dias 1–7", "Dias 8–14", "Dias 15–21", "Dias 22–28", "Dias 29–30"},
+			current: 5,
+		},
+		{
+			name:    "four full weeks",
+			first:   "2021-02-01",
+			today:   "2021-03-01",
+			labels:  []string{"Dias 1–7", "Dias 8–14", "Dias 15–21", "Dias 22–28"},
+			current: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weeks := calendarWeeks(day(tt.first), day(tt.today))
+			var labels []string
+			current := 0
+			next := day(tt.first)
+			for i, w := range weeks {
+				labels = append(labels, w.Label)
+				if w.Week != i+1 {
+					t.Errorf("week %d numbered %d", i+1, w.Week)
+				}
+				if w.IsCurrent {
+					current = w.Week
+				}
+				// Weeks are contiguous and every day falls in its own week.
+				if w.StartDate != next.Format("2006-01-02") {
+					t.Errorf("week %d starts %s, want %s", w.Week, w.StartDate, next.Format("2006-01-02"))
+				}
+				for d := day(w.StartDate); !d.After(day(w.EndDate)); d = d.AddDate(0, 0, 1) {
+					if weekOfMonth(d) != w.Week {
+						t.Errorf("%s falls in week %d, listed in week %d", d.Format("2006-01-02"), weekOfMonth(d), w.Week)
+					}
+				}
+				next = day(w.EndDate).AddDate(0, 0, 1)
+				if w.Events == nil {
+					t.Errorf("week %d events is nil, want empty", w.Week)
+				}
+			}
+			if !reflect.DeepEqual(labels, tt.labels) {
+				t.Errorf("labels = %v, want %v", labels, tt.labels)
+			}
+			if next.Day() != 1 {
+				t.Errorf("weeks end on %s, not the last day of the month", next.AddDate(0, 0, -1).Format("2006-01-02"))
+			}
+			if current != tt.current {
+				t.Errorf("current week = %d, want %d", current, tt.current)
+			}
+		})
+	}
+}
@@ -11,7 +11,9 @@ import (
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/gabrielrondon/cowpro/internal/health"
 	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/internal/zone"
 	"github.com/gabrielrondon/cowpro/pkg/response"
 )
 
@@ -197,9 +199,16 @@ func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 		id, _ := uuid.Parse(*req.ZoneID)
 		zoneID = &id
 	}
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
 	var a Animal
 	var bd *time.Time
-	err := h.pool.QueryRow(r.Context(), `
+	err = tx.QueryRow(r.Context(), `
 		INSERT INTO animals (id, farm_id, herd_id, zone_id, ear_tag, name, sex, breed, birth_date, entry_reason)
 		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9::date,$10)
 		RETURNING id, farm_id, herd_id, zone_id, ear_tag, name, sex, breed, birth_date, entry_reason, status,
@@ -213,6 +222,18 @@ func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 		response.InternalError(w)
 		return
 	}
+	if err := zone.SyncHerdMemberships(r.Context(), tx, farmID, []uuid.UUID{a.ID}); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := zone.SyncUsage(r.Context(), tx, farmID); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
 	if bd != nil {
 		s := bd.Format("2006-01-02")
 		a.BirthDate = &s
@@ -250,7 +271,14 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 		id, _ := uuid.Parse(*req.ZoneID)
 		zoneID = &id
 	}
-	tag, err := h.pool.Exec(r.Context(), `
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	tag, err := tx.Exec(r.Context(), `
 		UPDATE animals SET ear_tag=$1, name=$2, sex=$3, breed=$4,
 		       birth_date=$5::date, entry_reason=$6, herd_id=$7, zone_id=$8, updated_at=NOW()
 		WHERE id=$9 AND farm_id=$10`,
@@ -261,6 +289,18 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 		response.NotFound(w, "animal not found")
 		return
 	}
+	if err := zone.SyncHerdMemberships(r.Context(), tx, farmID, []uuid.UUID{animalID}); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := zone.SyncUsage(r.Context(), tx, farmID); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
 	h.Get(w, r)
 }
 
@@ -271,9 +311,31 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid animal id")
 		return
 	}
-	_, _ = h.pool.Exec(r.Context(),
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	if _, err := tx.Exec(r.Context(),
 		`UPDATE animals SET status='dead', updated_at=NOW() WHERE id=$1 AND farm_id=$2`,
-		animalID, farmID)
+		animalID, farmID); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := zone.SyncHerdMemberships(r.Context(), tx, farmID, []uuid.UUID{animalID}); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := zone.SyncUsage(r.Context(), tx, farmID); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
 	response.NoContent(w)
 }
 
@@ -359,6 +421,11 @@ func (h *Handler) GetGPSTrack(w http.ResponseWriter, r *http.Request) {
 	response.Ok(w, points)
 }
 
+// saleEventTypes are the reproductive event types that send an animal to
+// slaughter and are therefore blocked during meat withdrawal periods,
+// mapped to the status the animal takes once the event is recorded.
+var saleEventTypes = map[string]string{"sale": "sold", "slaughter": "dead"}
+
 func (h *Handler) AddReproductiveEvent(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
 	animalID, err := uuid.Parse(chi.URLParam(r, "id"))
@@ -373,18 +440,54 @@ func (h *Handler) AddReproductiveEvent(w http.ResponseWriter, r *http.Request) {
 		BirthWeight *float64 `json:"birth_weight"`
 		WeanWeight  *float64 `json:"wean_weight"`
 		Notes       *string  `json:"notes"`
+		// OverrideWithdrawal records a sale even though the animal is still
+		// within a medication withdrawal period.
+		OverrideWithdrawal bool `json:"override_withdrawal"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.EventType == "" || req.EventDate == "" {
 		response.BadRequest(w, "event_type and event_date required")
 		return
 	}
+	newStatus, isSale := saleEventTypes[req.EventType]
+	if isSale {
+		if _, err := time.Parse("2006-01-02", req.EventDate); err != nil {
+			response.BadRequest(w, "event_date must be YYYY-MM-DD")
+			return
+		}
+		ws, err := health.LoadWithdrawals(r.Context(), h.pool, farmID, req.EventDate, []uuid.UUID{animalID})
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		if len(ws) > 0 && ws[0].MeatWithdrawn {
+			if !req.OverrideWithdrawal {
+				response.JSON(w, http.StatusConflict, response.Response{
+					Error: "animal is within a withdrawal period until " + ws[0].MeatClearDate,
+					Data:  ws[0],
+				})
+				return
+			}
+			note := "Carência ignorada (liberação em " + ws[0].MeatClearDate + ")"
+			if req.Notes != nil && *req.Notes != "" {
+				note = *req.Notes + "\n" + note
+			}
+			req.Notes = &note
+		}
+	}
 	var partnerID *uuid.UUID
 	if req.PartnerID != nil {
 		id, _ := uuid.Parse(*req.PartnerID)
 		partnerID = &id
 	}
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
 	var id uuid.UUID
-	err = h.pool.QueryRow(r.Context(), `
+	err = tx.QueryRow(r.Context(), `
 		INSERT INTO reproductive_events
 		  (id, animal_id, farm_id, event_type, event_date, partner_id, birth_weight, wean_weight, notes)
 		VALUES ($1,$2,$3,$4,$5::date,$6,$7,$8,$9)
@@ -396,6 +499,28 @@ func (h *Handler) AddReproductiveEvent(w http.ResponseWriter, r *http.Request) {
 		response.InternalError(w)
 		return
 	}
+	// A sold or slaughtered animal leaves the herd, its zone and stocking
+	if isSale {
+		if _, err := tx.Exec(r.Context(),
+			`UPDATE animals SET status=$3, updated_at=NOW()
+			 WHERE id=$1 AND farm_id=$2 AND status='active'`,
+			animalID, farmID, newStatus); err != nil {
+			response.InternalError(w)
+			return
+		}
+		if err := zone.SyncHerdMemberships(r.Context(), tx, farmID, []uuid.UUID{animalID}); err != nil {
+			response.InternalError(w)
+			return
+		}
+		if err := zone.SyncUsage(r.Context(), tx, farmID); err != nil {
+			response.InternalError(w)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
 	response.Created(w, map[string]any{"id": id})
 }
 
@@ -454,6 +579,9 @@ func (h *Handler) BulkMove(w http.ResponseWriter, r *http.Request) {
 		AnimalIDs []uuid.UUID `json:"animal_ids"`
 		HerdID    *string     `json:"herd_id"`
 		ZoneID    *string     `json:"zone_id"`
+		// Force moves the animals even if the zone's stocking limit is
+		// exceeded under the reject overstock policy.
+		Force bool `json:"force"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.AnimalIDs) == 0 {
 		response.BadRequest(w, "animal_ids required")
@@ -468,7 +596,14 @@ func (h *Handler) BulkMove(w http.ResponseWriter, r *http.Request) {
 		id, _ := uuid.Parse(*req.ZoneID)
 		zoneID = &id
 	}
-	tag, err := h.pool.Exec(r.Context(), `
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	tag, err := tx.Exec(r.Context(), `
 		UPDATE animals SET
 		  herd_id = COALESCE($1, herd_id),
 		  zone_id = COALESCE($2, zone_id),
@@ -479,7 +614,28 @@ func (h *Handler) BulkMove(w http.ResponseWriter, r *http.Request) {
 		response.InternalError(w)
 		return
 	}
-	response.Ok(w, map[string]any{"updated": tag.RowsAffected()})
+	if herdID != nil {
+		if err := zone.SyncHerdMemberships(r.Context(), tx, farmID, req.AnimalIDs); err != nil {
+			response.InternalError(w)
+			return
+		}
+	}
+	over := []zone.Overstock{}
+	if zoneID != nil {
+		var ok bool
+		if over, ok = zone.GuardCapacity(w, r, tx, farmID, []uuid.UUID{*zoneID}, req.Force); !ok {
+			return
+		}
+		if err := zone.SyncUsage(r.Context(), tx, farmID); err != nil {
+			response.InternalError(w)
+			return
+		}
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, map[string]any{"updated": tag.RowsAffected(), "overstocked": over})
 }
 
 // =============================================
@@ -573,8 +729,15 @@ func (h *HerdHandler) Create(w http.ResponseWriter, r *http.Request) {
 		id, _ := uuid.Parse(*req.ZoneID)
 		zoneID = &id
 	}
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
 	var herd Herd
-	err := h.pool.QueryRow(r.Context(), `
+	err = tx.QueryRow(r.Context(), `
 		INSERT INTO herds (id, farm_id, name, color, zone_id)
 		VALUES ($1,$2,$3,$4,$5)
 		RETURNING id, farm_id, name, color, zone_id`,
@@ -584,6 +747,14 @@ func (h *HerdHandler) Create(w http.ResponseWriter, r *http.Request) {
 		response.InternalError(w)
 		return
 	}
+	if err := syncHerdLocation(r.Context(), tx, farmID, herd.ID, nil); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
 	response.Created(w, herd)
 }
 
@@ -608,7 +779,14 @@ func (h *HerdHandler) Update(w http.ResponseWriter, r *http.Request) {
 		id, _ := uuid.Parse(*req.ZoneID)
 		zoneID = &id
 	}
-	tag, err := h.pool.Exec(r.Context(), `
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	tag, err := tx.Exec(r.Context(), `
 		UPDATE herds SET name=$1, color=$2, zone_id=$3, updated_at=NOW()
 		WHERE id=$4 AND farm_id=$5`,
 		req.Name, req.Color, zoneID, herdID, farmID)
@@ -616,6 +794,14 @@ func (h *HerdHandler) Update(w http.ResponseWriter, r *http.Request) {
 		response.NotFound(w, "herd not found")
 		return
 	}
+	if err := syncHerdLocation(r.Context(), tx, farmID, herdID, nil); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
 	h.Get(w, r)
 }
 
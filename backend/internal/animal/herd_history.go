@@ -0,0 +1,218 @@
+package animal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/internal/zone"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// HERD HISTORY
+// =============================================
+
+type HerdMembership struct {
+	AnimalID   uuid.UUID  `json:"animal_id"`
+	EarTag     string     `json:"ear_tag"`
+	AnimalName *string    `json:"animal_name,omitempty"`
+	JoinedAt   time.Time  `json:"joined_at"`
+	LeftAt     *time.Time `json:"left_at,omitempty"`
+}
+
+type HerdLocation struct {
+	ZoneID      *uuid.UUID `json:"zone_id,omitempty"`
+	ZoneName    *string    `json:"zone_name,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+	AnimalCount int        `json:"animal_count"`
+	Notes       *string    `json:"notes,omitempty"`
+}
+
+type HerdHistory struct {
+	HerdID      uuid.UUID        `json:"herd_id"`
+	Memberships []HerdMembership `json:"memberships"`
+	Locations   []HerdLocation   `json:"locations"`
+}
+
+// syncHerdLocation closes the open location period of a herd when its zone
+// changed and opens a new one for the current zone.
+func syncHerdLocation(ctx context.Context, q db.DBTX, farmID, herdID uuid.UUID, notes *string) error {
+	_, err := q.Exec(ctx, `
+		UPDATE herd_locations l SET ended_at = NOW()
+		FROM herds h
+		WHERE l.herd_id = h.id AND l.ended_at IS NULL
+		  AND h.id = $1 AND h.farm_id = $2
+		  AND h.zone_id IS DISTINCT FROM l.zone_id`,
+		herdID, farmID)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, `
+		INSERT INTO herd_locations (farm_id, herd_id, zone_id, started_at, animal_count, notes)
+		SELECT h.farm_id, h.id, h.zone_id, NOW(),
+		       (SELECT COUNT(*) FROM animals a WHERE a.herd_id = h.id AND a.status = 'active'),
+		       $3
+		FROM herds h
+		WHERE h.id = $1 AND h.farm_id = $2 AND h.zone_id IS NOT NULL
+		  AND NOT EXISTS (
+		      SELECT 1 FROM herd_locations l
+		      WHERE l.herd_id = h.id AND l.ended_at IS NULL
+		  )`,
+		herdID, farmID, notes)
+	return err
+}
+
+// Move relocates a herd and all of its active animals to a zone atomically.
+func (h *HerdHandler) Move(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	herdID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid herd id")
+		return
+	}
+	var req struct {
+		ZoneID uuid.UUID `json:"zone_id"`
+		Notes  *string   `json:"notes"`
+		// Force moves the herd even when it takes the zone over its
+		// stocking limit under the reject policy.
+		Force bool `json:"force"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ZoneID == uuid.Nil {
+		response.BadRequest(w, "zone_id required")
+		return
+	}
+
+	var zoneExists bool
+	_ = h.pool.QueryRow(r.Context(),
+		`SELECT EXISTS(SELECT 1 FROM zones WHERE id=$1 AND farm_id=$2 AND is_active)`,
+		req.ZoneID, farmID,
+	).Scan(&zoneExists)
+	if !zoneExists {
+		response.NotFound(w, "zone not found")
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	tag, err := tx.Exec(r.Context(), `
+		UPDATE herds SET zone_id=$1, updated_at=NOW()
+		WHERE id=$2 AND farm_id=$3`,
+		req.ZoneID, herdID, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.NotFound(w, "herd not found")
+		return
+	}
+	tag, err = tx.Exec(r.Context(), `
+		UPDATE animals SET zone_id=$1, updated_at=NOW()
+		WHERE herd_id=$2 AND farm_id=$3 AND status='active'`,
+		req.ZoneID, herdID, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	over, ok := zone.GuardCapacity(w, r, tx, farmID, []uuid.UUID{req.ZoneID}, req.Force)
+	if !ok {
+		return
+	}
+	if err := syncHerdLocation(r.Context(), tx, farmID, herdID, req.Notes); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := zone.SyncUsage(r.Context(), tx, farmID); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, map[string]any{
+		"herd_id":     herdID,
+		"zone_id":     req.ZoneID,
+		"moved":       tag.RowsAffected(),
+		"overstocked": over,
+	})
+}
+
+// History returns the membership and location periods of a herd, newest first.
+func (h *HerdHandler) History(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	herdID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid herd id")
+		return
+	}
+	var exists bool
+	_ = h.pool.QueryRow(r.Context(),
+		`SELECT EXISTS(SELECT 1 FROM herds WHERE id=$1 AND farm_id=$2)`, herdID, farmID,
+	).Scan(&exists)
+	if !exists {
+		response.NotFound(w, "herd not found")
+		return
+	}
+
+	history := HerdHistory{
+		HerdID:      herdID,
+		Memberships: []HerdMembership{},
+		Locations:   []HerdLocation{},
+	}
+
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT m.animal_id, a.ear_tag, a.name, m.joined_at, m.left_at
+		FROM herd_memberships m
+		JOIN animals a ON a.id = m.animal_id
+		WHERE m.herd_id=$1 AND m.farm_id=$2
+		ORDER BY m.joined_at DESC, a.ear_tag`, herdID, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	for rows.Next() {
+		var m HerdMembership
+		if err := rows.Scan(&m.AnimalID, &m.EarTag, &m.AnimalName, &m.JoinedAt, &m.LeftAt); err != nil {
+			rows.Close()
+			response.InternalError(w)
+			return
+		}
+		history.Memberships = append(history.Memberships, m)
+	}
+	rows.Close()
+
+	rows, err = h.pool.Query(r.Context(), `
+		SELECT l.zone_id, z.name, l.started_at, l.ended_at, l.animal_count, l.notes
+		FROM herd_locations l
+		LEFT JOIN zones z ON z.id = l.zone_id
+		WHERE l.herd_id=$1 AND l.farm_id=$2
+		ORDER BY l.started_at DESC`, herdID, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l HerdLocation
+		if err := rows.Scan(&l.ZoneID, &l.ZoneName, &l.StartedAt, &l.EndedAt, &l.AnimalCount, &l.Notes); err != nil {
+			response.InternalError(w)
+			return
+		}
+		history.Locations = append(history.Locations, l)
+	}
+	response.Ok(w, history)
+}
@@ -0,0 +1,315 @@
+package animal
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// HERD METRICS
+// =============================================
+
+// weightBucketKg is the width of each bin in the weight distribution.
+const weightBucketKg = 50
+
+type MetricBucket struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+type WeightMetrics struct {
+	Weighed      int            `json:"weighed"`
+	AvgKg        *float64       `json:"avg_kg,omitempty"`
+	MinKg        *float64       `json:"min_kg,omitempty"`
+	MaxKg        *float64       `json:"max_kg,omitempty"`
+	Distribution []MetricBucket `json:"distribution"`
+}
+
+type ADGWindow struct {
+	Days     int      `json:"days"`
+	AvgKgDay *float64 `json:"avg_kg_day,omitempty"`
+	Animals  int      `json:"animals"`
+}
+
+// HerdCost is the herd's health cost in one currency.
+type HerdCost struct {
+	Currency    string  `json:"currency"`
+	CostCents   int64   `json:"cost_cents"`
+	CostPerHead float64 `json:"cost_per_head_cents"`
+}
+
+type HerdMetrics struct {
+	HerdID            uuid.UUID      `json:"herd_id"`
+	AnimalCount       int            `json:"animal_count"`
+	Weight            WeightMetrics  `json:"weight"`
+	ADG               []ADGWindow    `json:"adg"`
+	AgeStructure      []MetricBucket `json:"age_structure"`
+	Females           int            `json:"females"`
+	Pregnant          int            `json:"pregnant"`
+	PregnancyRatePct  *float64       `json:"pregnancy_rate_pct,omitempty"`
+	HealthCosts       []HerdCost     `json:"health_costs"`
+	DaysInCurrentZone *int           `json:"days_in_current_zone,omitempty"`
+	AvgKmDay          *float64       `json:"avg_km_day,omitempty"`
+	TrackedAnimals    int            `json:"tracked_animals"`
+}
+
+// parseWindows reads a comma separated list of day windows, e.g. "30,90,180".
+func parseWindows(raw string) []int {
+	if raw == "" {
+		return []int{30, 90, 180}
+	}
+	seen := map[int]bool{}
+	windows := []int{}
+	for _, part := range strings.Split(raw, ",") {
+		d, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || d < 1 || d > 730 || seen[d] {
+			continue
+		}
+		seen[d] = true
+		windows = append(windows, d)
+	}
+	sort.Ints(windows)
+	return windows
+}
+
+// Metrics returns performance indicators of a herd so lots can be compared
+// side by side. ADG windows are configurable with ?windows=30,90,180.
+func (h *HerdHandler) Metrics(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	herdID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid herd id")
+		return
+	}
+	ctx := r.Context()
+
+	m := HerdMetrics{
+		HerdID:       herdID,
+		ADG:          []ADGWindow{},
+		AgeStructure: []MetricBucket{},
+		HealthCosts:  []HerdCost{},
+		Weight:       WeightMetrics{Distribution: []MetricBucket{}},
+	}
+	err = h.pool.QueryRow(ctx, `
+		SELECT COUNT(a.id)::int,
+		       (COUNT(a.id) FILTER (WHERE a.sex='female'))::int
+		FROM herds hr
+		LEFT JOIN animals a ON a.herd_id = hr.id AND a.status='active'
+		WHERE hr.id=$1 AND hr.farm_id=$2
+		GROUP BY hr.id`, herdID, farmID,
+	).Scan(&m.AnimalCount, &m.Females)
+	if errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, "herd not found")
+		return
+	}
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	// Latest weight per animal, binned; the totals ride along on every bin
+	rows, err := h.pool.Query(ctx, `
+		WITH latest AS (
+			SELECT DISTINCT ON (wr.animal_id) wr.weight_kg
+			FROM weight_records wr
+			JOIN animals a ON a.id = wr.animal_id
+			WHERE a.herd_id=$1 AND a.farm_id=$2 AND a.status='active'
+			ORDER BY wr.animal_id, wr.recorded_at DESC
+		)
+		SELECT (FLOOR(weight_kg / $3) * $3)::int AS bucket, COUNT(*)::int,
+		       (SUM(COUNT(*)) OVER ())::int,
+		       (SUM(SUM(weight_kg)) OVER () / SUM(COUNT(*)) OVER ())::float8,
+		       (MIN(MIN(weight_kg)) OVER ())::float8,
+		       (MAX(MAX(weight_kg)) OVER ())::float8
+		FROM latest
+		GROUP BY bucket
+		ORDER BY bucket`, herdID, farmID, weightBucketKg)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	for rows.Next() {
+		var from, count int
+		if err := rows.Scan(&from, &count, &m.Weight.Weighed,
+			&m.Weight.AvgKg, &m.Weight.MinKg, &m.Weight.MaxKg); err != nil {
+			rows.Close()
+			response.InternalError(w)
+			return
+		}
+		m.Weight.Distribution = append(m.Weight.Distribution, MetricBucket{
+			Label: strconv.Itoa(from) + "–" + strconv.Itoa(from+weightBucketKg-1) + " kg",
+			Count: count,
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	// ADG: gain between first and last weighing inside each window
+	for _, days := range parseWindows(r.URL.Query().Get("windows")) {
+		win := ADGWindow{Days: days}
+		err := h.pool.QueryRow(ctx, `
+			WITH win AS (
+				SELECT wr.animal_id,
+				       (array_agg(wr.weight_kg ORDER BY wr.recorded_at ASC))[1]  AS first_w,
+				       MIN(wr.recorded_at) AS first_d,
+				       (array_agg(wr.weight_kg ORDER BY wr.recorded_at DESC))[1] AS last_w,
+				       MAX(wr.recorded_at) AS last_d
+				FROM weight_records wr
+				JOIN animals a ON a.id = wr.animal_id
+				WHERE a.herd_id=$1 AND a.farm_id=$2 AND a.status='active'
+				  AND wr.recorded_at >= CURRENT_DATE - $3::int
+				GROUP BY wr.animal_id
+			)
+			SELECT (AVG((last_w - first_w) / (last_d - first_d))
+			           FILTER (WHERE last_d > first_d))::float8,
+			       (COUNT(*) FILTER (WHERE last_d > first_d))::int
+			FROM win`, herdID, farmID, days,
+		).Scan(&win.AvgKgDay, &win.Animals)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		m.ADG = append(m.ADG, win)
+	}
+
+	// Age structure
+	rows, err = h.pool.Query(ctx, `
+		SELECT CASE
+		         WHEN a.birth_date IS NULL THEN 'desconhecida'
+		         WHEN a.birth_date > CURRENT_DATE - INTERVAL '12 months' THEN '0–12 meses'
+		         WHEN a.birth_date > CURRENT_DATE - INTERVAL '24 months' THEN '12–24 meses'
+		         WHEN a.birth_date > CURRENT_DATE - INTERVAL '36 months' THEN '24–36 meses'
+		         ELSE '36+ meses'
+		       END AS bucket,
+		       COUNT(*)::int
+		FROM animals a
+		WHERE a.herd_id=$1 AND a.farm_id=$2 AND a.status='active'
+		GROUP BY bucket
+		ORDER BY MIN(COALESCE(CURRENT_DATE - a.birth_date, 1000000))`, herdID, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	for rows.Next() {
+		var b MetricBucket
+		if err := rows.Scan(&b.Label, &b.Count); err != nil {
+			rows.Close()
+			response.InternalError(w)
+			return
+		}
+		m.AgeStructure = append(m.AgeStructure, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	// Pregnant = latest pregnancy/birth/abortion event is a pregnancy that
+	// has not yet gone past term
+	err = h.pool.QueryRow(ctx, `
+		WITH last_event AS (
+			SELECT DISTINCT ON (re.animal_id) re.animal_id, re.event_type, re.event_date
+			FROM reproductive_events re
+			JOIN animals a ON a.id = re.animal_id
+			WHERE a.herd_id=$1 AND a.farm_id=$2 AND a.status='active' AND a.sex='female'
+			  AND re.event_type IN ('pregnancy','birth','abortion')
+			ORDER BY re.animal_id, re.event_date DESC
+		)
+		SELECT COUNT(*)::int FROM last_event
+		WHERE event_type='pregnancy' AND event_date >= CURRENT_DATE - 300`, herdID, farmID,
+	).Scan(&m.Pregnant)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if m.Females > 0 {
+		rate := float64(m.Pregnant) / float64(m.Females) * 100
+		m.PregnancyRatePct = &rate
+	}
+
+	// Health cost over the last 12 months per currency. Each event's cost is
+	// split over the animals it covers: herd events count in full, other
+	// events count the shares of the herd's current members.
+	rows, err = h.pool.Query(ctx, `
+		WITH alloc AS (
+			SELECT he.herd_id, he.currency, ea.animal_id,
+			       he.cost_cents::numeric / COUNT(*) OVER (PARTITION BY he.id) AS share
+			FROM health_events he
+			LEFT JOIN health_event_animals ea ON ea.event_id = he.id
+			WHERE he.farm_id=$2
+			  AND he.started_at >= CURRENT_DATE - INTERVAL '12 months'
+		)
+		SELECT a.currency, ROUND(SUM(a.share))::bigint
+		FROM alloc a
+		WHERE a.herd_id=$1 OR a.animal_id IN (
+		      SELECT id FROM animals WHERE herd_id=$1 AND farm_id=$2 AND status='active')
+		GROUP BY a.currency
+		ORDER BY a.currency`,
+		herdID, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	for rows.Next() {
+		var c HerdCost
+		if err := rows.Scan(&c.Currency, &c.CostCents); err != nil {
+			rows.Close()
+			response.InternalError(w)
+			return
+		}
+		if m.AnimalCount > 0 {
+			c.CostPerHead = float64(c.CostCents) / float64(m.AnimalCount)
+		}
+		m.HealthCosts = append(m.HealthCosts, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	err = h.pool.QueryRow(ctx, `
+		SELECT (CURRENT_DATE - l.started_at::date)::int
+		FROM herd_locations l
+		JOIN herds hr ON hr.id = l.herd_id AND hr.zone_id = l.zone_id
+		WHERE l.herd_id=$1 AND l.farm_id=$2 AND l.ended_at IS NULL`, herdID, farmID,
+	).Scan(&m.DaysInCurrentZone)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		response.InternalError(w)
+		return
+	}
+
+	// GPS activity, same km/day estimate as the per-animal activity chart
+	err = h.pool.QueryRow(ctx, `
+		WITH daily AS (
+			SELECT date_trunc('day', gt.recorded_at) AS day, gt.animal_id,
+			       SUM(COALESCE(gt.speed_kmh,0)) / NULLIF(COUNT(*),0) * 24 AS km_day
+			FROM gps_tracks gt
+			JOIN animals a ON a.id = gt.animal_id
+			WHERE a.herd_id=$1 AND gt.farm_id=$2 AND a.status='active'
+			  AND gt.recorded_at >= NOW() - INTERVAL '7 days'
+			GROUP BY day, gt.animal_id
+		)
+		SELECT AVG(km_day)::float8, COUNT(DISTINCT animal_id)::int FROM daily`, herdID, farmID,
+	).Scan(&m.AvgKmDay, &m.TrackedAnimals)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	response.Ok(w, m)
+}
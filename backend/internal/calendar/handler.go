@@ -0,0 +1,236 @@
+package calendar
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/gabrielrondon/cowpro/internal/animal"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// Feed window relative to today, in days.
+const (
+	feedPastDays   = 30
+	feedFutureDays = 365
+)
+
+type Handler struct{ pool *pgxpool.Pool }
+
+func NewHandler(pool *pgxpool.Pool) *Handler { return &Handler{pool: pool} }
+
+type FeedInfo struct {
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// feedURL builds the public subscription URL for a token from the incoming
+// request, honouring reverse proxy headers.
+func feedURL(r *http.Request, token string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+		scheme = p
+	}
+	return fmt.Sprintf("%s://%s/api/v1/ical/%s.ics", scheme, r.Host, token)
+}
+
+// GetFeed returns the current user's feed URL for the active farm.
+func (h *Handler) GetFeed(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	userID := middleware.UserIDFromCtx(r.Context())
+
+	var token string
+	var info FeedInfo
+	err := h.pool.QueryRow(r.Context(),
+		`SELECT token, created_at FROM calendar_feeds WHERE farm_id=$1 AND user_id=$2`,
+		farmID, userID,
+	).Scan(&token, &info.CreatedAt)
+	if err != nil {
+		response.NotFound(w, "calendar feed not found")
+		return
+	}
+	info.URL = feedURL(r, token)
+	response.Ok(w, info)
+}
+
+// CreateFeed issues a new secret feed URL. Any previous URL of the user for
+// this farm stops working.
+func (h *Handler) CreateFeed(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	userID := middleware.UserIDFromCtx(r.Context())
+
+	token, err := newToken()
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	var info FeedInfo
+	err = h.pool.QueryRow(r.Context(), `
+		INSERT INTO calendar_feeds (farm_id, user_id, token)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (farm_id, user_id) DO UPDATE SET token = EXCLUDED.token, created_at = NOW()
+		RETURNING created_at`, farmID, userID, token,
+	).Scan(&info.CreatedAt)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	info.URL = feedURL(r, token)
+	response.Created(w, info)
+}
+
+// DeleteFeed revokes the user's feed URL.
+func (h *Handler) DeleteFeed(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	userID := middleware.UserIDFromCtx(r.Context())
+	_, err := h.pool.Exec(r.Context(),
+		`DELETE FROM calendar_feeds WHERE farm_id=$1 AND user_id=$2`, farmID, userID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.NoContent(w)
+}
+
+// entryUID keeps the same UID for an agenda entry when its date or type
+// changes, so subscribed calendars move the event instead of duplicating it.
+// A calving that becomes delayed keeps the UID of the imminent calving.
+// A protocol dose is identified by its protocol, animal and dose number, so
+// it keeps its UID when rescheduled or overdue.
+func entryUID(e animal.AgendaEntry) string {
+	kind := e.Type
+	switch e.Type {
+	case "imminent_birth", "delayed_birth":
+		kind = "calving"
+	case "protocol_due":
+		if e.AnimalID != nil {
+			return fmt.Sprintf("protocol-%s-%s-%d@pastotech", e.RefID, *e.AnimalID, e.Dose)
+		}
+	}
+	return fmt.Sprintf("%s-%s@pastotech", kind, e.RefID)
+}
+
+func summarize(label string, tags []string) string {
+	switch len(tags) {
+	case 0:
+		return label
+	case 1:
+		return label + ": " + tags[0]
+	default:
+		return fmt.Sprintf("%s: %d animais", label, len(tags))
+	}
+}
+
+// Feed serves the iCalendar document behind a secret token. It is public so
+// calendar clients can subscribe without credentials.
+func (h *Handler) Feed(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	token := chi.URLParam(r, "token")
+
+	var farmID, userID uuid.UUID
+	var farmName string
+	err := h.pool.QueryRow(ctx, `
+		SELECT cf.farm_id, cf.user_id, f.name
+		FROM calendar_feeds cf
+		JOIN farms f ON f.id = cf.farm_id
+		JOIN farm_members fm ON fm.farm_id = cf.farm_id AND fm.user_id = cf.user_id
+		WHERE cf.token = $1`, token,
+	).Scan(&farmID, &userID, &farmName)
+	if err != nil {
+		response.NotFound(w, "calendar feed not found")
+		return
+	}
+
+	loc := animal.FarmLocation(ctx, h.pool, farmID)
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from, to := today.AddDate(0, 0, -feedPastDays), today.AddDate(0, 0, feedFutureDays)
+
+	entries, err := animal.LoadAgendaEntries(ctx, h.pool, farmID, from, to)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	events := make([]icalEvent, 0, len(entries))
+	for _, e := range entries {
+		desc := []string{}
+		if len(e.Animals) > 0 {
+			desc = append(desc, "Animais: "+strings.Join(e.Animals, ", "))
+		}
+		if len(e.Zones) > 0 {
+			desc = append(desc, "Zonas: "+strings.Join(e.Zones, ", "))
+		}
+		events = append(events, icalEvent{
+			UID:         entryUID(e),
+			Date:        e.ExpectedDate,
+			Summary:     summarize(e.Label, e.Animals),
+			Description: strings.Join(desc, "\n"),
+			Categories:  e.Type,
+		})
+	}
+
+	// Open tasks assigned to the user or to nobody
+	rows, err := h.pool.Query(ctx, `
+		SELECT t.id, t.title, t.description, t.kind, t.due_date
+		FROM tasks t
+		WHERE t.farm_id = $1 AND t.status IN ('open','in_progress')
+		  AND t.due_date BETWEEN $3::date AND $4::date
+		  AND (t.assignee_id = $2 OR t.assignee_id IS NULL)
+		ORDER BY t.due_date`,
+		farmID, userID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var title, kind string
+		var description *string
+		var due time.Time
+		if err := rows.Scan(&id, &title, &description, &kind, &due); err != nil {
+			response.InternalError(w)
+			return
+		}
+		ev := icalEvent{
+			UID:        fmt.Sprintf("task-%s@pastotech", id),
+			Date:       due,
+			Summary:    title,
+			Categories: "task_" + kind,
+		}
+		if description != nil {
+			ev.Description = *description
+		}
+		events = append(events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="agenda.ics"`)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(render(farmName, loc.String(), events)))
+}
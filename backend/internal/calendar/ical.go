@@ -0,0 +1,88 @@
+package calendar
+
+import (
+	"strings"
+	"time"
+)
+
+// icalEvent is an all-day VEVENT.
+type icalEvent struct {
+	UID         string
+	Date        time.Time
+	Summary     string
+	Description string
+	Categories  string
+}
+
+// writer builds an RFC 5545 document. Lines end in CRLF and are folded at
+// 75 octets.
+type writer struct {
+	b strings.Builder
+}
+
+func (w *writer) line(name, value string) {
+	l := name + ":" + value
+	// Continuation lines start with a space, leaving 74 octets of content
+	limit := 75
+	for len(l) > limit {
+		cut := limit
+		// Never split a multi-byte UTF-8 sequence
+		for cut > 0 && l[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.b.WriteString(l[:cut])
+		w.b.WriteString("\r\n ")
+		l = l[cut:]
+		limit = 74
+	}
+	w.b.WriteString(l)
+	w.b.WriteString("\r\n")
+}
+
+// escapeText escapes a TEXT value (RFC 5545 §3.3.11).
+func escapeText(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	)
+	return r.Replace(s)
+}
+
+func (w *writer) event(e icalEvent, stamp time.Time) {
+	w.line("BEGIN", "VEVENT")
+	w.line("UID", e.UID)
+	w.line("DTSTAMP", stamp.UTC().Format("20060102T150405Z"))
+	w.line("DTSTART;VALUE=DATE", e.Date.Format("20060102"))
+	w.line("DTEND;VALUE=DATE", e.Date.AddDate(0, 0, 1).Format("20060102"))
+	w.line("SUMMARY", escapeText(e.Summary))
+	if e.Description != "" {
+		w.line("DESCRIPTION", escapeText(e.Description))
+	}
+	if e.Categories != "" {
+		w.line("CATEGORIES", escapeText(e.Categories))
+	}
+	w.line("TRANSP", "TRANSPARENT")
+	w.line("END", "VEVENT")
+}
+
+// render produces a VCALENDAR with the given events.
+func render(name, timezone string, events []icalEvent) string {
+	w := &writer{}
+	stamp := time.Now()
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//PastoTech//Agenda//PT")
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", "PUBLISH")
+	w.line("X-WR-CALNAME", escapeText(name))
+	w.line("X-WR-TIMEZONE", timezone)
+	w.line("REFRESH-INTERVAL;VALUE=DURATION", "PT1H")
+	for _, e := range events {
+		w.event(e, stamp)
+	}
+	w.line("END", "VCALENDAR")
+	return w.b.String()
+}
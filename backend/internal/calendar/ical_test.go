@@ -0,0 +1,86 @@
+package calendar
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestWriterLineFolding(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"short", "Vacinação"},
+		{"exactly 75 octets", strings.Repeat("a", 75-len("SUMMARY:"))},
+		{"76 octets", strings.Repeat("a", 76-len("SUMMARY:"))},
+		{"several folds", strings.Repeat("abcdefghij", 30)},
+		{"multi-byte", strings.Repeat("vacinação ", 40)},
+		{"multi-byte at the cut", strings.Repeat("a", 74-len("SUMMARY:")) + strings.Repeat("ç", 60)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &writer{}
+			w.line("SUMMARY", tt.value)
+			out := w.b.String()
+			if !strings.HasSuffix(out, "\r\n") {
+				t.Fatalf("line does not end in CRLF: %q", out)
+			}
+			lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+			var unfolded strings.Builder
+			for i, l := range lines {
+				if len(l) > 75 {
+					t.Errorf("line %d is %d octets, more than 75", i, len(l))
+				}
+				if !utf8.ValidString(l) {
+					t.Errorf("line %d splits a UTF-8 sequence: %q", i, l)
+				}
+				if i > 0 {
+					if !strings.HasPrefix(l, " ") {
+						t.Fatalf("continuation line %d does not start with a space", i)
+					}
+					l = l[1:]
+				}
+				unfolded.WriteString(l)
+			}
+			if got, want := unfolded.String(), "SUMMARY:"+tt.value; got != want {
+				t.Errorf("unfolded = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain", "plain"},
+		{`a\b`, `a\\b`},
+		{"a;b,c", `a\;b\,c`},
+		{"one\ntwo", `one\ntwo`},
+		{"one\r\ntwo", `one\ntwo`},
+	}
+	for _, tt := range tests {
+		if got := escapeText(tt.in); got != tt.want {
+			t.Errorf("escapeText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenderEvent(t *testing.T) {
+	day := time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)
+	out := render("Fazenda", "America/Sao_Paulo", []icalEvent{
+		{UID: "weaning-1@pastotech", Date: day, Summary: "Desmame: 123"},
+	})
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:weaning-1@pastotech\r\n",
+		"DTSTART;VALUE=DATE:20260331\r\n",
+		"DTEND;VALUE=DATE:20260401\r\n",
+		"SUMMARY:Desmame: 123\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("render output lacks %q", want)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/internal/zone"
 	"github.com/gabrielrondon/cowpro/pkg/response"
 )
 
@@ -27,12 +28,16 @@ type Farm struct {
 	OwnerID  uuid.UUID `json:"owner_id"`
 	Country  string    `json:"country"`
 	Timezone string    `json:"timezone"`
+
+	// OverstockPolicy is off, warn or reject: what happens when a move takes
+	// a zone over its stocking limit.
+	OverstockPolicy string `json:"overstock_policy"`
 }
 
 func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.UserIDFromCtx(r.Context())
 	rows, err := h.pool.Query(r.Context(), `
-		SELECT f.id, f.name, f.owner_id, f.country, f.timezone
+		SELECT f.id, f.name, f.owner_id, f.country, f.timezone, f.overstock_policy
 		FROM farms f
 		JOIN farm_members fm ON fm.farm_id = f.id
 		WHERE fm.user_id = $1
@@ -45,7 +50,7 @@ func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
 	farms := []Farm{}
 	for rows.Next() {
 		var f Farm
-		if err := rows.Scan(&f.ID, &f.Name, &f.OwnerID, &f.Country, &f.Timezone); err != nil {
+		if err := rows.Scan(&f.ID, &f.Name, &f.OwnerID, &f.Country, &f.Timezone, &f.OverstockPolicy); err != nil {
 			response.InternalError(w)
 			return
 		}
@@ -58,8 +63,8 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
 	var f Farm
 	err := h.pool.QueryRow(r.Context(),
-		`SELECT id, name, owner_id, country, timezone FROM farms WHERE id=$1`, farmID,
-	).Scan(&f.ID, &f.Name, &f.OwnerID, &f.Country, &f.Timezone)
+		`SELECT id, name, owner_id, country, timezone, overstock_policy FROM farms WHERE id=$1`, farmID,
+	).Scan(&f.ID, &f.Name, &f.OwnerID, &f.Country, &f.Timezone, &f.OverstockPolicy)
 	if err != nil {
 		response.NotFound(w, "farm not found")
 		return
@@ -93,9 +98,9 @@ func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 	err = tx.QueryRow(r.Context(), `
 		INSERT INTO farms (id, name, owner_id, country, timezone)
 		VALUES ($1,$2,$3,$4,$5)
-		RETURNING id, name, owner_id, country, timezone`,
+		RETURNING id, name, owner_id, country, timezone, overstock_policy`,
 		farmID, req.Name, userID, req.Country, req.Timezone,
-	).Scan(&f.ID, &f.Name, &f.OwnerID, &f.Country, &f.Timezone)
+	).Scan(&f.ID, &f.Name, &f.OwnerID, &f.Country, &f.Timezone, &f.OverstockPolicy)
 	if err != nil {
 		response.InternalError(w)
 		return
@@ -132,18 +137,26 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 		Name     string `json:"name"`
 		Country  string `json:"country"`
 		Timezone string `json:"timezone"`
+
+		// OverstockPolicy is left unchanged when omitted.
+		OverstockPolicy *string `json:"overstock_policy"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
 		response.BadRequest(w, "name required")
 		return
 	}
+	if p := req.OverstockPolicy; p != nil && *p != zone.PolicyOff && *p != zone.PolicyWarn && *p != zone.PolicyReject {
+		response.BadRequest(w, "overstock_policy must be off, warn or reject")
+		return
+	}
 	var f Farm
 	err := h.pool.QueryRow(r.Context(), `
-		UPDATE farms SET name=$1, country=$2, timezone=$3, updated_at=NOW()
+		UPDATE farms SET name=$1, country=$2, timezone=$3,
+		  overstock_policy=COALESCE($5, overstock_policy), updated_at=NOW()
 		WHERE id=$4
-		RETURNING id, name, owner_id, country, timezone`,
-		req.Name, req.Country, req.Timezone, farmID,
-	).Scan(&f.ID, &f.Name, &f.OwnerID, &f.Country, &f.Timezone)
+		RETURNING id, name, owner_id, country, timezone, overstock_policy`,
+		req.Name, req.Country, req.Timezone, farmID, req.OverstockPolicy,
+	).Scan(&f.ID, &f.Name, &f.OwnerID, &f.Country, &f.Timezone, &f.OverstockPolicy)
 	if err != nil {
 		response.NotFound(w, "farm not found")
 		return
@@ -318,10 +331,20 @@ type ProfitabilityStats struct {
 	TotalSales      int     `json:"total_sales"`
 	Currency        string  `json:"currency"`
 	CostPerAnimal   float64 `json:"cost_per_animal"`
+	// HealthCostByCurrency lists the cost in every currency used; the totals
+	// above are in the main one.
+	HealthCostByCurrency []CurrencyAmount `json:"health_cost_by_currency"`
+}
+
+type CurrencyAmount struct {
+	Currency    string `json:"currency"`
+	AmountCents int64  `json:"amount_cents"`
 }
 
 type Alert struct {
 	ID        string    `json:"id"`
+	// ZoneID is set for zone alerts (grazing, stocking).
+	ZoneID    *string   `json:"zone_id,omitempty"`
 	Type      string    `json:"type"`
 	Severity  string    `json:"severity"`
 	Message   string    `json:"message"`
@@ -332,7 +355,7 @@ type Alert struct {
 func (h *StatsHandler) ListAlerts(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
 	rows, err := h.pool.Query(r.Context(), `
-		SELECT id, type, severity, message, is_read, created_at
+		SELECT id, zone_id::text, type, severity, message, is_read, created_at
 		FROM alerts WHERE farm_id=$1
 		ORDER BY created_at DESC LIMIT 50`, farmID)
 	if err != nil {
@@ -343,7 +366,7 @@ func (h *StatsHandler) ListAlerts(w http.ResponseWriter, r *http.Request) {
 	alerts := []Alert{}
 	for rows.Next() {
 		var a Alert
-		if err := rows.Scan(&a.ID, &a.Type, &a.Severity, &a.Message, &a.IsRead, &a.CreatedAt); err != nil {
+		if err := rows.Scan(&a.ID, &a.ZoneID, &a.Type, &a.Severity, &a.Message, &a.IsRead, &a.CreatedAt); err != nil {
 			continue
 		}
 		alerts = append(alerts, a)
@@ -367,17 +390,36 @@ func (h *StatsHandler) Profitability(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
 	var s ProfitabilityStats
 	s.Currency = "BRL"
+	s.HealthCostByCurrency = []CurrencyAmount{}
 
 	_ = h.pool.QueryRow(r.Context(), `
-		SELECT
-		  COALESCE(SUM(cost_cents),0)::bigint,
-		  (SELECT COUNT(*) FROM reproductive_events
-		   WHERE farm_id=$1 AND event_type='sale'
-		     AND event_date >= DATE_TRUNC('year', NOW()))::int
+		SELECT COUNT(*)::int FROM reproductive_events
+		WHERE farm_id=$1 AND event_type='sale'
+		  AND event_date >= DATE_TRUNC('year', NOW())`, farmID,
+	).Scan(&s.TotalSales)
+
+	// Costs are never summed across currencies; the largest one is the main
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT currency, COALESCE(SUM(cost_cents),0)::bigint
 		FROM health_events
 		WHERE farm_id=$1
-		  AND started_at >= DATE_TRUNC('year', NOW())`, farmID,
-	).Scan(&s.TotalHealthCost, &s.TotalSales)
+		  AND started_at >= DATE_TRUNC('year', NOW())
+		GROUP BY currency
+		ORDER BY SUM(cost_cents) DESC`, farmID)
+	if err == nil {
+		for rows.Next() {
+			var c CurrencyAmount
+			if err := rows.Scan(&c.Currency, &c.AmountCents); err != nil {
+				continue
+			}
+			s.HealthCostByCurrency = append(s.HealthCostByCurrency, c)
+		}
+		rows.Close()
+	}
+	if len(s.HealthCostByCurrency) > 0 {
+		s.Currency = s.HealthCostByCurrency[0].Currency
+		s.TotalHealthCost = s.HealthCostByCurrency[0].AmountCents
+	}
 
 	var animalCount int
 	_ = h.pool.QueryRow(r.Context(),
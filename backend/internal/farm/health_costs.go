@@ -0,0 +1,262 @@
+package farm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/health"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// HEALTH COSTS
+// =============================================
+
+// healthCostAlloc spreads each health event's cost evenly over the animals
+// it covers and attributes every share to the herd the animal belonged to
+// on the event date (or the event's herd) and to the event's zone (or the
+// zone that herd was grazing). $1 farm, $2 from, $3 to.
+const healthCostAlloc = `
+	WITH alloc AS (
+		SELECT he.id AS event_id, he.event_type, he.product_id, he.currency,
+		       to_char(he.started_at, 'YYYY-MM') AS month,
+		       ea.animal_id,
+		       COALESCE(hm.herd_id, he.herd_id) AS herd_id,
+		       COALESCE(he.zone_id, hl.zone_id) AS zone_id,
+		       he.cost_cents::numeric / COUNT(*) OVER (PARTITION BY he.id) AS share
+		FROM health_events he
+		LEFT JOIN health_event_animals ea ON ea.event_id = he.id
+		LEFT JOIN LATERAL (
+			SELECT m.herd_id FROM herd_memberships m
+			WHERE m.animal_id = ea.animal_id AND m.joined_at::date <= he.started_at
+			  AND (m.left_at IS NULL OR m.left_at::date > he.started_at)
+			ORDER BY m.joined_at DESC LIMIT 1
+		) hm ON true
+		LEFT JOIN LATERAL (
+			SELECT l.zone_id FROM herd_locations l
+			WHERE l.herd_id = COALESCE(hm.herd_id, he.herd_id) AND l.started_at::date <= he.started_at
+			  AND (l.ended_at IS NULL OR l.ended_at::date > he.started_at)
+			ORDER BY l.started_at DESC LIMIT 1
+		) hl ON true
+		WHERE he.farm_id = $1 AND he.started_at BETWEEN $2::date AND $3::date
+	)`
+
+// costDimensions maps a grouping to its key and label expressions and the
+// join that provides the label.
+var costDimensions = []struct{ name, key, label, join string }{
+	{"event_type", "a.event_type", "a.event_type", ""},
+	{"product", "a.product_id::text", "p.name", "LEFT JOIN health_products p ON p.id = a.product_id"},
+	{"herd", "a.herd_id::text", "h.name", "LEFT JOIN herds h ON h.id = a.herd_id"},
+	{"zone", "a.zone_id::text", "z.name", "LEFT JOIN zones z ON z.id = a.zone_id"},
+	{"month", "a.month", "a.month", ""},
+}
+
+type CostBucket struct {
+	Key         *string `json:"key"` // null when the cost is not attributed
+	Label       *string `json:"label"`
+	Currency    string  `json:"currency"`
+	CostCents   int64   `json:"cost_cents"`
+	Events      int     `json:"events"`
+	Animals     int     `json:"animals"`
+	CostPerHead float64 `json:"cost_per_head_cents"` // per treated animal
+}
+
+type CurrencyCost struct {
+	Currency       string   `json:"currency"`
+	CostCents      int64    `json:"cost_cents"`
+	Events         int      `json:"events"`
+	AnimalsTreated int      `json:"animals_treated"`
+	CostPerHead    float64  `json:"cost_per_head_cents"` // per animal on the farm
+	CostPerKg      *float64 `json:"cost_per_kg_gained_cents,omitempty"`
+}
+
+type CostComparison struct {
+	Currency      string   `json:"currency"`
+	CostCents     int64    `json:"cost_cents"`
+	PreviousCents int64    `json:"previous_cents"`
+	ChangePct     *float64 `json:"change_pct,omitempty"`
+}
+
+type HealthCostPeriod struct {
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	Population int            `json:"population"` // animals on the farm during the period
+	KgGained   float64        `json:"kg_gained"`
+	Totals     []CurrencyCost `json:"totals"`
+}
+
+type HealthCostStats struct {
+	HealthCostPeriod
+	Groups   map[string][]CostBucket `json:"groups"`
+	Previous HealthCostPeriod        `json:"previous_year"`
+	YoY      []CostComparison        `json:"year_over_year"`
+}
+
+// HealthCosts breaks health spending between ?from= and ?to= (default: the
+// current year to date) down by event type, product, herd, zone and month,
+// each per currency, and compares it with the same period a year earlier.
+func (h *StatsHandler) HealthCosts(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	q := r.URL.Query()
+	now := time.Now()
+	from := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				response.BadRequest(w, name+" must be YYYY-MM-DD")
+				return
+			}
+			*dst = t
+		}
+	}
+	if to.Before(from) {
+		response.BadRequest(w, "to must not be before from")
+		return
+	}
+
+	cur, err := h.costPeriod(r.Context(), farmID, from, to)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	prev, err := h.costPeriod(r.Context(), farmID, from.AddDate(-1, 0, 0), to.AddDate(-1, 0, 0))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	s := HealthCostStats{
+		HealthCostPeriod: cur,
+		Groups:           map[string][]CostBucket{},
+		Previous:         prev,
+		YoY:              compareCosts(cur.Totals, prev.Totals),
+	}
+	for _, dim := range costDimensions {
+		buckets, err := h.costBuckets(r.Context(), farmID, from, to, dim.key, dim.label, dim.join)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		s.Groups[dim.name] = buckets
+	}
+	response.Ok(w, s)
+}
+
+func (h *StatsHandler) costBuckets(ctx context.Context, farmID uuid.UUID, from, to time.Time,
+	key, label, join string) ([]CostBucket, error) {
+	rows, err := h.pool.Query(ctx, healthCostAlloc+fmt.Sprintf(`
+		SELECT %[1]s, %[2]s, a.currency,
+		       ROUND(SUM(a.share))::bigint,
+		       COUNT(DISTINCT a.event_id)::int,
+		       COUNT(DISTINCT a.animal_id)::int
+		FROM alloc a
+		%[3]s
+		GROUP BY %[1]s, %[2]s, a.currency
+		ORDER BY %[2]s NULLS LAST, a.currency`, key, label, join),
+		farmID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	buckets := []CostBucket{}
+	for rows.Next() {
+		var b CostBucket
+		if err := rows.Scan(&b.Key, &b.Label, &b.Currency, &b.CostCents, &b.Events, &b.Animals); err != nil {
+			return nil, err
+		}
+		if b.Animals > 0 {
+			b.CostPerHead = float64(b.CostCents) / float64(b.Animals)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// costPeriod loads the per-currency totals of a period with the herd size
+// and weight gained used for the per-head and per-kg figures.
+func (h *StatsHandler) costPeriod(ctx context.Context, farmID uuid.UUID, from, to time.Time) (HealthCostPeriod, error) {
+	p := HealthCostPeriod{
+		From:   from.Format("2006-01-02"),
+		To:     to.Format("2006-01-02"),
+		Totals: []CurrencyCost{},
+	}
+	var err error
+	p.Population, err = health.Population(ctx, h.pool, farmID, p.From, p.To)
+	if err != nil {
+		return p, err
+	}
+	// Gain from the last weighing on or before the start (or the first one
+	// inside the period) to the last one inside it
+	err = h.pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(last_w - first_w), 0)::float8 FROM (
+			SELECT (array_agg(wr.weight_kg ORDER BY wr.recorded_at DESC))[1] AS last_w,
+			       (array_agg(wr.weight_kg ORDER BY wr.recorded_at ASC))[1]  AS first_w
+			FROM weight_records wr
+			WHERE wr.farm_id = $1 AND wr.recorded_at <= $3::date
+			  AND wr.recorded_at >= COALESCE((
+			      SELECT MAX(b.recorded_at) FROM weight_records b
+			      WHERE b.animal_id = wr.animal_id AND b.recorded_at <= $2::date), $2::date)
+			GROUP BY wr.animal_id
+			HAVING COUNT(*) > 1
+		) g`, farmID, p.From, p.To).Scan(&p.KgGained)
+	if err != nil {
+		return p, err
+	}
+
+	rows, err := h.pool.Query(ctx, healthCostAlloc+`
+		SELECT a.currency, ROUND(SUM(a.share))::bigint,
+		       COUNT(DISTINCT a.event_id)::int, COUNT(DISTINCT a.animal_id)::int
+		FROM alloc a
+		GROUP BY a.currency
+		ORDER BY SUM(a.share) DESC`, farmID, p.From, p.To)
+	if err != nil {
+		return p, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c CurrencyCost
+		if err := rows.Scan(&c.Currency, &c.CostCents, &c.Events, &c.AnimalsTreated); err != nil {
+			return p, err
+		}
+		if p.Population > 0 {
+			c.CostPerHead = float64(c.CostCents) / float64(p.Population)
+		}
+		if p.KgGained > 0 {
+			perKg := float64(c.CostCents) / p.KgGained
+			c.CostPerKg = &perKg
+		}
+		p.Totals = append(p.Totals, c)
+	}
+	return p, rows.Err()
+}
+
+// compareCosts pairs the totals of two periods by currency.
+func compareCosts(cur, prev []CurrencyCost) []CostComparison {
+	out := []CostComparison{}
+	index := map[string]int{}
+	for _, c := range cur {
+		index[c.Currency] = len(out)
+		out = append(out, CostComparison{Currency: c.Currency, CostCents: c.CostCents})
+	}
+	for _, p := range prev {
+		i, ok := index[p.Currency]
+		if !ok {
+			i = len(out)
+			out = append(out, CostComparison{Currency: p.Currency})
+		}
+		out[i].PreviousCents = p.CostCents
+	}
+	for i := range out {
+		if out[i].PreviousCents > 0 {
+			pct := float64(out[i].CostCents-out[i].PreviousCents) / float64(out[i].PreviousCents) * 100
+			out[i].ChangePct = &pct
+		}
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package farm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db/dbtest"
+)
+
+func pct(v float64) *float64 { return &v }
+
+func TestCompareCosts(t *testing.T) {
+	cur := []CurrencyCost{{Currency: "BRL", CostCents: 15000}, {Currency: "USD", CostCents: 500}}
+	prev := []CurrencyCost{{Currency: "USD", CostCents: 0}, {Currency: "BRL", CostCents: 10000}, {Currency: "PYG", CostCents: 800}}
+
+	got := compareCosts(cur, prev)
+	tests := []struct {
+		currency  string
+		cost      int64
+		previous  int64
+		changePct *float64
+	}{
+		{"BRL", 15000, 10000, pct(50)},
+		{"USD", 500, 0, nil}, // no base to compare against
+		{"PYG", 0, 800, pct(-100)},
+	}
+	if len(got) != len(tests) {
+		t.Fatalf("%d comparisons, want %d: %+v", len(got), len(tests), got)
+	}
+	for i, tt := range tests {
+		c := got[i]
+		if c.Currency != tt.currency || c.CostCents != tt.cost || c.PreviousCents != tt.previous {
+			t.Errorf("row %d = %+v, want %s %d/%d", i, c, tt.currency, tt.cost, tt.previous)
+		}
+		switch {
+		case tt.changePct == nil && c.ChangePct != nil:
+			t.Errorf("%s: change = %v, want none", tt.currency, *c.ChangePct)
+		case tt.changePct != nil && (c.ChangePct == nil || *c.ChangePct != *tt.changePct):
+			t.Errorf("%s: change = %v, want %v", tt.currency, c.ChangePct, *tt.changePct)
+		}
+	}
+
+	if got := compareCosts(nil, nil); got == nil || len(got) != 0 {
+		t.Errorf("compareCosts(nil, nil) = %#v, want empty slice", got)
+	}
+}
+
+// TestHealthCostAllocation checks how healthCostAlloc attributes event
+// costs: split over the animals treated, charged to each animal's herd on
+// the event date and to the zone the event names or that herd grazed.
+func TestHealthCostAllocation(t *testing.T) {
+	pool := dbtest.Pool(t)
+	farmID := dbtest.Farm(t, pool)
+	ctx := context.Background()
+
+	retiro := dbtest.Zone(t, pool, farmID, "Retiro")
+	herdA := dbtest.Herd(t, pool, farmID, "Lote A")
+	herdB := dbtest.Herd(t, pool, farmID, "Lote B")
+	a1 := dbtest.Animal(t, pool, farmID, "001", nil, nil)
+	a2 := dbtest.Animal(t, pool, farmID, "002", nil, nil)
+	a3 := dbtest.Animal(t, pool, farmID, "003", nil, nil)
+	dbtest.Exec(t, pool, `UPDATE animals SET created_at = '2025-06-01' WHERE farm_id = $1`, farmID)
+	// a2 moves from A to B on March 1st; only A has a zone, Retiro
+	dbtest.Exec(t, pool, `
+		INSERT INTO herd_memberships (farm_id, herd_id, animal_id, joined_at, left_at) VALUES
+			($1, $2, $4, '2026-01-01', NULL),
+			($1, $2, $5, '2026-01-01', '2026-03-01'),
+			($1, $3, $5, '2026-03-01', NULL),
+			($1, $3, $6, '2026-01-01', NULL)`, farmID, herdA, herdB, a1, a2, a3)
+	dbtest.Exec(t, pool, `
+		INSERT INTO herd_locations (farm_id, herd_id, zone_id, started_at)
+		VALUES ($1, $2, $3, '2026-01-01')`, farmID, herdA, retiro)
+
+	event := func(date string, herd, zone *uuid.UUID, cost int, currency string, animals ...uuid.UUID) {
+		id := dbtest.ID(t, pool, `
+			INSERT INTO health_events (farm_id, herd_id, zone_id, event_type, name, cost_cents, currency, started_at)
+			VALUES ($1, $2, $3, 'vaccine', 'Aftosa', $4, $5, $6) RETURNING id`,
+			farmID, herd, zone, cost, currency, date)
+		for _, a := range animals {
+			dbtest.Exec(t, pool, `INSERT INTO health_event_animals (event_id, animal_id) VALUES ($1, $2)`, id, a)
+		}
+	}
+	event("2026-02-10", &herdA, nil, 1000, "BRL", a1, a2)
+	event("2026-03-10", &herdB, nil, 900, "BRL", a2, a3)
+	event("2026-02-15", nil, nil, 500, "USD", a1)
+	event("2026-03-20", nil, &retiro, 600, "BRL", a3)
+	event("2026-03-05", nil, nil, 200, "BRL") // no animals recorded
+	event("2025-12-01", &herdA, nil, 999, "BRL", a1)
+
+	type bucket struct {
+		label    string // empty when not attributed
+		currency string
+		cost     int64
+		events   int
+		animals  int
+	}
+	h := &StatsHandler{pool: pool}
+	from, to := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		dimension string
+		want      []bucket
+	}{
+		{"herd", []bucket{
+			{"Lote A", "BRL", 1000, 1, 2},
+			{"Lote A", "USD", 500, 1, 1},
+			{"Lote B", "BRL", 1500, 2, 2},
+			{"", "BRL", 200, 1, 0},
+		}},
+		{"zone", []bucket{
+			{"Retiro", "BRL", 1600, 2, 3},
+			{"Retiro", "USD", 500, 1, 1},
+			{"", "BRL", 1100, 2, 2},
+		}},
+	}
+	for _, tt := range tests {
+		for _, dim := range costDimensions {
+			if dim.name != tt.dimension {
+				continue
+			}
+			buckets, err := h.costBuckets(ctx, farmID, from, to, dim.key, dim.label, dim.join)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []bucket{}
+			for _, b := range buckets {
+				label := ""
+				if b.Label != nil {
+					label = *b.Label
+				}
+				got = append(got, bucket{label, b.Currency, b.CostCents, b.Events, b.Animals})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("%s: got %v, want %v", tt.dimension, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("%s bucket %d = %v, want %v", tt.dimension, i, got[i], tt.want[i])
+				}
+			}
+		}
+	}
+
+	p, err := h.costPeriod(ctx, farmID, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Population != 3 || len(p.Totals) != 2 {
+		t.Fatalf("period = %+v, want 3 animals and two currencies", p)
+	}
+	brl, usd := p.Totals[0], p.Totals[1]
+	if brl.Currency != "BRL" || brl.CostCents != 2700 || brl.Events != 4 || brl.AnimalsTreated != 3 || brl.CostPerHead != 900 {
+		t.Errorf("BRL total = %+v", brl)
+	}
+	if usd.Currency != "USD" || usd.CostCents != 500 || usd.Events != 1 || usd.AnimalsTreated != 1 {
+		t.Errorf("USD total = %+v", usd)
+	}
+	if brl.CostPerKg != nil {
+		t.Errorf("cost per kg = %v without weighings", *brl.CostPerKg)
+	}
+}
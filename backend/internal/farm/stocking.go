@@ -0,0 +1,178 @@
+package farm
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// STOCKING RATE
+// =============================================
+
+// StockingCategory breaks the herd down by the categories of the
+// animal_units view (calf, yearling, young, cow, bull, adult_male).
+type StockingCategory struct {
+	Category    string  `json:"category"`
+	Animals     int     `json:"animals"`
+	AnimalUnits float64 `json:"animal_units"`
+	Weighed     int     `json:"weighed"`
+}
+
+type StockingZone struct {
+	ZoneID      uuid.UUID  `json:"zone_id"`
+	Name        string     `json:"name"`
+	GroupID     *uuid.UUID `json:"group_id"`
+	AreaHa      float64    `json:"area_ha"`
+	Animals     int        `json:"animals"`
+	AnimalUnits float64    `json:"animal_units"`
+	UGMHa       float64    `json:"ugm_ha"`
+	UGMHaLimit  *float64   `json:"ugm_ha_limit"`
+	OverLimit   bool       `json:"over_limit"`
+}
+
+type StockingGroup struct {
+	GroupID     *uuid.UUID `json:"group_id"`
+	Name        string     `json:"name"`
+	AreaHa      float64    `json:"area_ha"`
+	Animals     int        `json:"animals"`
+	AnimalUnits float64    `json:"animal_units"`
+	UGMHa       float64    `json:"ugm_ha"`
+}
+
+// StockingReport is the farm's stocking rate in animal units (UA, 450 kg of
+// live weight) over its active grazing area. Animals outside any zone count
+// toward the farm total but not toward a zone.
+type StockingReport struct {
+	AreaHa       float64            `json:"area_ha"`
+	Animals      int                `json:"animals"`
+	AnimalUnits  float64            `json:"animal_units"`
+	UGMHa        float64            `json:"ugm_ha"`
+	WeighedPct   float64            `json:"weighed_pct"`
+	UnzonedUnits float64            `json:"unzoned_animal_units"`
+	OverLimit    int                `json:"zones_over_limit"`
+	ByCategory   []StockingCategory `json:"by_category"`
+	Zones        []StockingZone     `json:"zones"`
+	Groups       []StockingGroup    `json:"groups"`
+}
+
+func round2(v float64) float64 { return math.Round(v*100) / 100 }
+
+// Stocking reports animal units and stocking rate for the farm, each active
+// zone and each zone group, with the herd broken down by category.
+func (h *StatsHandler) Stocking(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	rep := StockingReport{ByCategory: []StockingCategory{}, Zones: []StockingZone{}, Groups: []StockingGroup{}}
+
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT category, COUNT(*)::int, ROUND(SUM(ua)::numeric, 2)::float8,
+		       COUNT(*) FILTER (WHERE source = 'weight')::int
+		FROM animal_units WHERE farm_id = $1
+		GROUP BY category ORDER BY SUM(ua) DESC`, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	weighed := 0
+	for rows.Next() {
+		var c StockingCategory
+		if err := rows.Scan(&c.Category, &c.Animals, &c.AnimalUnits, &c.Weighed); err != nil {
+			rows.Close()
+			response.InternalError(w)
+			return
+		}
+		rep.Animals += c.Animals
+		rep.AnimalUnits += c.AnimalUnits
+		weighed += c.Weighed
+		rep.ByCategory = append(rep.ByCategory, c)
+	}
+	rows.Close()
+
+	rows, err = h.pool.Query(r.Context(), `
+		SELECT z.id, z.name, z.group_id, z.area_ha::float8,
+		       COUNT(au.animal_id)::int, COALESCE(ROUND(SUM(au.ua)::numeric, 2), 0)::float8,
+		       z.ugm_ha_limit::float8
+		FROM zones z
+		LEFT JOIN animal_units au ON au.zone_id = z.id
+		WHERE z.farm_id = $1 AND z.is_active
+		GROUP BY z.id
+		ORDER BY z.name`, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	zonedUnits := 0.0
+	groupIndex := map[uuid.UUID]int{}
+	for rows.Next() {
+		var z StockingZone
+		if err := rows.Scan(&z.ZoneID, &z.Name, &z.GroupID, &z.AreaHa,
+			&z.Animals, &z.AnimalUnits, &z.UGMHaLimit); err != nil {
+			rows.Close()
+			response.InternalError(w)
+			return
+		}
+		if z.AreaHa > 0 {
+			z.UGMHa = round2(z.AnimalUnits / z.AreaHa)
+		}
+		z.OverLimit = z.UGMHaLimit != nil && z.UGMHa > *z.UGMHaLimit
+		if z.OverLimit {
+			rep.OverLimit++
+		}
+		rep.AreaHa += z.AreaHa
+		zonedUnits += z.AnimalUnits
+		rep.Zones = append(rep.Zones, z)
+		if z.GroupID != nil {
+			if _, ok := groupIndex[*z.GroupID]; !ok {
+				groupIndex[*z.GroupID] = len(rep.Groups)
+				rep.Groups = append(rep.Groups, StockingGroup{GroupID: z.GroupID})
+			}
+			g := &rep.Groups[groupIndex[*z.GroupID]]
+			g.AreaHa += z.AreaHa
+			g.Animals += z.Animals
+			g.AnimalUnits += z.AnimalUnits
+		}
+	}
+	rows.Close()
+
+	rows, err = h.pool.Query(r.Context(),
+		`SELECT id, name FROM zone_groups WHERE farm_id = $1`, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			response.InternalError(w)
+			return
+		}
+		if i, ok := groupIndex[id]; ok {
+			rep.Groups[i].Name = name
+		}
+	}
+	rows.Close()
+	for i := range rep.Groups {
+		g := &rep.Groups[i]
+		g.AreaHa, g.AnimalUnits = round2(g.AreaHa), round2(g.AnimalUnits)
+		if g.AreaHa > 0 {
+			g.UGMHa = round2(g.AnimalUnits / g.AreaHa)
+		}
+	}
+
+	rep.AreaHa = round2(rep.AreaHa)
+	rep.AnimalUnits = round2(rep.AnimalUnits)
+	rep.UnzonedUnits = round2(rep.AnimalUnits - zonedUnits)
+	if rep.AreaHa > 0 {
+		rep.UGMHa = round2(rep.AnimalUnits / rep.AreaHa)
+	}
+	if rep.Animals > 0 {
+		rep.WeighedPct = round2(float64(weighed) / float64(rep.Animals) * 100)
+	}
+	response.Ok(w, rep)
+}
@@ -0,0 +1,254 @@
+package geoio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// =============================================
+// DBASE ATTRIBUTE TABLES
+// =============================================
+
+type dbfField struct {
+	Name     string
+	Type     byte
+	Length   int
+	Decimals int
+}
+
+// readDBF returns the records of a dBASE III table as string maps keyed by
+// field name. Text is decoded as UTF-8 when valid (or declared by a .cpg
+// file) and as Latin-1 otherwise, which covers files from older GIS tools.
+func readDBF(data []byte, utf8Declared bool) ([]map[string]any, error) {
+	if len(data) < 32 {
+		return nil, fmt.Errorf("dbf file is truncated")
+	}
+	count := int(binary.LittleEndian.Uint32(data[4:8]))
+	headerLen := int(binary.LittleEndian.Uint16(data[8:10]))
+	recordLen := int(binary.LittleEndian.Uint16(data[10:12]))
+	if headerLen > len(data) {
+		return nil, fmt.Errorf("dbf file is truncated")
+	}
+
+	var fields []dbfField
+	for off := 32; off+32 <= headerLen && data[off] != 0x0D; off += 32 {
+		d := data[off : off+32]
+		fields = append(fields, dbfField{
+			Name:     strings.TrimRight(string(d[:11]), "\x00 "),
+			Type:     d[11],
+			Length:   int(d[16]),
+			Decimals: int(d[17]),
+		})
+	}
+
+	records := make([]map[string]any, 0, count)
+	for i := 0; i < count; i++ {
+		start := headerLen + i*recordLen
+		if start+recordLen > len(data) {
+			break
+		}
+		rec := data[start : start+recordLen]
+		values := map[string]any{}
+		if rec[0] == '*' {
+			records = append(records, values)
+			continue
+		}
+		pos := 1
+		for _, f := range fields {
+			if pos+f.Length > len(rec) {
+				break
+			}
+			raw := bytes.TrimSpace(rec[pos : pos+f.Length])
+			pos += f.Length
+			switch f.Type {
+			case 'N', 'F':
+				if v, err := strconv.ParseFloat(string(raw), 64); err == nil {
+					values[f.Name] = v
+				}
+			case 'L':
+				switch strings.ToUpper(string(raw)) {
+				case "T", "Y":
+					values[f.Name] = true
+				case "F", "N":
+					values[f.Name] = false
+				}
+			default:
+				if len(raw) > 0 {
+					values[f.Name] = decodeText(raw, utf8Declared)
+				}
+			}
+		}
+		records = append(records, values)
+	}
+	return records, nil
+}
+
+func decodeText(b []byte, utf8Declared bool) string {
+	if utf8Declared || utf8.Valid(b) {
+		return string(b)
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// recordName picks the attribute most likely to hold the feature's name.
+func recordName(values map[string]any) string {
+	for _, key := range []string{"name", "nome", "nom_tema", "descricao", "label", "id"} {
+		for k, v := range values {
+			if strings.EqualFold(k, key) {
+				if s := strings.TrimSpace(fmt.Sprint(v)); s != "" {
+					return s
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// dbfFields derives the table layout from the features' properties: NAME
+// first, then every property in alphabetical order, typed from its first
+// non-nil value. dBASE limits names to 10 characters.
+func dbfFields(features []Feature) ([]dbfField, []string) {
+	fields := []dbfField{{Name: "NAME", Type: 'C', Length: 254}}
+	keys := []string{""}
+	types := map[string]byte{}
+	for _, f := range features {
+		for k, v := range f.Properties {
+			if _, seen := types[k]; seen || v == nil {
+				continue
+			}
+			switch v.(type) {
+			case float64, float32, int, int64, int32:
+				types[k] = 'N'
+			case bool:
+				types[k] = 'L'
+			default:
+				types[k] = 'C'
+			}
+		}
+	}
+	names := make([]string, 0, len(types))
+	for k := range types {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	used := map[string]bool{"NAME": true}
+	for _, k := range names {
+		name := strings.ToUpper(k)
+		if len(name) > 10 {
+			name = name[:10]
+		}
+		for n := 1; used[name]; n++ {
+			suffix := strconv.Itoa(n)
+			name = name[:min(len(name), 10-len(suffix))] + suffix
+		}
+		used[name] = true
+		f := dbfField{Name: name, Type: types[k]}
+		switch f.Type {
+		case 'N':
+			f.Length, f.Decimals = 19, 6
+		case 'L':
+			f.Length = 1
+		default:
+			f.Length = 254
+		}
+		fields = append(fields, f)
+		keys = append(keys, k)
+	}
+	return fields, keys
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// writeDBF writes a UTF-8 dBASE III table with one record per feature.
+func writeDBF(features []Feature) []byte {
+	fields, keys := dbfFields(features)
+	recordLen := 1
+	for _, f := range fields {
+		recordLen += f.Length
+	}
+	headerLen := 32 + 32*len(fields) + 1
+
+	var b bytes.Buffer
+	header := make([]byte, 32)
+	header[0] = 0x03
+	header[1], header[2], header[3] = 126, 1, 1
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(features)))
+	binary.LittleEndian.PutUint16(header[8:], uint16(headerLen))
+	binary.LittleEndian.PutUint16(header[10:], uint16(recordLen))
+	b.Write(header)
+	for _, f := range fields {
+		d := make([]byte, 32)
+		copy(d, f.Name)
+		d[11] = f.Type
+		d[16] = byte(f.Length)
+		d[17] = byte(f.Decimals)
+		b.Write(d)
+	}
+	b.WriteByte(0x0D)
+
+	for _, feat := range features {
+		b.WriteByte(' ')
+		for i, f := range fields {
+			var v any = feat.Name
+			if i > 0 {
+				v = feat.Properties[keys[i]]
+			}
+			var s string
+			switch f.Type {
+			case 'N':
+				if n, ok := toFloat(v); ok {
+					s = strconv.FormatFloat(n, 'f', f.Decimals, 64)
+				}
+				s = strings.Repeat(" ", max(0, f.Length-len(s))) + s
+			case 'L':
+				s = "?"
+				if bv, ok := v.(bool); ok {
+					s = map[bool]string{true: "T", false: "F"}[bv]
+				}
+			default:
+				if v != nil {
+					s = truncateUTF8(fmt.Sprint(v), f.Length)
+				}
+				s += strings.Repeat(" ", f.Length-len(s))
+			}
+			b.WriteString(s[:f.Length])
+		}
+	}
+	b.WriteByte(0x1A)
+	return b.Bytes()
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, !math.IsNaN(n) && !math.IsInf(n, 0)
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	}
+	return 0, false
+}
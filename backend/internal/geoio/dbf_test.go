@@ -0,0 +1,111 @@
+package geoio
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDBFFields(t *testing.T) {
+	features := []Feature{
+		{Properties: map[string]any{"grass_type": nil, "area_ha": 1.5}},
+		{Properties: map[string]any{"grass_type": "Mombaça", "is_isolation": false,
+			"ugm_ha_limit_a": 2, "ugm_ha_limit_b": 3}},
+	}
+	fields, keys := dbfFields(features)
+	var names []string
+	var types []byte
+	for _, f := range fields {
+		names = append(names, f.Name)
+		types = append(types, f.Type)
+	}
+	wantNames := []string{"NAME", "AREA_HA", "GRASS_TYPE", "IS_ISOLATI", "UGM_HA_LIM", "UGM_HA_LI1"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("names = %v, want %v", names, wantNames)
+	}
+	if string(types) != "CNCLNN" {
+		t.Errorf("types = %s, want CNCLNN", types)
+	}
+	wantKeys := []string{"", "area_ha", "grass_type", "is_isolation", "ugm_ha_limit_a", "ugm_ha_limit_b"}
+	if !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("keys = %v, want %v", keys, wantKeys)
+	}
+}
+
+func TestDBFRoundTrip(t *testing.T) {
+	long := strings.Repeat("ção", 100) // 600 bytes, cut below 254
+	features := []Feature{
+		{Name: "Piquete Açude", Properties: map[string]any{"area_ha": 12.345678, "ativo": true, "obs": long}},
+		{Name: "Sede", Properties: map[string]any{"area_ha": nil, "ativo": false}},
+	}
+	records, err := readDBF(writeDBF(features), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("%d records, want 2", len(records))
+	}
+	r := records[0]
+	if r["NAME"] != "Piquete Açude" || r["AREA_HA"] != 12.345678 || r["ATIVO"] != true {
+		t.Errorf("record 1 = %v", r)
+	}
+	obs, _ := r["OBS"].(string)
+	if len(obs) < 250 || len(obs) > 254 || !strings.HasPrefix(long, obs) || !utf8.ValidString(obs) {
+		t.Errorf("long text cut to %d bytes, not on a character boundary", len(obs))
+	}
+	r = records[1]
+	if r["NAME"] != "Sede" || r["ATIVO"] != false {
+		t.Errorf("record 2 = %v", r)
+	}
+	if _, ok := r["AREA_HA"]; ok {
+		t.Errorf("null number read as %v", r["AREA_HA"])
+	}
+	if _, ok := r["OBS"]; ok {
+		t.Errorf("missing text read as %q", r["OBS"])
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	latin1 := []byte{'A', 0xE7, 0xFA, 'd', 'e'} // "Açúde" in Latin-1
+	if got := decodeText(latin1, false); got != "Açúde" {
+		t.Errorf("latin-1 = %q", got)
+	}
+	if got := decodeText([]byte("Açúde"), false); got != "Açúde" {
+		t.Errorf("utf-8 = %q", got)
+	}
+}
+
+func TestRecordName(t *testing.T) {
+	tests := []struct {
+		values map[string]any
+		want   string
+	}{
+		{map[string]any{"NOME": " Retiro ", "ID": 3.0}, "Retiro"},
+		{map[string]any{"Name": "", "ID": 7.0}, "7"},
+		{map[string]any{"AREA": 1.0}, ""},
+	}
+	for _, tt := range tests {
+		if got := recordName(tt.values); got != tt.want {
+			t.Errorf("recordName(%v) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"abc", 5, "abc"},
+		{"abc", 2, "ab"},
+		{"ação", 2, "a"},
+		{"ação", 3, "aç"},
+	}
+	for _, tt := range tests {
+		if got := truncateUTF8(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
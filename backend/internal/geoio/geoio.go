@@ -0,0 +1,159 @@
+// Package geoio reads and writes the map formats farms exchange with GIS
+// tools: GeoJSON, KML/KMZ, GPX and zipped ESRI Shapefiles. It only handles
+// polygons and points, which is all a farm map holds, and leaves coordinate
+// reprojection to PostGIS.
+package geoio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Position is an [x, y] coordinate: longitude/latitude for geographic
+// systems, easting/northing for projected ones.
+type Position [2]float64
+
+// Ring is a closed sequence of positions.
+type Ring []Position
+
+// Polygon is an exterior ring followed by its holes.
+type Polygon []Ring
+
+// Feature is a named polygon or point with optional attributes.
+type Feature struct {
+	Name       string
+	Polygon    Polygon
+	Point      *Position
+	Properties map[string]any
+}
+
+// ErrNoFeatures is returned when a file holds nothing importable.
+var ErrNoFeatures = errors.New("no polygons or points found")
+
+// signedArea returns twice the signed planar area of a ring; positive when
+// counter-clockwise.
+func signedArea(r Ring) float64 {
+	var sum float64
+	for i := 0; i+1 < len(r); i++ {
+		sum += r[i][0]*r[i+1][1] - r[i+1][0]*r[i][1]
+	}
+	return sum
+}
+
+// closed returns the ring with its first position repeated at the end.
+func closed(r Ring) Ring {
+	if len(r) > 0 && r[0] != r[len(r)-1] {
+		r = append(r, r[0])
+	}
+	return r
+}
+
+func reversed(r Ring) Ring {
+	out := make(Ring, len(r))
+	for i, p := range r {
+		out[len(r)-1-i] = p
+	}
+	return out
+}
+
+// oriented returns the polygon with the exterior ring counter-clockwise and
+// holes clockwise when ccw is true, or the opposite (Shapefile order).
+func (p Polygon) oriented(ccw bool) Polygon {
+	out := make(Polygon, len(p))
+	for i, r := range p {
+		r = closed(r)
+		wantPositive := (i == 0) == ccw
+		if (signedArea(r) > 0) != wantPositive {
+			r = reversed(r)
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// GeoJSON encodes the polygon as a GeoJSON Polygon geometry.
+func (p Polygon) GeoJSON() []byte {
+	b, _ := json.Marshal(map[string]any{"type": "Polygon", "coordinates": p.oriented(true)})
+	return b
+}
+
+// PointGeoJSON encodes a position as a GeoJSON Point geometry.
+func PointGeoJSON(p Position) []byte {
+	b, _ := json.Marshal(map[string]any{"type": "Point", "coordinates": p})
+	return b
+}
+
+// bounds returns the min x, min y, max x and max y of the positions.
+func bounds(rings ...Ring) [4]float64 {
+	b := [4]float64{}
+	first := true
+	for _, r := range rings {
+		for _, p := range r {
+			if first {
+				b = [4]float64{p[0], p[1], p[0], p[1]}
+				first = false
+				continue
+			}
+			b[0], b[1] = min(b[0], p[0]), min(b[1], p[1])
+			b[2], b[3] = max(b[2], p[0]), max(b[3], p[1])
+		}
+	}
+	return b
+}
+
+// LooksGeographic reports whether every coordinate fits in longitude and
+// latitude ranges, a hint that data without a declared CRS is WGS84.
+func LooksGeographic(features []Feature) bool {
+	for _, f := range features {
+		rings := []Ring(f.Polygon)
+		if f.Point != nil {
+			rings = append(rings, Ring{*f.Point})
+		}
+		b := bounds(rings...)
+		if b[0] < -180 || b[2] > 180 || b[1] < -90 || b[3] > 90 {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPolygons names the parts of a multi-part shape "name (1)", "name (2)"…
+func splitPolygons(name string, polys []Polygon, props map[string]any) []Feature {
+	out := make([]Feature, 0, len(polys))
+	for i, p := range polys {
+		n := name
+		if len(polys) > 1 {
+			n = fmt.Sprintf("%s (%d)", name, i+1)
+		}
+		out = append(out, Feature{Name: n, Polygon: p, Properties: props})
+	}
+	return out
+}
+
+// ParseGeometry decodes a GeoJSON Polygon, MultiPolygon or Point geometry.
+func ParseGeometry(raw json.RawMessage) ([]Polygon, *Position, error) {
+	var g struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if err := json.Unmarshal(raw, &g); err != nil {
+		return nil, nil, err
+	}
+	switch g.Type {
+	case "Polygon":
+		var p Polygon
+		err := json.Unmarshal(g.Coordinates, &p)
+		return []Polygon{p}, nil, err
+	case "MultiPolygon":
+		var ps []Polygon
+		err := json.Unmarshal(g.Coordinates, &ps)
+		return ps, nil, err
+	case "Point":
+		var p Position
+		err := json.Unmarshal(g.Coordinates, &p)
+		return nil, &p, err
+	default:
+		return nil, nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+}
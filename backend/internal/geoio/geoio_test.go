@@ -0,0 +1,118 @@
+package geoio
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// pasture is a counter-clockwise square with a clockwise hole, the RFC 7946
+// orientation.
+var pasture = Polygon{
+	{{-47, -15}, {-46.99, -15}, {-46.99, -14.99}, {-47, -14.99}, {-47, -15}},
+	{{-46.998, -14.998}, {-46.998, -14.992}, {-46.992, -14.992}, {-46.992, -14.998}, {-46.998, -14.998}},
+}
+
+func TestClosed(t *testing.T) {
+	open := Ring{{0, 0}, {1, 0}, {1, 1}}
+	if got := closed(open); len(got) != 4 || got[3] != got[0] {
+		t.Errorf("closed(open) = %v", got)
+	}
+	done := Ring{{0, 0}, {1, 0}, {1, 1}, {0, 0}}
+	if got := closed(done); len(got) != 4 {
+		t.Errorf("closed(closed) = %v, want unchanged", got)
+	}
+	if got := closed(nil); len(got) != 0 {
+		t.Errorf("closed(nil) = %v", got)
+	}
+}
+
+func TestOriented(t *testing.T) {
+	cw := Polygon{reversed(pasture[0]), reversed(pasture[1])}
+	tests := []struct {
+		name string
+		in   Polygon
+		ccw  bool
+		want Polygon
+	}{
+		{"already ccw", pasture, true, pasture},
+		{"cw to ccw", cw, true, pasture},
+		{"ccw to shapefile order", pasture, false, cw},
+		{"unclosed", Polygon{pasture[0][:4]}, true, Polygon{pasture[0]}},
+	}
+	for _, tt := range tests {
+		if got := tt.in.oriented(tt.ccw); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	got := bounds(pasture...)
+	want := [4]float64{-47, -15, -46.99, -14.99}
+	if got != want {
+		t.Errorf("bounds = %v, want %v", got, want)
+	}
+	if got := bounds(); got != [4]float64{} {
+		t.Errorf("bounds() = %v, want zero", got)
+	}
+}
+
+func TestLooksGeographic(t *testing.T) {
+	geo := []Feature{{Polygon: pasture}, {Point: &Position{-47, -15}}}
+	if !LooksGeographic(geo) {
+		t.Error("WGS84 features reported as projected")
+	}
+	utm := []Feature{{Point: &Position{500000, 8340000}}}
+	if LooksGeographic(utm) {
+		t.Error("UTM features reported as geographic")
+	}
+}
+
+func TestParseGeometry(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		polys   int
+		point   bool
+		wantErr bool
+	}{
+		{"polygon", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}`, 1, false, false},
+		{"multipolygon", `{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]],[[[2,2],[3,2],[3,3],[2,2]]]]}`, 2, false, false},
+		{"point", `{"type":"Point","coordinates":[-47,-15]}`, 0, true, false},
+		{"line", `{"type":"LineString","coordinates":[[0,0],[1,1]]}`, 0, false, true},
+		{"not json", `nope`, 0, false, true},
+	}
+	for _, tt := range tests {
+		polys, point, err := ParseGeometry(json.RawMessage(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		if len(polys) != tt.polys || (point != nil) != tt.point {
+			t.Errorf("%s: %d polygons, point %v", tt.name, len(polys), point)
+		}
+	}
+}
+
+func TestPolygonGeoJSONRoundTrip(t *testing.T) {
+	cw := Polygon{reversed(pasture[0]), reversed(pasture[1])}
+	polys, _, err := ParseGeometry(cw.GeoJSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(polys, []Polygon{pasture}) {
+		t.Errorf("GeoJSON round trip = %v, want %v", polys, pasture)
+	}
+}
+
+func TestSplitPolygons(t *testing.T) {
+	one := splitPolygons("Retiro", []Polygon{pasture}, nil)
+	if len(one) != 1 || one[0].Name != "Retiro" {
+		t.Errorf("single part = %+v", one)
+	}
+	two := splitPolygons("Retiro", []Polygon{pasture, pasture}, nil)
+	if len(two) != 2 || two[0].Name != "Retiro (1)" || two[1].Name != "Retiro (2)" {
+		t.Errorf("two parts = %v, %v", two[0].Name, two[1].Name)
+	}
+}
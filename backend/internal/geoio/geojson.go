@@ -0,0 +1,80 @@
+package geoio
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+type geoJSONDoc struct {
+	Type       string          `json:"type"`
+	Geometry   json.RawMessage `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+	Features   []geoJSONDoc    `json:"features"`
+	CRS        *struct {
+		Properties struct {
+			Name string `json:"name"`
+		} `json:"properties"`
+	} `json:"crs"`
+}
+
+var epsgName = regexp.MustCompile(`EPSG:+(\d+)$`)
+
+// ReadGeoJSON reads a FeatureCollection, Feature or bare geometry. The SRID
+// comes from a legacy "crs" member when present and is 4326 otherwise, as
+// RFC 7946 requires.
+func ReadGeoJSON(data []byte) ([]Feature, int, error) {
+	var doc geoJSONDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+	srid := 4326
+	if doc.CRS != nil {
+		m := epsgName.FindStringSubmatch(doc.CRS.Properties.Name)
+		if m == nil {
+			if doc.CRS.Properties.Name != "urn:ogc:def:crs:OGC:1.3:CRS84" {
+				return nil, 0, fmt.Errorf("unsupported crs %q", doc.CRS.Properties.Name)
+			}
+		} else {
+			srid, _ = strconv.Atoi(m[1])
+		}
+	}
+
+	var docs []geoJSONDoc
+	switch doc.Type {
+	case "FeatureCollection":
+		docs = doc.Features
+	case "Feature":
+		docs = []geoJSONDoc{doc}
+	default:
+		docs = []geoJSONDoc{{Type: "Feature", Geometry: data}}
+	}
+
+	features := []Feature{}
+	for i, d := range docs {
+		if len(d.Geometry) == 0 || string(d.Geometry) == "null" {
+			continue
+		}
+		name := fmt.Sprintf("Feature %d", i+1)
+		for _, key := range []string{"name", "Name", "NAME", "nome", "NOME", "title"} {
+			if v, ok := d.Properties[key].(string); ok && v != "" {
+				name = v
+				break
+			}
+		}
+		polys, point, err := ParseGeometry(d.Geometry)
+		if err != nil {
+			continue
+		}
+		if point != nil {
+			features = append(features, Feature{Name: name, Point: point, Properties: d.Properties})
+			continue
+		}
+		features = append(features, splitPolygons(name, polys, d.Properties)...)
+	}
+	if len(features) == 0 {
+		return nil, 0, ErrNoFeatures
+	}
+	return features, srid, nil
+}
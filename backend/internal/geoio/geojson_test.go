@@ -0,0 +1,80 @@
+package geoio
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReadGeoJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		names   []string
+		srid    int
+		wantErr error
+	}{
+		{
+			name: "feature collection",
+			in: `{"type":"FeatureCollection","features":[
+				{"type":"Feature","properties":{"nome":"Piquete 1"},"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}},
+				{"type":"Feature","properties":{},"geometry":{"type":"Point","coordinates":[0.5,0.5]}},
+				{"type":"Feature","properties":{"name":"Sem geometria"},"geometry":null}]}`,
+			names: []string{"Piquete 1", "Feature 2"},
+			srid:  4326,
+		},
+		{
+			name:  "bare multipolygon",
+			in:    `{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]],[[[2,2],[3,2],[3,3],[2,2]]]]}`,
+			names: []string{"Feature 1 (1)", "Feature 1 (2)"},
+			srid:  4326,
+		},
+		{
+			name: "legacy crs",
+			in: `{"type":"Feature","crs":{"type":"name","properties":{"name":"urn:ogc:def:crs:EPSG::31983"}},
+				"properties":{"NAME":"Sede"},"geometry":{"type":"Point","coordinates":[500000,8340000]}}`,
+			names: []string{"Sede"},
+			srid:  31983,
+		},
+		{
+			name: "CRS84",
+			in: `{"type":"Feature","crs":{"type":"name","properties":{"name":"urn:ogc:def:crs:OGC:1.3:CRS84"}},
+				"properties":{},"geometry":{"type":"Point","coordinates":[-47,-15]}}`,
+			names: []string{"Feature 1"},
+			srid:  4326,
+		},
+		{
+			name:    "only lines",
+			in:      `{"type":"Feature","properties":{},"geometry":{"type":"LineString","coordinates":[[0,0],[1,1]]}}`,
+			wantErr: ErrNoFeatures,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			features, srid, err := ReadGeoJSON([]byte(tt.in))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if srid != tt.srid {
+				t.Errorf("srid = %d, want %d", srid, tt.srid)
+			}
+			if len(features) != len(tt.names) {
+				t.Fatalf("%d features, want %d", len(features), len(tt.names))
+			}
+			for i, f := range features {
+				if f.Name != tt.names[i] {
+					t.Errorf("feature %d name = %q, want %q", i, f.Name, tt.names[i])
+				}
+			}
+		})
+	}
+
+	if _, _, err := ReadGeoJSON([]byte(`{"type":"Feature","crs":{"properties":{"name":"EPSG-ish"}}}`)); err == nil {
+		t.Error("unsupported crs accepted")
+	}
+}
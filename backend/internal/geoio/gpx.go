@@ -0,0 +1,121 @@
+package geoio
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// =============================================
+// GPX
+// =============================================
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Name string  `xml:"name"`
+}
+
+type gpxDoc struct {
+	Waypoints []gpxPoint `xml:"wpt"`
+	Routes    []struct {
+		Name   string     `xml:"name"`
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+func gpxRing(points []gpxPoint) Ring {
+	r := make(Ring, len(points))
+	for i, p := range points {
+		r[i] = Position{p.Lon, p.Lat}
+	}
+	return r
+}
+
+// ReadGPX reads waypoints as points, and tracks and routes walked around a
+// boundary as polygons (each track segment or route is closed). Paths with
+// fewer than three points are skipped.
+func ReadGPX(data []byte) ([]Feature, error) {
+	var doc gpxDoc
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid GPX: %w", err)
+	}
+	features := []Feature{}
+	var polys []Polygon
+	add := func(name string, points []gpxPoint) {
+		if len(points) >= 3 {
+			polys = append(polys, Polygon{closed(gpxRing(points))})
+		}
+	}
+	for i, t := range doc.Tracks {
+		polys = nil
+		for _, seg := range t.Segments {
+			add(t.Name, seg.Points)
+		}
+		name := strings.TrimSpace(t.Name)
+		if name == "" {
+			name = fmt.Sprintf("Track %d", i+1)
+		}
+		features = append(features, splitPolygons(name, polys, nil)...)
+	}
+	for i, rt := range doc.Routes {
+		polys = nil
+		add(rt.Name, rt.Points)
+		name := strings.TrimSpace(rt.Name)
+		if name == "" {
+			name = fmt.Sprintf("Route %d", i+1)
+		}
+		features = append(features, splitPolygons(name, polys, nil)...)
+	}
+	for i, wp := range doc.Waypoints {
+		name := strings.TrimSpace(wp.Name)
+		if name == "" {
+			name = fmt.Sprintf("Waypoint %d", i+1)
+		}
+		p := Position{wp.Lon, wp.Lat}
+		features = append(features, Feature{Name: name, Point: &p})
+	}
+	if len(features) == 0 {
+		return nil, ErrNoFeatures
+	}
+	return features, nil
+}
+
+// WriteGPX writes WGS84 points as waypoints and the exterior ring of each
+// polygon as a closed track, since GPX has no area type.
+func WriteGPX(w io.Writer, name string, features []Feature) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<gpx version="1.1" creator="cowpro" xmlns="http://www.topografix.com/GPX/1/1">` + "\n")
+	b.WriteString("<metadata><name>" + kmlEscape(name) + "</name></metadata>\n")
+	coord := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, f := range features {
+		if f.Point == nil {
+			continue
+		}
+		fmt.Fprintf(&b, `<wpt lat="%s" lon="%s"><name>%s</name></wpt>`+"\n",
+			coord(f.Point[1]), coord(f.Point[0]), kmlEscape(f.Name))
+	}
+	for _, f := range features {
+		if len(f.Polygon) == 0 {
+			continue
+		}
+		b.WriteString("<trk><name>" + kmlEscape(f.Name) + "</name><trkseg>")
+		for _, p := range f.Polygon.oriented(true)[0] {
+			fmt.Fprintf(&b, `<trkpt lat="%s" lon="%s"/>`, coord(p[1]), coord(p[0]))
+		}
+		b.WriteString("</trkseg></trk>\n")
+	}
+	b.WriteString("</gpx>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
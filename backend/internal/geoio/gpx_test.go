@@ -0,0 +1,73 @@
+package geoio
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReadGPX(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+  <wpt lat="-15" lon="-47"><name>Porteira</name></wpt>
+  <wpt lat="-15.1" lon="-47.1"></wpt>
+  <trk><name>Cerca</name>
+    <trkseg><trkpt lat="0" lon="0"/><trkpt lat="0" lon="1"/><trkpt lat="1" lon="1"/></trkseg>
+    <trkseg><trkpt lat="2" lon="2"/><trkpt lat="2" lon="3"/><trkpt lat="3" lon="3"/></trkseg>
+  </trk>
+  <trk><trkseg><trkpt lat="0" lon="0"/><trkpt lat="1" lon="1"/></trkseg></trk>
+  <rte><rtept lat="0" lon="0"/><rtept lat="0" lon="1"/><rtept lat="1" lon="1"/><rtept lat="0" lon="0"/></rte>
+</gpx>`
+	features, err := ReadGPX([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range features {
+		names = append(names, f.Name)
+	}
+	want := []string{"Cerca (1)", "Cerca (2)", "Route 1", "Porteira", "Waypoint 2"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+	// Tracks are closed; already closed routes are left alone
+	if r := features[0].Polygon[0]; len(r) != 4 || r[0] != r[3] || r[1] != (Position{1, 0}) {
+		t.Errorf("track ring = %v", r)
+	}
+	if r := features[2].Polygon[0]; len(r) != 4 {
+		t.Errorf("route ring = %v", r)
+	}
+	if p := features[3].Point; *p != (Position{-47, -15}) {
+		t.Errorf("waypoint = %v", p)
+	}
+
+	if _, err := ReadGPX([]byte(`<gpx></gpx>`)); err != ErrNoFeatures {
+		t.Errorf("empty gpx: err = %v, want ErrNoFeatures", err)
+	}
+}
+
+func TestGPXRoundTrip(t *testing.T) {
+	gate := Position{-46.995, -15}
+	in := []Feature{
+		{Name: "Piquete & Sede", Polygon: pasture},
+		{Name: "Porteira", Point: &gate},
+	}
+	var buf bytes.Buffer
+	if err := WriteGPX(&buf, "Fazenda", in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ReadGPX(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("%d features, want 2", len(out))
+	}
+	// GPX has no holes: only the exterior ring survives
+	if out[0].Name != "Piquete & Sede" || !reflect.DeepEqual(out[0].Polygon, Polygon{pasture[0]}) {
+		t.Errorf("track = %q %v", out[0].Name, out[0].Polygon)
+	}
+	if out[1].Name != "Porteira" || *out[1].Point != gate {
+		t.Errorf("waypoint = %q %v", out[1].Name, out[1].Point)
+	}
+}
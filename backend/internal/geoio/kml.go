@@ -0,0 +1,234 @@
+package geoio
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// =============================================
+// KML / KMZ
+// =============================================
+
+type kmlPolygon struct {
+	Outer string   `xml:"outerBoundaryIs>LinearRing>coordinates"`
+	Inner []string `xml:"innerBoundaryIs>LinearRing>coordinates"`
+}
+
+type kmlGeometries struct {
+	Polygons []kmlPolygon    `xml:"Polygon"`
+	Points   []string        `xml:"Point>coordinates"`
+	Multi    []kmlGeometries `xml:"MultiGeometry"`
+}
+
+type kmlPlacemark struct {
+	Name string `xml:"name"`
+	kmlGeometries
+}
+
+// parseCoordinates reads a KML coordinates string: whitespace separated
+// "lon,lat[,alt]" tuples.
+func parseCoordinates(s string) (Ring, error) {
+	var r Ring
+	for _, tuple := range strings.Fields(s) {
+		parts := strings.Split(tuple, ",")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid coordinate %q", tuple)
+		}
+		x, err1 := strconv.ParseFloat(parts[0], 64)
+		y, err2 := strconv.ParseFloat(parts[1], 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid coordinate %q", tuple)
+		}
+		r = append(r, Position{x, y})
+	}
+	return r, nil
+}
+
+func (g kmlGeometries) collect() ([]Polygon, []Position, error) {
+	var polys []Polygon
+	var points []Position
+	for _, kp := range g.Polygons {
+		outer, err := parseCoordinates(kp.Outer)
+		if err != nil {
+			return nil, nil, err
+		}
+		p := Polygon{outer}
+		for _, in := range kp.Inner {
+			hole, err := parseCoordinates(in)
+			if err != nil {
+				return nil, nil, err
+			}
+			p = append(p, hole)
+		}
+		polys = append(polys, p)
+	}
+	for _, c := range g.Points {
+		r, err := parseCoordinates(c)
+		if err != nil || len(r) == 0 {
+			return nil, nil, fmt.Errorf("invalid point %q", c)
+		}
+		points = append(points, r[0])
+	}
+	for _, m := range g.Multi {
+		ps, pts, err := m.collect()
+		if err != nil {
+			return nil, nil, err
+		}
+		polys = append(polys, ps...)
+		points = append(points, pts...)
+	}
+	return polys, points, nil
+}
+
+// ReadKML reads every Placemark of a KML document, at any folder depth.
+// KML coordinates are always WGS84.
+func ReadKML(data []byte) ([]Feature, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	features := []Feature{}
+	n := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid KML: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" {
+			continue
+		}
+		var pm kmlPlacemark
+		if err := dec.DecodeElement(&pm, &start); err != nil {
+			return nil, fmt.Errorf("invalid KML placemark: %w", err)
+		}
+		n++
+		name := strings.TrimSpace(pm.Name)
+		if name == "" {
+			name = fmt.Sprintf("Placemark %d", n)
+		}
+		polys, points, err := pm.collect()
+		if err != nil {
+			return nil, fmt.Errorf("placemark %q: %w", name, err)
+		}
+		features = append(features, splitPolygons(name, polys, nil)...)
+		for _, p := range points {
+			features = append(features, Feature{Name: name, Point: &p})
+		}
+	}
+	if len(features) == 0 {
+		return nil, ErrNoFeatures
+	}
+	return features, nil
+}
+
+// ReadKMZ reads the main KML document of a KMZ archive (doc.kml, or the
+// first .kml file at the top level).
+func ReadKMZ(data []byte) ([]Feature, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid KMZ: %w", err)
+	}
+	var doc *zip.File
+	for _, f := range zr.File {
+		if !strings.EqualFold(f.Name[max(0, len(f.Name)-4):], ".kml") {
+			continue
+		}
+		if doc == nil || strings.EqualFold(f.Name, "doc.kml") ||
+			strings.Count(f.Name, "/") < strings.Count(doc.Name, "/") {
+			doc = f
+		}
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("KMZ contains no .kml document")
+	}
+	rc, err := doc.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	kml, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return ReadKML(kml)
+}
+
+func writeKMLCoordinates(b *strings.Builder, r Ring) {
+	b.WriteString("<coordinates>")
+	for i, p := range r {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatFloat(p[0], 'f', -1, 64))
+		b.WriteByte(',')
+		b.WriteString(strconv.FormatFloat(p[1], 'f', -1, 64))
+	}
+	b.WriteString("</coordinates>")
+}
+
+func kmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// WriteKML writes WGS84 features as a KML document with one folder per
+// group, in the order given. Properties become ExtendedData.
+func WriteKML(w io.Writer, name string, folders []string, groups map[string][]Feature) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2"><Document>`)
+	b.WriteString("<name>" + kmlEscape(name) + "</name>\n")
+	for _, folder := range folders {
+		b.WriteString("<Folder><name>" + kmlEscape(folder) + "</name>\n")
+		for _, f := range groups[folder] {
+			b.WriteString("<Placemark><name>" + kmlEscape(f.Name) + "</name>")
+			if len(f.Properties) > 0 {
+				keys := make([]string, 0, len(f.Properties))
+				for k := range f.Properties {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				b.WriteString("<ExtendedData>")
+				for _, k := range keys {
+					v := f.Properties[k]
+					if v == nil {
+						continue
+					}
+					fmt.Fprintf(&b, `<Data name="%s"><value>%s</value></Data>`,
+						kmlEscape(k), kmlEscape(fmt.Sprint(v)))
+				}
+				b.WriteString("</ExtendedData>")
+			}
+			switch {
+			case f.Point != nil:
+				b.WriteString("<Point>")
+				writeKMLCoordinates(&b, Ring{*f.Point})
+				b.WriteString("</Point>")
+			case len(f.Polygon) > 0:
+				p := f.Polygon.oriented(true)
+				b.WriteString("<Polygon><outerBoundaryIs><LinearRing>")
+				writeKMLCoordinates(&b, p[0])
+				b.WriteString("</LinearRing></outerBoundaryIs>")
+				for _, hole := range p[1:] {
+					b.WriteString("<innerBoundaryIs><LinearRing>")
+					writeKMLCoordinates(&b, hole)
+					b.WriteString("</LinearRing></innerBoundaryIs>")
+				}
+				b.WriteString("</Polygon>")
+			}
+			b.WriteString("</Placemark>\n")
+		}
+		b.WriteString("</Folder>\n")
+	}
+	b.WriteString("</Document></kml>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,124 @@
+package geoio
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCoordinates(t *testing.T) {
+	got, err := parseCoordinates("\n  -47,-15,0 -46.99,-15\t-46.99,-14.99,12.5\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Ring{{-47, -15}, {-46.99, -15}, {-46.99, -14.99}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, bad := range []string{"-47", "a,b", "-47,-15 x,1"} {
+		if _, err := parseCoordinates(bad); err == nil {
+			t.Errorf("parseCoordinates(%q) accepted", bad)
+		}
+	}
+}
+
+func TestReadKML(t *testing.T) {
+	doc := `<?xml version="1.0"?>
+<kml xmlns="http://www.opengis.net/kml/2.2"><Document><Folder><Folder>
+  <Placemark><name> Piquete 1 </name><Polygon><outerBoundaryIs><LinearRing>
+    <coordinates>0,0,0 1,0,0 1,1,0 0,0,0</coordinates></LinearRing></outerBoundaryIs>
+    <innerBoundaryIs><LinearRing><coordinates>0.2,0.1 0.8,0.1 0.8,0.5 0.2,0.1</coordinates></LinearRing></innerBoundaryIs>
+  </Polygon></Placemark>
+</Folder></Folder>
+<Placemark><name>Retiro</name><MultiGeometry>
+  <Polygon><outerBoundaryIs><LinearRing><coordinates>0,0 1,0 1,1 0,0</coordinates></LinearRing></outerBoundaryIs></Polygon>
+  <MultiGeometry><Polygon><outerBoundaryIs><LinearRing><coordinates>2,2 3,2 3,3 2,2</coordinates></LinearRing></outerBoundaryIs></Polygon></MultiGeometry>
+</MultiGeometry></Placemark>
+<Placemark><Point><coordinates>-47,-15</coordinates></Point></Placemark>
+<Placemark><name>Linha</name><LineString><coordinates>0,0 1,1</coordinates></LineString></Placemark>
+</Document></kml>`
+	features, err := ReadKML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range features {
+		names = append(names, f.Name)
+	}
+	want := []string{"Piquete 1", "Retiro (1)", "Retiro (2)", "Placemark 3"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("names = %v, want %v", names, want)
+	}
+	if len(features[0].Polygon) != 2 {
+		t.Errorf("Piquete 1 has %d rings, want exterior and hole", len(features[0].Polygon))
+	}
+	if p := features[3].Point; p == nil || *p != (Position{-47, -15}) {
+		t.Errorf("point = %v", p)
+	}
+
+	if _, err := ReadKML([]byte(`<kml><Document></Document></kml>`)); err != ErrNoFeatures {
+		t.Errorf("empty document: err = %v, want ErrNoFeatures", err)
+	}
+	if _, err := ReadKML([]byte(`<kml><Placemark><Point><coordinates>x</coordinates></Point></Placemark></kml>`)); err == nil {
+		t.Error("invalid point accepted")
+	}
+}
+
+func TestKMLRoundTrip(t *testing.T) {
+	point := Position{-46.995, -14.995}
+	groups := map[string][]Feature{
+		"Zonas":  {{Name: "Piquete <1> & 2", Polygon: pasture, Properties: map[string]any{"area_ha": 12.5, "grupo": nil}}},
+		"Pontos": {{Name: "Bebedouro", Point: &point}},
+	}
+	var buf bytes.Buffer
+	if err := WriteKML(&buf, "Fazenda", []string{"Zonas", "Pontos"}, groups); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `<Data name="area_ha"><value>12.5</value></Data>`) {
+		t.Error("properties not written as ExtendedData")
+	}
+	features, err := ReadKML(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 2 {
+		t.Fatalf("%d features, want 2", len(features))
+	}
+	if features[0].Name != "Piquete <1> & 2" || !reflect.DeepEqual(features[0].Polygon, pasture) {
+		t.Errorf("polygon = %q %v", features[0].Name, features[0].Polygon)
+	}
+	if features[1].Name != "Bebedouro" || *features[1].Point != point {
+		t.Errorf("point = %q %v", features[1].Name, features[1].Point)
+	}
+}
+
+func TestReadKMZ(t *testing.T) {
+	kml := func(name string) string {
+		return `<kml><Placemark><name>` + name + `</name><Point><coordinates>1,2</coordinates></Point></Placemark></kml>`
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"files/overlay.kml": kml("overlay"),
+		"doc.kml":           kml("main"),
+		"files/icon.png":    "png",
+	} {
+		w, _ := zw.Create(name)
+		_, _ = w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	features, err := ReadKMZ(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 1 || features[0].Name != "main" {
+		t.Errorf("features = %+v, want the doc.kml placemark", features)
+	}
+	if _, err := ReadKMZ([]byte("not a zip")); err == nil {
+		t.Error("invalid archive accepted")
+	}
+}
@@ -0,0 +1,296 @@
+package geoio
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strings"
+)
+
+// =============================================
+// ESRI SHAPEFILE (ZIPPED)
+// =============================================
+
+const (
+	shpNull     = 0
+	shpPoint    = 1
+	shpPolygon  = 5
+	shpPointZ   = 11
+	shpPolygonZ = 15
+	shpPointM   = 21
+	shpPolygonM = 25
+)
+
+// WGS84PRJ is the ESRI WKT written as the .prj of exported shapefiles.
+const WGS84PRJ = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+// Layer is a named set of features written as one shapefile.
+type Layer struct {
+	Name     string
+	Features []Feature
+}
+
+// ReadShapefileZip reads every shapefile in a zip archive. Names come from
+// the .dbf attribute table and the returned prj is the WKT of the
+// coordinate system, empty when the archive has no .prj. All layers must
+// share one coordinate system.
+func ReadShapefileZip(data []byte) ([]Feature, string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid zip archive: %w", err)
+	}
+	files := map[string][]byte{}
+	var bases []string
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(path.Base(f.Name), "._") {
+			continue
+		}
+		ext := strings.ToLower(path.Ext(f.Name))
+		switch ext {
+		case ".shp", ".dbf", ".prj", ".cpg":
+		default:
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, "", err
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, "", err
+		}
+		base := strings.TrimSuffix(f.Name, path.Ext(f.Name))
+		files[base+ext] = content
+		if ext == ".shp" {
+			bases = append(bases, base)
+		}
+	}
+	if len(bases) == 0 {
+		return nil, "", fmt.Errorf("zip contains no .shp file")
+	}
+	sort.Strings(bases)
+
+	features := []Feature{}
+	prj, prjSet := "", false
+	for _, base := range bases {
+		layerPrj := strings.TrimSpace(string(files[base+".prj"]))
+		if prjSet && layerPrj != prj {
+			return nil, "", fmt.Errorf("layers use different coordinate systems")
+		}
+		prj, prjSet = layerPrj, true
+
+		var records []map[string]any
+		if dbf, ok := files[base+".dbf"]; ok {
+			cpg := strings.ToUpper(strings.TrimSpace(string(files[base+".cpg"])))
+			records, err = readDBF(dbf, cpg == "UTF-8" || cpg == "UTF8" || cpg == "65001")
+			if err != nil {
+				return nil, "", fmt.Errorf("%s.dbf: %w", path.Base(base), err)
+			}
+		}
+		layer, err := readShp(files[base+".shp"], records, path.Base(base))
+		if err != nil {
+			return nil, "", fmt.Errorf("%s.shp: %w", path.Base(base), err)
+		}
+		features = append(features, layer...)
+	}
+	if len(features) == 0 {
+		return nil, "", ErrNoFeatures
+	}
+	return features, prj, nil
+}
+
+// readShp reads polygon and point records. In a shapefile each clockwise
+// ring starts a new polygon and the counter-clockwise rings after it are
+// its holes.
+func readShp(data []byte, records []map[string]any, layer string) ([]Feature, error) {
+	if len(data) < 100 || binary.BigEndian.Uint32(data[0:4]) != 9994 {
+		return nil, fmt.Errorf("not a shapefile")
+	}
+	features := []Feature{}
+	for off, n := 100, 0; off+8 <= len(data); n++ {
+		contentLen := int(binary.BigEndian.Uint32(data[off+4:off+8])) * 2
+		off += 8
+		if off+contentLen > len(data) || contentLen < 4 {
+			return nil, fmt.Errorf("record %d is truncated", n+1)
+		}
+		rec := data[off : off+contentLen]
+		off += contentLen
+
+		var props map[string]any
+		name := ""
+		if n < len(records) {
+			props = records[n]
+			name = recordName(props)
+		}
+		if name == "" {
+			name = fmt.Sprintf("%s %d", layer, n+1)
+		}
+
+		le := binary.LittleEndian
+		switch int(le.Uint32(rec[0:4])) {
+		case shpNull:
+			continue
+		case shpPoint, shpPointZ, shpPointM:
+			if len(rec) < 20 {
+				return nil, fmt.Errorf("record %d is truncated", n+1)
+			}
+			p := Position{
+				math.Float64frombits(le.Uint64(rec[4:12])),
+				math.Float64frombits(le.Uint64(rec[12:20])),
+			}
+			features = append(features, Feature{Name: name, Point: &p, Properties: props})
+		case shpPolygon, shpPolygonZ, shpPolygonM:
+			if len(rec) < 44 {
+				return nil, fmt.Errorf("record %d is truncated", n+1)
+			}
+			numParts := int(le.Uint32(rec[36:40]))
+			numPoints := int(le.Uint32(rec[40:44]))
+			pointsOff := 44 + 4*numParts
+			if pointsOff+16*numPoints > len(rec) {
+				return nil, fmt.Errorf("record %d is truncated", n+1)
+			}
+			var polys []Polygon
+			for part := 0; part < numParts; part++ {
+				start := int(le.Uint32(rec[44+4*part:]))
+				end := numPoints
+				if part+1 < numParts {
+					end = int(le.Uint32(rec[44+4*(part+1):]))
+				}
+				if start < 0 || end > numPoints || start >= end {
+					return nil, fmt.Errorf("record %d has invalid parts", n+1)
+				}
+				ring := make(Ring, 0, end-start)
+				for i := start; i < end; i++ {
+					p := pointsOff + 16*i
+					ring = append(ring, Position{
+						math.Float64frombits(le.Uint64(rec[p : p+8])),
+						math.Float64frombits(le.Uint64(rec[p+8 : p+16])),
+					})
+				}
+				if signedArea(ring) <= 0 || len(polys) == 0 {
+					polys = append(polys, Polygon{ring})
+				} else {
+					polys[len(polys)-1] = append(polys[len(polys)-1], ring)
+				}
+			}
+			features = append(features, splitPolygons(name, polys, props)...)
+		default:
+			return nil, fmt.Errorf("unsupported shape type %d; only polygons and points can be imported",
+				le.Uint32(rec[0:4]))
+		}
+	}
+	return features, nil
+}
+
+// WriteShapefileZip writes each layer as WGS84 shapefiles (.shp, .shx,
+// .dbf, .prj and .cpg) into one zip archive. A layer holds either polygons
+// or points; features of the other kind are left out.
+func WriteShapefileZip(w io.Writer, layers []Layer) error {
+	zw := zip.NewWriter(w)
+	for _, l := range layers {
+		shp, shx, features := writeShp(l.Features)
+		for _, f := range []struct {
+			ext  string
+			data []byte
+		}{
+			{".shp", shp},
+			{".shx", shx},
+			{".dbf", writeDBF(features)},
+			{".prj", []byte(WGS84PRJ)},
+			{".cpg", []byte("UTF-8")},
+		} {
+			fw, err := zw.Create(l.Name + f.ext)
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(f.data); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// writeShp encodes the features as a polygon or point shapefile, depending
+// on the first feature, and returns the .shp and .shx contents with the
+// features actually written.
+func writeShp(features []Feature) ([]byte, []byte, []Feature) {
+	points := len(features) > 0 && features[0].Point != nil
+	shapeType := shpPolygon
+	if points {
+		shapeType = shpPoint
+	}
+
+	var body, index bytes.Buffer
+	var written []Feature
+	var all []Ring
+	le := binary.LittleEndian
+	putF := func(b *bytes.Buffer, v float64) { _ = binary.Write(b, le, v) }
+	putI := func(b *bytes.Buffer, v int) { _ = binary.Write(b, le, int32(v)) }
+	for _, f := range features {
+		var rec bytes.Buffer
+		putI(&rec, shapeType)
+		if points {
+			if f.Point == nil {
+				continue
+			}
+			putF(&rec, f.Point[0])
+			putF(&rec, f.Point[1])
+			all = append(all, Ring{*f.Point})
+		} else {
+			if len(f.Polygon) == 0 {
+				continue
+			}
+			p := f.Polygon.oriented(false)
+			bb := bounds(p...)
+			for _, v := range bb {
+				putF(&rec, v)
+			}
+			total := 0
+			for _, r := range p {
+				total += len(r)
+			}
+			putI(&rec, len(p))
+			putI(&rec, total)
+			start := 0
+			for _, r := range p {
+				putI(&rec, start)
+				start += len(r)
+			}
+			for _, r := range p {
+				for _, pos := range r {
+					putF(&rec, pos[0])
+					putF(&rec, pos[1])
+				}
+			}
+			all = append(all, p...)
+		}
+		written = append(written, f)
+		_ = binary.Write(&index, binary.BigEndian, int32((100+body.Len())/2))
+		_ = binary.Write(&index, binary.BigEndian, int32(rec.Len()/2))
+		_ = binary.Write(&body, binary.BigEndian, int32(len(written)))
+		_ = binary.Write(&body, binary.BigEndian, int32(rec.Len()/2))
+		body.Write(rec.Bytes())
+	}
+
+	header := func(length int) []byte {
+		h := make([]byte, 100)
+		binary.BigEndian.PutUint32(h[0:], 9994)
+		binary.BigEndian.PutUint32(h[24:], uint32(length/2))
+		le.PutUint32(h[28:], 1000)
+		le.PutUint32(h[32:], uint32(shapeType))
+		for i, v := range bounds(all...) {
+			le.PutUint64(h[36+8*i:], math.Float64bits(v))
+		}
+		return h
+	}
+	shp := append(header(100+body.Len()), body.Bytes()...)
+	shx := append(header(100+index.Len()), index.Bytes()...)
+	return shp, shx, written
+}
@@ -0,0 +1,119 @@
+package geoio
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShapefileRoundTrip(t *testing.T) {
+	trough := Position{-46.995, -14.995}
+	second := Polygon{{{-46.99, -15}, {-46.98, -15}, {-46.98, -14.99}, {-46.99, -15}}}
+	layers := []Layer{
+		{Name: "zonas", Features: []Feature{
+			{Name: "Piquete 1", Polygon: pasture, Properties: map[string]any{"area_ha": 12.5, "isolation": true}},
+			{Name: "Piquete 2", Polygon: second, Properties: map[string]any{"area_ha": 3}},
+			{Name: "stray point", Point: &trough},
+		}},
+		{Name: "pontos", Features: []Feature{
+			{Name: "Bebedouro", Point: &trough, Properties: map[string]any{"category": "water"}},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := WriteShapefileZip(&buf, layers); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{}
+	for _, f := range zr.File {
+		files = append(files, f.Name)
+	}
+	for _, want := range []string{"zonas.shp", "zonas.shx", "zonas.dbf", "zonas.prj", "zonas.cpg", "pontos.shp"} {
+		if !strings.Contains(strings.Join(files, " "), want) {
+			t.Errorf("archive lacks %s: %v", want, files)
+		}
+	}
+
+	features, prj, err := ReadShapefileZip(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prj != WGS84PRJ {
+		t.Errorf("prj = %q", prj)
+	}
+	// Layers are read in name order; the point in the polygon layer is dropped
+	if len(features) != 3 {
+		t.Fatalf("%d features, want 3", len(features))
+	}
+	bebedouro, p1, p2 := features[0], features[1], features[2]
+	if bebedouro.Name != "Bebedouro" || *bebedouro.Point != trough || bebedouro.Properties["CATEGORY"] != "water" {
+		t.Errorf("point = %+v", bebedouro)
+	}
+	if p1.Name != "Piquete 1" || len(p1.Polygon) != 2 {
+		t.Fatalf("polygon 1 = %q with %d rings", p1.Name, len(p1.Polygon))
+	}
+	// Shapefile order (exterior clockwise) converts back to RFC 7946 order
+	if got := p1.Polygon.oriented(true); !reflect.DeepEqual(got, pasture) {
+		t.Errorf("polygon 1 = %v, want %v", got, pasture)
+	}
+	if p1.Properties["AREA_HA"] != 12.5 || p1.Properties["ISOLATION"] != true {
+		t.Errorf("polygon 1 properties = %v", p1.Properties)
+	}
+	if p2.Name != "Piquete 2" || p2.Properties["AREA_HA"] != 3.0 {
+		t.Errorf("polygon 2 = %q %v", p2.Name, p2.Properties)
+	}
+	if _, ok := p2.Properties["ISOLATION"]; ok {
+		t.Errorf("unset logical read as %v", p2.Properties["ISOLATION"])
+	}
+}
+
+func TestReadShapefileZipErrors(t *testing.T) {
+	zipOf := func(files map[string][]byte) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, data := range files {
+			w, _ := zw.Create(name)
+			_, _ = w.Write(data)
+		}
+		_ = zw.Close()
+		return buf.Bytes()
+	}
+	shp, _, _ := writeShp([]Feature{{Name: "a", Polygon: pasture}})
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"not a zip", []byte("nope"), "invalid zip"},
+		{"no shp", zipOf(map[string][]byte{"a.dbf": writeDBF(nil)}), "no .shp"},
+		{"not a shapefile", zipOf(map[string][]byte{"a.shp": make([]byte, 100)}), "not a shapefile"},
+		{"truncated", zipOf(map[string][]byte{"a.shp": shp[:len(shp)-10]}), "truncated"},
+		{"mixed crs", zipOf(map[string][]byte{
+			"a.shp": shp, "a.prj": []byte(WGS84PRJ),
+			"b.shp": shp, "b.prj": []byte(`PROJCS["SIRGAS 2000 / UTM zone 23S"]`),
+		}), "different coordinate systems"},
+	}
+	for _, tt := range tests {
+		_, _, err := ReadShapefileZip(tt.data)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestReadShpNamesWithoutDBF(t *testing.T) {
+	shp, _, _ := writeShp([]Feature{{Polygon: pasture}, {Polygon: pasture}})
+	features, err := readShp(shp, nil, "talhoes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 2 || features[0].Name != "talhoes 1" || features[1].Name != "talhoes 2" {
+		t.Errorf("features = %v, %v", features[0].Name, features[1].Name)
+	}
+}
@@ -0,0 +1,172 @@
+package grazing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/iot"
+	"github.com/gabrielrondon/cowpro/internal/zone"
+)
+
+// =============================================
+// GRAZING AND CAPACITY ALERTS
+// =============================================
+
+// RaiseZoneAlert inserts an alert about a zone unless one of the same type
+// was already raised for it since the given time, so each occupation is
+// reported once even after the alert is read.
+func RaiseZoneAlert(ctx context.Context, q db.DBTX, farmID, zoneID uuid.UUID, alertType, severity, message string, since time.Time) (bool, error) {
+	tag, err := q.Exec(ctx, `
+		INSERT INTO alerts (id, farm_id, zone_id, type, severity, message)
+		SELECT $1, $2, $3, $4, $5, $6
+		WHERE NOT EXISTS (
+			SELECT 1 FROM alerts
+			WHERE farm_id=$2 AND zone_id=$3 AND type=$4 AND created_at >= $7
+		)`, uuid.New(), farmID, zoneID, alertType, severity, message, since)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// CheckGrazing raises grazing_overstay alerts for zones occupied longer than
+// their grass type's occupation period and early_reentry alerts for zones
+// re-entered before resting for its rest period.
+func CheckGrazing(ctx context.Context, q db.DBTX, farmID uuid.UUID) error {
+	params, err := LoadParameters(ctx, q, farmID)
+	if err != nil {
+		return err
+	}
+	occ, err := zone.Occupations(ctx, q, farmID, nil)
+	if err != nil {
+		return err
+	}
+	rows, err := q.Query(ctx, `
+		SELECT id, name, grass_type FROM zones
+		WHERE farm_id = $1 AND is_active AND NOT is_isolation`, farmID)
+	if err != nil {
+		return err
+	}
+	type zoneInfo struct {
+		name      string
+		grassType *string
+	}
+	zones := map[uuid.UUID]zoneInfo{}
+	for rows.Next() {
+		var id uuid.UUID
+		var z zoneInfo
+		if err := rows.Scan(&id, &z.name, &z.grassType); err != nil {
+			rows.Close()
+			return err
+		}
+		zones[id] = z
+	}
+	rows.Close()
+
+	now := time.Now()
+	for id, list := range occ {
+		z, ok := zones[id]
+		if !ok || len(list) == 0 || list[len(list)-1].EndedAt != nil {
+			continue
+		}
+		cur := list[len(list)-1]
+		p := params.For(z.grassType)
+		grass := "o capim"
+		if z.grassType != nil && *z.grassType != "" {
+			grass = *z.grassType
+		}
+
+		occupied := now.Sub(cur.StartedAt).Hours() / 24
+		if occupied > float64(p.OccupationDays) {
+			msg := fmt.Sprintf("Piquete %s ocupado há %d dias (máximo %d dias para %s)",
+				z.name, int(occupied), p.OccupationDays, grass)
+			if _, err := RaiseZoneAlert(ctx, q, farmID, id, "grazing_overstay", "warning", msg, cur.StartedAt); err != nil {
+				return err
+			}
+		}
+
+		if len(list) > 1 {
+			prev := list[len(list)-2]
+			rested := cur.StartedAt.Sub(*prev.EndedAt).Hours() / 24
+			if rested < float64(p.RestDays) {
+				msg := fmt.Sprintf("Piquete %s reocupado após %d dias de descanso (mínimo %d dias para %s)",
+					z.name, int(math.Floor(rested)), p.RestDays, grass)
+				if _, err := RaiseZoneAlert(ctx, q, farmID, id, "early_reentry", "warning", msg, cur.StartedAt); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// CheckOverstock raises an overstock alert for each active zone above its
+// stocking limit and pushes it to the farm's connected clients. While a
+// zone stays overstocked it is reported again once a day, whatever the
+// farm's overstock policy, which only governs moves.
+func CheckOverstock(ctx context.Context, q db.DBTX, hub *iot.Hub, farmID uuid.UUID) error {
+	over, err := zone.Overstocked(ctx, q, farmID, nil)
+	if err != nil {
+		return err
+	}
+	since := time.Now().Add(-24 * time.Hour)
+	for _, o := range over {
+		msg := fmt.Sprintf("Piquete %s acima da capacidade: %.2f UA/ha (limite %.2f UA/ha, máximo %.1f UA)",
+			o.Name, o.UGMHa, o.UGMHaLimit, o.MaxAnimalUnits)
+		raised, err := RaiseZoneAlert(ctx, q, farmID, o.ZoneID, "overstock", "warning", msg, since)
+		if err != nil {
+			return err
+		}
+		if raised && hub != nil {
+			hub.BroadcastAlert(farmID, "overstock", msg)
+		}
+	}
+	return nil
+}
+
+// RunMonitor checks every farm with occupied zones on each tick until ctx is
+// canceled. Overstays depend on the clock, so they cannot be raised only
+// when animals move; overstock alerts are pushed to clients through hub.
+func RunMonitor(ctx context.Context, pool *pgxpool.Pool, hub *iot.Hub, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rows, err := pool.Query(ctx, `
+			SELECT DISTINCT z.farm_id FROM zone_usages u
+			JOIN zones z ON z.id = u.zone_id
+			WHERE u.ended_at IS NULL`)
+		if err == nil {
+			farms := []uuid.UUID{}
+			for rows.Next() {
+				var id uuid.UUID
+				if rows.Scan(&id) == nil {
+					farms = append(farms, id)
+				}
+			}
+			rows.Close()
+			for _, id := range farms {
+				if err := CheckGrazing(ctx, pool, id); err != nil {
+					slog.Error("grazing check failed", "farm_id", id, "err", err)
+				}
+				if err := CheckOverstock(ctx, pool, hub, id); err != nil {
+					slog.Error("overstock check failed", "farm_id", id, "err", err)
+				}
+			}
+		} else if ctx.Err() == nil {
+			slog.Error("grazing monitor query failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Package grazing plans rotational grazing: rest and occupation periods per
+// grass type, the next zone and move date for each herd, a schedule of
+// upcoming moves, and alerts when zones are grazed too long or re-entered
+// before resting.
+package grazing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+type Handler struct{ pool *pgxpool.Pool }
+
+func NewHandler(pool *pgxpool.Pool) *Handler { return &Handler{pool: pool} }
+
+// =============================================
+// REST AND OCCUPATION PERIODS
+// =============================================
+
+// Parameters are the rest and occupation periods for a grass type. Source
+// is "farm" for a farm override and "default" for the built-in value.
+type Parameters struct {
+	GrassType      string  `json:"grass_type"`
+	RestDays       int     `json:"rest_days"`
+	OccupationDays int     `json:"occupation_days"`
+	Source         string  `json:"source"`
+	Notes          *string `json:"notes,omitempty"`
+}
+
+// defaultKey holds the periods used when a zone's grass type is unknown.
+const defaultKey = "default"
+
+// defaultParameters are typical periods for tropical pastures under
+// rotational grazing; farms override them per grass type.
+var defaultParameters = map[string][2]int{ // rest days, occupation days
+	"marandu":    {30, 5},
+	"brizantha":  {30, 5},
+	"brachiaria": {30, 5},
+	"braquiaria": {30, 5},
+	"xaraes":     {35, 5},
+	"piata":      {30, 5},
+	"decumbens":  {35, 5},
+	"humidicola": {35, 7},
+	"mombaca":    {35, 3},
+	"tanzania":   {30, 3},
+	"massai":     {30, 5},
+	"tifton":     {28, 3},
+	"coastcross": {28, 3},
+	"estrela":    {28, 3},
+	defaultKey:   {30, 5},
+}
+
+var accents = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "é", "e", "ê", "e", "í", "i",
+	"ó", "o", "ô", "o", "õ", "o", "ú", "u", "ü", "u", "ç", "c",
+)
+
+// grassKey normalises a grass type for lookups: "Mombaça" and "mombaca"
+// are the same grass.
+func grassKey(s string) string {
+	return strings.Join(strings.Fields(accents.Replace(strings.ToLower(s))), " ")
+}
+
+// ParameterSet resolves the periods of a farm's zones.
+type ParameterSet map[string]Parameters
+
+// For returns the periods of a grass type: an exact match first, then the
+// longest known name contained in it ("Brachiaria brizantha cv. Marandu"
+// matches "marandu" before "brachiaria"), then the default.
+func (ps ParameterSet) For(grassType *string) Parameters {
+	if grassType == nil || strings.TrimSpace(*grassType) == "" {
+		return ps[defaultKey]
+	}
+	key := grassKey(*grassType)
+	if p, ok := ps[key]; ok {
+		return p
+	}
+	best := ""
+	for k := range ps {
+		if k != defaultKey && len(k) > len(best) && strings.Contains(key, k) {
+			best = k
+		}
+	}
+	if best != "" {
+		return ps[best]
+	}
+	return ps[defaultKey]
+}
+
+// LoadParameters returns the built-in periods with the farm's overrides
+// applied.
+func LoadParameters(ctx context.Context, q db.DBTX, farmID uuid.UUID) (ParameterSet, error) {
+	ps := ParameterSet{}
+	for k, v := range defaultParameters {
+		ps[k] = Parameters{GrassType: k, RestDays: v[0], OccupationDays: v[1], Source: "default"}
+	}
+	rows, err := q.Query(ctx, `
+		SELECT grass_type, rest_days, occupation_days, notes
+		FROM grazing_parameters WHERE farm_id=$1`, farmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := Parameters{Source: "farm"}
+		if err := rows.Scan(&p.GrassType, &p.RestDays, &p.OccupationDays, &p.Notes); err != nil {
+			return nil, err
+		}
+		ps[p.GrassType] = p
+	}
+	return ps, rows.Err()
+}
+
+// ListParameters returns the effective periods: farm overrides, built-in
+// defaults, and the resolution of every grass type used by the farm's zones.
+func (h *Handler) ListParameters(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	ps, err := LoadParameters(r.Context(), h.pool, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT DISTINCT grass_type FROM zones
+		WHERE farm_id=$1 AND grass_type IS NOT NULL AND grass_type <> ''`, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+	type zoneGrass struct {
+		GrassType string     `json:"grass_type"`
+		Uses      Parameters `json:"uses"`
+	}
+	zoneGrasses := []zoneGrass{}
+	for rows.Next() {
+		var g string
+		if err := rows.Scan(&g); err != nil {
+			response.InternalError(w)
+			return
+		}
+		zoneGrasses = append(zoneGrasses, zoneGrass{GrassType: g, Uses: ps.For(&g)})
+	}
+
+	list := make([]Parameters, 0, len(ps))
+	for _, p := range ps {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Source != list[j].Source {
+			return list[i].Source == "farm"
+		}
+		return list[i].GrassType < list[j].GrassType
+	})
+	response.Ok(w, map[string]any{"parameters": list, "zone_grass_types": zoneGrasses})
+}
+
+type ParametersRequest struct {
+	GrassType      string  `json:"grass_type"`
+	RestDays       int     `json:"rest_days"`
+	OccupationDays int     `json:"occupation_days"`
+	Notes          *string `json:"notes"`
+}
+
+func (req ParametersRequest) validate() string {
+	switch {
+	case grassKey(req.GrassType) == "":
+		return "grass_type is required (use \"default\" for zones without one)"
+	case req.RestDays <= 0 || req.RestDays > 365:
+		return "rest_days must be between 1 and 365"
+	case req.OccupationDays <= 0 || req.OccupationDays > 120:
+		return "occupation_days must be between 1 and 120"
+	}
+	return ""
+}
+
+// SaveParameters creates or replaces the farm's periods for a grass type.
+func (h *Handler) SaveParameters(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	var req ParametersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+	p := Parameters{Source: "farm"}
+	err := h.pool.QueryRow(r.Context(), `
+		INSERT INTO grazing_parameters (farm_id, grass_type, rest_days, occupation_days, notes)
+		VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT (farm_id, grass_type) DO UPDATE SET
+		  rest_days = EXCLUDED.rest_days, occupation_days = EXCLUDED.occupation_days,
+		  notes = EXCLUDED.notes, updated_at = NOW()
+		RETURNING grass_type, rest_days, occupation_days, notes`,
+		farmID, grassKey(req.GrassType), req.RestDays, req.OccupationDays, req.Notes,
+	).Scan(&p.GrassType, &p.RestDays, &p.OccupationDays, &p.Notes)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, p)
+}
+
+// DeleteParameters removes a farm override, restoring the built-in periods.
+func (h *Handler) DeleteParameters(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	_, err := h.pool.Exec(r.Context(),
+		`DELETE FROM grazing_parameters WHERE farm_id=$1 AND grass_type=$2`,
+		farmID, grassKey(chi.URLParam(r, "grassType")))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.NoContent(w)
+}
@@ -0,0 +1,544 @@
+package grazing
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/internal/zone"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// PLANNER
+// =============================================
+
+const day = 24 * time.Hour
+
+// neverGrazedRatio is the rest ratio assumed for zones with no usage
+// history: rested, but ranked below zones known to be well rested.
+const neverGrazedRatio = 1.5
+
+type zoneState struct {
+	id        uuid.UUID
+	name      string
+	grassType *string
+	areaHa    float64
+	limit     *float64
+	params    Parameters
+	// lastEnd is when the zone was last vacated; nil if never grazed or
+	// occupied now.
+	lastEnd *time.Time
+	// occupiedSince is the start of the current occupation.
+	occupiedSince *time.Time
+	// herd is the herd planned to be in the zone; untracked marks animals
+	// outside any planned herd, which keep the zone busy.
+	herd      *uuid.UUID
+	untracked bool
+	// forage is the zone's forage budget when a recent assessment reflects
+	// its current state (taken since it was last grazed).
+	forage *zone.ForageBudget
+}
+
+type herdState struct {
+	id      uuid.UUID
+	name    string
+	color   string
+	animals int
+	units   float64
+	zoneID  *uuid.UUID
+	since   *time.Time
+	moveAt  time.Time
+	overdue bool
+	// forageLimited marks a move brought forward because the zone's
+	// forage runs out before the occupation period ends.
+	forageLimited bool
+}
+
+// Candidate is a zone evaluated as a herd's next destination.
+type Candidate struct {
+	ZoneID           uuid.UUID `json:"zone_id"`
+	ZoneName         string    `json:"zone_name"`
+	GrassType        *string   `json:"grass_type"`
+	AreaHa           float64   `json:"area_ha"`
+	RestDays         *float64  `json:"rest_days"`
+	RequiredRestDays int       `json:"required_rest_days"`
+	Ready            bool      `json:"ready"`
+	OccupationDays   int       `json:"occupation_days"`
+	// UGMHa is the herd's stocking in the zone, in animal units per hectare.
+	UGMHa      float64  `json:"ugm_ha"`
+	UGMHaLimit *float64 `json:"ugm_ha_limit"`
+	OverLimit  bool     `json:"over_limit"`
+	// ForageDays is how long the zone's assessed forage feeds the herd;
+	// null without a recent assessment.
+	ForageDays    *float64 `json:"forage_days"`
+	ShortOfForage bool     `json:"short_of_forage"`
+	Score         float64  `json:"score"`
+}
+
+type planner struct {
+	zones map[uuid.UUID]*zoneState
+	herds []*herdState
+	today time.Time
+}
+
+func round1(v float64) float64 { return math.Round(v*10) / 10 }
+
+func date(t time.Time) string { return t.Format("2006-01-02") }
+
+// loadPlanner reads the active grazing zones (isolation zones excluded),
+// their occupation history and the farm's herds with animals.
+func loadPlanner(ctx context.Context, q db.DBTX, farmID uuid.UUID) (*planner, error) {
+	params, err := LoadParameters(ctx, q, farmID)
+	if err != nil {
+		return nil, err
+	}
+	p := &planner{zones: map[uuid.UUID]*zoneState{}, today: time.Now().UTC().Truncate(day)}
+
+	rows, err := q.Query(ctx, `
+		SELECT z.id, z.name, z.grass_type, z.area_ha::float8, z.ugm_ha_limit::float8,
+		       COUNT(a.id) FILTER (WHERE NOT EXISTS (
+		           SELECT 1 FROM herds h WHERE h.id = a.herd_id AND h.zone_id = z.id))::int
+		FROM zones z
+		LEFT JOIN animals a ON a.zone_id = z.id AND a.status = 'active'
+		WHERE z.farm_id = $1 AND z.is_active AND NOT z.is_isolation
+		GROUP BY z.id`, farmID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		z := &zoneState{}
+		var untracked int
+		if err := rows.Scan(&z.id, &z.name, &z.grassType, &z.areaHa, &z.limit, &untracked); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		z.params = params.For(z.grassType)
+		z.untracked = untracked > 0
+		p.zones[z.id] = z
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	occ, err := zone.Occupations(ctx, q, farmID, nil)
+	if err != nil {
+		return nil, err
+	}
+	for id, list := range occ {
+		z, ok := p.zones[id]
+		if !ok || len(list) == 0 {
+			continue
+		}
+		last := list[len(list)-1]
+		if last.EndedAt == nil {
+			z.occupiedSince = &last.StartedAt
+		} else {
+			z.lastEnd = last.EndedAt
+		}
+	}
+
+	budgets, err := zone.ForageBudgets(ctx, q, farmID, nil)
+	if err != nil {
+		return nil, err
+	}
+	for id, b := range budgets {
+		z, ok := p.zones[id]
+		if !ok || b.Stale || (z.lastEnd != nil && b.AssessedOn < date(*z.lastEnd)) {
+			continue
+		}
+		budget := b
+		z.forage = &budget
+	}
+
+	rows, err = q.Query(ctx, `
+		SELECT h.id, h.name, h.color, h.zone_id, COUNT(au.animal_id)::int, SUM(au.ua), l.started_at
+		FROM herds h
+		JOIN animal_units au ON au.herd_id = h.id
+		LEFT JOIN herd_locations l ON l.herd_id = h.id AND l.ended_at IS NULL
+		WHERE h.farm_id = $1
+		GROUP BY h.id, l.started_at
+		ORDER BY h.name`, farmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		h := &herdState{}
+		if err := rows.Scan(&h.id, &h.name, &h.color, &h.zoneID, &h.animals, &h.units, &h.since); err != nil {
+			return nil, err
+		}
+		h.moveAt = p.today
+		if h.zoneID != nil {
+			if z, ok := p.zones[*h.zoneID]; ok {
+				z.herd = &h.id
+				// The occupation period runs from when the zone was entered,
+				// even if this herd joined animals already there.
+				if z.occupiedSince != nil && (h.since == nil || z.occupiedSince.Before(*h.since)) {
+					h.since = z.occupiedSince
+				}
+				if h.since != nil {
+					h.moveAt = h.since.UTC().Truncate(day).Add(time.Duration(z.params.OccupationDays) * day)
+				}
+				if z.forage != nil && z.forage.DaysRemaining != nil {
+					if out := p.today.Add(time.Duration(*z.forage.DaysRemaining) * day); out.Before(h.moveAt) {
+						h.moveAt, h.forageLimited = out, true
+					}
+				}
+			} else {
+				// Herd in an isolation or inactive zone: not part of the rotation.
+				h.zoneID = nil
+			}
+		}
+		if h.moveAt.Before(p.today) {
+			h.moveAt, h.overdue = p.today, true
+		}
+		p.herds = append(p.herds, h)
+	}
+	return p, rows.Err()
+}
+
+// candidates ranks the zones a herd could enter at t: ready zones (rested
+// for their grass type's period) first, then zones within their stocking
+// limit, then the most rested. Zones whose assessed forage would not last
+// the occupation period rank lower. Zones busy with other animals are left
+// out.
+func (p *planner) candidates(h *herdState, t time.Time) []Candidate {
+	out := []Candidate{}
+	for _, z := range p.zones {
+		if (h.zoneID != nil && z.id == *h.zoneID) || z.untracked || z.herd != nil || z.occupiedSince != nil {
+			continue
+		}
+		c := Candidate{
+			ZoneID: z.id, ZoneName: z.name, GrassType: z.grassType, AreaHa: z.areaHa,
+			RequiredRestDays: z.params.RestDays, OccupationDays: z.params.OccupationDays,
+			UGMHaLimit: z.limit,
+		}
+		ratio := neverGrazedRatio
+		if z.lastEnd != nil {
+			rest := round1(t.Sub(*z.lastEnd).Hours() / 24)
+			c.RestDays = &rest
+			ratio = math.Min(rest/float64(z.params.RestDays), 3)
+		}
+		c.Ready = ratio >= 1
+		if z.areaHa > 0 {
+			c.UGMHa = math.Round(h.units/z.areaHa*100) / 100
+		}
+		c.OverLimit = z.limit != nil && c.UGMHa > *z.limit
+		if z.forage != nil && h.units > 0 {
+			fd := round1(z.forage.RemainingKg / (h.units * zone.DailyIntakeKgDM))
+			c.ForageDays = &fd
+			c.ShortOfForage = fd < float64(z.params.OccupationDays)
+		}
+		c.Score = round1(ratio * 10)
+		if c.Ready {
+			c.Score += 100
+		}
+		if !c.OverLimit {
+			c.Score += 50
+		}
+		if c.ShortOfForage {
+			c.Score -= 25
+		}
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].ZoneName < out[j].ZoneName
+	})
+	return out
+}
+
+// Block is a stretch of a herd in a zone, or of a zone grazed or resting.
+type Block struct {
+	Kind       string     `json:"kind"` // grazing | rest
+	ZoneID     *uuid.UUID `json:"zone_id,omitempty"`
+	ZoneName   string     `json:"zone_name,omitempty"`
+	HerdID     *uuid.UUID `json:"herd_id,omitempty"`
+	HerdName   string     `json:"herd_name,omitempty"`
+	Start      string     `json:"start"`
+	End        string     `json:"end"`
+	Planned    bool       `json:"planned"`
+	EarlyEntry bool       `json:"early_entry,omitempty"`
+	OverLimit  bool       `json:"over_limit,omitempty"`
+}
+
+// move is one planned herd move produced by the simulation.
+type move struct {
+	herd         *herdState
+	from         *uuid.UUID
+	to           Candidate
+	at           time.Time
+	alternatives []Candidate
+}
+
+// simulate plays the rotation forward until end: the herd due first moves
+// to its best candidate, which becomes busy, and its old zone starts
+// resting. The herd stays for the occupation period, or until the zone's
+// assessed forage runs out. Herds with nowhere to go are retried the next
+// day.
+func (p *planner) simulate(end time.Time) []move {
+	var moves []move
+	for i := 0; i < 5000; i++ {
+		var next *herdState
+		for _, h := range p.herds {
+			if next == nil || h.moveAt.Before(next.moveAt) {
+				next = h
+			}
+		}
+		if next == nil || !next.moveAt.Before(end) {
+			break
+		}
+		cands := p.candidates(next, next.moveAt)
+		if len(cands) == 0 {
+			next.moveAt = next.moveAt.Add(day)
+			continue
+		}
+		m := move{herd: next, from: next.zoneID, to: cands[0], at: next.moveAt}
+		if len(cands) > 1 {
+			m.alternatives = cands[1:min(len(cands), 4)]
+		}
+		moves = append(moves, m)
+
+		if next.zoneID != nil {
+			if old, ok := p.zones[*next.zoneID]; ok {
+				at := next.moveAt
+				old.herd, old.occupiedSince, old.lastEnd, old.forage = nil, nil, &at, nil
+			}
+		}
+		z := p.zones[cands[0].ZoneID]
+		at := next.moveAt
+		z.herd, z.occupiedSince, z.lastEnd = &next.id, &at, nil
+		next.zoneID, next.since = &z.id, &at
+		stay := z.params.OccupationDays
+		next.forageLimited = false
+		if fd := cands[0].ForageDays; fd != nil && int(*fd) < stay {
+			stay, next.forageLimited = max(int(*fd), 1), true
+		}
+		next.moveAt = at.Add(time.Duration(stay) * day)
+		next.overdue = false
+	}
+	return moves
+}
+
+// HerdPlan is the recommended next move of a herd.
+type HerdPlan struct {
+	HerdID            uuid.UUID   `json:"herd_id"`
+	HerdName          string      `json:"herd_name"`
+	Animals           int         `json:"animals"`
+	AnimalUnits       float64     `json:"animal_units"`
+	ZoneID            *uuid.UUID  `json:"zone_id"`
+	ZoneName          *string     `json:"zone_name"`
+	InZoneSince       *time.Time  `json:"in_zone_since"`
+	OccupationDays    *float64    `json:"occupation_days"`
+	MaxOccupationDays *int        `json:"max_occupation_days"`
+	MoveOn            *string     `json:"move_on"`
+	Overdue           bool        `json:"overdue"`
+	Next              *Candidate  `json:"next"`
+	Alternatives      []Candidate `json:"alternatives"`
+
+	// ForageDays is how long the current zone's assessed forage lasts;
+	// ForageLimited is set when that brings the move forward.
+	ForageDays    *float64 `json:"forage_days"`
+	ForageLimited bool     `json:"forage_limited"`
+}
+
+// Plan recommends, for every herd, the move date (when its current zone
+// reaches the occupation period of its grass type, or earlier if its
+// assessed forage runs out) and the next zone,
+// ranked by rest against the grass type's rest period and by stocking
+// limit. Herds are planned together so two herds are never sent to the
+// same zone.
+func (h *Handler) Plan(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	p, err := loadPlanner(r.Context(), h.pool, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	plans := make([]HerdPlan, 0, len(p.herds))
+	index := map[uuid.UUID]int{}
+	for _, hs := range p.herds {
+		hp := HerdPlan{HerdID: hs.id, HerdName: hs.name, Animals: hs.animals, AnimalUnits: round1(hs.units),
+			ZoneID: hs.zoneID, InZoneSince: hs.since, Overdue: hs.overdue, ForageLimited: hs.forageLimited,
+			Alternatives: []Candidate{}}
+		if hs.zoneID != nil {
+			z := p.zones[*hs.zoneID]
+			hp.ZoneName = &z.name
+			hp.MaxOccupationDays = &z.params.OccupationDays
+			if z.forage != nil {
+				hp.ForageDays = z.forage.DaysRemaining
+			}
+			if hs.since != nil {
+				d := round1(time.Since(*hs.since).Hours() / 24)
+				hp.OccupationDays = &d
+			}
+		}
+		index[hs.id] = len(plans)
+		plans = append(plans, hp)
+	}
+
+	// Simulate far enough for every herd's first move.
+	end := p.today.Add(365 * day)
+	planned := map[uuid.UUID]bool{}
+	for _, m := range p.simulate(end) {
+		if planned[m.herd.id] {
+			continue
+		}
+		planned[m.herd.id] = true
+		hp := &plans[index[m.herd.id]]
+		to, on := m.to, date(m.at)
+		hp.Next, hp.MoveOn = &to, &on
+		if m.alternatives != nil {
+			hp.Alternatives = m.alternatives
+		}
+		if len(planned) == len(plans) {
+			break
+		}
+	}
+	response.Ok(w, plans)
+}
+
+// Schedule plays the rotation forward ?days= (default 60, max 365) and
+// returns it twice for drawing: per herd (the zones it moves through) and
+// per zone (grazing and rest blocks).
+func (h *Handler) Schedule(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	horizon := 60
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			response.BadRequest(w, "days must be between 1 and 365")
+			return
+		}
+		horizon = n
+	}
+	p, err := loadPlanner(r.Context(), h.pool, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	from := p.today
+	end := from.Add(time.Duration(horizon) * day)
+
+	// Starting state, before the simulation mutates it.
+	type stay struct {
+		herd    *herdState
+		zone    uuid.UUID
+		start   time.Time
+		planned bool
+	}
+	current := map[uuid.UUID]*stay{}
+	initialLastEnd := map[uuid.UUID]*time.Time{}
+	for id, z := range p.zones {
+		initialLastEnd[id] = z.lastEnd
+	}
+	for _, hs := range p.herds {
+		if hs.zoneID != nil {
+			start := from
+			if hs.since != nil {
+				start = *hs.since
+			}
+			current[hs.id] = &stay{herd: hs, zone: *hs.zoneID, start: start}
+		}
+	}
+
+	herdBlocks := map[uuid.UUID][]Block{}
+	zoneBlocks := map[uuid.UUID][]Block{}
+	addBlock := func(hs *herdState, zoneID uuid.UUID, start, stop time.Time, planned bool, c *Candidate) {
+		z := p.zones[zoneID]
+		hid, zid := hs.id, zoneID
+		b := Block{Kind: "grazing", ZoneID: &zid, ZoneName: z.name, HerdID: &hid, HerdName: hs.name,
+			Start: date(start), End: date(stop), Planned: planned}
+		if c != nil {
+			b.EarlyEntry, b.OverLimit = !c.Ready, c.OverLimit
+		}
+		herdBlocks[hs.id] = append(herdBlocks[hs.id], b)
+		zoneBlocks[zoneID] = append(zoneBlocks[zoneID], b)
+	}
+
+	entered := map[uuid.UUID]*Candidate{}
+	for _, m := range p.simulate(end) {
+		if s := current[m.herd.id]; s != nil {
+			addBlock(m.herd, s.zone, s.start, m.at, s.planned, entered[m.herd.id])
+		}
+		to := m.to
+		entered[m.herd.id] = &to
+		current[m.herd.id] = &stay{herd: m.herd, zone: m.to.ZoneID, start: m.at, planned: true}
+	}
+	for _, s := range current {
+		// Open stays end at the planned move, which may lie past the horizon.
+		addBlock(s.herd, s.zone, s.start, s.herd.moveAt, s.planned, entered[s.herd.id])
+	}
+
+	type herdRow struct {
+		HerdID   uuid.UUID `json:"herd_id"`
+		HerdName string    `json:"herd_name"`
+		Color    string    `json:"color"`
+		Animals  int       `json:"animals"`
+		Units    float64   `json:"animal_units"`
+		Blocks   []Block   `json:"blocks"`
+	}
+	type zoneRow struct {
+		ZoneID         uuid.UUID `json:"zone_id"`
+		ZoneName       string    `json:"zone_name"`
+		GrassType      *string   `json:"grass_type"`
+		RestDays       int       `json:"rest_days"`
+		OccupationDays int       `json:"occupation_days"`
+		Blocks         []Block   `json:"blocks"`
+	}
+	herds := []herdRow{}
+	for _, hs := range p.herds {
+		blocks := herdBlocks[hs.id]
+		if blocks == nil {
+			blocks = []Block{}
+		}
+		sort.Slice(blocks, func(i, j int) bool { return blocks[i].Start < blocks[j].Start })
+		herds = append(herds, herdRow{HerdID: hs.id, HerdName: hs.name, Color: hs.color, Animals: hs.animals,
+			Units: round1(hs.units), Blocks: blocks})
+	}
+	zones := []zoneRow{}
+	for id, z := range p.zones {
+		grazing := zoneBlocks[id]
+		sort.Slice(grazing, func(i, j int) bool { return grazing[i].Start < grazing[j].Start })
+		blocks := []Block{}
+		// Rest runs from the last exit (or the start of the window) to the
+		// next entry, or to the end of the window.
+		restFrom := date(from)
+		if z.untracked {
+			restFrom = ""
+		} else if le := initialLastEnd[id]; le != nil {
+			restFrom = date(*le)
+		}
+		for _, g := range grazing {
+			if restFrom != "" && restFrom < g.Start {
+				blocks = append(blocks, Block{Kind: "rest", Start: restFrom, End: g.Start, Planned: g.Planned})
+			}
+			blocks = append(blocks, g)
+			restFrom = g.End
+		}
+		if restFrom != "" && restFrom < date(end) {
+			blocks = append(blocks, Block{Kind: "rest", Start: restFrom, End: date(end), Planned: true})
+		}
+		zones = append(zones, zoneRow{ZoneID: id, ZoneName: z.name, GrassType: z.grassType,
+			RestDays: z.params.RestDays, OccupationDays: z.params.OccupationDays, Blocks: blocks})
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].ZoneName < zones[j].ZoneName })
+
+	response.Ok(w, map[string]any{
+		"from":  date(from),
+		"to":    date(end),
+		"herds": herds,
+		"zones": zones,
+	})
+}
@@ -0,0 +1,286 @@
+package grazing
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/zone"
+)
+
+var today = time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+
+func at(days int) time.Time { return today.Add(time.Duration(days) * day) }
+
+func ptrTime(t time.Time) *time.Time { return &t }
+
+func ptrFloat(v float64) *float64 { return &v }
+
+func ptrUUID(id uuid.UUID) *uuid.UUID { return &id }
+
+// forageFor is a forage budget that feeds 15 animal units for days.
+func forageFor(days float64) *zone.ForageBudget {
+	return &zone.ForageBudget{RemainingKg: days * 15 * zone.DailyIntakeKgDM}
+}
+
+// testZone is a 10 ha zone resting for 30 days and grazed for 5, last
+// vacated restedDays ago; a negative restedDays means never grazed.
+func testZone(name string, restedDays int) *zoneState {
+	z := &zoneState{id: uuid.New(), name: name, areaHa: 10,
+		params: Parameters{RestDays: 30, OccupationDays: 5}}
+	if restedDays >= 0 {
+		z.lastEnd = ptrTime(at(-restedDays))
+	}
+	return z
+}
+
+func testPlanner(zones ...*zoneState) *planner {
+	p := &planner{zones: map[uuid.UUID]*zoneState{}, today: today}
+	for _, z := range zones {
+		p.zones[z.id] = z
+	}
+	return p
+}
+
+func (p *planner) zone(name string) *zoneState {
+	for _, z := range p.zones {
+		if z.name == name {
+			return z
+		}
+	}
+	return nil
+}
+
+// place puts a herd of 20 animals (15 animal units) in z since sinceDays ago (nil z: no zone)
+// and schedules its move after the zone's occupation period.
+func (p *planner) place(name string, z *zoneState, sinceDays int) *herdState {
+	h := &herdState{id: uuid.New(), name: name, animals: 20, units: 15, moveAt: p.today}
+	if z != nil {
+		since := at(-sinceDays)
+		z.herd, z.occupiedSince, z.lastEnd = &h.id, &since, nil
+		h.zoneID, h.since = &z.id, &since
+		h.moveAt = since.Add(time.Duration(z.params.OccupationDays) * day)
+	}
+	p.herds = append(p.herds, h)
+	return h
+}
+
+func names(cands []Candidate) []string {
+	out := []string{}
+	for _, c := range cands {
+		out = append(out, c.ZoneName)
+	}
+	return out
+}
+
+func TestCandidates(t *testing.T) {
+	tests := []struct {
+		name  string
+		zones func() []*zoneState
+		want  []string
+	}{
+		{
+			name: "rested zones first, never grazed below well rested",
+			zones: func() []*zoneState {
+				return []*zoneState{testZone("Cerrado", 10), testZone("Baixada", 50), testZone("Novo", -1)}
+			},
+			want: []string{"Baixada", "Novo", "Cerrado"},
+		},
+		{
+			name: "within stocking limit before more rested",
+			zones: func() []*zoneState {
+				small := testZone("Curral", 60)
+				small.areaHa, small.limit = 1, ptrFloat(5)
+				big := testZone("Varjão", 35)
+				big.limit = ptrFloat(5)
+				return []*zoneState{small, big}
+			},
+			want: []string{"Varjão", "Curral"},
+		},
+		{
+			name: "ready over limit before resting within limit",
+			zones: func() []*zoneState {
+				small := testZone("Curral", 40)
+				small.areaHa, small.limit = 1, ptrFloat(5)
+				return []*zoneState{small, testZone("Varjão", 20)}
+			},
+			want: []string{"Curral", "Varjão"},
+		},
+		{
+			name: "rest counts up to three periods, then by name",
+			zones: func() []*zoneState {
+				return []*zoneState{testZone("Baixo", 200), testZone("Alto", 100)}
+			},
+			want: []string{"Alto", "Baixo"},
+		},
+		{
+			name: "busy zones left out",
+			zones: func() []*zoneState {
+				other := testZone("Outro lote", 60)
+				other.herd = ptrUUID(uuid.New())
+				loose := testZone("Avulsos", 60)
+				loose.untracked = true
+				occupied := testZone("Ocupado", 60)
+				occupied.occupiedSince = ptrTime(at(-1))
+				return []*zoneState{other, loose, occupied, testZone("Livre", 5)}
+			},
+			want: []string{"Livre"},
+		},
+		{
+			name: "short of forage for the occupation period ranks lower",
+			zones: func() []*zoneState {
+				short := testZone("Pelado", 50)
+				short.forage = forageFor(3)
+				fed := testZone("Capim alto", 35)
+				fed.forage = forageFor(12)
+				return []*zoneState{short, fed}
+			},
+			want: []string{"Capim alto", "Pelado"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := testZone("Atual", 40)
+			p := testPlanner(append(tt.zones(), current)...)
+			h := p.place("Lote 1", current, 2)
+			if got := names(p.candidates(h, today)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCandidateFields(t *testing.T) {
+	rested, fresh, small := testZone("Baixada", 45), testZone("Novo", -1), testZone("Curral", 15)
+	small.areaHa, small.limit = 2, ptrFloat(5)
+	// 5 head/ha but 3.75 UA/ha: the limit is in animal units
+	light := testZone("Piquete", 30)
+	light.areaHa, light.limit = 4, ptrFloat(4.5)
+	p := testPlanner(rested, fresh, small, light)
+	h := p.place("Lote 1", nil, 0)
+
+	got := map[string]Candidate{}
+	for _, c := range p.candidates(h, today) {
+		got[c.ZoneName] = c
+	}
+	tests := []struct {
+		zone      string
+		restDays  *float64
+		ready     bool
+		ugmHa     float64
+		overLimit bool
+		score     float64
+	}{
+		{"Baixada", ptrFloat(45), true, 1.5, false, 165},
+		{"Novo", nil, true, 1.5, false, 165},
+		{"Curral", ptrFloat(15), false, 7.5, true, 5},
+		{"Piquete", ptrFloat(30), true, 3.75, false, 160},
+	}
+	for _, tt := range tests {
+		c := got[tt.zone]
+		if !reflect.DeepEqual(c.RestDays, tt.restDays) || c.Ready != tt.ready || c.UGMHa != tt.ugmHa ||
+			c.OverLimit != tt.overLimit || c.Score != tt.score {
+			t.Errorf("%s = rest %v ready %v ugm/ha %v over %v score %v", tt.zone,
+				c.RestDays, c.Ready, c.UGMHa, c.OverLimit, c.Score)
+		}
+		if c.RequiredRestDays != 30 || c.OccupationDays != 5 {
+			t.Errorf("%s periods = %d/%d, want 30/5", tt.zone, c.RequiredRestDays, c.OccupationDays)
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	type step struct {
+		herd, from, to string
+		day            int
+		ready          bool
+	}
+	tests := []struct {
+		name  string
+		setup func(p *planner) // zones are Retiro, Baixada and Cerrado
+		days  int
+		want  []step
+	}{
+		{
+			name: "two herds rotate through three zones",
+			setup: func(p *planner) {
+				p.place("Lote 1", p.zone("Retiro"), 3)
+				p.place("Lote 2", nil, 0)
+				p.zone("Baixada").lastEnd = ptrTime(at(-40))
+				p.zone("Cerrado").lastEnd = ptrTime(at(-35))
+			},
+			days: 12,
+			want: []step{
+				{"Lote 2", "", "Baixada", 0, true},
+				{"Lote 1", "Retiro", "Cerrado", 2, true},
+				{"Lote 2", "Baixada", "Retiro", 5, false},
+				{"Lote 1", "Cerrado", "Baixada", 7, false},
+				{"Lote 2", "Retiro", "Cerrado", 10, false},
+			},
+		},
+		{
+			name: "herds with nowhere to go are retried daily",
+			setup: func(p *planner) {
+				p.place("Lote 1", p.zone("Retiro"), 5)
+				p.place("Lote 2", p.zone("Baixada"), 1)
+				p.zone("Cerrado").untracked = true
+			},
+			days: 3,
+			want: []step{},
+		},
+		{
+			name: "assessed forage cuts the stay short until the zone is grazed",
+			setup: func(p *planner) {
+				p.place("Lote 1", nil, 0)
+				p.zone("Retiro").lastEnd = ptrTime(at(-60))
+				p.zone("Retiro").forage = forageFor(3.5)
+				p.zone("Baixada").lastEnd = ptrTime(at(-10))
+				p.zone("Cerrado").untracked = true
+			},
+			days: 14,
+			want: []step{
+				{"Lote 1", "", "Retiro", 0, true},
+				{"Lote 1", "Retiro", "Baixada", 3, false},
+				{"Lote 1", "Baixada", "Retiro", 8, false},
+				{"Lote 1", "Retiro", "Baixada", 13, false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPlanner(testZone("Retiro", 40), testZone("Baixada", 40), testZone("Cerrado", 40))
+			tt.setup(p)
+			got := []step{}
+			for _, m := range p.simulate(at(tt.days)) {
+				from := ""
+				if m.from != nil {
+					from = p.zones[*m.from].name
+				}
+				got = append(got, step{m.herd.name, from, m.to.ZoneName,
+					int(m.at.Sub(today) / day), m.to.Ready})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("moves:\n got %v\nwant %v", got, tt.want)
+			}
+			for _, h := range p.herds {
+				if h.moveAt.Before(at(tt.days)) {
+					t.Errorf("%s left due on day %d, before the end", h.name, int(h.moveAt.Sub(today)/day))
+				}
+			}
+		})
+	}
+}
+
+func TestSimulateAlternatives(t *testing.T) {
+	p := testPlanner(testZone("A", 60), testZone("B", 50), testZone("C", 40), testZone("D", 35), testZone("E", 10))
+	p.place("Lote 1", nil, 0)
+	moves := p.simulate(at(1))
+	if len(moves) != 1 {
+		t.Fatalf("%d moves, want 1", len(moves))
+	}
+	if m := moves[0]; m.to.ZoneName != "A" || !reflect.DeepEqual(names(m.alternatives), []string{"B", "C", "D"}) {
+		t.Errorf("moved to %s with alternatives %v", m.to.ZoneName, names(m.alternatives))
+	}
+}
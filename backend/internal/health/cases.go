@@ -0,0 +1,811 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/internal/zone"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// DISEASE CASES
+// =============================================
+
+type CaseHandler struct{ pool *pgxpool.Pool }
+
+func NewCaseHandler(pool *pgxpool.Pool) *CaseHandler {
+	return &CaseHandler{pool: pool}
+}
+
+var validOutcomes = map[string]bool{"recovered": true, "died": true, "culled": true}
+
+// outcomeStatuses maps the outcomes that remove an animal from the farm to
+// the status it takes. A culled animal goes to slaughter, so it is sold.
+var outcomeStatuses = map[string]string{"died": "dead", "culled": "sold"}
+
+var errNoIsolationZone = errors.New("contagious cases need an isolation zone")
+
+type CaseAnimal struct {
+	AnimalID       uuid.UUID  `json:"animal_id"`
+	EarTag         string     `json:"ear_tag"`
+	Name           *string    `json:"name,omitempty"`
+	AddedAt        string     `json:"added_at"`
+	Outcome        *string    `json:"outcome,omitempty"`
+	OutcomeAt      *string    `json:"outcome_at,omitempty"`
+	Quarantined    bool       `json:"quarantined"`
+	QuarantinedAt  *time.Time `json:"quarantined_at,omitempty"`
+	ReleasedAt     *time.Time `json:"released_at,omitempty"`
+	PreviousZoneID *uuid.UUID `json:"previous_zone_id,omitempty"`
+}
+
+type DiseaseCase struct {
+	ID                uuid.UUID    `json:"id"`
+	FarmID            uuid.UUID    `json:"farm_id"`
+	Disease           string       `json:"disease"`
+	Diagnosis         *string      `json:"diagnosis,omitempty"`
+	Symptoms          []string     `json:"symptoms"`
+	DiagnosedAt       string       `json:"diagnosed_at"`
+	Contagious        bool         `json:"contagious"`
+	IsolationZoneID   *uuid.UUID   `json:"isolation_zone_id,omitempty"`
+	IsolationZoneName *string      `json:"isolation_zone_name,omitempty"`
+	TreatmentPlan     *string      `json:"treatment_plan,omitempty"`
+	Status            string       `json:"status"`
+	ClosedAt          *string      `json:"closed_at,omitempty"`
+	Notes             *string      `json:"notes,omitempty"`
+	AnimalCount       int          `json:"animal_count"`
+	Quarantined       int          `json:"quarantined"`
+	Animals           []CaseAnimal `json:"animals,omitempty"`
+	CreatedAt         time.Time    `json:"created_at"`
+}
+
+type CaseRequest struct {
+	Disease         string     `json:"disease"`
+	Diagnosis       *string    `json:"diagnosis"`
+	Symptoms        []string   `json:"symptoms"`
+	DiagnosedAt     string     `json:"diagnosed_at"`
+	Contagious      bool       `json:"contagious"`
+	IsolationZoneID *uuid.UUID `json:"isolation_zone_id"`
+	TreatmentPlan   *string    `json:"treatment_plan"`
+	Notes           *string    `json:"notes"`
+	// AnimalIDs is only read on create; use the animals endpoint afterwards.
+	AnimalIDs []uuid.UUID `json:"animal_ids"`
+}
+
+const caseSelect = `
+	SELECT c.id, c.farm_id, c.disease, c.diagnosis, c.symptoms,
+	       to_char(c.diagnosed_at, 'YYYY-MM-DD'), c.contagious, c.isolation_zone_id, z.name,
+	       c.treatment_plan, c.status, to_char(c.closed_at, 'YYYY-MM-DD'), c.notes,
+	       (SELECT COUNT(*)::int FROM disease_case_animals ca WHERE ca.case_id = c.id),
+	       (SELECT COUNT(*)::int FROM disease_case_animals ca
+	        WHERE ca.case_id = c.id AND ca.quarantined_at IS NOT NULL AND ca.released_at IS NULL),
+	       c.created_at
+	FROM disease_cases c
+	LEFT JOIN zones z ON z.id = c.isolation_zone_id`
+
+func scanCase(row interface{ Scan(...any) error }) (DiseaseCase, error) {
+	var c DiseaseCase
+	err := row.Scan(&c.ID, &c.FarmID, &c.Disease, &c.Diagnosis, &c.Symptoms,
+		&c.DiagnosedAt, &c.Contagious, &c.IsolationZoneID, &c.IsolationZoneName,
+		&c.TreatmentPlan, &c.Status, &c.ClosedAt, &c.Notes,
+		&c.AnimalCount, &c.Quarantined, &c.CreatedAt)
+	if c.Symptoms == nil {
+		c.Symptoms = []string{}
+	}
+	return c, err
+}
+
+// validate normalises a case request and returns a message when invalid.
+func (req *CaseRequest) validate() string {
+	if req.Disease == "" {
+		return "disease required"
+	}
+	if req.Symptoms == nil {
+		req.Symptoms = []string{}
+	}
+	if req.DiagnosedAt == "" {
+		req.DiagnosedAt = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.DiagnosedAt); err != nil {
+		return "diagnosed_at must be YYYY-MM-DD"
+	}
+	return ""
+}
+
+// resolveIsolationZone checks the zone a case quarantines into. Contagious
+// cases without one use the farm's first zone flagged as isolation.
+func resolveIsolationZone(ctx context.Context, q db.DBTX, farmID uuid.UUID, requested *uuid.UUID, contagious bool) (*uuid.UUID, error) {
+	if requested == nil && !contagious {
+		return nil, nil
+	}
+	var id uuid.UUID
+	err := q.QueryRow(ctx, `
+		SELECT id FROM zones
+		WHERE farm_id = $1 AND ($2::uuid IS NULL AND is_isolation OR id = $2)
+		ORDER BY name LIMIT 1`, farmID, requested).Scan(&id)
+	if err != nil && requested != nil {
+		return nil, errors.New("isolation zone not found")
+	}
+	if err != nil {
+		return nil, errNoIsolationZone
+	}
+	return &id, nil
+}
+
+func loadCaseAnimals(ctx context.Context, q db.DBTX, caseID uuid.UUID) ([]CaseAnimal, error) {
+	rows, err := q.Query(ctx, `
+		SELECT ca.animal_id, a.ear_tag, a.name, to_char(ca.added_at, 'YYYY-MM-DD'),
+		       ca.outcome, to_char(ca.outcome_at, 'YYYY-MM-DD'),
+		       ca.quarantined_at IS NOT NULL AND ca.released_at IS NULL,
+		       ca.quarantined_at, ca.released_at, ca.previous_zone_id
+		FROM disease_case_animals ca
+		JOIN animals a ON a.id = ca.animal_id
+		WHERE ca.case_id = $1
+		ORDER BY a.ear_tag`, caseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	animals := []CaseAnimal{}
+	for rows.Next() {
+		var a CaseAnimal
+		if err := rows.Scan(&a.AnimalID, &a.EarTag, &a.Name, &a.AddedAt,
+			&a.Outcome, &a.OutcomeAt, &a.Quarantined,
+			&a.QuarantinedAt, &a.ReleasedAt, &a.PreviousZoneID); err != nil {
+			return nil, err
+		}
+		animals = append(animals, a)
+	}
+	return animals, rows.Err()
+}
+
+// loadCase returns a case with its animals.
+func loadCase(ctx context.Context, q db.DBTX, farmID, id uuid.UUID) (DiseaseCase, error) {
+	c, err := scanCase(q.QueryRow(ctx, caseSelect+` WHERE c.id = $1 AND c.farm_id = $2`, id, farmID))
+	if err != nil {
+		return c, err
+	}
+	c.Animals, err = loadCaseAnimals(ctx, q, id)
+	return c, err
+}
+
+// addCaseAnimals links farm animals to a case, quarantining them when the
+// case is contagious.
+func addCaseAnimals(ctx context.Context, q db.DBTX, farmID uuid.UUID, c DiseaseCase, animalIDs []uuid.UUID, on string) error {
+	if len(animalIDs) == 0 {
+		return nil
+	}
+	_, err := q.Exec(ctx, `
+		INSERT INTO disease_case_animals (case_id, animal_id, added_at)
+		SELECT $1, a.id, $4::date FROM animals a
+		WHERE a.id = ANY($2) AND a.farm_id = $3
+		ON CONFLICT DO NOTHING`, c.ID, animalIDs, farmID, on)
+	if err != nil {
+		return err
+	}
+	if c.Contagious && c.IsolationZoneID != nil {
+		return quarantine(ctx, q, farmID, c.ID, *c.IsolationZoneID, animalIDs)
+	}
+	return nil
+}
+
+// quarantine moves animals of a case without an outcome into the isolation
+// zone, remembering where they were. An animal already quarantined by
+// another case keeps its original zone as the one to return to.
+func quarantine(ctx context.Context, q db.DBTX, farmID, caseID, zoneID uuid.UUID, animalIDs []uuid.UUID) error {
+	_, err := q.Exec(ctx, `
+		UPDATE disease_case_animals ca SET
+		  previous_zone_id = COALESCE((
+		      SELECT o.previous_zone_id FROM disease_case_animals o
+		      WHERE o.animal_id = a.id AND o.case_id <> ca.case_id
+		        AND o.quarantined_at IS NOT NULL AND o.released_at IS NULL
+		      LIMIT 1), a.zone_id),
+		  quarantined_at = NOW(),
+		  released_at = NULL
+		FROM animals a
+		WHERE ca.animal_id = a.id AND ca.case_id = $1 AND a.farm_id = $2
+		  AND ($3::uuid[] IS NULL OR a.id = ANY($3))
+		  AND ca.outcome IS NULL
+		  AND (ca.quarantined_at IS NULL OR ca.released_at IS NOT NULL)`,
+		caseID, farmID, animalIDs)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, `
+		UPDATE animals a SET zone_id = $3, updated_at = NOW()
+		FROM disease_case_animals ca
+		WHERE ca.animal_id = a.id AND ca.case_id = $1 AND a.farm_id = $2
+		  AND ca.quarantined_at IS NOT NULL AND ca.released_at IS NULL
+		  AND a.zone_id IS DISTINCT FROM $3`,
+		caseID, farmID, zoneID)
+	if err != nil {
+		return err
+	}
+	return zone.SyncUsage(ctx, q, farmID)
+}
+
+// release lifts the quarantine of a case's animals (all when animalIDs is
+// nil). Animals still in the isolation zone and not held by another case
+// return to the zone they came from.
+func release(ctx context.Context, q db.DBTX, farmID, caseID uuid.UUID, zoneID *uuid.UUID, animalIDs []uuid.UUID) (int64, error) {
+	tag, err := q.Exec(ctx, `
+		WITH released AS (
+			UPDATE disease_case_animals SET released_at = NOW()
+			WHERE case_id = $1 AND quarantined_at IS NOT NULL AND released_at IS NULL
+			  AND ($4::uuid[] IS NULL OR animal_id = ANY($4))
+			RETURNING animal_id, previous_zone_id
+		)
+		UPDATE animals a SET zone_id = r.previous_zone_id, updated_at = NOW()
+		FROM released r
+		WHERE a.id = r.animal_id AND a.farm_id = $2
+		  AND a.zone_id IS NOT DISTINCT FROM $3
+		  AND NOT EXISTS (
+		      SELECT 1 FROM disease_case_animals o
+		      WHERE o.animal_id = a.id AND o.case_id <> $1
+		        AND o.quarantined_at IS NOT NULL AND o.released_at IS NULL
+		  )`, caseID, farmID, zoneID, animalIDs)
+	if err != nil {
+		return 0, err
+	}
+	if err := zone.SyncUsage(ctx, q, farmID); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// List returns cases, optionally filtered by ?status= and ?disease=.
+func (h *CaseHandler) List(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	q := r.URL.Query()
+	var status, disease *string
+	if v := q.Get("status"); v != "" {
+		status = &v
+	}
+	if v := q.Get("disease"); v != "" {
+		disease = &v
+	}
+	rows, err := h.pool.Query(r.Context(), caseSelect+`
+		WHERE c.farm_id = $1
+		  AND ($2::text IS NULL OR c.status = $2)
+		  AND ($3::text IS NULL OR c.disease ILIKE $3)
+		ORDER BY c.diagnosed_at DESC, c.created_at DESC`, farmID, status, disease)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+
+	cases := []DiseaseCase{}
+	for rows.Next() {
+		c, err := scanCase(rows)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		cases = append(cases, c)
+	}
+	response.Ok(w, cases)
+}
+
+func (h *CaseHandler) Get(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid case id")
+		return
+	}
+	c, err := loadCase(r.Context(), h.pool, farmID, id)
+	if err != nil {
+		response.NotFound(w, "case not found")
+		return
+	}
+	response.Ok(w, c)
+}
+
+// Create opens a case. Contagious cases quarantine the affected animals in
+// the isolation zone.
+func (h *CaseHandler) Create(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	var req CaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	zoneID, err := resolveIsolationZone(r.Context(), tx, farmID, req.IsolationZoneID, req.Contagious)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+
+	id := uuid.New()
+	_, err = tx.Exec(r.Context(), `
+		INSERT INTO disease_cases
+		  (id, farm_id, disease, diagnosis, symptoms, diagnosed_at, contagious,
+		   isolation_zone_id, treatment_plan, notes)
+		VALUES ($1,$2,$3,$4,$5,$6::date,$7,$8,$9,$10)`,
+		id, farmID, req.Disease, req.Diagnosis, req.Symptoms, req.DiagnosedAt, req.Contagious,
+		zoneID, req.TreatmentPlan, req.Notes)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	c, err := scanCase(tx.QueryRow(r.Context(), caseSelect+` WHERE c.id = $1`, id))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := addCaseAnimals(r.Context(), tx, farmID, c, req.AnimalIDs, req.DiagnosedAt); err != nil {
+		response.InternalError(w)
+		return
+	}
+	c, err = loadCase(r.Context(), tx, farmID, id)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Created(w, c)
+}
+
+// Update edits a case. Turning contagious on quarantines the animals still
+// without an outcome; turning it off releases them.
+func (h *CaseHandler) Update(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid case id")
+		return
+	}
+	var req CaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	prev, err := scanCase(tx.QueryRow(r.Context(),
+		caseSelect+` WHERE c.id = $1 AND c.farm_id = $2 FOR UPDATE OF c`, id, farmID))
+	if err != nil {
+		response.NotFound(w, "case not found")
+		return
+	}
+	requested := req.IsolationZoneID
+	if requested == nil {
+		requested = prev.IsolationZoneID
+	}
+	zoneID, err := resolveIsolationZone(r.Context(), tx, farmID, requested, req.Contagious)
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+	// Animals held in a zone that is no longer the case's isolation zone are
+	// released first and quarantined again below when still contagious.
+	if prev.Contagious && (!req.Contagious || prev.IsolationZoneID == nil || *zoneID != *prev.IsolationZoneID) {
+		if _, err := release(r.Context(), tx, farmID, id, prev.IsolationZoneID, nil); err != nil {
+			response.InternalError(w)
+			return
+		}
+	}
+
+	_, err = tx.Exec(r.Context(), `
+		UPDATE disease_cases SET
+		  disease=$3, diagnosis=$4, symptoms=$5, diagnosed_at=$6::date, contagious=$7,
+		  isolation_zone_id=$8, treatment_plan=$9, notes=$10, updated_at=NOW()
+		WHERE id=$1 AND farm_id=$2`,
+		id, farmID, req.Disease, req.Diagnosis, req.Symptoms, req.DiagnosedAt, req.Contagious,
+		zoneID, req.TreatmentPlan, req.Notes)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if req.Contagious && prev.Status == "open" {
+		if err := quarantine(r.Context(), tx, farmID, id, *zoneID, nil); err != nil {
+			response.InternalError(w)
+			return
+		}
+	}
+	c, err := loadCase(r.Context(), tx, farmID, id)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, c)
+}
+
+// Delete removes a case, releasing any animals it still holds in quarantine.
+func (h *CaseHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid case id")
+		return
+	}
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	c, err := scanCase(tx.QueryRow(r.Context(),
+		caseSelect+` WHERE c.id = $1 AND c.farm_id = $2`, id, farmID))
+	if err == nil {
+		if _, err := release(r.Context(), tx, farmID, id, c.IsolationZoneID, nil); err != nil {
+			response.InternalError(w)
+			return
+		}
+		_, _ = tx.Exec(r.Context(), `DELETE FROM disease_cases WHERE id=$1`, id)
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.NoContent(w)
+}
+
+type CaseAnimalsRequest struct {
+	AnimalIDs []uuid.UUID `json:"animal_ids"`
+	Date      string      `json:"date"`
+}
+
+// AddAnimals adds newly affected animals to an open case.
+func (h *CaseHandler) AddAnimals(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid case id")
+		return
+	}
+	var req CaseAnimalsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.AnimalIDs) == 0 {
+		response.BadRequest(w, "animal_ids required")
+		return
+	}
+	if req.Date == "" {
+		req.Date = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		response.BadRequest(w, "date must be YYYY-MM-DD")
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	c, err := scanCase(tx.QueryRow(r.Context(),
+		caseSelect+` WHERE c.id = $1 AND c.farm_id = $2 FOR UPDATE OF c`, id, farmID))
+	if err != nil {
+		response.NotFound(w, "case not found")
+		return
+	}
+	if c.Status != "open" {
+		response.Error(w, http.StatusConflict, "case is closed")
+		return
+	}
+	if err := addCaseAnimals(r.Context(), tx, farmID, c, req.AnimalIDs, req.Date); err != nil {
+		response.InternalError(w)
+		return
+	}
+	c, err = loadCase(r.Context(), tx, farmID, id)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, c)
+}
+
+type OutcomeRequest struct {
+	AnimalIDs []uuid.UUID `json:"animal_ids"`
+	Outcome   string      `json:"outcome"` // recovered | died | culled
+	Date      string      `json:"date"`
+	// OverrideWithdrawal culls animals even though they are still within a
+	// medication withdrawal period.
+	OverrideWithdrawal bool `json:"override_withdrawal"`
+}
+
+// RecordOutcome closes the case for some of its animals. They leave
+// quarantine, animals that died are marked dead and culled ones sold, and
+// the case closes once every animal has an outcome.
+func (h *CaseHandler) RecordOutcome(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid case id")
+		return
+	}
+	var req OutcomeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.AnimalIDs) == 0 {
+		response.BadRequest(w, "animal_ids and outcome required")
+		return
+	}
+	if !validOutcomes[req.Outcome] {
+		response.BadRequest(w, "outcome must be recovered, died or culled")
+		return
+	}
+	if req.Date == "" {
+		req.Date = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		response.BadRequest(w, "date must be YYYY-MM-DD")
+		return
+	}
+	// Culled animals go to slaughter, so like a sale they are blocked
+	// during meat withdrawal periods
+	if req.Outcome == "culled" && !req.OverrideWithdrawal {
+		ws, err := LoadWithdrawals(r.Context(), h.pool, farmID, req.Date, req.AnimalIDs)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		withdrawn := []AnimalWithdrawal{}
+		for _, aw := range ws {
+			if aw.MeatWithdrawn {
+				withdrawn = append(withdrawn, aw)
+			}
+		}
+		if len(withdrawn) > 0 {
+			response.JSON(w, http.StatusConflict, response.Response{
+				Error: "animals are within a withdrawal period",
+				Data:  withdrawn,
+			})
+			return
+		}
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	c, err := scanCase(tx.QueryRow(r.Context(),
+		caseSelect+` WHERE c.id = $1 AND c.farm_id = $2 FOR UPDATE OF c`, id, farmID))
+	if err != nil {
+		response.NotFound(w, "case not found")
+		return
+	}
+	tag, err := tx.Exec(r.Context(), `
+		UPDATE disease_case_animals SET outcome = $3, outcome_at = $4::date
+		WHERE case_id = $1 AND animal_id = ANY($2)`,
+		id, req.AnimalIDs, req.Outcome, req.Date)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.BadRequest(w, "animals are not part of this case")
+		return
+	}
+	if _, err := release(r.Context(), tx, farmID, id, c.IsolationZoneID, req.AnimalIDs); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if status, ok := outcomeStatuses[req.Outcome]; ok {
+		_, err = tx.Exec(r.Context(), `
+			UPDATE animals SET status=$3, updated_at=NOW()
+			WHERE id = ANY($1) AND farm_id = $2 AND status = 'active'`, req.AnimalIDs, farmID, status)
+		if err == nil {
+			err = zone.SyncHerdMemberships(r.Context(), tx, farmID, req.AnimalIDs)
+		}
+		if err == nil {
+			err = zone.SyncUsage(r.Context(), tx, farmID)
+		}
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+	}
+	_, err = tx.Exec(r.Context(), `
+		UPDATE disease_cases SET status = 'closed', closed_at = $2::date, updated_at = NOW()
+		WHERE id = $1 AND status = 'open'
+		  AND NOT EXISTS (
+		      SELECT 1 FROM disease_case_animals WHERE case_id = $1 AND outcome IS NULL
+		  )`, id, req.Date)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	c, err = loadCase(r.Context(), tx, farmID, id)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, c)
+}
+
+// Release lifts the quarantine of the listed animals (all when empty)
+// without recording an outcome.
+func (h *CaseHandler) Release(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid case id")
+		return
+	}
+	var req CaseAnimalsRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	var animalIDs []uuid.UUID
+	if len(req.AnimalIDs) > 0 {
+		animalIDs = req.AnimalIDs
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	c, err := scanCase(tx.QueryRow(r.Context(),
+		caseSelect+` WHERE c.id = $1 AND c.farm_id = $2`, id, farmID))
+	if err != nil {
+		response.NotFound(w, "case not found")
+		return
+	}
+	moved, err := release(r.Context(), tx, farmID, id, c.IsolationZoneID, animalIDs)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, map[string]any{"moved": moved})
+}
+
+// =============================================
+// MORBIDITY / MORTALITY
+// =============================================
+
+type DiseaseStats struct {
+	Disease          string  `json:"disease"`
+	Cases            int     `json:"cases"`
+	Affected         int     `json:"affected"`
+	Recovered        int     `json:"recovered"`
+	Died             int     `json:"died"`
+	Culled           int     `json:"culled"`
+	Ongoing          int     `json:"ongoing"`
+	MorbidityRate    float64 `json:"morbidity_rate"`     // affected / animals at risk
+	MortalityRate    float64 `json:"mortality_rate"`     // died / animals at risk
+	CaseFatalityRate float64 `json:"case_fatality_rate"` // died / affected
+}
+
+type DiseaseStatsResult struct {
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	Population int            `json:"population"` // animals on the farm during the period
+	Diseases   []DiseaseStats `json:"diseases"`
+}
+
+// Population counts the animals on the farm at some point between from and
+// to (YYYY-MM-DD): registered before the period ended and still active or
+// leaving during it. An animal leaves on the date of its sale, slaughter or
+// death event, of its death or culling in a disease case or, failing those,
+// when its last herd membership ended; a departed animal with none of these
+// is not counted.
+func Population(ctx context.Context, q db.DBTX, farmID uuid.UUID, from, to string) (int, error) {
+	var n int
+	err := q.QueryRow(ctx, `
+		SELECT COUNT(*)::int FROM animals a
+		WHERE a.farm_id = $1 AND a.created_at::date <= $3::date
+		  AND (a.status = 'active' OR COALESCE(
+		      (SELECT MAX(e.event_date) FROM reproductive_events e
+		       WHERE e.animal_id = a.id AND e.event_type IN ('sale', 'slaughter', 'death')),
+		      (SELECT MAX(ca.outcome_at) FROM disease_case_animals ca
+		       WHERE ca.animal_id = a.id AND ca.outcome IN ('died', 'culled')),
+		      (SELECT MAX(m.left_at)::date FROM herd_memberships m WHERE m.animal_id = a.id)
+		  ) >= $2::date)`,
+		farmID, from, to).Scan(&n)
+	return n, err
+}
+
+// Stats reports morbidity and mortality per disease for cases diagnosed
+// between ?from= and ?to= (default: the last 365 days).
+func (h *CaseHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	q := r.URL.Query()
+	to := time.Now().Format("2006-01-02")
+	from := time.Now().AddDate(-1, 0, 0).Format("2006-01-02")
+	for name, dst := range map[string]*string{"from": &from, "to": &to} {
+		if v := q.Get(name); v != "" {
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				response.BadRequest(w, name+" must be YYYY-MM-DD")
+				return
+			}
+			*dst = v
+		}
+	}
+
+	result := DiseaseStatsResult{From: from, To: to, Diseases: []DiseaseStats{}}
+	var err error
+	result.Population, err = Population(r.Context(), h.pool, farmID, from, to)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT c.disease,
+		       COUNT(DISTINCT c.id)::int,
+		       COUNT(DISTINCT ca.animal_id)::int,
+		       COUNT(DISTINCT ca.animal_id) FILTER (WHERE ca.outcome = 'recovered')::int,
+		       COUNT(DISTINCT ca.animal_id) FILTER (WHERE ca.outcome = 'died')::int,
+		       COUNT(DISTINCT ca.animal_id) FILTER (WHERE ca.outcome = 'culled')::int,
+		       COUNT(DISTINCT ca.animal_id) FILTER (WHERE ca.animal_id IS NOT NULL AND ca.outcome IS NULL)::int
+		FROM disease_cases c
+		LEFT JOIN disease_case_animals ca ON ca.case_id = c.id
+		WHERE c.farm_id = $1 AND c.diagnosed_at BETWEEN $2::date AND $3::date
+		GROUP BY c.disease
+		ORDER BY COUNT(DISTINCT ca.animal_id) DESC, c.disease`, farmID, from, to)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s DiseaseStats
+		if err := rows.Scan(&s.Disease, &s.Cases, &s.Affected,
+			&s.Recovered, &s.Died, &s.Culled, &s.Ongoing); err != nil {
+			response.InternalError(w)
+			return
+		}
+		if result.Population > 0 {
+			s.MorbidityRate = float64(s.Affected) / float64(result.Population)
+			s.MortalityRate = float64(s.Died) / float64(result.Population)
+		}
+		if s.Affected > 0 {
+			s.CaseFatalityRate = float64(s.Died) / float64(s.Affected)
+		}
+		result.Diseases = append(result.Diseases, s)
+	}
+	response.Ok(w, result)
+}
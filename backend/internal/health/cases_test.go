@@ -0,0 +1,52 @@
+package health
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db/dbtest"
+)
+
+// TestPopulation checks that departed animals count towards a period's
+// population by the date they left, not by when their row last changed.
+func TestPopulation(t *testing.T) {
+	pool := dbtest.Pool(t)
+	farmID := dbtest.Farm(t, pool)
+	ctx := context.Background()
+
+	herdID := dbtest.Herd(t, pool, farmID, "Lote A")
+	active := dbtest.Animal(t, pool, farmID, "001", nil, nil)
+	soldBefore := dbtest.Animal(t, pool, farmID, "002", nil, nil)
+	culledDuring := dbtest.Animal(t, pool, farmID, "003", nil, nil)
+	leftBefore := dbtest.Animal(t, pool, farmID, "004", &herdID, nil)
+	later := dbtest.Animal(t, pool, farmID, "005", nil, nil)
+	dbtest.Exec(t, pool, `UPDATE animals SET created_at = '2025-06-01' WHERE id = ANY($1)`,
+		[]uuid.UUID{active, soldBefore, culledDuring, leftBefore})
+	dbtest.Exec(t, pool, `UPDATE animals SET created_at = '2027-01-15' WHERE id = $1`, later)
+
+	dbtest.Exec(t, pool, `
+		INSERT INTO reproductive_events (animal_id, farm_id, event_type, event_date)
+		VALUES ($1, $2, 'sale', '2025-12-20')`, soldBefore, farmID)
+	caseID := dbtest.ID(t, pool, `
+		INSERT INTO disease_cases (farm_id, disease, diagnosed_at)
+		VALUES ($1, 'Tristeza parasitária', '2026-01-20') RETURNING id`, farmID)
+	dbtest.Exec(t, pool, `
+		INSERT INTO disease_case_animals (case_id, animal_id, outcome, outcome_at)
+		VALUES ($1, $2, 'culled', '2026-02-01')`, caseID, culledDuring)
+	dbtest.Exec(t, pool, `
+		INSERT INTO herd_memberships (farm_id, herd_id, animal_id, joined_at, left_at)
+		VALUES ($1, $2, $3, '2025-06-01', '2025-11-01')`, farmID, herdID, leftBefore)
+	dbtest.Exec(t, pool, `UPDATE animals SET status = 'sold' WHERE id = ANY($1)`,
+		[]uuid.UUID{soldBefore, culledDuring})
+	dbtest.Exec(t, pool, `UPDATE animals SET status = 'dead' WHERE id = $1`, leftBefore)
+
+	n, err := Population(ctx, pool, farmID, "2026-01-01", "2026-12-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("population = %d, want 2 (the active and the culled animal)", n)
+	}
+}
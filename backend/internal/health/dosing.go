@@ -0,0 +1,322 @@
+package health
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// DOSE CALCULATOR
+// =============================================
+
+type DoseRequest struct {
+	// ProductID supplies the label concentration and dose; both can be
+	// overridden by the request.
+	ProductID         *uuid.UUID  `json:"product_id"`
+	ConcentrationMgMl *float64    `json:"concentration_mg_ml"`
+	DoseMgKg          *float64    `json:"dose_mg_kg"`
+	AnimalIDs         []uuid.UUID `json:"animal_ids"`
+	HerdID            *uuid.UUID  `json:"herd_id"`
+	// Date is the treatment date; weights recorded after it are ignored.
+	Date string `json:"date"`
+
+	// CreateEvents records one health event per dosed animal, taking the
+	// volume from the product's stock.
+	CreateEvents bool    `json:"create_events"`
+	EventType    string  `json:"event_type"`
+	Name         string  `json:"name"`
+	Description  *string `json:"description"`
+	CostCents    int     `json:"cost_cents"` // total, split across the animals
+	Currency     string  `json:"currency"`
+}
+
+type DoseLine struct {
+	AnimalID uuid.UUID `json:"animal_id"`
+	EarTag   string    `json:"ear_tag"`
+	Name     *string   `json:"name,omitempty"`
+	WeightKg *float64  `json:"weight_kg"`
+	// WeightSource is "recorded" for the animal's own latest weighing,
+	// "herd_average" when it has none, or empty when no weight is known.
+	WeightSource string   `json:"weight_source"`
+	WeighedAt    *string  `json:"weighed_at,omitempty"`
+	DoseMg       *float64 `json:"dose_mg"`
+	VolumeMl     *float64 `json:"volume_ml"`
+}
+
+type DoseResult struct {
+	ProductID         *uuid.UUID    `json:"product_id,omitempty"`
+	ConcentrationMgMl float64       `json:"concentration_mg_ml"`
+	DoseMgKg          float64       `json:"dose_mg_kg"`
+	Animals           []DoseLine    `json:"animals"`
+	Dosed             int           `json:"dosed"`
+	Estimated         int           `json:"estimated"` // dosed from the herd average
+	Missing           []uuid.UUID   `json:"missing"`   // no weight available
+	TotalMg           float64       `json:"total_mg"`
+	TotalMl           float64       `json:"total_ml"`
+	Events            []HealthEvent `json:"events,omitempty"`
+}
+
+// roundMl rounds a volume to what a syringe can measure.
+func roundMl(v float64) float64 { return math.Round(v*10) / 10 }
+
+// mlPerUnit is the volume in ml of one unit of the volume units a product
+// can be stocked in.
+var mlPerUnit = map[string]float64{"ml": 1, "l": 1000, "litro": 1000, "litros": 1000}
+
+// stockQuantity converts a dosed volume to the product's stock unit. It
+// reports false when the product is not stocked by volume.
+func stockQuantity(unit *string, ml float64) (float64, bool) {
+	if unit == nil {
+		return 0, false
+	}
+	f, ok := mlPerUnit[strings.ToLower(strings.TrimSpace(*unit))]
+	if !ok {
+		return 0, false
+	}
+	return ml / f, true
+}
+
+// DoseCalculator computes per-animal doses from each animal's most recent
+// weight, falling back to the average of its herd, and optionally records
+// them as health events.
+func (h *ProductHandler) DoseCalculator(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	var req DoseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if len(req.AnimalIDs) == 0 && req.HerdID == nil {
+		response.BadRequest(w, "animal_ids or herd_id required")
+		return
+	}
+	if req.Date == "" {
+		req.Date = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		response.BadRequest(w, "date must be YYYY-MM-DD")
+		return
+	}
+	if req.CostCents < 0 {
+		response.BadRequest(w, "cost_cents must not be negative")
+		return
+	}
+
+	var product *Product
+	if req.ProductID != nil {
+		p, err := scanProduct(h.pool.QueryRow(r.Context(),
+			productSelect+` WHERE id=$1 AND farm_id=$2`, *req.ProductID, farmID))
+		if err != nil {
+			response.NotFound(w, "product not found")
+			return
+		}
+		product = &p
+		if req.ConcentrationMgMl == nil {
+			req.ConcentrationMgMl = p.ConcentrationMgMl
+		}
+		if req.DoseMgKg == nil {
+			req.DoseMgKg = p.DoseMgKg
+		}
+	}
+	if req.ConcentrationMgMl == nil || *req.ConcentrationMgMl <= 0 {
+		response.BadRequest(w, "concentration_mg_ml required")
+		return
+	}
+	if req.DoseMgKg == nil || *req.DoseMgKg <= 0 {
+		response.BadRequest(w, "dose_mg_kg required")
+		return
+	}
+
+	var listed []uuid.UUID
+	if len(req.AnimalIDs) > 0 {
+		listed = req.AnimalIDs
+	}
+	rows, err := h.pool.Query(r.Context(), `
+		WITH target AS (
+			SELECT a.id, a.ear_tag, a.name, a.herd_id
+			FROM animals a
+			WHERE a.farm_id = $1
+			  AND CASE WHEN $2::uuid[] IS NOT NULL THEN a.id = ANY($2)
+			           ELSE a.status = 'active' END
+			  AND ($3::uuid IS NULL OR a.herd_id = $3)
+		),
+		latest AS (
+			SELECT DISTINCT ON (wr.animal_id) wr.animal_id, wr.weight_kg, wr.recorded_at
+			FROM weight_records wr
+			JOIN animals a ON a.id = wr.animal_id
+			WHERE a.farm_id = $1 AND wr.recorded_at <= $4::date
+			  AND (a.status = 'active' OR a.id IN (SELECT id FROM target))
+			ORDER BY wr.animal_id, wr.recorded_at DESC
+		),
+		herd_avg AS (
+			SELECT a.herd_id, AVG(l.weight_kg) AS weight_kg
+			FROM latest l
+			JOIN animals a ON a.id = l.animal_id
+			WHERE a.herd_id IS NOT NULL AND a.status = 'active'
+			GROUP BY a.herd_id
+		)
+		SELECT t.id, t.ear_tag, t.name,
+		       COALESCE(l.weight_kg, ha.weight_kg)::float8,
+		       CASE WHEN l.weight_kg IS NOT NULL THEN 'recorded'
+		            WHEN ha.weight_kg IS NOT NULL THEN 'herd_average'
+		            ELSE '' END,
+		       to_char(l.recorded_at, 'YYYY-MM-DD')
+		FROM target t
+		LEFT JOIN latest l    ON l.animal_id = t.id
+		LEFT JOIN herd_avg ha ON ha.herd_id = t.herd_id
+		ORDER BY t.ear_tag`, farmID, listed, req.HerdID, req.Date)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+
+	result := DoseResult{
+		ProductID:         req.ProductID,
+		ConcentrationMgMl: *req.ConcentrationMgMl,
+		DoseMgKg:          *req.DoseMgKg,
+		Animals:           []DoseLine{},
+		Missing:           []uuid.UUID{},
+	}
+	for rows.Next() {
+		var l DoseLine
+		if err := rows.Scan(&l.AnimalID, &l.EarTag, &l.Name,
+			&l.WeightKg, &l.WeightSource, &l.WeighedAt); err != nil {
+			response.InternalError(w)
+			return
+		}
+		if l.WeightKg == nil {
+			result.Missing = append(result.Missing, l.AnimalID)
+		} else {
+			mg := *l.WeightKg * result.DoseMgKg
+			ml := roundMl(mg / result.ConcentrationMgMl)
+			l.DoseMg, l.VolumeMl = &mg, &ml
+			result.Dosed++
+			if l.WeightSource == "herd_average" {
+				result.Estimated++
+			}
+			result.TotalMg += mg
+			result.TotalMl += ml
+		}
+		result.Animals = append(result.Animals, l)
+	}
+	if err := rows.Err(); err != nil {
+		response.InternalError(w)
+		return
+	}
+	result.TotalMl = roundMl(result.TotalMl)
+	if len(result.Animals) == 0 {
+		response.BadRequest(w, "no animals found")
+		return
+	}
+
+	if !req.CreateEvents {
+		response.Ok(w, result)
+		return
+	}
+	if result.Dosed == 0 {
+		response.BadRequest(w, "no animal has a known weight")
+		return
+	}
+
+	if req.EventType == "" {
+		req.EventType = "other"
+		if product != nil && product.Kind == "vaccine" {
+			req.EventType = "vaccine"
+		}
+	}
+	if req.Name == "" {
+		if product == nil {
+			response.BadRequest(w, "name required")
+			return
+		}
+		req.Name = product.Name
+	}
+	var productID *string
+	if req.ProductID != nil {
+		s := req.ProductID.String()
+		productID = &s
+	}
+	// Doses are taken out of stock only for products with lots, so only
+	// those need a unit a volume converts to
+	convertible := false
+	if product != nil {
+		_, convertible = stockQuantity(product.Unit, 1)
+		var tracked bool
+		if err := h.pool.QueryRow(r.Context(),
+			`SELECT EXISTS (SELECT 1 FROM product_lots WHERE product_id=$1 AND farm_id=$2)`,
+			product.ID, farmID).Scan(&tracked); err != nil {
+			response.InternalError(w)
+			return
+		}
+		if tracked && !convertible {
+			unit := "no unit"
+			if product.Unit != nil {
+				unit = "unit " + *product.Unit
+			}
+			response.BadRequest(w, "product has "+unit+"; doses can only be taken from a product stocked in ml or L")
+			return
+		}
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	result.Events = []HealthEvent{}
+	share, rest := req.CostCents/result.Dosed, req.CostCents%result.Dosed
+	i := 0
+	for _, l := range result.Animals {
+		if l.VolumeMl == nil {
+			continue
+		}
+		cost := share
+		if i < rest {
+			cost++
+		}
+		i++
+		aid := l.AnimalID.String()
+		var quantity *float64
+		if convertible {
+			q, _ := stockQuantity(product.Unit, *l.VolumeMl)
+			quantity = &q
+		}
+		e, err := Insert(r.Context(), tx, farmID, CreateRequest{
+			AnimalID:        &aid,
+			ProductID:       productID,
+			EventType:       req.EventType,
+			Name:            req.Name,
+			Description:     req.Description,
+			CostCents:       cost,
+			Currency:        req.Currency,
+			DoseMgKg:        req.DoseMgKg,
+			ProductQuantity: quantity,
+			AnimalCount:     1,
+			StartedAt:       req.Date,
+		})
+		if err != nil {
+			writeEventError(w, err)
+			return
+		}
+		result.Events = append(result.Events, e)
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if productID != nil {
+		_ = CheckStock(r.Context(), h.pool, farmID)
+	}
+	response.Created(w, result)
+}
@@ -1,7 +1,9 @@
 package health
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -10,6 +12,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/gabrielrondon/cowpro/internal/db"
 	"github.com/gabrielrondon/cowpro/internal/middleware"
 	"github.com/gabrielrondon/cowpro/pkg/response"
 )
@@ -23,6 +26,9 @@ type HealthEvent struct {
 	FarmID      uuid.UUID  `json:"farm_id"`
 	AnimalID    *uuid.UUID `json:"animal_id,omitempty"`
 	HerdID      *uuid.UUID `json:"herd_id,omitempty"`
+	ZoneID      *uuid.UUID `json:"zone_id,omitempty"`
+	ProtocolID  *uuid.UUID `json:"protocol_id,omitempty"`
+	ProductID   *uuid.UUID `json:"product_id,omitempty"`
 	EventType   string     `json:"event_type"`
 	Name        string     `json:"name"`
 	Description *string    `json:"description,omitempty"`
@@ -30,14 +36,56 @@ type HealthEvent struct {
 	Currency    string     `json:"currency"`
 	DoseMgKg    *float64   `json:"dose_mg_kg,omitempty"`
 	QuantityKg  *float64   `json:"quantity_kg,omitempty"`
-	AnimalCount int        `json:"animal_count"`
-	StartedAt   string     `json:"started_at"`
-	EndedAt     *string    `json:"ended_at,omitempty"`
+	// ProductQuantity is the amount of product used, in the product's unit
+	ProductQuantity *float64 `json:"product_quantity,omitempty"`
+	AnimalCount     int      `json:"animal_count"`
+	StartedAt       string   `json:"started_at"`
+	EndedAt         *string  `json:"ended_at,omitempty"`
+	// Animals covered by the event, including every treated member of a
+	// herd or zone treatment
+	AnimalIDs []uuid.UUID `json:"animal_ids"`
 	// Joined
-	AnimalName *string `json:"animal_name,omitempty"`
-	HerdName   *string `json:"herd_name,omitempty"`
+	AnimalName  *string `json:"animal_name,omitempty"`
+	HerdName    *string `json:"herd_name,omitempty"`
+	ZoneName    *string `json:"zone_name,omitempty"`
+	ProductName *string `json:"product_name,omitempty"`
 }
 
+const eventSelect = `
+	SELECT he.id, he.farm_id, he.animal_id, he.herd_id, he.zone_id, he.protocol_id, he.product_id,
+	       he.event_type, he.name, he.description,
+	       he.cost_cents, he.currency, he.dose_mg_kg, he.quantity_kg,
+	       he.product_quantity::float8, he.animal_count,
+	       to_char(he.started_at, 'YYYY-MM-DD'),
+	       to_char(he.ended_at,   'YYYY-MM-DD'),
+	       ARRAY(SELECT hea.animal_id FROM health_event_animals hea WHERE hea.event_id = he.id),
+	       a.name  AS animal_name,
+	       hr.name AS herd_name,
+	       z.name  AS zone_name,
+	       p.name  AS product_name
+	FROM health_events he
+	LEFT JOIN animals a  ON a.id  = he.animal_id
+	LEFT JOIN herds   hr ON hr.id = he.herd_id
+	LEFT JOIN zones   z  ON z.id  = he.zone_id
+	LEFT JOIN health_products p ON p.id = he.product_id`
+
+func scanEvent(row interface{ Scan(...any) error }) (HealthEvent, error) {
+	var e HealthEvent
+	err := row.Scan(
+		&e.ID, &e.FarmID, &e.AnimalID, &e.HerdID, &e.ZoneID, &e.ProtocolID, &e.ProductID,
+		&e.EventType, &e.Name, &e.Description,
+		&e.CostCents, &e.Currency, &e.DoseMgKg, &e.QuantityKg,
+		&e.ProductQuantity, &e.AnimalCount, &e.StartedAt, &e.EndedAt, &e.AnimalIDs,
+		&e.AnimalName, &e.HerdName, &e.ZoneName, &e.ProductName,
+	)
+	if e.AnimalIDs == nil {
+		e.AnimalIDs = []uuid.UUID{}
+	}
+	return e, err
+}
+
+// List returns the farm's health events. With ?animal_id= it returns the
+// animal's full history, including herd and zone treatments it received.
 func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
 	q := r.URL.Query()
@@ -52,24 +100,14 @@ func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
 		argN++
 	}
 	if aid := q.Get("animal_id"); aid != "" {
-		where += " AND he.animal_id = $" + strconv.Itoa(argN)
+		where += ` AND EXISTS (SELECT 1 FROM health_event_animals hea
+		                       WHERE hea.event_id = he.id AND hea.animal_id = $` + strconv.Itoa(argN) + `)`
 		args = append(args, aid)
 		argN++
 	}
 	_ = argN
 
-	rows, err := h.pool.Query(r.Context(), `
-		SELECT he.id, he.farm_id, he.animal_id, he.herd_id,
-		       he.event_type, he.name, he.description,
-		       he.cost_cents, he.currency, he.dose_mg_kg, he.quantity_kg,
-		       he.animal_count,
-		       to_char(he.started_at, 'YYYY-MM-DD'),
-		       to_char(he.ended_at,   'YYYY-MM-DD'),
-		       a.name  AS animal_name,
-		       hr.name AS herd_name
-		FROM health_events he
-		LEFT JOIN animals a  ON a.id  = he.animal_id
-		LEFT JOIN herds   hr ON hr.id = he.herd_id
+	rows, err := h.pool.Query(r.Context(), eventSelect+`
 		WHERE `+where+`
 		ORDER BY he.started_at DESC`, args...)
 	if err != nil {
@@ -80,14 +118,8 @@ func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
 
 	events := []HealthEvent{}
 	for rows.Next() {
-		var e HealthEvent
-		if err := rows.Scan(
-			&e.ID, &e.FarmID, &e.AnimalID, &e.HerdID,
-			&e.EventType, &e.Name, &e.Description,
-			&e.CostCents, &e.Currency, &e.DoseMgKg, &e.QuantityKg,
-			&e.AnimalCount, &e.StartedAt, &e.EndedAt,
-			&e.AnimalName, &e.HerdName,
-		); err != nil {
+		e, err := scanEvent(rows)
+		if err != nil {
 			response.InternalError(w)
 			return
 		}
@@ -103,24 +135,8 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid id")
 		return
 	}
-	var e HealthEvent
-	err = h.pool.QueryRow(r.Context(), `
-		SELECT he.id, he.farm_id, he.animal_id, he.herd_id,
-		       he.event_type, he.name, he.description,
-		       he.cost_cents, he.currency, he.dose_mg_kg, he.quantity_kg,
-		       he.animal_count,
-		       to_char(he.started_at,'YYYY-MM-DD'),
-		       to_char(he.ended_at,  'YYYY-MM-DD'),
-		       a.name, hr.name
-		FROM health_events he
-		LEFT JOIN animals a  ON a.id  = he.animal_id
-		LEFT JOIN herds   hr ON hr.id = he.herd_id
-		WHERE he.id=$1 AND he.farm_id=$2`, id, farmID,
-	).Scan(&e.ID, &e.FarmID, &e.AnimalID, &e.HerdID,
-		&e.EventType, &e.Name, &e.Description,
-		&e.CostCents, &e.Currency, &e.DoseMgKg, &e.QuantityKg,
-		&e.AnimalCount, &e.StartedAt, &e.EndedAt,
-		&e.AnimalName, &e.HerdName)
+	e, err := scanEvent(h.pool.QueryRow(r.Context(),
+		eventSelect+` WHERE he.id=$1 AND he.farm_id=$2`, id, farmID))
 	if err != nil {
 		response.NotFound(w, "event not found")
 		return
@@ -131,6 +147,9 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 type CreateRequest struct {
 	AnimalID    *string  `json:"animal_id"`
 	HerdID      *string  `json:"herd_id"`
+	ZoneID      *string  `json:"zone_id"`
+	ProtocolID  *string  `json:"protocol_id"`
+	ProductID   *string  `json:"product_id"`
 	EventType   string   `json:"event_type"`
 	Name        string   `json:"name"`
 	Description *string  `json:"description"`
@@ -138,9 +157,16 @@ type CreateRequest struct {
 	Currency    string   `json:"currency"`
 	DoseMgKg    *float64 `json:"dose_mg_kg"`
 	QuantityKg  *float64 `json:"quantity_kg"`
-	AnimalCount int      `json:"animal_count"`
-	StartedAt   string   `json:"started_at"`
-	EndedAt     *string  `json:"ended_at"`
+	// ProductQuantity is taken from the product's stock, first-expiring lot
+	// first. When CostCents is 0 the cost is derived from the lots used.
+	ProductQuantity *float64 `json:"product_quantity"`
+	AnimalCount     int      `json:"animal_count"`
+	StartedAt       string   `json:"started_at"`
+	EndedAt         *string  `json:"ended_at"`
+	// AnimalIDs lists the animals actually treated by a herd or zone
+	// treatment. When empty, every active animal of the herd or zone is
+	// recorded.
+	AnimalIDs []uuid.UUID `json:"animal_ids"`
 }
 
 func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
@@ -150,43 +176,285 @@ func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "name, event_type and started_at required")
 		return
 	}
+	req.defaults()
+	if msg := req.validateDates(); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	e, err := Insert(r.Context(), tx, farmID, req)
+	if err != nil {
+		writeEventError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if e.ProductID != nil {
+		_ = CheckStock(r.Context(), h.pool, farmID)
+	}
+	response.Created(w, e)
+}
+
+// Errors returned by Insert for requests that reference bad ids.
+var (
+	ErrInvalidID        = errors.New("invalid id")
+	ErrProductNotFound  = errors.New("product not found")
+	ErrProtocolNotFound = errors.New("protocol not found")
+)
+
+// writeEventError maps an error from storing an event to a response.
+func writeEventError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidID):
+		response.BadRequest(w, "animal_id, herd_id, zone_id, protocol_id and product_id must be valid ids")
+	case errors.Is(err, ErrProductNotFound):
+		response.NotFound(w, "product not found")
+	case errors.Is(err, ErrProtocolNotFound):
+		response.NotFound(w, "protocol not found")
+	case errors.Is(err, ErrInsufficientStock):
+		response.Error(w, http.StatusConflict, "not enough product in stock")
+	case errors.Is(err, ErrMixedCurrencies):
+		response.Error(w, http.StatusUnprocessableEntity,
+			"the lots used are priced in different currencies; set cost_cents and currency")
+	default:
+		response.InternalError(w)
+	}
+}
+
+// parseIDs converts the optional string ids of a request. A malformed id
+// yields ErrInvalidID.
+func (req CreateRequest) parseIDs() (animalID, herdID, zoneID, protocolID, productID *uuid.UUID, err error) {
+	parse := func(s *string) *uuid.UUID {
+		if s == nil || *s == "" {
+			return nil
+		}
+		id, perr := uuid.Parse(*s)
+		if perr != nil {
+			err = ErrInvalidID
+			return nil
+		}
+		return &id
+	}
+	animalID, herdID, zoneID = parse(req.AnimalID), parse(req.HerdID), parse(req.ZoneID)
+	protocolID, productID = parse(req.ProtocolID), parse(req.ProductID)
+	return animalID, herdID, zoneID, protocolID, productID, err
+}
+
+// checkRefs makes sure the protocol and product of an event belong to the
+// farm, so another farm's product never shows up in its withdrawals.
+func checkRefs(ctx context.Context, q db.DBTX, farmID uuid.UUID, protocolID, productID *uuid.UUID) error {
+	var exists bool
+	if protocolID != nil {
+		if err := q.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM health_protocols WHERE id=$1 AND farm_id=$2)`,
+			*protocolID, farmID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrProtocolNotFound
+		}
+	}
+	if productID != nil {
+		if err := q.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM health_products WHERE id=$1 AND farm_id=$2)`,
+			*productID, farmID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrProductNotFound
+		}
+	}
+	return nil
+}
+
+func (req *CreateRequest) defaults() {
 	if req.Currency == "" {
 		req.Currency = "BRL"
 	}
 	if req.AnimalCount < 1 {
 		req.AnimalCount = 1
 	}
-	var animalID, herdID *uuid.UUID
-	if req.AnimalID != nil {
-		id, _ := uuid.Parse(*req.AnimalID)
-		animalID = &id
+	if req.StartedAt == "" {
+		req.StartedAt = time.Now().Format("2006-01-02")
+	}
+}
+
+// validateDates checks started_at and the optional ended_at, returning the
+// error message or "" when both are valid dates.
+func (req *CreateRequest) validateDates() string {
+	if _, err := time.Parse("2006-01-02", req.StartedAt); err != nil {
+		return "started_at must be YYYY-MM-DD"
 	}
-	if req.HerdID != nil {
-		id, _ := uuid.Parse(*req.HerdID)
-		herdID = &id
+	if req.EndedAt != nil {
+		if _, err := time.Parse("2006-01-02", *req.EndedAt); err != nil {
+			return "ended_at must be YYYY-MM-DD"
+		}
 	}
+	return ""
+}
 
-	var e HealthEvent
-	err := h.pool.QueryRow(r.Context(), `
+// Insert stores a health event and the animals it covers. It is shared with
+// flows that record health events as a side effect, such as completing a
+// task, and should run inside a transaction.
+func Insert(ctx context.Context, q db.DBTX, farmID uuid.UUID, req CreateRequest) (HealthEvent, error) {
+	req.defaults()
+	animalID, herdID, zoneID, protocolID, productID, err := req.parseIDs()
+	if err != nil {
+		return HealthEvent{}, err
+	}
+	if err := checkRefs(ctx, q, farmID, protocolID, productID); err != nil {
+		return HealthEvent{}, err
+	}
+
+	id := uuid.New()
+	_, err = q.Exec(ctx, `
 		INSERT INTO health_events
 		  (id, farm_id, animal_id, herd_id, event_type, name, description,
 		   cost_cents, currency, dose_mg_kg, quantity_kg, animal_count,
-		   started_at, ended_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13::date,$14::date)
-		RETURNING id, farm_id, animal_id, herd_id, event_type, name, description,
-		          cost_cents, currency, dose_mg_kg, quantity_kg, animal_count,
-		          to_char(started_at,'YYYY-MM-DD'), to_char(ended_at,'YYYY-MM-DD')`,
-		uuid.New(), farmID, animalID, herdID, req.EventType, req.Name, req.Description,
+		   started_at, ended_at, protocol_id, zone_id, product_id, product_quantity)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13::date,$14::date,$15,$16,$17,$18)`,
+		id, farmID, animalID, herdID, req.EventType, req.Name, req.Description,
 		req.CostCents, req.Currency, req.DoseMgKg, req.QuantityKg, req.AnimalCount,
-		req.StartedAt, req.EndedAt,
-	).Scan(&e.ID, &e.FarmID, &e.AnimalID, &e.HerdID, &e.EventType, &e.Name, &e.Description,
-		&e.CostCents, &e.Currency, &e.DoseMgKg, &e.QuantityKg, &e.AnimalCount,
-		&e.StartedAt, &e.EndedAt)
+		req.StartedAt, req.EndedAt, protocolID, zoneID, productID, req.ProductQuantity,
+	)
+	if err != nil {
+		return HealthEvent{}, err
+	}
+	if err := fanOut(ctx, q, farmID, id, animalID, herdID, zoneID, req.AnimalIDs); err != nil {
+		return HealthEvent{}, err
+	}
+	if err := applyStock(ctx, q, farmID, id, productID, req); err != nil {
+		return HealthEvent{}, err
+	}
+	return scanEvent(q.QueryRow(ctx, eventSelect+` WHERE he.id=$1`, id))
+}
+
+// fanOut records the animals covered by an event: the individual animal, or
+// for herd and zone treatments the listed animals (restricted to members of
+// that herd or zone) or else all of its active members. The event's
+// animal_count follows the number of animals recorded.
+func fanOut(ctx context.Context, q db.DBTX, farmID, eventID uuid.UUID,
+	animalID, herdID, zoneID *uuid.UUID, treated []uuid.UUID) error {
+	if _, err := q.Exec(ctx, `DELETE FROM health_event_animals WHERE event_id=$1`, eventID); err != nil {
+		return err
+	}
+	if animalID == nil && herdID == nil && zoneID == nil && len(treated) == 0 {
+		return nil
+	}
+	var listed []uuid.UUID
+	if len(treated) > 0 {
+		listed = treated
+	}
+	tag, err := q.Exec(ctx, `
+		INSERT INTO health_event_animals (event_id, animal_id)
+		SELECT $1, a.id FROM animals a
+		WHERE a.farm_id = $2
+		  AND CASE
+		        WHEN $3::uuid IS NOT NULL THEN a.id = $3
+		        WHEN $6::uuid[] IS NOT NULL THEN a.id = ANY($6)
+		             AND ($4::uuid IS NULL OR a.herd_id = $4)
+		             AND ($5::uuid IS NULL OR a.zone_id = $5)
+		        ELSE a.status = 'active'
+		             AND ($4::uuid IS NULL OR a.herd_id = $4)
+		             AND ($5::uuid IS NULL OR a.zone_id = $5)
+		      END
+		ON CONFLICT DO NOTHING`,
+		eventID, farmID, animalID, herdID, zoneID, listed)
+	if err != nil {
+		return err
+	}
+	if animalID == nil && tag.RowsAffected() > 0 {
+		_, err = q.Exec(ctx, `UPDATE health_events SET animal_count=$2 WHERE id=$1`,
+			eventID, tag.RowsAffected())
+	}
+	return err
+}
+
+// Update replaces an event. Herd and zone treatments are fanned out again,
+// so the covered animals follow the new target.
+func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid id")
+		return
+	}
+	var req CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.EventType == "" {
+		response.BadRequest(w, "name, event_type and started_at required")
+		return
+	}
+	req.defaults()
+	if msg := req.validateDates(); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+	animalID, herdID, zoneID, protocolID, productID, err := req.parseIDs()
+	if err != nil {
+		writeEventError(w, err)
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
 	if err != nil {
 		response.InternalError(w)
 		return
 	}
-	response.Created(w, e)
+	defer tx.Rollback(r.Context())
+
+	if err := checkRefs(r.Context(), tx, farmID, protocolID, productID); err != nil {
+		writeEventError(w, err)
+		return
+	}
+
+	tag, err := tx.Exec(r.Context(), `
+		UPDATE health_events SET
+		  animal_id=$3, herd_id=$4, zone_id=$5, protocol_id=$6, event_type=$7, name=$8,
+		  description=$9, cost_cents=$10, currency=$11, dose_mg_kg=$12, quantity_kg=$13,
+		  animal_count=$14, started_at=$15::date, ended_at=$16::date, product_id=$17,
+		  product_quantity=$18
+		WHERE id=$1 AND farm_id=$2`,
+		id, farmID, animalID, herdID, zoneID, protocolID, req.EventType, req.Name,
+		req.Description, req.CostCents, req.Currency, req.DoseMgKg, req.QuantityKg,
+		req.AnimalCount, req.StartedAt, req.EndedAt, productID, req.ProductQuantity)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.NotFound(w, "event not found")
+		return
+	}
+	if err := fanOut(r.Context(), tx, farmID, id, animalID, herdID, zoneID, req.AnimalIDs); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := releaseStock(r.Context(), tx, id); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := applyStock(r.Context(), tx, farmID, id, productID, req); err != nil {
+		writeEventError(w, err)
+		return
+	}
+	e, err := scanEvent(tx.QueryRow(r.Context(), eventSelect+` WHERE he.id=$1`, id))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, e)
 }
 
 func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
@@ -196,10 +464,33 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid id")
 		return
 	}
-	_, _ = h.pool.Exec(r.Context(),
-		`DELETE FROM health_events WHERE id=$1 AND farm_id=$2`, id, farmID)
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var found uuid.UUID
+	err = tx.QueryRow(r.Context(),
+		`SELECT id FROM health_events WHERE id=$1 AND farm_id=$2 FOR UPDATE`, id, farmID).Scan(&found)
+	if err != nil {
+		response.NoContent(w)
+		return
+	}
+	// Give back what the event consumed before its movements cascade
+	if err := releaseStock(r.Context(), tx, id); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if _, err := tx.Exec(r.Context(),
+		`DELETE FROM health_events WHERE id=$1 AND farm_id=$2`, id, farmID); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
 	response.NoContent(w)
 }
-
-// silence unused
-var _ = time.Now
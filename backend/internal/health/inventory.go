@@ -0,0 +1,613 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// PHARMACY INVENTORY
+// =============================================
+
+// ErrInsufficientStock is returned when a health event uses more of a
+// product than the farm has in non-expired lots.
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// ErrMixedCurrencies is returned when the cost of a health event would be
+// derived from lots priced in different currencies.
+var ErrMixedCurrencies = errors.New("lots in different currencies")
+
+type Lot struct {
+	ID                uuid.UUID `json:"id"`
+	ProductID         uuid.UUID `json:"product_id"`
+	LotNumber         *string   `json:"lot_number,omitempty"`
+	ExpiresOn         *string   `json:"expires_on,omitempty"`
+	QuantityInitial   float64   `json:"quantity_initial"`
+	QuantityRemaining float64   `json:"quantity_remaining"`
+	UnitCostCents     float64   `json:"unit_cost_cents"`
+	Currency          string    `json:"currency"`
+	Supplier          *string   `json:"supplier,omitempty"`
+	ReceivedAt        string    `json:"received_at"`
+	Expired           bool      `json:"expired"`
+}
+
+type StockMovement struct {
+	ID            uuid.UUID  `json:"id"`
+	LotID         uuid.UUID  `json:"lot_id"`
+	LotNumber     *string    `json:"lot_number,omitempty"`
+	Kind          string     `json:"kind"`
+	Quantity      float64    `json:"quantity"`
+	UnitCostCents float64    `json:"unit_cost_cents"`
+	HealthEventID *uuid.UUID `json:"health_event_id,omitempty"`
+	Notes         *string    `json:"notes,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type StockSummary struct {
+	ProductID      uuid.UUID `json:"product_id"`
+	Name           string    `json:"name"`
+	Unit           *string   `json:"unit,omitempty"`
+	OnHand         float64   `json:"on_hand"` // non-expired lots
+	Expired        float64   `json:"expired"` // still on the shelf, past expiry
+	ValueCents     int64     `json:"value_cents"`
+	MinStock       *float64  `json:"min_stock,omitempty"`
+	LowStock       bool      `json:"low_stock"`
+	NextExpiry     *string   `json:"next_expiry,omitempty"`
+	ExpiringSoonQt float64   `json:"expiring_soon"` // within the product's expiry_alert_days
+}
+
+// Stock returns on-hand quantities and value per product.
+func (h *ProductHandler) Stock(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT p.id, p.name, p.unit, p.min_stock::float8,
+		       COALESCE(SUM(l.quantity_remaining) FILTER (
+		           WHERE l.expires_on IS NULL OR l.expires_on >= CURRENT_DATE), 0)::float8,
+		       COALESCE(SUM(l.quantity_remaining) FILTER (
+		           WHERE l.expires_on < CURRENT_DATE), 0)::float8,
+		       COALESCE(SUM(l.quantity_remaining * l.unit_cost_cents) FILTER (
+		           WHERE l.expires_on IS NULL OR l.expires_on >= CURRENT_DATE), 0)::bigint,
+		       to_char(MIN(l.expires_on) FILTER (WHERE l.expires_on >= CURRENT_DATE), 'YYYY-MM-DD'),
+		       COALESCE(SUM(l.quantity_remaining) FILTER (
+		           WHERE l.expires_on >= CURRENT_DATE
+		             AND l.expires_on < CURRENT_DATE + p.expiry_alert_days), 0)::float8
+		FROM health_products p
+		LEFT JOIN product_lots l ON l.product_id = p.id AND l.quantity_remaining > 0
+		WHERE p.farm_id = $1
+		GROUP BY p.id
+		ORDER BY p.name`, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+
+	stock := []StockSummary{}
+	for rows.Next() {
+		var s StockSummary
+		if err := rows.Scan(&s.ProductID, &s.Name, &s.Unit, &s.MinStock, &s.OnHand, &s.Expired,
+			&s.ValueCents, &s.NextExpiry, &s.ExpiringSoonQt); err != nil {
+			response.InternalError(w)
+			return
+		}
+		s.LowStock = s.MinStock != nil && s.OnHand < *s.MinStock
+		stock = append(stock, s)
+	}
+	response.Ok(w, stock)
+}
+
+// Lots lists a product's lots, including empty ones with ?all=true.
+func (h *ProductHandler) Lots(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	productID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid product id")
+		return
+	}
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT id, product_id, lot_number, to_char(expires_on, 'YYYY-MM-DD'),
+		       quantity_initial::float8, quantity_remaining::float8, unit_cost_cents::float8,
+		       currency, supplier, to_char(received_at, 'YYYY-MM-DD'),
+		       COALESCE(expires_on < CURRENT_DATE, FALSE)
+		FROM product_lots
+		WHERE product_id = $1 AND farm_id = $2 AND ($3 OR quantity_remaining > 0)
+		ORDER BY expires_on NULLS LAST, received_at`,
+		productID, farmID, r.URL.Query().Get("all") == "true")
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+
+	lots := []Lot{}
+	for rows.Next() {
+		var l Lot
+		if err := rows.Scan(&l.ID, &l.ProductID, &l.LotNumber, &l.ExpiresOn,
+			&l.QuantityInitial, &l.QuantityRemaining, &l.UnitCostCents,
+			&l.Currency, &l.Supplier, &l.ReceivedAt, &l.Expired); err != nil {
+			response.InternalError(w)
+			return
+		}
+		lots = append(lots, l)
+	}
+	response.Ok(w, lots)
+}
+
+// Movements lists a product's stock ledger, newest first.
+func (h *ProductHandler) Movements(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	productID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid product id")
+		return
+	}
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT m.id, m.lot_id, l.lot_number, m.kind, m.quantity::float8, m.unit_cost_cents::float8,
+		       m.health_event_id, m.notes, m.created_at
+		FROM stock_movements m
+		JOIN product_lots l ON l.id = m.lot_id
+		WHERE m.product_id = $1 AND m.farm_id = $2
+		ORDER BY m.created_at DESC
+		LIMIT 500`, productID, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+
+	moves := []StockMovement{}
+	for rows.Next() {
+		var m StockMovement
+		if err := rows.Scan(&m.ID, &m.LotID, &m.LotNumber, &m.Kind, &m.Quantity, &m.UnitCostCents,
+			&m.HealthEventID, &m.Notes, &m.CreatedAt); err != nil {
+			response.InternalError(w)
+			return
+		}
+		moves = append(moves, m)
+	}
+	response.Ok(w, moves)
+}
+
+type PurchaseRequest struct {
+	LotNumber     *string `json:"lot_number"`
+	ExpiresOn     *string `json:"expires_on"`
+	Quantity      float64 `json:"quantity"`
+	UnitCostCents float64 `json:"unit_cost_cents"`
+	Currency      string  `json:"currency"`
+	Supplier      *string `json:"supplier"`
+	ReceivedAt    string  `json:"received_at"`
+	Notes         *string `json:"notes"`
+}
+
+// Purchase adds stock as a new lot.
+func (h *ProductHandler) Purchase(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	userID := middleware.UserIDFromCtx(r.Context())
+	productID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid product id")
+		return
+	}
+	var req PurchaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if req.Quantity <= 0 {
+		response.BadRequest(w, "quantity must be positive")
+		return
+	}
+	if req.UnitCostCents < 0 {
+		response.BadRequest(w, "unit_cost_cents must not be negative")
+		return
+	}
+	if req.ExpiresOn != nil {
+		if _, err := time.Parse("2006-01-02", *req.ExpiresOn); err != nil {
+			response.BadRequest(w, "expires_on must be YYYY-MM-DD")
+			return
+		}
+	}
+	if req.ReceivedAt == "" {
+		req.ReceivedAt = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.ReceivedAt); err != nil {
+		response.BadRequest(w, "received_at must be YYYY-MM-DD")
+		return
+	}
+	if req.Currency == "" {
+		req.Currency = "BRL"
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var exists bool
+	_ = tx.QueryRow(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM health_products WHERE id=$1 AND farm_id=$2)`,
+		productID, farmID).Scan(&exists)
+	if !exists {
+		response.NotFound(w, "product not found")
+		return
+	}
+
+	lotID := uuid.New()
+	_, err = tx.Exec(r.Context(), `
+		INSERT INTO product_lots
+		  (id, farm_id, product_id, lot_number, expires_on, quantity_initial, quantity_remaining,
+		   unit_cost_cents, currency, supplier, received_at)
+		VALUES ($1,$2,$3,$4,$5::date,$6,$6,$7,$8,$9,$10::date)`,
+		lotID, farmID, productID, req.LotNumber, req.ExpiresOn, req.Quantity,
+		req.UnitCostCents, req.Currency, req.Supplier, req.ReceivedAt)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	_, err = tx.Exec(r.Context(), `
+		INSERT INTO stock_movements
+		  (id, farm_id, product_id, lot_id, kind, quantity, unit_cost_cents, notes, created_by)
+		VALUES ($1,$2,$3,$4,'purchase',$5,$6,$7,$8)`,
+		uuid.New(), farmID, productID, lotID, req.Quantity, req.UnitCostCents, req.Notes, userID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Created(w, map[string]any{"lot_id": lotID})
+}
+
+type AdjustmentRequest struct {
+	LotID    uuid.UUID `json:"lot_id"`
+	Kind     string    `json:"kind"`     // adjustment | discard
+	Quantity float64   `json:"quantity"` // signed for adjustments, positive for discards
+	Notes    *string   `json:"notes"`
+}
+
+// Adjust corrects a lot after a stock count, or discards expired or damaged
+// product.
+func (h *ProductHandler) Adjust(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	userID := middleware.UserIDFromCtx(r.Context())
+	productID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid product id")
+		return
+	}
+	var req AdjustmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = "adjustment"
+	}
+	switch req.Kind {
+	case "adjustment":
+	case "discard":
+		req.Quantity = -math.Abs(req.Quantity)
+	default:
+		response.BadRequest(w, "kind must be adjustment or discard")
+		return
+	}
+	if req.Quantity == 0 {
+		response.BadRequest(w, "quantity required")
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var unitCost float64
+	err = tx.QueryRow(r.Context(), `
+		UPDATE product_lots SET quantity_remaining = quantity_remaining + $4
+		WHERE id=$1 AND product_id=$2 AND farm_id=$3 AND quantity_remaining + $4 >= 0
+		RETURNING unit_cost_cents::float8`,
+		req.LotID, productID, farmID, req.Quantity).Scan(&unitCost)
+	if err != nil {
+		response.BadRequest(w, "unknown lot or quantity exceeds the lot's stock")
+		return
+	}
+	_, err = tx.Exec(r.Context(), `
+		INSERT INTO stock_movements
+		  (id, farm_id, product_id, lot_id, kind, quantity, unit_cost_cents, notes, created_by)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+		uuid.New(), farmID, productID, req.LotID, req.Kind, req.Quantity, unitCost, req.Notes, userID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	_ = CheckStock(r.Context(), h.pool, farmID)
+	response.NoContent(w)
+}
+
+// consumeStock takes quantity of a product for a health event from its
+// non-expired lots, first-expiring first, and returns the cost of what was
+// used and the currency of the lots (empty when they differ).
+func consumeStock(ctx context.Context, q db.DBTX, farmID, productID, eventID uuid.UUID,
+	quantity float64, on string) (int, string, error) {
+	rows, err := q.Query(ctx, `
+		SELECT id, quantity_remaining::float8, unit_cost_cents::float8, currency
+		FROM product_lots
+		WHERE product_id = $1 AND farm_id = $2 AND quantity_remaining > 0
+		  AND (expires_on IS NULL OR expires_on >= $3::date)
+		ORDER BY expires_on NULLS LAST, received_at, created_at
+		FOR UPDATE`, productID, farmID, on)
+	if err != nil {
+		return 0, "", err
+	}
+	type lot struct {
+		id        uuid.UUID
+		remaining float64
+		unitCost  float64
+		currency  string
+	}
+	lots := []lot{}
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.id, &l.remaining, &l.unitCost, &l.currency); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		lots = append(lots, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", err
+	}
+
+	left := quantity
+	cost := 0.0
+	currency := ""
+	for i, l := range lots {
+		if left <= 0 {
+			break
+		}
+		take := math.Min(left, l.remaining)
+		if _, err := q.Exec(ctx,
+			`UPDATE product_lots SET quantity_remaining = quantity_remaining - $2 WHERE id = $1`,
+			l.id, take); err != nil {
+			return 0, "", err
+		}
+		if _, err := q.Exec(ctx, `
+			INSERT INTO stock_movements
+			  (id, farm_id, product_id, lot_id, kind, quantity, unit_cost_cents, health_event_id)
+			VALUES ($1,$2,$3,$4,'consumption',$5,$6,$7)`,
+			uuid.New(), farmID, productID, l.id, -take, l.unitCost, eventID); err != nil {
+			return 0, "", err
+		}
+		cost += take * l.unitCost
+		left -= take
+		if i == 0 {
+			currency = l.currency
+		} else if currency != l.currency {
+			currency = ""
+		}
+	}
+	// Tolerate rounding noise from NUMERIC(12,3) quantities
+	if left > 0.0005 {
+		return 0, "", ErrInsufficientStock
+	}
+	return int(math.Round(cost)), currency, nil
+}
+
+// releaseStock returns what a health event consumed to its lots, before the
+// event is edited or deleted.
+func releaseStock(ctx context.Context, q db.DBTX, eventID uuid.UUID) error {
+	_, err := q.Exec(ctx, `
+		WITH used AS (
+			DELETE FROM stock_movements
+			WHERE health_event_id = $1 AND kind = 'consumption'
+			RETURNING lot_id, quantity
+		)
+		UPDATE product_lots l SET quantity_remaining = l.quantity_remaining - u.total
+		FROM (SELECT lot_id, SUM(quantity) AS total FROM used GROUP BY lot_id) u
+		WHERE l.id = u.lot_id`, eventID)
+	return err
+}
+
+// applyStock consumes the product recorded on an event and, when the event
+// has no explicit cost, derives cost_cents from the lots used.
+func applyStock(ctx context.Context, q db.DBTX, farmID, eventID uuid.UUID,
+	productID *uuid.UUID, req CreateRequest) error {
+	if productID == nil || req.ProductQuantity == nil || *req.ProductQuantity <= 0 {
+		return nil
+	}
+	// Products that never had a lot are not managed in the inventory
+	var tracked bool
+	if err := q.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM product_lots WHERE product_id=$1 AND farm_id=$2)`, *productID, farmID,
+	).Scan(&tracked); err != nil || !tracked {
+		return err
+	}
+	cost, currency, err := consumeStock(ctx, q, farmID, *productID, eventID, *req.ProductQuantity, req.StartedAt)
+	if err != nil {
+		return err
+	}
+	if req.CostCents == 0 && currency == "" {
+		return ErrMixedCurrencies
+	}
+	if req.CostCents == 0 {
+		_, err = q.Exec(ctx,
+			`UPDATE health_events SET cost_cents=$2, currency=$3 WHERE id=$1`, eventID, cost, currency)
+	}
+	return err
+}
+
+// =============================================
+// STOCK ALERTS
+// =============================================
+
+// raiseAlert keeps one unread stock alert per type, product and lot (nil
+// for product-wide alerts): an existing one gets the new severity and
+// message, otherwise a new alert is inserted.
+func raiseAlert(ctx context.Context, q db.DBTX, farmID, productID uuid.UUID, lotID *uuid.UUID,
+	alertType, severity, message string) error {
+	tag, err := q.Exec(ctx, `
+		UPDATE alerts SET severity=$5, message=$6
+		WHERE farm_id=$1 AND type=$2 AND product_id=$3 AND lot_id IS NOT DISTINCT FROM $4
+		  AND is_read=FALSE`, farmID, alertType, productID, lotID, severity, message)
+	if err != nil || tag.RowsAffected() > 0 {
+		return err
+	}
+	_, err = q.Exec(ctx, `
+		INSERT INTO alerts (id, farm_id, type, severity, message, product_id, lot_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		uuid.New(), farmID, alertType, severity, message, productID, lotID)
+	return err
+}
+
+// CheckStock raises low_stock alerts for products below their minimum and
+// product_expiring alerts for lots expiring within the product's warning
+// window (or already expired with stock left).
+func CheckStock(ctx context.Context, q db.DBTX, farmID uuid.UUID) error {
+	rows, err := q.Query(ctx, `
+		SELECT p.id, p.name, p.unit, p.min_stock::float8,
+		       COALESCE(SUM(l.quantity_remaining) FILTER (
+		           WHERE l.expires_on IS NULL OR l.expires_on >= CURRENT_DATE), 0)::float8
+		FROM health_products p
+		LEFT JOIN product_lots l ON l.product_id = p.id
+		WHERE p.farm_id = $1 AND p.min_stock IS NOT NULL
+		GROUP BY p.id
+		HAVING COALESCE(SUM(l.quantity_remaining) FILTER (
+		           WHERE l.expires_on IS NULL OR l.expires_on >= CURRENT_DATE), 0) < p.min_stock`, farmID)
+	if err != nil {
+		return err
+	}
+	type low struct {
+		id     uuid.UUID
+		name   string
+		unit   *string
+		min    float64
+		onHand float64
+	}
+	lows := []low{}
+	for rows.Next() {
+		var l low
+		if err := rows.Scan(&l.id, &l.name, &l.unit, &l.min, &l.onHand); err != nil {
+			rows.Close()
+			return err
+		}
+		lows = append(lows, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, l := range lows {
+		unit := ""
+		if l.unit != nil {
+			unit = " " + *l.unit
+		}
+		msg := fmt.Sprintf("Estoque baixo de %s: %g%s (mínimo %g%s)", l.name, l.onHand, unit, l.min, unit)
+		if err := raiseAlert(ctx, q, farmID, l.id, nil, "low_stock", "warning", msg); err != nil {
+			return err
+		}
+	}
+
+	rows, err = q.Query(ctx, `
+		SELECT p.id, l.id, p.name, COALESCE(l.lot_number, ''), to_char(l.expires_on, 'DD/MM/YYYY'),
+		       l.expires_on < CURRENT_DATE
+		FROM product_lots l
+		JOIN health_products p ON p.id = l.product_id
+		WHERE l.farm_id = $1 AND l.quantity_remaining > 0
+		  AND l.expires_on < CURRENT_DATE + p.expiry_alert_days`, farmID)
+	if err != nil {
+		return err
+	}
+	type expiring struct {
+		productID, lotID uuid.UUID
+		name, lot, date  string
+		expired          bool
+	}
+	exps := []expiring{}
+	for rows.Next() {
+		var e expiring
+		if err := rows.Scan(&e.productID, &e.lotID, &e.name, &e.lot, &e.date, &e.expired); err != nil {
+			rows.Close()
+			return err
+		}
+		exps = append(exps, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, e := range exps {
+		lot := ""
+		if e.lot != "" {
+			lot = " (lote " + e.lot + ")"
+		}
+		msg := fmt.Sprintf("%s%s vence em %s", e.name, lot, e.date)
+		severity := "info"
+		if e.expired {
+			msg = fmt.Sprintf("%s%s venceu em %s", e.name, lot, e.date)
+			severity = "warning"
+		}
+		if err := raiseAlert(ctx, q, farmID, e.productID, &e.lotID, "product_expiring", severity, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunStockMonitor checks every farm with stock on each tick until ctx is
+// canceled. Expiry alerts depend on the date, so they cannot be raised only
+// when stock changes.
+func RunStockMonitor(ctx context.Context, pool *pgxpool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rows, err := pool.Query(ctx, `SELECT DISTINCT farm_id FROM product_lots WHERE quantity_remaining > 0
+		                               UNION SELECT farm_id FROM health_products WHERE min_stock IS NOT NULL`)
+		if err == nil {
+			farms := []uuid.UUID{}
+			for rows.Next() {
+				var id uuid.UUID
+				if rows.Scan(&id) == nil {
+					farms = append(farms, id)
+				}
+			}
+			rows.Close()
+			for _, id := range farms {
+				if err := CheckStock(ctx, pool, id); err != nil {
+					slog.Error("stock check failed", "farm_id", id, "err", err)
+				}
+			}
+		} else if ctx.Err() == nil {
+			slog.Error("stock monitor query failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/gabrielrondon/cowpro/internal/db/dbtest"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+)
+
+// stockLot is a lot to create: expiry date ("" for none), quantity, unit
+// cost and currency.
+type stockLot struct {
+	expires  string
+	quantity float64
+	unitCost float64
+	currency string
+}
+
+// stockFixture creates a product with the given lots.
+func stockFixture(t *testing.T, pool *pgxpool.Pool, farmID uuid.UUID, lots ...stockLot) (uuid.UUID, []uuid.UUID) {
+	t.Helper()
+	productID := dbtest.ID(t, pool, `
+		INSERT INTO health_products (farm_id, name, kind, unit)
+		VALUES ($1, 'Ivermectina', 'antiparasitic', 'ml') RETURNING id`, farmID)
+	ids := make([]uuid.UUID, len(lots))
+	for i, l := range lots {
+		var expires *string
+		if l.expires != "" {
+			expires = &l.expires
+		}
+		ids[i] = dbtest.ID(t, pool, `
+			INSERT INTO product_lots
+			  (farm_id, product_id, expires_on, quantity_initial, quantity_remaining, unit_cost_cents, currency)
+			VALUES ($1, $2, $3::date, $4, $4, $5, $6) RETURNING id`,
+			farmID, productID, expires, l.quantity, l.unitCost, l.currency)
+	}
+	return productID, ids
+}
+
+func remaining(t *testing.T, pool *pgxpool.Pool, lotIDs []uuid.UUID) []float64 {
+	t.Helper()
+	out := make([]float64, len(lotIDs))
+	for i, id := range lotIDs {
+		if err := pool.QueryRow(context.Background(),
+			`SELECT quantity_remaining::float8 FROM product_lots WHERE id=$1`, id).Scan(&out[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out
+}
+
+func equalFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func treatment(productID uuid.UUID, quantity float64, costCents int) CreateRequest {
+	p := productID.String()
+	return CreateRequest{
+		ProductID: &p, EventType: "treatment", Name: "Vermifugação",
+		CostCents: costCents, ProductQuantity: &quantity, StartedAt: "2026-03-10",
+	}
+}
+
+// TestConsumeStockFEFO checks that a health event takes its product from
+// the first-expiring lots, skips expired ones, costs what it used and
+// consumes nothing when stock is short.
+func TestConsumeStockFEFO(t *testing.T) {
+	pool := dbtest.Pool(t)
+	farmID := dbtest.Farm(t, pool)
+	ctx := context.Background()
+
+	productID, lots := stockFixture(t, pool, farmID,
+		stockLot{"2026-12-01", 5, 100, "BRL"},
+		stockLot{"2026-06-01", 3, 200, "BRL"},
+		stockLot{"2025-01-01", 10, 50, "BRL"}, // expired on the event date
+		stockLot{"", 2, 300, "BRL"},
+	)
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	e, err := Insert(ctx, tx, farmID, treatment(productID, 4, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if e.CostCents != 700 || e.Currency != "BRL" {
+		t.Errorf("cost = %d %s, want 3×200 + 1×100 = 700 BRL", e.CostCents, e.Currency)
+	}
+	if got, want := remaining(t, pool, lots), []float64{4, 0, 10, 2}; !equalFloats(got, want) {
+		t.Errorf("remaining = %v, want %v", got, want)
+	}
+
+	tx, err = pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := Insert(ctx, tx, farmID, treatment(productID, 7, 0)); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("err = %v, want ErrInsufficientStock (6 usable)", err)
+	}
+}
+
+// TestDeleteReleasesStock checks that deleting a health event gives back
+// what it consumed to the lots it came from.
+func TestDeleteReleasesStock(t *testing.T) {
+	pool := dbtest.Pool(t)
+	farmID := dbtest.Farm(t, pool)
+	ctx := context.Background()
+
+	productID, lots := stockFixture(t, pool, farmID,
+		stockLot{"2026-06-01", 3, 200, "BRL"},
+		stockLot{"2026-12-01", 5, 100, "BRL"},
+	)
+	e, err := Insert(ctx, pool, farmID, treatment(productID, 4, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := remaining(t, pool, lots), []float64{0, 4}; !equalFloats(got, want) {
+		t.Fatalf("remaining after event = %v, want %v", got, want)
+	}
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", e.ID.String())
+	r := httptest.NewRequest(http.MethodDelete, "/health-events/"+e.ID.String(), nil)
+	r = r.WithContext(context.WithValue(
+		context.WithValue(r.Context(), chi.RouteCtxKey, rctx), middleware.FarmIDKey, farmID))
+	w := httptest.NewRecorder()
+	NewHandler(pool).Delete(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete = %d %s", w.Code, w.Body)
+	}
+
+	if got, want := remaining(t, pool, lots), []float64{3, 5}; !equalFloats(got, want) {
+		t.Errorf("remaining after delete = %v, want %v", got, want)
+	}
+	var movements int
+	if err := pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM stock_movements WHERE product_id=$1 AND kind='consumption'`, productID,
+	).Scan(&movements); err != nil {
+		t.Fatal(err)
+	}
+	if movements != 0 {
+		t.Errorf("%d consumption movements left, want 0", movements)
+	}
+}
+
+// TestMixedCurrencyLots checks that an event drawing on lots priced in
+// different currencies needs an explicit cost.
+func TestMixedCurrencyLots(t *testing.T) {
+	pool := dbtest.Pool(t)
+	farmID := dbtest.Farm(t, pool)
+	ctx := context.Background()
+
+	productID, lots := stockFixture(t, pool, farmID,
+		stockLot{"2026-06-01", 1, 200, "BRL"},
+		stockLot{"2026-12-01", 5, 40, "USD"},
+	)
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := Insert(ctx, tx, farmID, treatment(productID, 2, 0)); !errors.Is(err, ErrMixedCurrencies) {
+		t.Fatalf("err = %v, want ErrMixedCurrencies", err)
+	}
+	if err := tx.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := Insert(ctx, pool, farmID, treatment(productID, 2, 500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.CostCents != 500 || e.Currency != "BRL" {
+		t.Errorf("cost = %d %s, want the explicit 500 BRL", e.CostCents, e.Currency)
+	}
+	if got, want := remaining(t, pool, lots), []float64{0, 4}; !equalFloats(got, want) {
+		t.Errorf("remaining = %v, want %v", got, want)
+	}
+}
+
+// TestLowStockAlertOnce checks that a product below its minimum keeps one
+// unread low_stock alert, with the current quantity, as stock keeps falling.
+func TestLowStockAlertOnce(t *testing.T) {
+	pool := dbtest.Pool(t)
+	farmID := dbtest.Farm(t, pool)
+	ctx := context.Background()
+
+	productID, _ := stockFixture(t, pool, farmID, stockLot{"", 5, 100, "BRL"})
+	dbtest.Exec(t, pool, `UPDATE health_products SET min_stock = 10 WHERE id = $1`, productID)
+	if err := CheckStock(ctx, pool, farmID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Insert(ctx, pool, farmID, treatment(productID, 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckStock(ctx, pool, farmID); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	var message string
+	if err := pool.QueryRow(ctx, `
+		SELECT COUNT(*) OVER (), message FROM alerts
+		WHERE farm_id = $1 AND type = 'low_stock' AND product_id = $2 AND NOT is_read`,
+		farmID, productID).Scan(&count, &message); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d unread low_stock alerts, want 1", count)
+	}
+	if want := "Estoque baixo de Ivermectina: 3 ml (mínimo 10 ml)"; message != want {
+		t.Errorf("message = %q, want %q", message, want)
+	}
+}
@@ -0,0 +1,250 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// HEALTH PRODUCTS
+// =============================================
+
+type ProductHandler struct{ pool *pgxpool.Pool }
+
+func NewProductHandler(pool *pgxpool.Pool) *ProductHandler {
+	return &ProductHandler{pool: pool}
+}
+
+var validProductKinds = map[string]bool{
+	"vaccine":           true,
+	"antibiotic":        true,
+	"antiparasitic":     true,
+	"anti_inflammatory": true,
+	"other":             true,
+}
+
+type Product struct {
+	ID                 uuid.UUID `json:"id"`
+	FarmID             uuid.UUID `json:"farm_id"`
+	Name               string    `json:"name"`
+	Kind               string    `json:"kind"`
+	ActiveIngredient   *string   `json:"active_ingredient,omitempty"`
+	Unit               *string   `json:"unit,omitempty"`
+	MeatWithdrawalDays int       `json:"meat_withdrawal_days"`
+	MilkWithdrawalDays int       `json:"milk_withdrawal_days"`
+	MinStock           *float64  `json:"min_stock,omitempty"`
+	ExpiryAlertDays    int       `json:"expiry_alert_days"`
+	ConcentrationMgMl  *float64  `json:"concentration_mg_ml,omitempty"`
+	DoseMgKg           *float64  `json:"dose_mg_kg,omitempty"`
+	Notes              *string   `json:"notes,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+type ProductRequest struct {
+	Name               string   `json:"name"`
+	Kind               string   `json:"kind"`
+	ActiveIngredient   *string  `json:"active_ingredient"`
+	Unit               *string  `json:"unit"`
+	MeatWithdrawalDays int      `json:"meat_withdrawal_days"`
+	MilkWithdrawalDays int      `json:"milk_withdrawal_days"`
+	MinStock           *float64 `json:"min_stock"`
+	ExpiryAlertDays    *int     `json:"expiry_alert_days"`
+	ConcentrationMgMl  *float64 `json:"concentration_mg_ml"`
+	DoseMgKg           *float64 `json:"dose_mg_kg"`
+	Notes              *string  `json:"notes"`
+}
+
+const productSelect = `
+	SELECT id, farm_id, name, kind, active_ingredient, unit,
+	       meat_withdrawal_days, milk_withdrawal_days, min_stock::float8, expiry_alert_days,
+	       concentration_mg_ml::float8, dose_mg_kg::float8, notes, created_at
+	FROM health_products`
+
+func scanProduct(row interface{ Scan(...any) error }) (Product, error) {
+	var p Product
+	err := row.Scan(&p.ID, &p.FarmID, &p.Name, &p.Kind, &p.ActiveIngredient, &p.Unit,
+		&p.MeatWithdrawalDays, &p.MilkWithdrawalDays, &p.MinStock, &p.ExpiryAlertDays,
+		&p.ConcentrationMgMl, &p.DoseMgKg, &p.Notes, &p.CreatedAt)
+	return p, err
+}
+
+func (req *ProductRequest) validate() string {
+	if req.Name == "" {
+		return "name required"
+	}
+	if req.Kind == "" {
+		req.Kind = "other"
+	}
+	if !validProductKinds[req.Kind] {
+		return "invalid kind"
+	}
+	if req.MeatWithdrawalDays < 0 || req.MilkWithdrawalDays < 0 {
+		return "withdrawal days must not be negative"
+	}
+	if req.MinStock != nil && *req.MinStock < 0 {
+		return "min_stock must not be negative"
+	}
+	if req.ExpiryAlertDays == nil {
+		days := 30
+		req.ExpiryAlertDays = &days
+	} else if *req.ExpiryAlertDays < 0 {
+		return "expiry_alert_days must not be negative"
+	}
+	if req.ConcentrationMgMl != nil && *req.ConcentrationMgMl <= 0 {
+		return "concentration_mg_ml must be positive"
+	}
+	if req.DoseMgKg != nil && *req.DoseMgKg <= 0 {
+		return "dose_mg_kg must be positive"
+	}
+	return ""
+}
+
+func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	rows, err := h.pool.Query(r.Context(), productSelect+` WHERE farm_id=$1 ORDER BY name`, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+
+	products := []Product{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		products = append(products, p)
+	}
+	response.Ok(w, products)
+}
+
+func (h *ProductHandler) Get(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid product id")
+		return
+	}
+	p, err := scanProduct(h.pool.QueryRow(r.Context(),
+		productSelect+` WHERE id=$1 AND farm_id=$2`, id, farmID))
+	if err != nil {
+		response.NotFound(w, "product not found")
+		return
+	}
+	response.Ok(w, p)
+}
+
+func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	var req ProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+	p, err := scanProduct(h.pool.QueryRow(r.Context(), `
+		INSERT INTO health_products
+		  (id, farm_id, name, kind, active_ingredient, unit,
+		   meat_withdrawal_days, milk_withdrawal_days, min_stock, expiry_alert_days,
+		   concentration_mg_ml, dose_mg_kg, notes)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)
+		ON CONFLICT (farm_id, name) DO NOTHING
+		RETURNING id, farm_id, name, kind, active_ingredient, unit,
+		          meat_withdrawal_days, milk_withdrawal_days, min_stock::float8, expiry_alert_days,
+		          concentration_mg_ml::float8, dose_mg_kg::float8, notes, created_at`,
+		uuid.New(), farmID, req.Name, req.Kind, req.ActiveIngredient, req.Unit,
+		req.MeatWithdrawalDays, req.MilkWithdrawalDays, req.MinStock, req.ExpiryAlertDays,
+		req.ConcentrationMgMl, req.DoseMgKg, req.Notes))
+	// ON CONFLICT DO NOTHING returns no row for a duplicate name
+	if errors.Is(err, pgx.ErrNoRows) || db.IsUniqueViolation(err) {
+		response.Error(w, http.StatusConflict, "a product with this name already exists")
+		return
+	}
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Created(w, p)
+}
+
+func (h *ProductHandler) Update(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid product id")
+		return
+	}
+	var req ProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+	p, err := scanProduct(h.pool.QueryRow(r.Context(), `
+		UPDATE health_products SET
+		  name=$3, kind=$4, active_ingredient=$5, unit=$6,
+		  meat_withdrawal_days=$7, milk_withdrawal_days=$8, min_stock=$9, expiry_alert_days=$10,
+		  concentration_mg_ml=$11, dose_mg_kg=$12, notes=$13, updated_at=NOW()
+		WHERE id=$1 AND farm_id=$2
+		RETURNING id, farm_id, name, kind, active_ingredient, unit,
+		          meat_withdrawal_days, milk_withdrawal_days, min_stock::float8, expiry_alert_days,
+		          concentration_mg_ml::float8, dose_mg_kg::float8, notes, created_at`,
+		id, farmID, req.Name, req.Kind, req.ActiveIngredient, req.Unit,
+		req.MeatWithdrawalDays, req.MilkWithdrawalDays, req.MinStock, req.ExpiryAlertDays,
+		req.ConcentrationMgMl, req.DoseMgKg, req.Notes))
+	if errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, "product not found")
+		return
+	}
+	if db.IsUniqueViolation(err) {
+		response.Error(w, http.StatusConflict, "a product with this name already exists")
+		return
+	}
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	// Thresholds may have changed
+	_ = CheckStock(r.Context(), h.pool, farmID)
+	response.Ok(w, p)
+}
+
+// Delete removes an unused product. Products referenced by health events
+// are kept so their withdrawal periods keep applying.
+func (h *ProductHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid product id")
+		return
+	}
+	var used bool
+	_ = h.pool.QueryRow(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM health_events WHERE product_id=$1)`, id).Scan(&used)
+	if used {
+		response.Error(w, http.StatusConflict, "product is used by health events")
+		return
+	}
+	_, _ = h.pool.Exec(r.Context(),
+		`DELETE FROM health_products WHERE id=$1 AND farm_id=$2`, id, farmID)
+	response.NoContent(w)
+}
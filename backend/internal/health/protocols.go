@@ -0,0 +1,498 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// SANITARY PROTOCOLS
+// =============================================
+
+type ProtocolHandler struct{ pool *pgxpool.Pool }
+
+func NewProtocolHandler(pool *pgxpool.Pool) *ProtocolHandler {
+	return &ProtocolHandler{pool: pool}
+}
+
+type Protocol struct {
+	ID           uuid.UUID  `json:"id"`
+	FarmID       uuid.UUID  `json:"farm_id"`
+	Name         string     `json:"name"`
+	EventType    string     `json:"event_type"`
+	Product      *string    `json:"product,omitempty"`
+	ProductID    *uuid.UUID `json:"product_id,omitempty"`
+	Dose         *float64   `json:"dose,omitempty"`
+	DoseUnit     *string    `json:"dose_unit,omitempty"`
+	TargetSex    *string    `json:"target_sex,omitempty"`
+	MinAgeDays   *int       `json:"min_age_days,omitempty"`
+	MaxAgeDays   *int       `json:"max_age_days,omitempty"`
+	BoosterDays  []int32    `json:"booster_days"`
+	IntervalDays *int       `json:"interval_days,omitempty"`
+	StartsOn     string     `json:"starts_on"`
+	Active       bool       `json:"active"`
+	Notes        *string    `json:"notes,omitempty"`
+	DueNow       int        `json:"due_now"` // animals with a dose due today or overdue
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type ProtocolRequest struct {
+	Name         string     `json:"name"`
+	EventType    string     `json:"event_type"`
+	Product      *string    `json:"product"`
+	ProductID    *uuid.UUID `json:"product_id"`
+	Dose         *float64   `json:"dose"`
+	DoseUnit     *string    `json:"dose_unit"`
+	TargetSex    *string    `json:"target_sex"`
+	MinAgeDays   *int       `json:"min_age_days"`
+	MaxAgeDays   *int       `json:"max_age_days"`
+	BoosterDays  []int32    `json:"booster_days"`
+	IntervalDays *int       `json:"interval_days"`
+	StartsOn     string     `json:"starts_on"`
+	Active       *bool      `json:"active"`
+	Notes        *string    `json:"notes"`
+}
+
+const protocolSelect = `
+	SELECT p.id, p.farm_id, p.name, p.event_type, p.product, p.product_id,
+	       p.dose::float8, p.dose_unit, p.target_sex, p.min_age_days, p.max_age_days, p.booster_days, p.interval_days,
+	       to_char(p.starts_on, 'YYYY-MM-DD'), p.active, p.notes,
+	       (SELECT COUNT(*)::int FROM protocol_due pd
+	        WHERE pd.protocol_id = p.id AND pd.due_date <= CURRENT_DATE),
+	       p.created_at
+	FROM health_protocols p`
+
+func scanProtocol(row interface{ Scan(...any) error }) (Protocol, error) {
+	var p Protocol
+	err := row.Scan(&p.ID, &p.FarmID, &p.Name, &p.EventType, &p.Product, &p.ProductID,
+		&p.Dose, &p.DoseUnit, &p.TargetSex, &p.MinAgeDays, &p.MaxAgeDays, &p.BoosterDays, &p.IntervalDays,
+		&p.StartsOn, &p.Active, &p.Notes, &p.DueNow, &p.CreatedAt)
+	if p.BoosterDays == nil {
+		p.BoosterDays = []int32{}
+	}
+	return p, err
+}
+
+// validate normalises a protocol request and returns a message when invalid.
+func (req *ProtocolRequest) validate() string {
+	if req.Name == "" {
+		return "name required"
+	}
+	if req.EventType == "" {
+		req.EventType = "vaccine"
+	}
+	if req.TargetSex != nil && *req.TargetSex != "male" && *req.TargetSex != "female" {
+		return "target_sex must be male or female"
+	}
+	if req.MinAgeDays != nil && *req.MinAgeDays < 0 || req.MaxAgeDays != nil && *req.MaxAgeDays < 0 {
+		return "ages must not be negative"
+	}
+	if req.MinAgeDays != nil && req.MaxAgeDays != nil && *req.MaxAgeDays < *req.MinAgeDays {
+		return "max_age_days must not be below min_age_days"
+	}
+	if req.IntervalDays != nil && *req.IntervalDays < 1 {
+		return "interval_days must be positive"
+	}
+	if req.BoosterDays == nil {
+		req.BoosterDays = []int32{}
+	}
+	prev := int32(0)
+	for _, d := range req.BoosterDays {
+		if d <= prev {
+			return "booster_days must be positive and increasing"
+		}
+		prev = d
+	}
+	if req.StartsOn == "" {
+		req.StartsOn = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.StartsOn); err != nil {
+		return "starts_on must be YYYY-MM-DD"
+	}
+	return ""
+}
+
+func (h *ProtocolHandler) List(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	rows, err := h.pool.Query(r.Context(),
+		protocolSelect+` WHERE p.farm_id = $1 ORDER BY p.active DESC, p.name`, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+
+	protocols := []Protocol{}
+	for rows.Next() {
+		p, err := scanProtocol(rows)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		protocols = append(protocols, p)
+	}
+	response.Ok(w, protocols)
+}
+
+func (h *ProtocolHandler) Get(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid protocol id")
+		return
+	}
+	p, err := scanProtocol(h.pool.QueryRow(r.Context(),
+		protocolSelect+` WHERE p.id = $1 AND p.farm_id = $2`, id, farmID))
+	if err != nil {
+		response.NotFound(w, "protocol not found")
+		return
+	}
+	response.Ok(w, p)
+}
+
+func (h *ProtocolHandler) Create(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	var req ProtocolRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+	if err := checkRefs(r.Context(), h.pool, farmID, nil, req.ProductID); err != nil {
+		writeEventError(w, err)
+		return
+	}
+	active := req.Active == nil || *req.Active
+
+	id := uuid.New()
+	_, err := h.pool.Exec(r.Context(), `
+		INSERT INTO health_protocols
+		  (id, farm_id, name, event_type, product, dose, dose_unit, target_sex,
+		   min_age_days, max_age_days, booster_days, interval_days, starts_on, active, notes,
+		   product_id)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13::date,$14,$15,$16)`,
+		id, farmID, req.Name, req.EventType, req.Product, req.Dose, req.DoseUnit, req.TargetSex,
+		req.MinAgeDays, req.MaxAgeDays, req.BoosterDays, req.IntervalDays, req.StartsOn, active, req.Notes,
+		req.ProductID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	p, err := scanProtocol(h.pool.QueryRow(r.Context(), protocolSelect+` WHERE p.id = $1`, id))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Created(w, p)
+}
+
+func (h *ProtocolHandler) Update(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid protocol id")
+		return
+	}
+	var req ProtocolRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+	if err := checkRefs(r.Context(), h.pool, farmID, nil, req.ProductID); err != nil {
+		writeEventError(w, err)
+		return
+	}
+	active := req.Active == nil || *req.Active
+
+	tag, err := h.pool.Exec(r.Context(), `
+		UPDATE health_protocols SET
+		  name=$3, event_type=$4, product=$5, dose=$6, dose_unit=$7, target_sex=$8,
+		  min_age_days=$9, max_age_days=$10, booster_days=$11, interval_days=$12,
+		  starts_on=$13::date, active=$14, notes=$15, product_id=$16, updated_at=NOW()
+		WHERE id=$1 AND farm_id=$2`,
+		id, farmID, req.Name, req.EventType, req.Product, req.Dose, req.DoseUnit, req.TargetSex,
+		req.MinAgeDays, req.MaxAgeDays, req.BoosterDays, req.IntervalDays, req.StartsOn, active, req.Notes,
+		req.ProductID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.NotFound(w, "protocol not found")
+		return
+	}
+	p, err := scanProtocol(h.pool.QueryRow(r.Context(), protocolSelect+` WHERE p.id = $1`, id))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, p)
+}
+
+// Delete removes a protocol. Events it generated are kept and lose the link.
+func (h *ProtocolHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid protocol id")
+		return
+	}
+	_, _ = h.pool.Exec(r.Context(),
+		`DELETE FROM health_protocols WHERE id=$1 AND farm_id=$2`, id, farmID)
+	response.NoContent(w)
+}
+
+// =============================================
+// DUE DOSES
+// =============================================
+
+type DueItem struct {
+	ProtocolID    uuid.UUID  `json:"protocol_id"`
+	ProtocolName  string     `json:"protocol_name"`
+	AnimalID      uuid.UUID  `json:"animal_id"`
+	EarTag        string     `json:"ear_tag"`
+	AnimalName    *string    `json:"animal_name,omitempty"`
+	HerdID        *uuid.UUID `json:"herd_id,omitempty"`
+	HerdName      *string    `json:"herd_name,omitempty"`
+	DoseNumber    int        `json:"dose_number"`
+	LastDose      *string    `json:"last_dose,omitempty"`
+	DueDate       string     `json:"due_date"`
+	DaysRemaining int        `json:"days_remaining"` // negative when overdue
+}
+
+// Due lists per-animal doses due up to ?to= (default: 30 days ahead),
+// including overdue ones. Optional filters: protocol_id, herd_id.
+func (h *ProtocolHandler) Due(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	q := r.URL.Query()
+
+	to := time.Now().AddDate(0, 0, 30).Format("2006-01-02")
+	if v := q.Get("to"); v != "" {
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			response.BadRequest(w, "to must be YYYY-MM-DD")
+			return
+		}
+		to = v
+	}
+	var protocolID, herdID *uuid.UUID
+	if v := q.Get("protocol_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			response.BadRequest(w, "invalid protocol_id")
+			return
+		}
+		protocolID = &id
+	}
+	if v := q.Get("herd_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			response.BadRequest(w, "invalid herd_id")
+			return
+		}
+		herdID = &id
+	}
+
+	items, err := loadDue(r.Context(), h.pool, farmID, to, protocolID, herdID, nil)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, items)
+}
+
+// loadDue returns doses due on or before `to`, optionally restricted to a
+// protocol, a herd or a set of animals.
+func loadDue(ctx context.Context, q db.DBTX, farmID uuid.UUID, to string,
+	protocolID, herdID *uuid.UUID, animalIDs []uuid.UUID) ([]DueItem, error) {
+	rows, err := q.Query(ctx, `
+		SELECT pd.protocol_id, p.name, pd.animal_id, a.ear_tag, a.name, a.herd_id, hr.name,
+		       pd.dose_number, to_char(pd.last_dose, 'YYYY-MM-DD'),
+		       to_char(pd.due_date, 'YYYY-MM-DD'), (pd.due_date - CURRENT_DATE)::int
+		FROM protocol_due pd
+		JOIN health_protocols p ON p.id = pd.protocol_id
+		JOIN animals a ON a.id = pd.animal_id
+		LEFT JOIN herds hr ON hr.id = a.herd_id
+		WHERE pd.farm_id = $1 AND pd.due_date <= $2::date
+		  AND ($3::uuid IS NULL OR pd.protocol_id = $3)
+		  AND ($4::uuid IS NULL OR a.herd_id = $4)
+		  AND ($5::uuid[] IS NULL OR pd.animal_id = ANY($5))
+		ORDER BY pd.due_date, p.name, a.ear_tag`,
+		farmID, to, protocolID, herdID, animalIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []DueItem{}
+	for rows.Next() {
+		var it DueItem
+		if err := rows.Scan(&it.ProtocolID, &it.ProtocolName, &it.AnimalID, &it.EarTag,
+			&it.AnimalName, &it.HerdID, &it.HerdName, &it.DoseNumber, &it.LastDose,
+			&it.DueDate, &it.DaysRemaining); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// =============================================
+// EXECUTION
+// =============================================
+
+type ExecuteRequest struct {
+	// AnimalIDs defaults to every animal with a dose due on Date.
+	AnimalIDs   []uuid.UUID `json:"animal_ids"`
+	HerdID      *uuid.UUID  `json:"herd_id"`
+	Date        string      `json:"date"`
+	CostCents   int         `json:"cost_cents"` // total, split across the animals
+	Currency    string      `json:"currency"`
+	Description *string     `json:"description"`
+}
+
+type ExecuteResult struct {
+	ProtocolID uuid.UUID     `json:"protocol_id"`
+	Events     []HealthEvent `json:"events"`
+	Skipped    []uuid.UUID   `json:"skipped"` // animals not eligible for the protocol
+}
+
+// Execute applies a protocol in one step, creating a health event per
+// treated animal so each one advances in its dose schedule.
+func (h *ProtocolHandler) Execute(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid protocol id")
+		return
+	}
+	var req ExecuteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if req.Date == "" {
+		req.Date = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		response.BadRequest(w, "date must be YYYY-MM-DD")
+		return
+	}
+	if req.CostCents < 0 {
+		response.BadRequest(w, "cost_cents must not be negative")
+		return
+	}
+
+	p, err := scanProtocol(h.pool.QueryRow(r.Context(),
+		protocolSelect+` WHERE p.id = $1 AND p.farm_id = $2`, id, farmID))
+	if err != nil {
+		response.NotFound(w, "protocol not found")
+		return
+	}
+
+	// Only animals the protocol currently applies to are treated. When no
+	// animals are listed, every dose due up to the execution date is applied.
+	var filter []uuid.UUID
+	if len(req.AnimalIDs) > 0 {
+		filter = req.AnimalIDs
+	}
+	due, err := loadDue(r.Context(), h.pool, farmID, "infinity", &id, req.HerdID, filter)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	eligible := map[uuid.UUID]bool{}
+	targets := []uuid.UUID{}
+	for _, d := range due {
+		if filter == nil && d.DueDate > req.Date {
+			continue
+		}
+		eligible[d.AnimalID] = true
+		targets = append(targets, d.AnimalID)
+	}
+	result := ExecuteResult{ProtocolID: id, Events: []HealthEvent{}, Skipped: []uuid.UUID{}}
+	for _, a := range req.AnimalIDs {
+		if !eligible[a] {
+			result.Skipped = append(result.Skipped, a)
+		}
+	}
+	if len(targets) == 0 {
+		response.BadRequest(w, "no animals due for this protocol")
+		return
+	}
+
+	name := p.Name
+	if p.Product != nil && *p.Product != "" {
+		name = fmt.Sprintf("%s (%s)", p.Name, *p.Product)
+	}
+	var doseMgKg, perAnimal *float64
+	if p.DoseUnit != nil && *p.DoseUnit == "mg/kg" {
+		doseMgKg = p.Dose
+	} else if p.DoseUnit != nil && !strings.HasSuffix(*p.DoseUnit, "/kg") {
+		// Fixed doses are taken from stock; weight-based ones need the dose calculator
+		perAnimal = p.Dose
+	}
+	protocolID := id.String()
+	var productID *string
+	if p.ProductID != nil {
+		s := p.ProductID.String()
+		productID = &s
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	share, rest := req.CostCents/len(targets), req.CostCents%len(targets)
+	for i, animalID := range targets {
+		cost := share
+		if i < rest {
+			cost++
+		}
+		aid := animalID.String()
+		e, err := Insert(r.Context(), tx, farmID, CreateRequest{
+			AnimalID:        &aid,
+			ProtocolID:      &protocolID,
+			ProductID:       productID,
+			EventType:       p.EventType,
+			Name:            name,
+			Description:     req.Description,
+			CostCents:       cost,
+			Currency:        req.Currency,
+			DoseMgKg:        doseMgKg,
+			AnimalCount:     1,
+			StartedAt:       req.Date,
+			ProductQuantity: perAnimal,
+		})
+		if err != nil {
+			writeEventError(w, err)
+			return
+		}
+		result.Events = append(result.Events, e)
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Created(w, result)
+}
@@ -0,0 +1,125 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// WITHDRAWAL PERIODS (carência)
+// =============================================
+
+type WithdrawalEvent struct {
+	EventID       uuid.UUID `json:"event_id"`
+	EventName     string    `json:"event_name"`
+	ProductID     uuid.UUID `json:"product_id"`
+	ProductName   string    `json:"product_name"`
+	LastTreatment string    `json:"last_treatment"`
+	MeatClearDate string    `json:"meat_clear_date"`
+	MilkClearDate string    `json:"milk_clear_date"`
+}
+
+// AnimalWithdrawal summarises the withdrawal status of one animal. Clear
+// dates are the first day meat or milk may be sold.
+type AnimalWithdrawal struct {
+	AnimalID      uuid.UUID         `json:"animal_id"`
+	EarTag        string            `json:"ear_tag"`
+	Name          *string           `json:"name,omitempty"`
+	MeatClearDate string            `json:"meat_clear_date"`
+	MilkClearDate string            `json:"milk_clear_date"`
+	MeatWithdrawn bool              `json:"meat_withdrawn"`
+	MilkWithdrawn bool              `json:"milk_withdrawn"`
+	Events        []WithdrawalEvent `json:"events"`
+}
+
+// LoadWithdrawals returns animals whose meat or milk clear date is after
+// `on` (YYYY-MM-DD). With animalIDs set, it is restricted to those animals.
+func LoadWithdrawals(ctx context.Context, q db.DBTX, farmID uuid.UUID, on string, animalIDs []uuid.UUID) ([]AnimalWithdrawal, error) {
+	rows, err := q.Query(ctx, `
+		SELECT aw.animal_id, a.ear_tag, a.name,
+		       aw.event_id, aw.event_name, aw.product_id, aw.product_name,
+		       to_char(aw.last_treatment, 'YYYY-MM-DD'),
+		       to_char(aw.meat_clear_date, 'YYYY-MM-DD'),
+		       to_char(aw.milk_clear_date, 'YYYY-MM-DD')
+		FROM animal_withdrawals aw
+		JOIN animals a ON a.id = aw.animal_id
+		WHERE aw.farm_id = $1
+		  AND (aw.meat_clear_date > $2::date OR aw.milk_clear_date > $2::date)
+		  AND ($3::uuid[] IS NULL OR aw.animal_id = ANY($3))
+		ORDER BY a.ear_tag, aw.last_treatment DESC`, farmID, on, animalIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []AnimalWithdrawal{}
+	index := map[uuid.UUID]int{}
+	for rows.Next() {
+		var aw AnimalWithdrawal
+		var ev WithdrawalEvent
+		if err := rows.Scan(&aw.AnimalID, &aw.EarTag, &aw.Name,
+			&ev.EventID, &ev.EventName, &ev.ProductID, &ev.ProductName,
+			&ev.LastTreatment, &ev.MeatClearDate, &ev.MilkClearDate); err != nil {
+			return nil, err
+		}
+		i, ok := index[aw.AnimalID]
+		if !ok {
+			aw.Events = []WithdrawalEvent{}
+			result = append(result, aw)
+			i = len(result) - 1
+			index[aw.AnimalID] = i
+		}
+		cur := &result[i]
+		cur.Events = append(cur.Events, ev)
+		// Dates are ISO formatted, so string order is date order
+		cur.MeatClearDate = max(cur.MeatClearDate, ev.MeatClearDate)
+		cur.MilkClearDate = max(cur.MilkClearDate, ev.MilkClearDate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range result {
+		result[i].MeatWithdrawn = result[i].MeatClearDate > on
+		result[i].MilkWithdrawn = result[i].MilkClearDate > on
+	}
+	return result, nil
+}
+
+// Withdrawals lists animals currently under a withdrawal period. ?on= checks
+// another date (e.g. a planned sale), ?animal_id= restricts to one animal.
+func (h *Handler) Withdrawals(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	q := r.URL.Query()
+
+	on := time.Now().Format("2006-01-02")
+	if v := q.Get("on"); v != "" {
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			response.BadRequest(w, "on must be YYYY-MM-DD")
+			return
+		}
+		on = v
+	}
+	var animalIDs []uuid.UUID
+	if v := q.Get("animal_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			response.BadRequest(w, "invalid animal_id")
+			return
+		}
+		animalIDs = []uuid.UUID{id}
+	}
+
+	result, err := LoadWithdrawals(r.Context(), h.pool, farmID, on, animalIDs)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, result)
+}
@@ -37,6 +37,8 @@ type Antenna struct {
 	RadiusM   int       `json:"radius_m"`
 	IsActive  bool      `json:"is_active"`
 	CreatedAt time.Time `json:"created_at"`
+	// Geometry is the location as a GeoJSON Point.
+	Geometry json.RawMessage `json:"geometry"`
 }
 
 // ─── Handler ──────────────────────────────────────────────────────────────────
@@ -224,7 +226,7 @@ func (h *DeviceHandler) ListAntennas(w http.ResponseWriter, r *http.Request) {
 		SELECT id, farm_id, name,
 		       ST_Y(location::geometry) AS lat,
 		       ST_X(location::geometry) AS lng,
-		       radius_m, is_active, created_at
+		       radius_m, is_active, created_at, ST_AsGeoJSON(location)::json
 		FROM antennas
 		WHERE farm_id = $1
 		ORDER BY name`, farmID)
@@ -238,7 +240,7 @@ func (h *DeviceHandler) ListAntennas(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var a Antenna
 		if err := rows.Scan(&a.ID, &a.FarmID, &a.Name, &a.Lat, &a.Lng,
-			&a.RadiusM, &a.IsActive, &a.CreatedAt); err != nil {
+			&a.RadiusM, &a.IsActive, &a.CreatedAt, &a.Geometry); err != nil {
 			response.InternalError(w)
 			return
 		}
@@ -275,9 +277,9 @@ func (h *DeviceHandler) CreateAntenna(w http.ResponseWriter, r *http.Request) {
 		RETURNING id, farm_id, name,
 		          ST_Y(location::geometry),
 		          ST_X(location::geometry),
-		          radius_m, is_active, created_at`,
+		          radius_m, is_active, created_at, ST_AsGeoJSON(location)::json`,
 		farmID, body.Name, body.Lng, body.Lat, body.RadiusM,
-	).Scan(&a.ID, &a.FarmID, &a.Name, &a.Lat, &a.Lng, &a.RadiusM, &a.IsActive, &a.CreatedAt)
+	).Scan(&a.ID, &a.FarmID, &a.Name, &a.Lat, &a.Lng, &a.RadiusM, &a.IsActive, &a.CreatedAt, &a.Geometry)
 	if err != nil {
 		response.InternalError(w)
 		return
@@ -40,6 +40,9 @@ type IngestGPSRequest struct {
 	SpeedKmh  float64   `json:"speed_kmh"`
 	Battery   int       `json:"battery"`
 	Timestamp time.Time `json:"timestamp"`
+
+	// TemperatureC is the collar's ambient reading, when it has a sensor.
+	TemperatureC *float64 `json:"temperature_c"`
 }
 
 func (h *Handler) IngestGPS(w http.ResponseWriter, r *http.Request) {
@@ -68,9 +71,9 @@ func (h *Handler) IngestGPS(w http.ResponseWriter, r *http.Request) {
 	// Insert GPS track
 	point := fmt.Sprintf("POINT(%f %f)", req.Lng, req.Lat)
 	_, err = h.pool.Exec(r.Context(),
-		`INSERT INTO gps_tracks (id, device_id, animal_id, farm_id, location, speed_kmh, battery_pct, recorded_at)
-		 VALUES ($1, $2, $3, $4, ST_GeogFromText($5), $6, $7, $8)`,
-		uuid.New(), deviceID, animalID, farmID, point, req.SpeedKmh, req.Battery, req.Timestamp,
+		`INSERT INTO gps_tracks (id, device_id, animal_id, farm_id, location, speed_kmh, battery_pct, recorded_at, temperature_c)
+		 VALUES ($1, $2, $3, $4, ST_GeogFromText($5), $6, $7, $8, $9)`,
+		uuid.New(), deviceID, animalID, farmID, point, req.SpeedKmh, req.Battery, req.Timestamp, req.TemperatureC,
 	)
 	if err != nil {
 		slog.Error("failed to insert gps track", "err", err)
@@ -101,7 +104,7 @@ func (h *Handler) IngestGPS(w http.ResponseWriter, r *http.Request) {
 		Timestamp: req.Timestamp.Format(time.RFC3339),
 	})
 
-	// Check if animal is out of its assigned zone
+	// Check if animal is outside the farm perimeter or its assigned zone
 	go h.checkZoneViolation(animalID, farmID, point)
 
 	w.WriteHeader(http.StatusAccepted)
@@ -166,7 +169,28 @@ func (h *Handler) checkZoneViolation(animalID, farmID uuid.UUID, point string) {
 	var assignedZoneID *uuid.UUID
 	var insideZone bool
 
+	// Leaving the farm outranks leaving a zone
+	if h.checkPerimeterBreach(animalID, farmID, point) {
+		return
+	}
+
+	// Quarantined animals must stay inside the isolation zone of their case
+	var isolationZoneID uuid.UUID
 	err := h.pool.QueryRow(
+		context.Background(),
+		`SELECT c.isolation_zone_id FROM disease_case_animals ca
+		 JOIN disease_cases c ON c.id = ca.case_id
+		 WHERE ca.animal_id = $1 AND c.isolation_zone_id IS NOT NULL
+		   AND ca.quarantined_at IS NOT NULL AND ca.released_at IS NULL
+		 LIMIT 1`,
+		animalID,
+	).Scan(&isolationZoneID)
+	if err == nil {
+		h.checkQuarantineBreach(animalID, farmID, isolationZoneID, point)
+		return
+	}
+
+	err = h.pool.QueryRow(
 		context.Background(),
 		`SELECT zone_id FROM animals WHERE id = $1`,
 		animalID,
@@ -197,3 +221,72 @@ func (h *Handler) checkZoneViolation(animalID, farmID uuid.UUID, point string) {
 		)
 	}
 }
+
+// checkQuarantineBreach raises a critical alert when a quarantined animal
+// is outside its isolation zone. Only one unread alert is kept per animal.
+func (h *Handler) checkQuarantineBreach(animalID, farmID, zoneID uuid.UUID, point string) {
+	var insideZone bool
+	err := h.pool.QueryRow(
+		context.Background(),
+		`SELECT ST_Covers(geometry, ST_GeogFromText($1))
+		 FROM zones WHERE id = $2`,
+		point, zoneID,
+	).Scan(&insideZone)
+	if err != nil {
+		slog.Error("failed to check isolation zone", "err", err, "animal_id", animalID)
+		return
+	}
+	if insideZone {
+		return
+	}
+
+	tag, err := h.pool.Exec(
+		context.Background(),
+		`INSERT INTO alerts (id, farm_id, animal_id, type, severity, message)
+		 SELECT $1, $2, $3, 'quarantine_breach', 'critical', 'Animal em quarentena saiu da zona de isolamento'
+		 WHERE NOT EXISTS (
+		     SELECT 1 FROM alerts
+		     WHERE animal_id = $3 AND type = 'quarantine_breach' AND is_read = FALSE
+		 )`,
+		uuid.New(), farmID, animalID,
+	)
+	if err != nil || tag.RowsAffected() == 0 {
+		return
+	}
+	h.hub.BroadcastAlert(farmID, "quarantine_breach",
+		fmt.Sprintf("Animal %s em quarentena saiu da zona de isolamento", animalID))
+}
+
+// checkPerimeterBreach raises a critical alert when an animal is outside
+// every perimeter of its farm, which usually means a cut fence or theft.
+// Farms without perimeters are not checked. Only one unread alert is kept
+// per animal. It reports whether the animal is outside.
+func (h *Handler) checkPerimeterBreach(animalID, farmID uuid.UUID, point string) bool {
+	var perimeters int
+	var inside bool
+	err := h.pool.QueryRow(
+		context.Background(),
+		`SELECT COUNT(*)::int, COALESCE(BOOL_OR(ST_Covers(geometry, ST_GeogFromText($2))), FALSE)
+		 FROM perimeters WHERE farm_id = $1`,
+		farmID, point,
+	).Scan(&perimeters, &inside)
+	if err != nil || perimeters == 0 || inside {
+		return false
+	}
+
+	tag, err := h.pool.Exec(
+		context.Background(),
+		`INSERT INTO alerts (id, farm_id, animal_id, type, severity, message)
+		 SELECT $1, $2, $3, 'perimeter_breach', 'critical', 'Animal fora do perímetro da fazenda (cerca cortada ou furto?)'
+		 WHERE NOT EXISTS (
+		     SELECT 1 FROM alerts
+		     WHERE animal_id = $3 AND type = 'perimeter_breach' AND is_read = FALSE
+		 )`,
+		uuid.New(), farmID, animalID,
+	)
+	if err == nil && tag.RowsAffected() > 0 {
+		h.hub.BroadcastAlert(farmID, "perimeter_breach",
+			fmt.Sprintf("Animal %s fora do perímetro da fazenda", animalID))
+	}
+	return true
+}
@@ -0,0 +1,748 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/health"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// ─── Types ────────────────────────────────────────────────────────────────────
+
+var validKinds = map[string]bool{
+	"general":     true,
+	"health":      true, // completion records health events
+	"weighing":    true, // completion records weight records
+	"maintenance": true,
+}
+
+var validRecurrences = map[string]bool{
+	"daily":   true,
+	"weekly":  true,
+	"monthly": true,
+	"yearly":  true,
+}
+
+// errNoTargets is returned when completing a health task that covers no
+// active animal, so no health event can be recorded.
+var errNoTargets = errors.New("task has no active animals, herds or zones to treat")
+
+// transitions lists the statuses reachable from each status through
+// POST /tasks/{id}/status. A task only becomes "done" via /complete.
+var transitions = map[string][]string{
+	"open":        {"in_progress", "canceled"},
+	"in_progress": {"open", "canceled"},
+	"canceled":    {"open"},
+}
+
+type Task struct {
+	ID                 uuid.UUID   `json:"id"`
+	FarmID             uuid.UUID   `json:"farm_id"`
+	Title              string      `json:"title"`
+	Description        *string     `json:"description,omitempty"`
+	Kind               string      `json:"kind"`
+	Status             string      `json:"status"`
+	AssigneeID         *uuid.UUID  `json:"assignee_id,omitempty"`
+	AssigneeName       *string     `json:"assignee_name,omitempty"`
+	DueDate            *string     `json:"due_date,omitempty"`
+	Recurrence         *string     `json:"recurrence,omitempty"`
+	RecurrenceInterval int         `json:"recurrence_interval"`
+	RecurrenceUntil    *string     `json:"recurrence_until,omitempty"`
+	PreviousTaskID     *uuid.UUID  `json:"previous_task_id,omitempty"`
+	HealthEventType    *string     `json:"health_event_type,omitempty"`
+	CompletedAt        *time.Time  `json:"completed_at,omitempty"`
+	CompletedBy        *uuid.UUID  `json:"completed_by,omitempty"`
+	CreatedAt          time.Time   `json:"created_at"`
+	AnimalIDs          []uuid.UUID `json:"animal_ids"`
+	HerdIDs            []uuid.UUID `json:"herd_ids"`
+	ZoneIDs            []uuid.UUID `json:"zone_ids"`
+}
+
+type TaskRequest struct {
+	Title              string      `json:"title"`
+	Description        *string     `json:"description"`
+	Kind               string      `json:"kind"`
+	AssigneeID         *uuid.UUID  `json:"assignee_id"`
+	DueDate            *string     `json:"due_date"`
+	Recurrence         *string     `json:"recurrence"`
+	RecurrenceInterval int         `json:"recurrence_interval"`
+	RecurrenceUntil    *string     `json:"recurrence_until"`
+	HealthEventType    *string     `json:"health_event_type"`
+	AnimalIDs          []uuid.UUID `json:"animal_ids"`
+	HerdIDs            []uuid.UUID `json:"herd_ids"`
+	ZoneIDs            []uuid.UUID `json:"zone_ids"`
+}
+
+// ─── Handler ──────────────────────────────────────────────────────────────────
+
+type Handler struct{ pool *pgxpool.Pool }
+
+func NewHandler(pool *pgxpool.Pool) *Handler { return &Handler{pool: pool} }
+
+const taskSelect = `
+	SELECT t.id, t.farm_id, t.title, t.description, t.kind, t.status,
+	       t.assignee_id, u.name,
+	       to_char(t.due_date, 'YYYY-MM-DD'),
+	       t.recurrence, t.recurrence_interval,
+	       to_char(t.recurrence_until, 'YYYY-MM-DD'),
+	       t.previous_task_id, t.health_event_type,
+	       t.completed_at, t.completed_by, t.created_at,
+	       ARRAY(SELECT animal_id FROM task_animals WHERE task_id = t.id),
+	       ARRAY(SELECT herd_id   FROM task_herds   WHERE task_id = t.id),
+	       ARRAY(SELECT zone_id   FROM task_zones   WHERE task_id = t.id)
+	FROM tasks t
+	LEFT JOIN users u ON u.id = t.assignee_id`
+
+type scanner interface{ Scan(dest ...any) error }
+
+func scanTask(row scanner) (Task, error) {
+	var t Task
+	err := row.Scan(
+		&t.ID, &t.FarmID, &t.Title, &t.Description, &t.Kind, &t.Status,
+		&t.AssigneeID, &t.AssigneeName, &t.DueDate,
+		&t.Recurrence, &t.RecurrenceInterval, &t.RecurrenceUntil,
+		&t.PreviousTaskID, &t.HealthEventType,
+		&t.CompletedAt, &t.CompletedBy, &t.CreatedAt,
+		&t.AnimalIDs, &t.HerdIDs, &t.ZoneIDs,
+	)
+	return t, err
+}
+
+func (h *Handler) listWhere(w http.ResponseWriter, r *http.Request, where string, args []any) {
+	rows, err := h.pool.Query(r.Context(),
+		taskSelect+` WHERE `+where+` ORDER BY t.due_date NULLS LAST, t.created_at`, args...)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+	tasks := []Task{}
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		tasks = append(tasks, t)
+	}
+	response.Ok(w, tasks)
+}
+
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	q := r.URL.Query()
+
+	where := "t.farm_id = $1"
+	args := []any{farmID}
+	argN := 2
+
+	if status := q.Get("status"); status != "" {
+		where += fmt.Sprintf(" AND t.status = $%d", argN)
+		args = append(args, status)
+		argN++
+	}
+	if kind := q.Get("kind"); kind != "" {
+		where += fmt.Sprintf(" AND t.kind = $%d", argN)
+		args = append(args, kind)
+		argN++
+	}
+	if assignee := q.Get("assignee_id"); assignee != "" {
+		if _, err := uuid.Parse(assignee); err != nil {
+			response.BadRequest(w, "invalid assignee_id")
+			return
+		}
+		where += fmt.Sprintf(" AND t.assignee_id = $%d", argN)
+		args = append(args, assignee)
+		argN++
+	}
+	if from := q.Get("due_from"); from != "" {
+		if _, err := time.Parse("2006-01-02", from); err != nil {
+			response.BadRequest(w, "due_from must be YYYY-MM-DD")
+			return
+		}
+		where += fmt.Sprintf(" AND t.due_date >= $%d::date", argN)
+		args = append(args, from)
+		argN++
+	}
+	if to := q.Get("due_to"); to != "" {
+		if _, err := time.Parse("2006-01-02", to); err != nil {
+			response.BadRequest(w, "due_to must be YYYY-MM-DD")
+			return
+		}
+		where += fmt.Sprintf(" AND t.due_date <= $%d::date", argN)
+		args = append(args, to)
+		argN++
+	}
+	h.listWhere(w, r, where, args)
+}
+
+// Mine lists the open work assigned to the authenticated user.
+func (h *Handler) Mine(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	userID := middleware.UserIDFromCtx(r.Context())
+	h.listWhere(w, r,
+		"t.farm_id = $1 AND t.assignee_id = $2 AND t.status IN ('open','in_progress')",
+		[]any{farmID, userID})
+}
+
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid task id")
+		return
+	}
+	t, err := scanTask(h.pool.QueryRow(r.Context(),
+		taskSelect+` WHERE t.id = $1 AND t.farm_id = $2`, id, farmID))
+	if err != nil {
+		response.NotFound(w, "task not found")
+		return
+	}
+	response.Ok(w, t)
+}
+
+// validate normalises a task request and checks that every referenced
+// member, animal, herd and zone belongs to the farm.
+func (h *Handler) validate(ctx context.Context, farmID uuid.UUID, req *TaskRequest) string {
+	if req.Title == "" {
+		return "title required"
+	}
+	if req.Kind == "" {
+		req.Kind = "general"
+	}
+	if !validKinds[req.Kind] {
+		return "kind must be general, health, weighing or maintenance"
+	}
+	if req.Recurrence != nil && *req.Recurrence == "" {
+		req.Recurrence = nil
+	}
+	if req.Recurrence != nil {
+		if !validRecurrences[*req.Recurrence] {
+			return "recurrence must be daily, weekly, monthly or yearly"
+		}
+		if req.DueDate == nil {
+			return "recurring tasks need a due_date"
+		}
+	}
+	if req.RecurrenceInterval < 1 {
+		req.RecurrenceInterval = 1
+	}
+	if req.Kind == "health" && (req.HealthEventType == nil || *req.HealthEventType == "") {
+		v := "vaccine"
+		req.HealthEventType = &v
+	}
+
+	if req.AssigneeID != nil {
+		var member bool
+		_ = h.pool.QueryRow(ctx,
+			`SELECT EXISTS(SELECT 1 FROM farm_members WHERE farm_id=$1 AND user_id=$2)`,
+			farmID, *req.AssigneeID,
+		).Scan(&member)
+		if !member {
+			return "assignee must be a member of the farm"
+		}
+	}
+	checks := []struct {
+		table string
+		ids   []uuid.UUID
+	}{
+		{"animals", req.AnimalIDs},
+		{"herds", req.HerdIDs},
+		{"zones", req.ZoneIDs},
+	}
+	for _, c := range checks {
+		if len(c.ids) == 0 {
+			continue
+		}
+		var found int
+		_ = h.pool.QueryRow(ctx,
+			`SELECT COUNT(DISTINCT id) FROM `+c.table+` WHERE farm_id=$1 AND id=ANY($2)`,
+			farmID, c.ids,
+		).Scan(&found)
+		if found != len(uniqueIDs(c.ids)) {
+			return "unknown " + c.table + " linked to task"
+		}
+	}
+	return ""
+}
+
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := map[uuid.UUID]bool{}
+	out := []uuid.UUID{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// replaceLinks rewrites the animal, herd and zone links of a task.
+func replaceLinks(ctx context.Context, q db.DBTX, taskID uuid.UUID, animals, herds, zones []uuid.UUID) error {
+	links := []struct {
+		table, column string
+		ids           []uuid.UUID
+	}{
+		{"task_animals", "animal_id", animals},
+		{"task_herds", "herd_id", herds},
+		{"task_zones", "zone_id", zones},
+	}
+	for _, l := range links {
+		if _, err := q.Exec(ctx, `DELETE FROM `+l.table+` WHERE task_id=$1`, taskID); err != nil {
+			return err
+		}
+		if len(l.ids) == 0 {
+			continue
+		}
+		if _, err := q.Exec(ctx,
+			`INSERT INTO `+l.table+` (task_id, `+l.column+`)
+			 SELECT $1, unnest($2::uuid[]) ON CONFLICT DO NOTHING`,
+			taskID, l.ids); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	userID := middleware.UserIDFromCtx(r.Context())
+	var req TaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if msg := h.validate(r.Context(), farmID, &req); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	taskID := uuid.New()
+	_, err = tx.Exec(r.Context(), `
+		INSERT INTO tasks (id, farm_id, title, description, kind, assignee_id, created_by,
+		                   due_date, recurrence, recurrence_interval, recurrence_until, health_event_type)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8::date,$9,$10,$11::date,$12)`,
+		taskID, farmID, req.Title, req.Description, req.Kind, req.AssigneeID, userID,
+		req.DueDate, req.Recurrence, req.RecurrenceInterval, req.RecurrenceUntil, req.HealthEventType)
+	if err != nil {
+		response.BadRequest(w, "invalid task dates")
+		return
+	}
+	if err := replaceLinks(r.Context(), tx, taskID, req.AnimalIDs, req.HerdIDs, req.ZoneIDs); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	t, err := scanTask(h.pool.QueryRow(r.Context(), taskSelect+` WHERE t.id = $1`, taskID))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Created(w, t)
+}
+
+func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid task id")
+		return
+	}
+	var req TaskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if msg := h.validate(r.Context(), farmID, &req); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	tag, err := tx.Exec(r.Context(), `
+		UPDATE tasks SET title=$1, description=$2, kind=$3, assignee_id=$4, due_date=$5::date,
+		       recurrence=$6, recurrence_interval=$7, recurrence_until=$8::date,
+		       health_event_type=$9, updated_at=NOW()
+		WHERE id=$10 AND farm_id=$11`,
+		req.Title, req.Description, req.Kind, req.AssigneeID, req.DueDate,
+		req.Recurrence, req.RecurrenceInterval, req.RecurrenceUntil,
+		req.HealthEventType, id, farmID)
+	if err != nil {
+		response.BadRequest(w, "invalid task dates")
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.NotFound(w, "task not found")
+		return
+	}
+	if err := replaceLinks(r.Context(), tx, id, req.AnimalIDs, req.HerdIDs, req.ZoneIDs); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	h.Get(w, r)
+}
+
+func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid task id")
+		return
+	}
+	_, _ = h.pool.Exec(r.Context(), `DELETE FROM tasks WHERE id=$1 AND farm_id=$2`, id, farmID)
+	response.NoContent(w)
+}
+
+// SetStatus moves a task through the open → in_progress → canceled workflow.
+func (h *Handler) SetStatus(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid task id")
+		return
+	}
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Status == "" {
+		response.BadRequest(w, "status required")
+		return
+	}
+	var current string
+	if err := h.pool.QueryRow(r.Context(),
+		`SELECT status FROM tasks WHERE id=$1 AND farm_id=$2`, id, farmID,
+	).Scan(&current); err != nil {
+		response.NotFound(w, "task not found")
+		return
+	}
+	allowed := false
+	for _, s := range transitions[current] {
+		if s == req.Status {
+			allowed = true
+		}
+	}
+	if !allowed {
+		response.Error(w, http.StatusConflict,
+			fmt.Sprintf("cannot change task status from %s to %s", current, req.Status))
+		return
+	}
+	_, err = h.pool.Exec(r.Context(),
+		`UPDATE tasks SET status=$1, updated_at=NOW() WHERE id=$2 AND farm_id=$3 AND status=$4`,
+		req.Status, id, farmID, current)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	h.Get(w, r)
+}
+
+type CompleteRequest struct {
+	CompletedAt string  `json:"completed_at"` // YYYY-MM-DD, defaults to today
+	Notes       *string `json:"notes"`
+	// Health tasks: details of the health events to record
+	CostCents  int      `json:"cost_cents"`
+	Currency   string   `json:"currency"`
+	DoseMgKg   *float64 `json:"dose_mg_kg"`
+	QuantityKg *float64 `json:"quantity_kg"`
+	// Weighing tasks: one weight per animal
+	Weights []struct {
+		AnimalID uuid.UUID `json:"animal_id"`
+		WeightKg float64   `json:"weight_kg"`
+	} `json:"weights"`
+}
+
+type CompleteResult struct {
+	Task           Task        `json:"task"`
+	HealthEventIDs []uuid.UUID `json:"health_event_ids"`
+	WeightRecords  int         `json:"weight_records"`
+	NextTaskID     *uuid.UUID  `json:"next_task_id,omitempty"`
+}
+
+// Complete marks a task done, records the health events or weights implied
+// by its kind and schedules the next occurrence of recurring tasks.
+func (h *Handler) Complete(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	userID := middleware.UserIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid task id")
+		return
+	}
+	var req CompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if req.CompletedAt == "" {
+		req.CompletedAt = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.CompletedAt); err != nil {
+		response.BadRequest(w, "completed_at must be YYYY-MM-DD")
+		return
+	}
+
+	t, err := scanTask(h.pool.QueryRow(r.Context(),
+		taskSelect+` WHERE t.id = $1 AND t.farm_id = $2`, id, farmID))
+	if err != nil {
+		response.NotFound(w, "task not found")
+		return
+	}
+	if t.Status == "done" || t.Status == "canceled" {
+		response.Error(w, http.StatusConflict, "task is already "+t.Status)
+		return
+	}
+	if t.Kind == "weighing" && len(req.Weights) == 0 {
+		response.BadRequest(w, "weights required to complete a weighing task")
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// Claim the task first so a concurrent completion waits on the row and
+	// then finds it done, instead of recording everything twice
+	tag, err := tx.Exec(r.Context(), `
+		UPDATE tasks SET status='done',
+		       completed_at = CASE WHEN $4::date = CURRENT_DATE THEN NOW() ELSE $4::date END,
+		       completed_by=$1, updated_at=NOW()
+		WHERE id=$2 AND farm_id=$3 AND status NOT IN ('done','canceled')`,
+		userID, id, farmID, req.CompletedAt)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.Error(w, http.StatusConflict, "task is already done or canceled")
+		return
+	}
+
+	result := CompleteResult{HealthEventIDs: []uuid.UUID{}}
+
+	switch t.Kind {
+	case "health":
+		ids, err := recordHealthEvents(r.Context(), tx, farmID, t, req)
+		if errors.Is(err, errNoTargets) {
+			response.BadRequest(w, err.Error())
+			return
+		}
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		result.HealthEventIDs = ids
+	case "weighing":
+		for _, wt := range req.Weights {
+			if wt.WeightKg <= 0 {
+				response.BadRequest(w, "weight_kg must be positive")
+				return
+			}
+			tag, err := tx.Exec(r.Context(), `
+				INSERT INTO weight_records (id, animal_id, farm_id, weight_kg, recorded_at, notes)
+				SELECT $1, a.id, a.farm_id, $3, $4::date, $5
+				FROM animals a WHERE a.id=$2 AND a.farm_id=$6`,
+				uuid.New(), wt.AnimalID, wt.WeightKg, req.CompletedAt, req.Notes, farmID)
+			if err != nil {
+				response.InternalError(w)
+				return
+			}
+			if tag.RowsAffected() == 0 {
+				response.BadRequest(w, "unknown animal "+wt.AnimalID.String())
+				return
+			}
+			result.WeightRecords++
+		}
+	}
+
+	if next, ok := nextOccurrence(t); ok {
+		nextID := uuid.New()
+		_, err = tx.Exec(r.Context(), `
+			INSERT INTO tasks (id, farm_id, title, description, kind, assignee_id, created_by,
+			                   due_date, recurrence, recurrence_interval, recurrence_until,
+			                   previous_task_id, health_event_type)
+			SELECT $1, farm_id, title, description, kind, assignee_id, created_by,
+			       $2::date, recurrence, recurrence_interval, recurrence_until,
+			       id, health_event_type
+			FROM tasks WHERE id=$3`,
+			nextID, next.Format("2006-01-02"), id)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		if err := replaceLinks(r.Context(), tx, nextID, t.AnimalIDs, t.HerdIDs, t.ZoneIDs); err != nil {
+			response.InternalError(w)
+			return
+		}
+		result.NextTaskID = &nextID
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	result.Task, err = scanTask(h.pool.QueryRow(r.Context(), taskSelect+` WHERE t.id = $1`, id))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, result)
+}
+
+// recordHealthEvents creates one herd-level event per linked herd and one
+// event per linked animal, including the animals grazing linked zones.
+func recordHealthEvents(ctx context.Context, q db.DBTX, farmID uuid.UUID, t Task, req CompleteRequest) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	base := health.CreateRequest{
+		EventType:   *t.HealthEventType,
+		Name:        t.Title,
+		Description: req.Notes,
+		Currency:    req.Currency,
+		DoseMgKg:    req.DoseMgKg,
+		QuantityKg:  req.QuantityKg,
+		StartedAt:   req.CompletedAt,
+	}
+
+	// Split the cost across events in proportion to the animals they cover
+	type target struct {
+		animalID *string
+		herdID   *string
+		count    int
+	}
+	targets := []target{}
+	for _, herdID := range t.HerdIDs {
+		var count int
+		if err := q.QueryRow(ctx,
+			`SELECT COUNT(*) FROM animals WHERE herd_id=$1 AND farm_id=$2 AND status='active'`,
+			herdID, farmID).Scan(&count); err != nil {
+			return nil, err
+		}
+		s := herdID.String()
+		targets = append(targets, target{herdID: &s, count: max(count, 1)})
+	}
+	rows, err := q.Query(ctx, `
+		SELECT DISTINCT a.id FROM animals a
+		WHERE a.farm_id=$1 AND a.status='active'
+		  AND (a.id = ANY($2) OR a.zone_id = ANY($3))
+		  AND (a.herd_id IS NULL OR NOT a.herd_id = ANY(COALESCE($4, '{}'::uuid[])))`,
+		farmID, t.AnimalIDs, t.ZoneIDs, t.HerdIDs)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		s := id.String()
+		targets = append(targets, target{animalID: &s, count: 1})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, errNoTargets
+	}
+
+	total := 0
+	for _, tg := range targets {
+		total += tg.count
+	}
+	assigned := 0
+	for i, tg := range targets {
+		ev := base
+		ev.AnimalID = tg.animalID
+		ev.HerdID = tg.herdID
+		ev.AnimalCount = tg.count
+		if i == len(targets)-1 {
+			ev.CostCents = req.CostCents - assigned
+		} else {
+			ev.CostCents = req.CostCents * tg.count / total
+		}
+		assigned += ev.CostCents
+		e, err := health.Insert(ctx, q, farmID, ev)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, e.ID)
+	}
+	return ids, nil
+}
+
+// nextOccurrence returns the due date of the next occurrence of a recurring
+// task, or false when the task does not repeat or the series has ended.
+func nextOccurrence(t Task) (time.Time, bool) {
+	if t.Recurrence == nil || t.DueDate == nil {
+		return time.Time{}, false
+	}
+	due, err := time.Parse("2006-01-02", *t.DueDate)
+	if err != nil {
+		return time.Time{}, false
+	}
+	n := max(t.RecurrenceInterval, 1)
+	var next time.Time
+	switch *t.Recurrence {
+	case "daily":
+		next = due.AddDate(0, 0, n)
+	case "weekly":
+		next = due.AddDate(0, 0, 7*n)
+	case "monthly":
+		next = addMonths(due, n)
+	case "yearly":
+		next = addMonths(due, 12*n)
+	default:
+		return time.Time{}, false
+	}
+	if t.RecurrenceUntil != nil {
+		until, err := time.Parse("2006-01-02", *t.RecurrenceUntil)
+		if err == nil && next.After(until) {
+			return time.Time{}, false
+		}
+	}
+	return next, true
+}
+
+// addMonths moves t forward n months, keeping the day but clamping it to
+// the last day of the target month, so Jan 31 is followed by Feb 28.
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, 0, 0, 0, 0, t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(t.Day(), last)-1)
+}
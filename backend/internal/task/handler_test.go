@@ -0,0 +1,127 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db/dbtest"
+)
+
+func ptr(s string) *string { return &s }
+
+func TestNextOccurrence(t *testing.T) {
+	tests := []struct {
+		name string
+		task Task
+		want string // empty when the series ends
+	}{
+		{"not recurring", Task{DueDate: ptr("2026-03-10")}, ""},
+		{"no due date", Task{Recurrence: ptr("daily")}, ""},
+		{"bad due date", Task{Recurrence: ptr("daily"), DueDate: ptr("10/03/2026")}, ""},
+		{"unknown recurrence", Task{Recurrence: ptr("hourly"), DueDate: ptr("2026-03-10")}, ""},
+		{"daily", Task{Recurrence: ptr("daily"), DueDate: ptr("2026-03-10")}, "2026-03-11"},
+		{"zero interval counts as one", Task{Recurrence: ptr("daily"), RecurrenceInterval: 0, DueDate: ptr("2026-03-10")}, "2026-03-11"},
+		{"every 2 weeks", Task{Recurrence: ptr("weekly"), RecurrenceInterval: 2, DueDate: ptr("2026-03-10")}, "2026-03-24"},
+		{"every 3 months", Task{Recurrence: ptr("monthly"), RecurrenceInterval: 3, DueDate: ptr("2026-11-15")}, "2027-02-15"},
+		{"month end clamps", Task{Recurrence: ptr("monthly"), DueDate: ptr("2027-01-31")}, "2027-02-28"},
+		{"month end in leap year", Task{Recurrence: ptr("monthly"), DueDate: ptr("2028-01-31")}, "2028-02-29"},
+		{"yearly", Task{Recurrence: ptr("yearly"), DueDate: ptr("2026-03-10")}, "2027-03-10"},
+		{"yearly from leap day", Task{Recurrence: ptr("yearly"), DueDate: ptr("2028-02-29")}, "2029-02-28"},
+		{"next on until", Task{Recurrence: ptr("weekly"), DueDate: ptr("2026-03-10"), RecurrenceUntil: ptr("2026-03-17")}, "2026-03-17"},
+		{"next after until", Task{Recurrence: ptr("weekly"), DueDate: ptr("2026-03-10"), RecurrenceUntil: ptr("2026-03-16")}, ""},
+	}
+	for _, tt := range tests {
+		next, ok := nextOccurrence(tt.task)
+		got := ""
+		if ok {
+			got = next.Format("2006-01-02")
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRecordHealthEvents checks that completing a health task records one
+// event per linked herd and per loose animal in linked zones, splitting the
+// cost by the animals each covers.
+func TestRecordHealthEvents(t *testing.T) {
+	pool := dbtest.Pool(t)
+	farmID := dbtest.Farm(t, pool)
+	ctx := context.Background()
+
+	zoneID := dbtest.Zone(t, pool, farmID, "Retiro")
+	herdID := dbtest.Herd(t, pool, farmID, "Lote A")
+	for _, tag := range []string{"001", "002", "003"} {
+		dbtest.Animal(t, pool, farmID, tag, &herdID, nil)
+	}
+	dbtest.Animal(t, pool, farmID, "004", &herdID, &zoneID) // in the zone, but treated with its herd
+	loose := dbtest.Animal(t, pool, farmID, "005", nil, &zoneID)
+	dbtest.Animal(t, pool, farmID, "006", nil, nil)
+
+	vaccine := "vaccine"
+	task := Task{Title: "Aftosa", HealthEventType: &vaccine,
+		HerdIDs: []uuid.UUID{herdID}, ZoneIDs: []uuid.UUID{zoneID}}
+	ids, err := recordHealthEvents(ctx, pool, farmID, task,
+		CompleteRequest{CompletedAt: "2026-03-10", CostCents: 1001, Currency: "BRL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type event struct {
+		herd, animal *uuid.UUID
+		cost, count  int
+	}
+	want := []event{{&herdID, nil, 800, 4}, {nil, &loose, 201, 1}}
+	if len(ids) != len(want) {
+		t.Fatalf("%d events, want %d", len(ids), len(want))
+	}
+	for i, id := range ids {
+		var e event
+		var name, date string
+		err := pool.QueryRow(ctx, `
+			SELECT herd_id, animal_id, cost_cents, animal_count, name, started_at::text
+			FROM health_events WHERE id=$1`, id).Scan(&e.herd, &e.animal, &e.cost, &e.count, &name, &date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalID(e.herd, want[i].herd) || !equalID(e.animal, want[i].animal) ||
+			e.cost != want[i].cost || e.count != want[i].count {
+			t.Errorf("event %d = herd %v animal %v cost %d for %d animals, want %v", i, e.herd, e.animal, e.cost, e.count, want[i])
+		}
+		if name != "Aftosa" || date != "2026-03-10" {
+			t.Errorf("event %d = %q on %s", i, name, date)
+		}
+	}
+}
+
+func equalID(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// TestRecordHealthEventsNoTargets checks that a health task covering no
+// active animal records nothing and reports errNoTargets.
+func TestRecordHealthEventsNoTargets(t *testing.T) {
+	pool := dbtest.Pool(t)
+	farmID := dbtest.Farm(t, pool)
+	ctx := context.Background()
+
+	zoneID := dbtest.Zone(t, pool, farmID, "Vazio")
+	vaccine := "vaccine"
+	for _, task := range []Task{
+		{Title: "Aftosa", HealthEventType: &vaccine},
+		{Title: "Aftosa", HealthEventType: &vaccine, ZoneIDs: []uuid.UUID{zoneID}},
+	} {
+		_, err := recordHealthEvents(ctx, pool, farmID, task,
+			CompleteRequest{CompletedAt: "2026-03-10", Currency: "BRL"})
+		if !errors.Is(err, errNoTargets) {
+			t.Errorf("zones %v: err = %v, want errNoTargets", task.ZoneIDs, err)
+		}
+	}
+}
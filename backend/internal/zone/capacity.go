@@ -0,0 +1,108 @@
+package zone
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// ZONE CAPACITY
+// =============================================
+
+// Overstock policies (farms.overstock_policy) for moves that take a zone
+// over its ugm_ha_limit.
+const (
+	PolicyOff    = "off"
+	PolicyWarn   = "warn"
+	PolicyReject = "reject"
+)
+
+// Overstock is an active zone stocked above its ugm_ha_limit.
+// MaxAnimalUnits is the most the zone holds within its limit.
+type Overstock struct {
+	ZoneID         uuid.UUID `json:"zone_id"`
+	Name           string    `json:"name"`
+	AreaHa         float64   `json:"area_ha"`
+	Animals        int       `json:"animals"`
+	AnimalUnits    float64   `json:"animal_units"`
+	UGMHa          float64   `json:"ugm_ha"`
+	UGMHaLimit     float64   `json:"ugm_ha_limit"`
+	MaxAnimalUnits float64   `json:"max_animal_units"`
+}
+
+// OverstockPolicy returns the farm's overstock policy.
+func OverstockPolicy(ctx context.Context, q db.DBTX, farmID uuid.UUID) (string, error) {
+	var policy string
+	err := q.QueryRow(ctx,
+		`SELECT overstock_policy FROM farms WHERE id=$1`, farmID).Scan(&policy)
+	return policy, err
+}
+
+// Overstocked returns the farm's active zones over their limit, restricted
+// to zoneIDs when any are given. Zones without a limit or an area are never
+// overstocked.
+func Overstocked(ctx context.Context, q db.DBTX, farmID uuid.UUID, zoneIDs []uuid.UUID) ([]Overstock, error) {
+	rows, err := q.Query(ctx, `
+		SELECT z.id, z.name, z.area_ha::float8, COUNT(au.animal_id)::int,
+		       COALESCE(ROUND(SUM(au.ua)::numeric, 2), 0)::float8,
+		       ROUND((COALESCE(SUM(au.ua), 0) / z.area_ha)::numeric, 2)::float8,
+		       z.ugm_ha_limit::float8,
+		       ROUND((z.ugm_ha_limit * z.area_ha)::numeric, 2)::float8
+		FROM zones z
+		JOIN animal_units au ON au.zone_id = z.id
+		WHERE z.farm_id = $1 AND z.is_active
+		  AND z.ugm_ha_limit IS NOT NULL AND z.area_ha > 0
+		  AND (COALESCE(cardinality($2::uuid[]), 0) = 0 OR z.id = ANY($2))
+		GROUP BY z.id
+		HAVING SUM(au.ua) > z.ugm_ha_limit * z.area_ha
+		ORDER BY z.name`, farmID, zoneIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []Overstock{}
+	for rows.Next() {
+		var o Overstock
+		if err := rows.Scan(&o.ZoneID, &o.Name, &o.AreaHa, &o.Animals,
+			&o.AnimalUnits, &o.UGMHa, &o.UGMHaLimit, &o.MaxAnimalUnits); err != nil {
+			return nil, err
+		}
+		list = append(list, o)
+	}
+	return list, rows.Err()
+}
+
+// GuardCapacity applies the farm's overstock policy to the destination
+// zones of a move made in q, before it is committed. Under the reject
+// policy it writes a 409 listing the overstocked zones and returns
+// ok=false unless force is set. Otherwise it returns the zones over their
+// limit so the response can warn about them; none under the off policy.
+func GuardCapacity(w http.ResponseWriter, r *http.Request, q db.DBTX, farmID uuid.UUID,
+	zoneIDs []uuid.UUID, force bool) ([]Overstock, bool) {
+	policy, err := OverstockPolicy(r.Context(), q, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return nil, false
+	}
+	if policy == PolicyOff {
+		return []Overstock{}, true
+	}
+	over, err := Overstocked(r.Context(), q, farmID, zoneIDs)
+	if err != nil {
+		response.InternalError(w)
+		return nil, false
+	}
+	if len(over) > 0 && policy == PolicyReject && !force {
+		response.JSON(w, http.StatusConflict, response.Response{
+			Error: "move exceeds the stocking limit of " + over[0].Name + " (use force=true to move anyway)",
+			Data:  over,
+		})
+		return nil, false
+	}
+	return over, true
+}
@@ -0,0 +1,347 @@
+package zone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// FORAGE ASSESSMENTS
+// =============================================
+
+const (
+	// DailyIntakeKgDM is the dry matter eaten per day by one animal unit:
+	// 2.5% of 450 kg of live weight.
+	DailyIntakeKgDM = 11.25
+	// GrazingEfficiency is the share of the standing dry matter that can be
+	// grazed; the rest is left as residue for regrowth.
+	GrazingEfficiency = 0.5
+	// ForageValidDays is how long an assessment is trusted for a budget.
+	ForageValidDays = 45
+)
+
+type ForageAssessment struct {
+	ID            uuid.UUID  `json:"id"`
+	ZoneID        uuid.UUID  `json:"zone_id"`
+	AssessedOn    string     `json:"assessed_on"`
+	HeightCm      *float64   `json:"height_cm"`
+	DryMatterKgHa *float64   `json:"dry_matter_kg_ha"`
+	CoverPct      *float64   `json:"cover_pct"`
+	QualityScore  *int       `json:"quality_score"`
+	Photos        []string   `json:"photos"`
+	Notes         *string    `json:"notes"`
+	CreatedBy     *uuid.UUID `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// ForageBudget is what a zone's latest dry matter assessment still feeds.
+// The grazeable forage at the assessment, less what the animal units in the
+// zone have eaten since, gives the carrying capacity in UA-days and, at the
+// current stocking, the days of grazing remaining. Regrowth is not modelled,
+// so budgets older than ForageValidDays are marked stale.
+type ForageBudget struct {
+	ZoneID                 uuid.UUID `json:"zone_id"`
+	ZoneName               string    `json:"zone_name"`
+	AreaHa                 float64   `json:"area_ha"`
+	AssessmentID           uuid.UUID `json:"assessment_id"`
+	AssessedOn             string    `json:"assessed_on"`
+	AgeDays                int       `json:"age_days"`
+	Stale                  bool      `json:"stale"`
+	HeightCm               *float64  `json:"height_cm"`
+	DryMatterKgHa          float64   `json:"dry_matter_kg_ha"`
+	QualityScore           *int      `json:"quality_score"`
+	AvailableKg            float64   `json:"available_kg"`
+	GrazedKg               float64   `json:"grazed_kg"`
+	RemainingKg            float64   `json:"remaining_kg"`
+	CarryingCapacityUADays float64   `json:"carrying_capacity_ua_days"`
+	AnimalUnits            float64   `json:"animal_units"`
+	UGMHa                  float64   `json:"ugm_ha"`
+	// DaysRemaining and RunsOutOn are null while the zone is empty.
+	DaysRemaining *float64 `json:"days_remaining"`
+	RunsOutOn     *string  `json:"runs_out_on"`
+}
+
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
+
+// ForageBudgets returns the budget of every active zone of a farm (one zone
+// when zoneID is set) from its latest assessment with dry matter. Grazing
+// since the assessment comes from the animal units of each usage period.
+func ForageBudgets(ctx context.Context, q db.DBTX, farmID uuid.UUID, zoneID *uuid.UUID) (map[uuid.UUID]ForageBudget, error) {
+	rows, err := q.Query(ctx, `
+		WITH latest AS (
+			SELECT DISTINCT ON (zone_id) zone_id, id, assessed_on,
+			       dry_matter_kg_ha::float8 AS dm, height_cm::float8 AS height_cm, quality_score
+			FROM forage_assessments
+			WHERE farm_id = $1 AND dry_matter_kg_ha IS NOT NULL
+			  AND ($2::uuid IS NULL OR zone_id = $2)
+			ORDER BY zone_id, assessed_on DESC, created_at DESC
+		)
+		SELECT z.id, z.name, z.area_ha::float8, l.id, l.assessed_on, l.dm, l.height_cm, l.quality_score,
+		       COALESCE((
+		           SELECT SUM(COALESCE(u.animal_units, u.animal_count) *
+		                      EXTRACT(EPOCH FROM COALESCE(u.ended_at, NOW())
+		                                         - GREATEST(u.started_at, l.assessed_on::timestamptz)) / 86400)
+		           FROM zone_usages u
+		           WHERE u.zone_id = z.id AND COALESCE(u.ended_at, NOW()) > l.assessed_on::timestamptz
+		       ), 0)::float8,
+		       COALESCE((SELECT SUM(au.ua) FROM animal_units au WHERE au.zone_id = z.id), 0)::float8
+		FROM latest l
+		JOIN zones z ON z.id = l.zone_id
+		WHERE z.is_active`, farmID, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	out := map[uuid.UUID]ForageBudget{}
+	for rows.Next() {
+		var b ForageBudget
+		var assessed time.Time
+		var uaDays float64
+		if err := rows.Scan(&b.ZoneID, &b.ZoneName, &b.AreaHa, &b.AssessmentID, &assessed,
+			&b.DryMatterKgHa, &b.HeightCm, &b.QualityScore, &uaDays, &b.AnimalUnits); err != nil {
+			return nil, err
+		}
+		b.AssessedOn = assessed.Format("2006-01-02")
+		b.AgeDays = int(today.Sub(assessed.UTC().Truncate(24*time.Hour)).Hours() / 24)
+		b.Stale = b.AgeDays > ForageValidDays
+
+		available := b.DryMatterKgHa * b.AreaHa * GrazingEfficiency
+		grazed := math.Max(uaDays, 0) * DailyIntakeKgDM
+		remaining := math.Max(available-grazed, 0)
+		b.AvailableKg, b.GrazedKg, b.RemainingKg = round(available, 0), round(grazed, 0), round(remaining, 0)
+		b.CarryingCapacityUADays = round(remaining/DailyIntakeKgDM, 1)
+		b.AnimalUnits = round(b.AnimalUnits, 2)
+		if b.AreaHa > 0 {
+			b.UGMHa = round(b.AnimalUnits/b.AreaHa, 2)
+		}
+		if b.AnimalUnits > 0 {
+			d := round(remaining/(b.AnimalUnits*DailyIntakeKgDM), 1)
+			on := today.AddDate(0, 0, int(d)).Format("2006-01-02")
+			b.DaysRemaining, b.RunsOutOn = &d, &on
+		}
+		out[b.ZoneID] = b
+	}
+	return out, rows.Err()
+}
+
+type ForageRequest struct {
+	AssessedOn    string   `json:"assessed_on"`
+	HeightCm      *float64 `json:"height_cm"`
+	DryMatterKgHa *float64 `json:"dry_matter_kg_ha"`
+	CoverPct      *float64 `json:"cover_pct"`
+	QualityScore  *int     `json:"quality_score"`
+	Photos        []string `json:"photos"`
+	Notes         *string  `json:"notes"`
+}
+
+func (req *ForageRequest) validate() string {
+	if req.AssessedOn == "" {
+		req.AssessedOn = time.Now().Format("2006-01-02")
+	} else if t, err := time.Parse("2006-01-02", req.AssessedOn); err != nil {
+		return "assessed_on must be a date (YYYY-MM-DD)"
+	} else if t.After(time.Now()) {
+		return "assessed_on cannot be in the future"
+	}
+	switch {
+	case req.HeightCm == nil && req.DryMatterKgHa == nil && req.CoverPct == nil && req.QualityScore == nil:
+		return "at least one of height_cm, dry_matter_kg_ha, cover_pct or quality_score is required"
+	case req.HeightCm != nil && (*req.HeightCm < 0 || *req.HeightCm > 500):
+		return "height_cm must be between 0 and 500"
+	case req.DryMatterKgHa != nil && (*req.DryMatterKgHa < 0 || *req.DryMatterKgHa > 50000):
+		return "dry_matter_kg_ha must be between 0 and 50000"
+	case req.CoverPct != nil && (*req.CoverPct < 0 || *req.CoverPct > 100):
+		return "cover_pct must be between 0 and 100"
+	case req.QualityScore != nil && (*req.QualityScore < 1 || *req.QualityScore > 5):
+		return "quality_score must be between 1 and 5"
+	}
+	photos := []string{}
+	for _, p := range req.Photos {
+		if p = strings.TrimSpace(p); p != "" {
+			photos = append(photos, p)
+		}
+	}
+	req.Photos = photos
+	return ""
+}
+
+const forageSelect = `
+	SELECT id, zone_id, assessed_on, height_cm::float8, dry_matter_kg_ha::float8,
+	       cover_pct::float8, quality_score, photos, notes, created_by, created_at
+	FROM forage_assessments`
+
+func scanForage(row interface{ Scan(...any) error }) (ForageAssessment, error) {
+	var f ForageAssessment
+	var assessed time.Time
+	err := row.Scan(&f.ID, &f.ZoneID, &assessed, &f.HeightCm, &f.DryMatterKgHa,
+		&f.CoverPct, &f.QualityScore, &f.Photos, &f.Notes, &f.CreatedBy, &f.CreatedAt)
+	f.AssessedOn = assessed.Format("2006-01-02")
+	return f, err
+}
+
+// ListForage returns a zone's assessments within ?from= and ?to= (default
+// the last 365 days), newest first, with its current forage budget.
+func (h *Handler) ListForage(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	zoneID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid zone id")
+		return
+	}
+	from, to, ok := usageWindow(r)
+	if !ok {
+		response.BadRequest(w, "from and to must be dates (YYYY-MM-DD), from before to")
+		return
+	}
+	var exists bool
+	if err := h.pool.QueryRow(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM zones WHERE id=$1 AND farm_id=$2)`, zoneID, farmID).Scan(&exists); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if !exists {
+		response.NotFound(w, "zone not found")
+		return
+	}
+	rows, err := h.pool.Query(r.Context(), forageSelect+`
+		WHERE zone_id = $1 AND assessed_on >= $2 AND assessed_on < $3
+		ORDER BY assessed_on DESC, created_at DESC`, zoneID, from, to)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+	list := []ForageAssessment{}
+	for rows.Next() {
+		f, err := scanForage(rows)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		list = append(list, f)
+	}
+	if err := rows.Err(); err != nil {
+		response.InternalError(w)
+		return
+	}
+	budgets, err := ForageBudgets(r.Context(), h.pool, farmID, &zoneID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	var budget *ForageBudget
+	if b, ok := budgets[zoneID]; ok {
+		budget = &b
+	}
+	response.Ok(w, map[string]any{"assessments": list, "budget": budget})
+}
+
+// CreateForage records a forage assessment of a zone.
+func (h *Handler) CreateForage(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	userID := middleware.UserIDFromCtx(r.Context())
+	zoneID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid zone id")
+		return
+	}
+	var req ForageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+	f, err := scanForage(h.pool.QueryRow(r.Context(), `
+		INSERT INTO forage_assessments
+		  (id, farm_id, zone_id, assessed_on, height_cm, dry_matter_kg_ha, cover_pct,
+		   quality_score, photos, notes, created_by)
+		SELECT $1, $2, z.id, $4::date, $5::numeric, $6::numeric, $7::numeric,
+		       $8::smallint, $9::text[], $10::text, $11::uuid
+		FROM zones z WHERE z.id = $3 AND z.farm_id = $2
+		RETURNING id, zone_id, assessed_on, height_cm::float8, dry_matter_kg_ha::float8,
+		          cover_pct::float8, quality_score, photos, notes, created_by, created_at`,
+		uuid.New(), farmID, zoneID, req.AssessedOn, req.HeightCm, req.DryMatterKgHa, req.CoverPct,
+		req.QualityScore, req.Photos, req.Notes, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		response.NotFound(w, "zone not found")
+		return
+	}
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Created(w, f)
+}
+
+// DeleteForage removes an assessment recorded by mistake.
+func (h *Handler) DeleteForage(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	zoneID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid zone id")
+		return
+	}
+	assessmentID, err := uuid.Parse(chi.URLParam(r, "assessmentId"))
+	if err != nil {
+		response.BadRequest(w, "invalid assessment id")
+		return
+	}
+	tag, err := h.pool.Exec(r.Context(),
+		`DELETE FROM forage_assessments WHERE id=$1 AND zone_id=$2 AND farm_id=$3`,
+		assessmentID, zoneID, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.NotFound(w, "assessment not found")
+		return
+	}
+	response.NoContent(w)
+}
+
+// ForageOverview returns the forage budget of every assessed zone, the
+// zones running out first at the top.
+func (h *Handler) ForageOverview(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	budgets, err := ForageBudgets(r.Context(), h.pool, farmID, nil)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	list := make([]ForageBudget, 0, len(budgets))
+	for _, b := range budgets {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i].DaysRemaining, list[j].DaysRemaining
+		if (a == nil) != (b == nil) {
+			return a != nil
+		}
+		if a != nil && *a != *b {
+			return *a < *b
+		}
+		return list[i].ZoneName < list[j].ZoneName
+	})
+	response.Ok(w, list)
+}
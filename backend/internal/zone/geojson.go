@@ -0,0 +1,179 @@
+package zone
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// GEOJSON FEATURE COLLECTION
+// =============================================
+
+type Feature struct {
+	Type       string          `json:"type"`
+	ID         string          `json:"id"`
+	Geometry   json.RawMessage `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+func feature(id string, geometry json.RawMessage, props map[string]any) Feature {
+	return Feature{Type: "Feature", ID: id, Geometry: geometry, Properties: props}
+}
+
+// parseKinds reads ?kinds=, a comma separated subset of zone, perimeter,
+// key_point and antenna; all kinds when absent.
+func parseKinds(r *http.Request) map[string]bool {
+	kinds := map[string]bool{"zone": true, "perimeter": true, "key_point": true, "antenna": true}
+	if v := r.URL.Query().Get("kinds"); v != "" {
+		kinds = map[string]bool{}
+		for _, k := range strings.Split(v, ",") {
+			kinds[strings.TrimSpace(k)] = true
+		}
+	}
+	return kinds
+}
+
+// GeoJSON returns the farm map as a FeatureCollection that mapping libraries
+// can load directly. Every feature has a "kind" property: zone, perimeter,
+// key_point or antenna; ?kinds= limits the kinds included.
+func (h *Handler) GeoJSON(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	fc, err := h.farmMap(r.Context(), farmID, parseKinds(r))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	_ = json.NewEncoder(w).Encode(fc)
+}
+
+// farmMap collects the farm's map features of the requested kinds.
+func (h *Handler) farmMap(ctx context.Context, farmID uuid.UUID, kinds map[string]bool) (FeatureCollection, error) {
+	fc := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	if kinds["perimeter"] {
+		rows, err := h.pool.Query(ctx, `
+			SELECT id::text, name, area_ha::float8, ST_AsGeoJSON(geometry)::json
+			FROM perimeters WHERE farm_id=$1 ORDER BY name`, farmID)
+		if err != nil {
+			return fc, err
+		}
+		for rows.Next() {
+			var id, name string
+			var area float64
+			var geom json.RawMessage
+			if err := rows.Scan(&id, &name, &area, &geom); err != nil {
+				rows.Close()
+				return fc, err
+			}
+			fc.Features = append(fc.Features, feature(id, geom, map[string]any{
+				"kind": "perimeter", "name": name, "area_ha": area,
+			}))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fc, err
+		}
+	}
+
+	if kinds["zone"] {
+		rows, err := h.pool.Query(ctx, zoneSelect+`
+			WHERE z.farm_id = $1
+			GROUP BY z.id
+			ORDER BY z.name`, farmID)
+		if err != nil {
+			return fc, err
+		}
+		for rows.Next() {
+			z, err := scanZone(rows)
+			if err != nil {
+				rows.Close()
+				return fc, err
+			}
+			fc.Features = append(fc.Features, feature(z.ID.String(), z.Geometry, map[string]any{
+				"kind":         "zone",
+				"name":         z.Name,
+				"group_id":     z.GroupID,
+				"area_ha":      z.AreaHa,
+				"grass_type":   z.GrassType,
+				"animal_count": z.AnimalCount,
+				"animal_units": z.AnimalUnits,
+				"ugm_ha":       z.UGMHa,
+				"ugm_ha_limit": z.UGMHaLimit,
+				"over_limit":   z.UGMHaLimit != nil && z.UGMHa > *z.UGMHaLimit,
+				"is_active":    z.IsActive,
+				"is_isolation": z.IsIsolation,
+			}))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fc, err
+		}
+	}
+
+	if kinds["key_point"] {
+		rows, err := h.pool.Query(ctx, `
+			SELECT id::text, name, icon, category, radius_m::float8, is_active, ST_AsGeoJSON(location)::json
+			FROM key_points WHERE farm_id=$1 ORDER BY name`, farmID)
+		if err != nil {
+			return fc, err
+		}
+		for rows.Next() {
+			var id, name, icon, category string
+			var radius float64
+			var active bool
+			var geom json.RawMessage
+			if err := rows.Scan(&id, &name, &icon, &category, &radius, &active, &geom); err != nil {
+				rows.Close()
+				return fc, err
+			}
+			fc.Features = append(fc.Features, feature(id, geom, map[string]any{
+				"kind": "key_point", "name": name, "icon": icon, "category": category,
+				"radius_m": radius, "is_active": active,
+			}))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fc, err
+		}
+	}
+
+	if kinds["antenna"] {
+		rows, err := h.pool.Query(ctx, `
+			SELECT id::text, name, radius_m, is_active, ST_AsGeoJSON(location)::json
+			FROM antennas WHERE farm_id=$1 ORDER BY name`, farmID)
+		if err != nil {
+			return fc, err
+		}
+		for rows.Next() {
+			var id, name string
+			var radius int
+			var active bool
+			var geom json.RawMessage
+			if err := rows.Scan(&id, &name, &radius, &active, &geom); err != nil {
+				rows.Close()
+				return fc, err
+			}
+			fc.Features = append(fc.Features, feature(id, geom, map[string]any{
+				"kind": "antenna", "name": name, "radius_m": radius, "is_active": active,
+			}))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fc, err
+		}
+	}
+
+	return fc, nil
+}
@@ -0,0 +1,286 @@
+package zone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// GEOMETRY VALIDATION
+// =============================================
+
+// minOverlapHa is the overlap tolerated between neighbouring polygons, so
+// zones drawn against a shared fence line are not reported.
+const minOverlapHa = 0.01
+
+// GeometryIssue describes a problem found in a submitted polygon. Repaired
+// issues were fixed automatically; the others reject the geometry unless
+// they only concern its siting and the request is forced.
+type GeometryIssue struct {
+	Code     string     `json:"code"` // not_polygon | invalid_coordinates | too_few_points | unclosed_ring | wrong_winding | empty_area | self_intersection | outside_perimeter | overlap
+	Message  string     `json:"message"`
+	Repaired bool       `json:"repaired,omitempty"`
+	ZoneID   *uuid.UUID `json:"zone_id,omitempty"`
+	AreaHa   float64    `json:"area_ha,omitempty"`
+}
+
+// GeometryError rejects a polygon that could not be used or repaired.
+type GeometryError struct{ Issues []GeometryIssue }
+
+func (e *GeometryError) Error() string {
+	msgs := make([]string, len(e.Issues))
+	for i, is := range e.Issues {
+		msgs[i] = is.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func invalidGeometry(code, format string, args ...any) *GeometryError {
+	return &GeometryError{Issues: []GeometryIssue{{Code: code, Message: fmt.Sprintf(format, args...)}}}
+}
+
+// Geometry is a validated polygon ready to be stored.
+type Geometry struct {
+	GeoJSON string
+	AreaHa  float64
+	Repairs []GeometryIssue
+}
+
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSONObject  `json:"geometry"`
+	Features    []geoJSONObject `json:"features"`
+}
+
+// polygonRings extracts the rings of a single polygon from a GeoJSON
+// Polygon, a MultiPolygon with one member, or a Feature or FeatureCollection
+// wrapping one of those. The document may also arrive as a JSON string.
+func polygonRings(raw json.RawMessage) ([][][]float64, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		raw = json.RawMessage(text)
+	}
+	var obj geoJSONObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, invalidGeometry("not_polygon", "geometry is not valid GeoJSON")
+	}
+	switch obj.Type {
+	case "FeatureCollection":
+		if len(obj.Features) != 1 {
+			return nil, invalidGeometry("not_polygon", "feature collection must contain exactly one feature, got %d", len(obj.Features))
+		}
+		b, _ := json.Marshal(obj.Features[0])
+		return polygonRings(b)
+	case "Feature":
+		if obj.Geometry == nil {
+			return nil, invalidGeometry("not_polygon", "feature has no geometry")
+		}
+		b, _ := json.Marshal(obj.Geometry)
+		return polygonRings(b)
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(obj.Coordinates, &rings); err != nil || len(rings) == 0 {
+			return nil, invalidGeometry("not_polygon", "polygon coordinates must be an array of rings")
+		}
+		return rings, nil
+	case "MultiPolygon":
+		var polys [][][][]float64
+		if err := json.Unmarshal(obj.Coordinates, &polys); err != nil || len(polys) == 0 {
+			return nil, invalidGeometry("not_polygon", "multipolygon coordinates must be an array of polygons")
+		}
+		if len(polys) > 1 {
+			return nil, invalidGeometry("not_polygon", "multipolygon has %d parts; a zone must be a single polygon", len(polys))
+		}
+		return polys[0], nil
+	case "":
+		return nil, invalidGeometry("not_polygon", "geometry required")
+	default:
+		return nil, invalidGeometry("not_polygon", "geometry must be a Polygon, got %s", obj.Type)
+	}
+}
+
+// ringArea returns twice the signed planar area of a closed ring; positive
+// when counter-clockwise.
+func ringArea(ring [][]float64) float64 {
+	var sum float64
+	for i := 0; i < len(ring)-1; i++ {
+		sum += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return sum
+}
+
+// normalizePolygon checks the structure of a polygon and fixes what can be
+// fixed without changing its shape: unclosed rings and the RFC 7946
+// winding order (exterior counter-clockwise, holes clockwise).
+func normalizePolygon(raw json.RawMessage) (string, []GeometryIssue, error) {
+	rings, err := polygonRings(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	var repairs []GeometryIssue
+	for i, ring := range rings {
+		name := "exterior ring"
+		if i > 0 {
+			name = fmt.Sprintf("hole %d", i)
+		}
+		for j, pos := range ring {
+			if len(pos) < 2 {
+				return "", nil, invalidGeometry("invalid_coordinates", "%s position %d must have longitude and latitude", name, j)
+			}
+			if pos[0] < -180 || pos[0] > 180 || pos[1] < -90 || pos[1] > 90 {
+				return "", nil, invalidGeometry("invalid_coordinates",
+					"%s position %d (%g, %g) is outside longitude/latitude range; coordinates must be [lng, lat] in WGS84",
+					name, j, pos[0], pos[1])
+			}
+			ring[j] = pos[:2]
+		}
+		if len(ring) > 0 && (ring[0][0] != ring[len(ring)-1][0] || ring[0][1] != ring[len(ring)-1][1]) {
+			ring = append(ring, ring[0])
+			repairs = append(repairs, GeometryIssue{Code: "unclosed_ring", Repaired: true,
+				Message: name + " was not closed; first point appended"})
+		}
+		if len(ring) < 4 {
+			return "", nil, invalidGeometry("too_few_points", "%s needs at least 3 distinct points", name)
+		}
+		area := ringArea(ring)
+		if area == 0 {
+			return "", nil, invalidGeometry("empty_area", "%s has no area (points are collinear)", name)
+		}
+		if (i == 0) != (area > 0) {
+			for a, b := 0, len(ring)-1; a < b; a, b = a+1, b-1 {
+				ring[a], ring[b] = ring[b], ring[a]
+			}
+			repairs = append(repairs, GeometryIssue{Code: "wrong_winding", Repaired: true,
+				Message: name + " had the wrong winding order and was reversed"})
+		}
+		rings[i] = ring
+	}
+	out, _ := json.Marshal(map[string]any{"type": "Polygon", "coordinates": rings})
+	return string(out), repairs, nil
+}
+
+// PrepareGeometry validates a submitted polygon, repairs self-intersections
+// when PostGIS can turn it into a single valid polygon, and computes its
+// area on the spheroid.
+func PrepareGeometry(ctx context.Context, q db.DBTX, raw json.RawMessage) (Geometry, error) {
+	geojson, repairs, err := normalizePolygon(raw)
+	if err != nil {
+		return Geometry{}, err
+	}
+
+	var valid bool
+	var reason string
+	err = q.QueryRow(ctx, `
+		SELECT ST_IsValid(g), ST_IsValidReason(g)
+		FROM (SELECT ST_SetSRID(ST_GeomFromGeoJSON($1), 4326) AS g) s`, geojson,
+	).Scan(&valid, &reason)
+	if err != nil {
+		return Geometry{}, err
+	}
+	if !valid {
+		var parts int
+		var fixed *string
+		err = q.QueryRow(ctx, `
+			SELECT ST_NumGeometries(m),
+			       CASE WHEN ST_NumGeometries(m) = 1
+			            THEN ST_AsGeoJSON(ST_ForcePolygonCCW(ST_GeometryN(m, 1))) END
+			FROM (SELECT ST_CollectionExtract(ST_MakeValid(ST_SetSRID(ST_GeomFromGeoJSON($1), 4326)), 3) AS m) s`,
+			geojson,
+		).Scan(&parts, &fixed)
+		if err != nil {
+			return Geometry{}, err
+		}
+		if fixed == nil {
+			return Geometry{}, invalidGeometry("self_intersection",
+				"polygon is invalid (%s) and repairing it would produce %d separate polygons; redraw it without crossing edges",
+				reason, parts)
+		}
+		geojson = *fixed
+		repairs = append(repairs, GeometryIssue{Code: "self_intersection", Repaired: true,
+			Message: "polygon was invalid (" + reason + ") and was repaired"})
+	}
+
+	g := Geometry{GeoJSON: geojson, Repairs: repairs}
+	err = q.QueryRow(ctx,
+		`SELECT ST_Area(ST_GeogFromGeoJSON($1)) / 10000`, geojson).Scan(&g.AreaHa)
+	if err != nil {
+		return Geometry{}, err
+	}
+	if g.AreaHa <= 0 {
+		return Geometry{}, invalidGeometry("empty_area", "polygon has no area")
+	}
+	return g, nil
+}
+
+// SitingIssues reports the parts of a zone polygon outside the farm's
+// perimeters (when any are drawn) and its overlaps with other active
+// zones. excludeID skips the zone being edited.
+func SitingIssues(ctx context.Context, q db.DBTX, farmID uuid.UUID, excludeID *uuid.UUID, geojson string) ([]GeometryIssue, error) {
+	issues := []GeometryIssue{}
+
+	var perimeters int
+	var outsideHa float64
+	err := q.QueryRow(ctx, `
+		SELECT COUNT(*)::int,
+		       COALESCE(ST_Area(ST_Difference(
+		           ST_SetSRID(ST_GeomFromGeoJSON($2), 4326),
+		           ST_Union(p.geometry::geometry))::geography) / 10000, 0)
+		FROM perimeters p WHERE p.farm_id = $1`, farmID, geojson,
+	).Scan(&perimeters, &outsideHa)
+	if err != nil {
+		return nil, err
+	}
+	if perimeters > 0 && outsideHa > minOverlapHa {
+		issues = append(issues, GeometryIssue{Code: "outside_perimeter", AreaHa: outsideHa,
+			Message: fmt.Sprintf("%.2f ha lie outside the farm perimeter", outsideHa)})
+	}
+
+	rows, err := q.Query(ctx, `
+		SELECT id, name, overlap_ha FROM (
+			SELECT z.id, z.name,
+			       ST_Area(ST_Intersection(z.geometry::geometry,
+			           ST_SetSRID(ST_GeomFromGeoJSON($2), 4326))::geography) / 10000 AS overlap_ha
+			FROM zones z
+			WHERE z.farm_id = $1 AND z.is_active
+			  AND ($3::uuid IS NULL OR z.id <> $3)
+			  AND ST_Intersects(z.geometry::geometry, ST_SetSRID(ST_GeomFromGeoJSON($2), 4326))
+		) o
+		WHERE overlap_ha > $4
+		ORDER BY overlap_ha DESC`, farmID, geojson, excludeID, minOverlapHa)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var name string
+		var ha float64
+		if err := rows.Scan(&id, &name, &ha); err != nil {
+			return nil, err
+		}
+		issues = append(issues, GeometryIssue{Code: "overlap", ZoneID: &id, AreaHa: ha,
+			Message: fmt.Sprintf("overlaps zone %q by %.2f ha", name, ha)})
+	}
+	return issues, rows.Err()
+}
+
+// writeGeometryError answers 422 with the issues of a rejected geometry,
+// or 500 for any other error.
+func writeGeometryError(w http.ResponseWriter, err error) {
+	var ge *GeometryError
+	if errors.As(err, &ge) {
+		response.JSON(w, http.StatusUnprocessableEntity, response.Response{Error: ge.Error(), Data: ge.Issues})
+		return
+	}
+	response.InternalError(w)
+}
@@ -0,0 +1,151 @@
+package zone
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// square is a 1x1 degree ring near the origin, counter-clockwise.
+const square = `[[0,0],[1,0],[1,1],[0,1],[0,0]]`
+
+func TestNormalizePolygon(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string // coordinates of the normalized polygon
+		repairs []string
+		errCode string
+	}{
+		{
+			name:  "valid polygon",
+			input: `{"type":"Polygon","coordinates":[` + square + `]}`,
+			want:  `[` + square + `]`,
+		},
+		{
+			name:    "unclosed ring",
+			input:   `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`,
+			want:    `[` + square + `]`,
+			repairs: []string{"unclosed_ring"},
+		},
+		{
+			name:    "clockwise exterior",
+			input:   `{"type":"Polygon","coordinates":[[[0,0],[0,1],[1,1],[1,0],[0,0]]]}`,
+			want:    `[` + square + `]`,
+			repairs: []string{"wrong_winding"},
+		},
+		{
+			name: "counter-clockwise hole",
+			input: `{"type":"Polygon","coordinates":[` + square + `,
+				[[0.2,0.2],[0.8,0.2],[0.8,0.8],[0.2,0.8],[0.2,0.2]]]}`,
+			want: `[` + square + `,
+				[[0.2,0.2],[0.2,0.8],[0.8,0.8],[0.8,0.2],[0.2,0.2]]]`,
+			repairs: []string{"wrong_winding"},
+		},
+		{
+			name:    "unclosed and clockwise",
+			input:   `{"type":"Polygon","coordinates":[[[0,0],[0,1],[1,1],[1,0]]]}`,
+			want:    `[` + square + `]`,
+			repairs: []string{"unclosed_ring", "wrong_winding"},
+		},
+		{
+			name:  "altitude dropped",
+			input: `{"type":"Polygon","coordinates":[[[0,0,5],[1,0,5],[1,1,5],[0,1,5],[0,0,5]]]}`,
+			want:  `[` + square + `]`,
+		},
+		{
+			name:  "feature as a string",
+			input: `"{\"type\":\"Feature\",\"geometry\":{\"type\":\"Polygon\",\"coordinates\":[` + square + `]}}"`,
+			want:  `[` + square + `]`,
+		},
+		{
+			name:  "single-feature collection",
+			input: `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Polygon","coordinates":[` + square + `]}}]}`,
+			want:  `[` + square + `]`,
+		},
+		{
+			name:  "multipolygon with one part",
+			input: `{"type":"MultiPolygon","coordinates":[[` + square + `]]}`,
+			want:  `[` + square + `]`,
+		},
+		{
+			name:    "multipolygon with two parts",
+			input:   `{"type":"MultiPolygon","coordinates":[[` + square + `],[` + square + `]]}`,
+			errCode: "not_polygon",
+		},
+		{
+			name:    "point",
+			input:   `{"type":"Point","coordinates":[0,0]}`,
+			errCode: "not_polygon",
+		},
+		{
+			name:    "latitude out of range",
+			input:   `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,91],[0,0]]]}`,
+			errCode: "invalid_coordinates",
+		},
+		{
+			name:    "two points",
+			input:   `{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,0]]]}`,
+			errCode: "too_few_points",
+		},
+		{
+			name:    "collinear",
+			input:   `{"type":"Polygon","coordinates":[[[0,0],[1,1],[2,2],[0,0]]]}`,
+			errCode: "empty_area",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, repairs, err := normalizePolygon(json.RawMessage(tt.input))
+			if tt.errCode != "" {
+				var ge *GeometryError
+				if !errors.As(err, &ge) || ge.Issues[0].Code != tt.errCode {
+					t.Fatalf("err = %v, want %s", err, tt.errCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got struct {
+				Type        string
+				Coordinates [][][]float64
+			}
+			if err := json.Unmarshal([]byte(out), &got); err != nil {
+				t.Fatal(err)
+			}
+			var want [][][]float64
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if got.Type != "Polygon" || !reflect.DeepEqual(got.Coordinates, want) {
+				t.Errorf("got %s, want Polygon %s", out, tt.want)
+			}
+			codes := []string{}
+			for _, r := range repairs {
+				if !r.Repaired {
+					t.Errorf("repair %s not marked repaired", r.Code)
+				}
+				codes = append(codes, r.Code)
+			}
+			if tt.repairs == nil {
+				tt.repairs = []string{}
+			}
+			if !reflect.DeepEqual(codes, tt.repairs) {
+				t.Errorf("repairs = %v, want %v", codes, tt.repairs)
+			}
+		})
+	}
+}
+
+func TestRingArea(t *testing.T) {
+	ccw := [][]float64{{0, 0}, {2, 0}, {2, 1}, {0, 1}, {0, 0}}
+	if got := ringArea(ccw); got != 4 {
+		t.Errorf("ringArea(ccw) = %g, want 4", got)
+	}
+	cw := [][]float64{{0, 0}, {0, 1}, {2, 1}, {2, 0}, {0, 0}}
+	if got := ringArea(cw); got != -4 {
+		t.Errorf("ringArea(cw) = %g, want -4", got)
+	}
+}
@@ -0,0 +1,149 @@
+package zone
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// ZONE GROUP STATS
+// =============================================
+
+// Rest status of a zone.
+const (
+	StatusOccupied    = "occupied"
+	StatusResting     = "resting"
+	StatusNeverGrazed = "never_grazed"
+)
+
+type GroupZone struct {
+	ZoneID      uuid.UUID  `json:"zone_id"`
+	Name        string     `json:"name"`
+	GrassType   *string    `json:"grass_type"`
+	AreaHa      float64    `json:"area_ha"`
+	Animals     int        `json:"animals"`
+	AnimalUnits float64    `json:"animal_units"`
+	UGMHa       float64    `json:"ugm_ha"`
+	UGMHaLimit  *float64   `json:"ugm_ha_limit"`
+	OverLimit   bool       `json:"over_limit"`
+	Status      string     `json:"status"`
+	Usage       UsageStats `json:"usage"`
+}
+
+// GroupStats rolls a zone group (a retiro) up from its active zones. Rest
+// figures cover the resting zones; OccupancyPct is weighted by area.
+type GroupStats struct {
+	ZoneGroup
+	From             string      `json:"from"`
+	To               string      `json:"to"`
+	OccupiedZones    int         `json:"occupied_zones"`
+	RestingZones     int         `json:"resting_zones"`
+	NeverGrazedZones int         `json:"never_grazed_zones"`
+	OccupiedAreaHa   float64     `json:"occupied_area_ha"`
+	RestingAreaHa    float64     `json:"resting_area_ha"`
+	AvgRestDays      *float64    `json:"avg_rest_days"`
+	MaxRestDays      *float64    `json:"max_rest_days"`
+	OccupancyPct     float64     `json:"occupancy_pct"`
+	ZonesOverLimit   int         `json:"zones_over_limit"`
+	Zones            []GroupZone `json:"zones"`
+}
+
+// GroupStats reports a group's area, animal units and stocking rate, with
+// the rest status and usage of each member zone within ?from= and ?to=
+// (default the last year).
+func (h *Handler) GroupStats(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	groupID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid group id")
+		return
+	}
+	from, to, ok := usageWindow(r)
+	if !ok {
+		response.BadRequest(w, "from and to must be dates (YYYY-MM-DD), from before to")
+		return
+	}
+	g, err := loadGroup(r.Context(), h.pool, farmID, groupID)
+	if err != nil {
+		response.NotFound(w, "group not found")
+		return
+	}
+	occ, err := Occupations(r.Context(), h.pool, farmID, nil)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT z.id, z.name, z.grass_type, z.area_ha::float8,
+		       COUNT(au.animal_id)::int, COALESCE(ROUND(SUM(au.ua)::numeric, 2), 0)::float8,
+		       z.ugm_ha_limit::float8
+		FROM zones z
+		LEFT JOIN animal_units au ON au.zone_id = z.id
+		WHERE z.farm_id = $1 AND z.group_id = $2 AND z.is_active
+		GROUP BY z.id
+		ORDER BY z.name`, farmID, groupID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+	st := GroupStats{ZoneGroup: g, From: from.Format("2006-01-02"),
+		To: to.AddDate(0, 0, -1).Format("2006-01-02"), Zones: []GroupZone{}}
+	var restSum, occupancy float64
+	for rows.Next() {
+		var z GroupZone
+		if err := rows.Scan(&z.ZoneID, &z.Name, &z.GrassType, &z.AreaHa,
+			&z.Animals, &z.AnimalUnits, &z.UGMHaLimit); err != nil {
+			response.InternalError(w)
+			return
+		}
+		if z.AreaHa > 0 {
+			z.UGMHa = round(z.AnimalUnits/z.AreaHa, 2)
+		}
+		z.OverLimit = z.UGMHaLimit != nil && z.UGMHa > *z.UGMHaLimit
+		if z.OverLimit {
+			st.ZonesOverLimit++
+		}
+		z.Usage = usageStats(occ[z.ZoneID], from, to)
+		z.Usage.ZoneID, z.Usage.ZoneName = z.ZoneID, z.Name
+		switch {
+		case z.Usage.Occupied:
+			z.Status = StatusOccupied
+			st.OccupiedZones++
+			st.OccupiedAreaHa += z.AreaHa
+		case z.Usage.RestDays != nil:
+			z.Status = StatusResting
+			st.RestingZones++
+			st.RestingAreaHa += z.AreaHa
+			restSum += *z.Usage.RestDays
+			if st.MaxRestDays == nil || *z.Usage.RestDays > *st.MaxRestDays {
+				st.MaxRestDays = z.Usage.RestDays
+			}
+		default:
+			z.Status = StatusNeverGrazed
+			st.NeverGrazedZones++
+		}
+		occupancy += z.Usage.OccupancyPct * z.AreaHa
+		st.Zones = append(st.Zones, z)
+	}
+	if err := rows.Err(); err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	if st.RestingZones > 0 {
+		avg := round(restSum/float64(st.RestingZones), 1)
+		st.AvgRestDays = &avg
+	}
+	if st.AreaHa > 0 {
+		st.OccupancyPct = round(occupancy/st.AreaHa, 1)
+	}
+	st.OccupiedAreaHa, st.RestingAreaHa = round(st.OccupiedAreaHa, 2), round(st.RestingAreaHa, 2)
+	response.Ok(w, st)
+}
@@ -1,14 +1,18 @@
 package zone
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/gabrielrondon/cowpro/internal/db"
 	"github.com/gabrielrondon/cowpro/internal/middleware"
 	"github.com/gabrielrondon/cowpro/pkg/response"
 )
@@ -22,29 +26,57 @@ func NewHandler(pool *pgxpool.Pool) *Handler { return &Handler{pool: pool} }
 // =============================================
 
 type Zone struct {
-	ID         uuid.UUID  `json:"id"`
-	FarmID     uuid.UUID  `json:"farm_id"`
-	GroupID    *uuid.UUID `json:"group_id,omitempty"`
-	Name       string     `json:"name"`
-	AreaHa     float64    `json:"area_ha"`
-	GrassType  *string    `json:"grass_type,omitempty"`
-	UGMHaLimit *float64   `json:"ugm_ha_limit,omitempty"`
-	IsActive   bool       `json:"is_active"`
-	AnimalCount int       `json:"animal_count"`
-	UGMHa      float64    `json:"ugm_ha"`
+	ID          uuid.UUID  `json:"id"`
+	FarmID      uuid.UUID  `json:"farm_id"`
+	GroupID     *uuid.UUID `json:"group_id,omitempty"`
+	Name        string     `json:"name"`
+	AreaHa      float64    `json:"area_ha"`
+	GrassType   *string    `json:"grass_type,omitempty"`
+	UGMHaLimit  *float64   `json:"ugm_ha_limit,omitempty"`
+	IsActive    bool       `json:"is_active"`
+	IsIsolation bool       `json:"is_isolation"`
+	AnimalCount int        `json:"animal_count"`
+	// AnimalUnits is the stocking in UA (450 kg of live weight, see the
+	// animal_units view); UGMHa is UA per hectare.
+	AnimalUnits float64 `json:"animal_units"`
+	UGMHa       float64 `json:"ugm_ha"`
+	// Geometry is the zone polygon as GeoJSON.
+	Geometry json.RawMessage `json:"geometry"`
+	// GeometryIssues are siting problems accepted with force=true.
+	GeometryIssues []GeometryIssue `json:"geometry_issues"`
+	// Repairs lists fixes applied to the submitted polygon.
+	Repairs []GeometryIssue `json:"repairs,omitempty"`
+}
+
+const zoneSelect = `
+	SELECT z.id, z.farm_id, z.group_id, z.name, z.area_ha,
+	       z.grass_type, z.ugm_ha_limit, z.is_active, z.is_isolation,
+	       COUNT(au.animal_id)::int AS animal_count,
+	       COALESCE(ROUND(SUM(au.ua)::numeric, 2), 0)::float8 AS animal_units,
+	       CASE WHEN z.area_ha > 0
+	            THEN ROUND((COALESCE(SUM(au.ua), 0) / z.area_ha)::numeric, 2)::float8
+	            ELSE 0 END AS ugm_ha,
+	       ST_AsGeoJSON(z.geometry)::json, z.geometry_issues
+	FROM zones z
+	LEFT JOIN animal_units au ON au.zone_id = z.id`
+
+func scanZone(row interface{ Scan(...any) error }) (Zone, error) {
+	var z Zone
+	err := row.Scan(&z.ID, &z.FarmID, &z.GroupID, &z.Name, &z.AreaHa,
+		&z.GrassType, &z.UGMHaLimit, &z.IsActive, &z.IsIsolation,
+		&z.AnimalCount, &z.AnimalUnits, &z.UGMHa, &z.Geometry, &z.GeometryIssues)
+	return z, err
+}
+
+func loadZone(ctx context.Context, q db.DBTX, farmID, zoneID uuid.UUID) (Zone, error) {
+	return scanZone(q.QueryRow(ctx, zoneSelect+`
+		WHERE z.id = $1 AND z.farm_id = $2
+		GROUP BY z.id`, zoneID, farmID))
 }
 
 func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
-	rows, err := h.pool.Query(r.Context(), `
-		SELECT z.id, z.farm_id, z.group_id, z.name, z.area_ha,
-		       z.grass_type, z.ugm_ha_limit, z.is_active,
-		       COUNT(a.id)::int AS animal_count,
-		       CASE WHEN z.area_ha > 0
-		            THEN COUNT(a.id)::float / z.area_ha
-		            ELSE 0 END AS ugm_ha
-		FROM zones z
-		LEFT JOIN animals a ON a.zone_id = z.id AND a.status = 'active'
+	rows, err := h.pool.Query(r.Context(), zoneSelect+`
 		WHERE z.farm_id = $1
 		GROUP BY z.id
 		ORDER BY z.name`, farmID)
@@ -55,9 +87,8 @@ func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 	zones := []Zone{}
 	for rows.Next() {
-		var z Zone
-		if err := rows.Scan(&z.ID, &z.FarmID, &z.GroupID, &z.Name, &z.AreaHa,
-			&z.GrassType, &z.UGMHaLimit, &z.IsActive, &z.AnimalCount, &z.UGMHa); err != nil {
+		z, err := scanZone(rows)
+		if err != nil {
 			response.InternalError(w)
 			return
 		}
@@ -73,20 +104,7 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid zone id")
 		return
 	}
-	var z Zone
-	err = h.pool.QueryRow(r.Context(), `
-		SELECT z.id, z.farm_id, z.group_id, z.name, z.area_ha,
-		       z.grass_type, z.ugm_ha_limit, z.is_active,
-		       COUNT(a.id)::int AS animal_count,
-		       CASE WHEN z.area_ha > 0
-		            THEN COUNT(a.id)::float / z.area_ha
-		            ELSE 0 END AS ugm_ha
-		FROM zones z
-		LEFT JOIN animals a ON a.zone_id = z.id AND a.status = 'active'
-		WHERE z.id = $1 AND z.farm_id = $2
-		GROUP BY z.id`, zoneID, farmID,
-	).Scan(&z.ID, &z.FarmID, &z.GroupID, &z.Name, &z.AreaHa,
-		&z.GrassType, &z.UGMHaLimit, &z.IsActive, &z.AnimalCount, &z.UGMHa)
+	z, err := loadZone(r.Context(), h.pool, farmID, zoneID)
 	if err != nil {
 		response.NotFound(w, "zone not found")
 		return
@@ -95,37 +113,75 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 }
 
 type ZoneRequest struct {
-	GroupID    *uuid.UUID `json:"group_id"`
-	Name       string     `json:"name"`
-	GeoJSON    string     `json:"geojson"`
-	AreaHa     float64    `json:"area_ha"`
-	GrassType  *string    `json:"grass_type"`
-	UGMHaLimit *float64   `json:"ugm_ha_limit"`
-	IsActive   bool       `json:"is_active"`
+	GroupID *uuid.UUID `json:"group_id"`
+	Name    string     `json:"name"`
+	// GeoJSON is a Polygon (or a Feature wrapping one), as an object or a
+	// string. The area is computed from it. Optional on update.
+	GeoJSON    json.RawMessage `json:"geojson"`
+	GrassType  *string         `json:"grass_type"`
+	UGMHaLimit *float64        `json:"ugm_ha_limit"`
+	IsActive   bool            `json:"is_active"`
+	// IsIsolation marks a quarantine zone for contagious disease cases.
+	IsIsolation bool `json:"is_isolation"`
+	// Force accepts a zone outside the perimeter or overlapping other zones,
+	// recording the problems in geometry_issues.
+	Force bool `json:"force"`
+}
+
+// checkGeometry validates a zone polygon and its siting. It writes the
+// error response and returns ok=false when the zone must be rejected.
+func (h *Handler) checkGeometry(w http.ResponseWriter, r *http.Request, farmID uuid.UUID,
+	zoneID *uuid.UUID, req ZoneRequest) (Geometry, []GeometryIssue, bool) {
+	geom, err := PrepareGeometry(r.Context(), h.pool, req.GeoJSON)
+	if err != nil {
+		writeGeometryError(w, err)
+		return geom, nil, false
+	}
+	issues, err := SitingIssues(r.Context(), h.pool, farmID, zoneID, geom.GeoJSON)
+	if err != nil {
+		response.InternalError(w)
+		return geom, nil, false
+	}
+	if len(issues) > 0 && !req.Force {
+		writeGeometryError(w, &GeometryError{Issues: issues})
+		return geom, nil, false
+	}
+	return geom, issues, true
 }
 
 func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
 	var req ZoneRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || len(req.GeoJSON) == 0 {
 		response.BadRequest(w, "name and geojson are required")
 		return
 	}
-	var z Zone
-	err := h.pool.QueryRow(r.Context(), `
-		INSERT INTO zones (id, farm_id, group_id, name, geometry, area_ha, grass_type, ugm_ha_limit, is_active)
-		VALUES ($1,$2,$3,$4,ST_GeogFromGeoJSON($5),$6,$7,$8,$9)
-		RETURNING id, farm_id, group_id, name, area_ha, grass_type, ugm_ha_limit, is_active`,
-		uuid.New(), farmID, req.GroupID, req.Name, req.GeoJSON, req.AreaHa,
-		req.GrassType, req.UGMHaLimit, req.IsActive,
-	).Scan(&z.ID, &z.FarmID, &z.GroupID, &z.Name, &z.AreaHa, &z.GrassType, &z.UGMHaLimit, &z.IsActive)
+	geom, issues, ok := h.checkGeometry(w, r, farmID, nil, req)
+	if !ok {
+		return
+	}
+	id := uuid.New()
+	_, err := h.pool.Exec(r.Context(), `
+		INSERT INTO zones (id, farm_id, group_id, name, geometry, area_ha, grass_type, ugm_ha_limit, is_active, is_isolation, geometry_issues)
+		VALUES ($1,$2,$3,$4,ST_GeogFromGeoJSON($5),$6,$7,$8,$9,$10,$11)`,
+		id, farmID, req.GroupID, req.Name, geom.GeoJSON, geom.AreaHa,
+		req.GrassType, req.UGMHaLimit, req.IsActive, req.IsIsolation, issues,
+	)
 	if err != nil {
 		response.InternalError(w)
 		return
 	}
+	z, err := loadZone(r.Context(), h.pool, farmID, id)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	z.Repairs = geom.Repairs
 	response.Created(w, z)
 }
 
+// Update edits a zone. When geojson is sent the polygon is validated like on
+// create and the area recalculated; otherwise the geometry is kept.
 func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
 	zoneID, err := uuid.Parse(chi.URLParam(r, "id"))
@@ -138,18 +194,43 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid body")
 		return
 	}
-	var z Zone
-	err = h.pool.QueryRow(r.Context(), `
+	var geom Geometry
+	var issues []GeometryIssue
+	if len(req.GeoJSON) > 0 && string(req.GeoJSON) != "null" {
+		var ok bool
+		if geom, issues, ok = h.checkGeometry(w, r, farmID, &zoneID, req); !ok {
+			return
+		}
+	}
+	var geojson *string
+	if geom.GeoJSON != "" {
+		geojson = &geom.GeoJSON
+	}
+	tag, err := h.pool.Exec(r.Context(), `
 		UPDATE zones SET name=$1, group_id=$2, grass_type=$3, ugm_ha_limit=$4,
-		       is_active=$5, updated_at=NOW()
-		WHERE id=$6 AND farm_id=$7
-		RETURNING id, farm_id, group_id, name, area_ha, grass_type, ugm_ha_limit, is_active`,
-		req.Name, req.GroupID, req.GrassType, req.UGMHaLimit, req.IsActive, zoneID, farmID,
-	).Scan(&z.ID, &z.FarmID, &z.GroupID, &z.Name, &z.AreaHa, &z.GrassType, &z.UGMHaLimit, &z.IsActive)
+		       is_active=$5, is_isolation=$8,
+		       geometry = COALESCE(ST_GeogFromGeoJSON($9), geometry),
+		       area_ha = CASE WHEN $9::text IS NULL THEN area_ha ELSE $10 END,
+		       geometry_issues = CASE WHEN $9::text IS NULL THEN geometry_issues ELSE $11 END,
+		       updated_at=NOW()
+		WHERE id=$6 AND farm_id=$7`,
+		req.Name, req.GroupID, req.GrassType, req.UGMHaLimit, req.IsActive, zoneID, farmID, req.IsIsolation,
+		geojson, geom.AreaHa, issues,
+	)
 	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if tag.RowsAffected() == 0 {
 		response.NotFound(w, "zone not found")
 		return
 	}
+	z, err := loadZone(r.Context(), h.pool, farmID, zoneID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	z.Repairs = geom.Repairs
 	response.Ok(w, z)
 }
 
@@ -171,19 +252,42 @@ func (h *Handler) AssignAnimals(w http.ResponseWriter, r *http.Request) {
 		response.BadRequest(w, "invalid zone id")
 		return
 	}
-	var req struct{ AnimalIDs []uuid.UUID `json:"animal_ids"` }
+	var req struct {
+		AnimalIDs []uuid.UUID `json:"animal_ids"`
+		// Force moves the animals even if the zone's stocking limit is
+		// exceeded under the reject overstock policy.
+		Force bool `json:"force"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.AnimalIDs) == 0 {
 		response.BadRequest(w, "animal_ids required")
 		return
 	}
-	tag, err := h.pool.Exec(r.Context(),
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	tag, err := tx.Exec(r.Context(),
 		`UPDATE animals SET zone_id=$1, updated_at=NOW() WHERE id=ANY($2) AND farm_id=$3`,
 		zoneID, req.AnimalIDs, farmID)
 	if err != nil {
 		response.InternalError(w)
 		return
 	}
-	response.Ok(w, map[string]any{"moved": tag.RowsAffected()})
+	over, ok := GuardCapacity(w, r, tx, farmID, []uuid.UUID{zoneID}, req.Force)
+	if !ok {
+		return
+	}
+	if err := SyncUsage(r.Context(), tx, farmID); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, map[string]any{"moved": tag.RowsAffected(), "overstocked": over})
 }
 
 func (h *Handler) MoveAnimals(w http.ResponseWriter, r *http.Request) {
@@ -196,19 +300,26 @@ func (h *Handler) MoveAnimals(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		ToZoneID  uuid.UUID   `json:"to_zone_id"`
 		AnimalIDs []uuid.UUID `json:"animal_ids"`
+		Force     bool        `json:"force"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		response.BadRequest(w, "invalid body")
 		return
 	}
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
 	var tag interface{ RowsAffected() int64 }
 	if len(req.AnimalIDs) > 0 {
-		tag, err = h.pool.Exec(r.Context(),
+		tag, err = tx.Exec(r.Context(),
 			`UPDATE animals SET zone_id=$1, updated_at=NOW()
 			 WHERE id=ANY($2) AND zone_id=$3 AND farm_id=$4`,
 			req.ToZoneID, req.AnimalIDs, fromZoneID, farmID)
 	} else {
-		tag, err = h.pool.Exec(r.Context(),
+		tag, err = tx.Exec(r.Context(),
 			`UPDATE animals SET zone_id=$1, updated_at=NOW()
 			 WHERE zone_id=$2 AND farm_id=$3`,
 			req.ToZoneID, fromZoneID, farmID)
@@ -217,7 +328,19 @@ func (h *Handler) MoveAnimals(w http.ResponseWriter, r *http.Request) {
 		response.InternalError(w)
 		return
 	}
-	response.Ok(w, map[string]any{"moved": tag.RowsAffected()})
+	over, ok := GuardCapacity(w, r, tx, farmID, []uuid.UUID{req.ToZoneID}, req.Force)
+	if !ok {
+		return
+	}
+	if err := SyncUsage(r.Context(), tx, farmID); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, map[string]any{"moved": tag.RowsAffected(), "overstocked": over})
 }
 
 // =============================================
@@ -230,23 +353,46 @@ type ZoneGroup struct {
 	Name        string    `json:"name"`
 	AnimalCount int       `json:"animal_count"`
 	ZoneCount   int       `json:"zone_count"`
+	AreaHa      float64   `json:"area_ha"`
+	AnimalUnits float64   `json:"animal_units"`
+	UGMHa       float64   `json:"ugm_ha"`
+}
+
+// groupSelect reads a farm's zone groups (one group when $2 is set) with
+// the animals, area and stocking of their active zones.
+const groupSelect = `
+	SELECT g.id, g.farm_id, g.name,
+	       COALESCE(SUM(ac.cnt),0)::int AS animal_count,
+	       COUNT(z.id)::int AS zone_count,
+	       COALESCE(SUM(z.area_ha),0)::float8 AS area_ha,
+	       ROUND(COALESCE(SUM(ac.ua),0)::numeric, 2)::float8 AS animal_units,
+	       CASE WHEN SUM(z.area_ha) > 0
+	            THEN ROUND((COALESCE(SUM(ac.ua),0) / SUM(z.area_ha))::numeric, 2)::float8
+	            ELSE 0 END AS ugm_ha
+	FROM zone_groups g
+	LEFT JOIN zones z ON z.group_id = g.id AND z.is_active
+	LEFT JOIN (
+		SELECT zone_id, COUNT(*) AS cnt, SUM(ua) AS ua FROM animal_units
+		GROUP BY zone_id
+	) ac ON ac.zone_id = z.id
+	WHERE g.farm_id = $1 AND ($2::uuid IS NULL OR g.id = $2)
+	GROUP BY g.id
+	ORDER BY g.name`
+
+func scanGroup(row interface{ Scan(...any) error }) (ZoneGroup, error) {
+	var g ZoneGroup
+	err := row.Scan(&g.ID, &g.FarmID, &g.Name, &g.AnimalCount, &g.ZoneCount,
+		&g.AreaHa, &g.AnimalUnits, &g.UGMHa)
+	return g, err
+}
+
+func loadGroup(ctx context.Context, q db.DBTX, farmID, groupID uuid.UUID) (ZoneGroup, error) {
+	return scanGroup(q.QueryRow(ctx, groupSelect, farmID, groupID))
 }
 
 func (h *Handler) ListGroups(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
-	rows, err := h.pool.Query(r.Context(), `
-		SELECT g.id, g.farm_id, g.name,
-		       COALESCE(SUM(ac.cnt),0)::int AS animal_count,
-		       COUNT(z.id)::int AS zone_count
-		FROM zone_groups g
-		LEFT JOIN zones z ON z.group_id = g.id
-		LEFT JOIN (
-			SELECT zone_id, COUNT(*) AS cnt FROM animals
-			WHERE status='active' GROUP BY zone_id
-		) ac ON ac.zone_id = z.id
-		WHERE g.farm_id = $1
-		GROUP BY g.id
-		ORDER BY g.name`, farmID)
+	rows, err := h.pool.Query(r.Context(), groupSelect, farmID, nil)
 	if err != nil {
 		response.InternalError(w)
 		return
@@ -254,8 +400,8 @@ func (h *Handler) ListGroups(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 	groups := []ZoneGroup{}
 	for rows.Next() {
-		var g ZoneGroup
-		if err := rows.Scan(&g.ID, &g.FarmID, &g.Name, &g.AnimalCount, &g.ZoneCount); err != nil {
+		g, err := scanGroup(rows)
+		if err != nil {
 			response.InternalError(w)
 			return
 		}
@@ -266,7 +412,9 @@ func (h *Handler) ListGroups(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) CreateGroup(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
-	var req struct{ Name string `json:"name"` }
+	var req struct {
+		Name string `json:"name"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
 		response.BadRequest(w, "name is required")
 		return
@@ -283,28 +431,158 @@ func (h *Handler) CreateGroup(w http.ResponseWriter, r *http.Request) {
 	response.Created(w, g)
 }
 
+func (h *Handler) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	groupID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid group id")
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		response.BadRequest(w, "name is required")
+		return
+	}
+	tag, err := h.pool.Exec(r.Context(),
+		`UPDATE zone_groups SET name=$3 WHERE id=$1 AND farm_id=$2`, groupID, farmID, req.Name)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.NotFound(w, "group not found")
+		return
+	}
+	g, err := loadGroup(r.Context(), h.pool, farmID, groupID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, g)
+}
+
+// DeleteGroup removes a group. Its zones are kept, without a group.
+func (h *Handler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	groupID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid group id")
+		return
+	}
+	tag, err := h.pool.Exec(r.Context(), `DELETE FROM zone_groups WHERE id=$1 AND farm_id=$2`, groupID, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.NotFound(w, "group not found")
+		return
+	}
+	response.NoContent(w)
+}
+
+// AssignGroup moves zones into a group, whatever group they were in, or out
+// of any group when group_id is null.
+func (h *Handler) AssignGroup(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	var req struct {
+		GroupID *uuid.UUID  `json:"group_id"`
+		ZoneIDs []uuid.UUID `json:"zone_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.ZoneIDs) == 0 {
+		response.BadRequest(w, "zone_ids required")
+		return
+	}
+	if req.GroupID != nil {
+		var exists bool
+		err := h.pool.QueryRow(r.Context(),
+			`SELECT EXISTS (SELECT 1 FROM zone_groups WHERE id=$1 AND farm_id=$2)`,
+			*req.GroupID, farmID).Scan(&exists)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		if !exists {
+			response.NotFound(w, "group not found")
+			return
+		}
+	}
+	var missing int
+	err := h.pool.QueryRow(r.Context(), `
+		SELECT COUNT(*)::int FROM unnest($2::uuid[]) AS ids(id)
+		WHERE NOT EXISTS (SELECT 1 FROM zones z WHERE z.id = ids.id AND z.farm_id = $1)`,
+		farmID, req.ZoneIDs).Scan(&missing)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if missing > 0 {
+		response.NotFound(w, "zone not found")
+		return
+	}
+	tag, err := h.pool.Exec(r.Context(), `
+		UPDATE zones SET group_id=$3, updated_at=NOW()
+		WHERE farm_id=$1 AND id = ANY($2) AND group_id IS DISTINCT FROM $3`,
+		farmID, req.ZoneIDs, req.GroupID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, map[string]any{"group_id": req.GroupID, "moved": tag.RowsAffected()})
+}
+
 // =============================================
 // KEY POINTS
 // =============================================
 
+// KeyPointCategories are the kinds of key point. Water points (water and
+// trough) are used for visit analytics and no_water alerts.
+var KeyPointCategories = []string{"water", "trough", "salt", "feeder", "shade", "gate", "corral", "barn", "vet", "other"}
+
+// waterCategories are the categories counted as water points.
+var waterCategories = []string{"water", "trough"}
+
 type KeyPoint struct {
 	ID       uuid.UUID `json:"id"`
 	FarmID   uuid.UUID `json:"farm_id"`
 	Name     string    `json:"name"`
 	Icon     string    `json:"icon"`
-	Lat      float64   `json:"lat"`
-	Lng      float64   `json:"lng"`
-	IsActive bool      `json:"is_active"`
+	Category string    `json:"category"`
+	// RadiusM is how close an animal must come to count as visiting.
+	RadiusM  float64 `json:"radius_m"`
+	Lat      float64 `json:"lat"`
+	Lng      float64 `json:"lng"`
+	IsActive bool    `json:"is_active"`
+	Notes    *string `json:"notes,omitempty"`
+	// Geometry is the location as a GeoJSON Point.
+	Geometry json.RawMessage `json:"geometry"`
 }
 
+const keyPointColumns = `id, farm_id, name, icon, category, radius_m::float8,
+	       ST_Y(location::geometry), ST_X(location::geometry),
+	       is_active, notes, ST_AsGeoJSON(location)::json`
+
+func scanKeyPoint(row interface{ Scan(...any) error }) (KeyPoint, error) {
+	var kp KeyPoint
+	err := row.Scan(&kp.ID, &kp.FarmID, &kp.Name, &kp.Icon, &kp.Category, &kp.RadiusM,
+		&kp.Lat, &kp.Lng, &kp.IsActive, &kp.Notes, &kp.Geometry)
+	return kp, err
+}
+
+// ListKeyPoints returns the farm's key points, optionally filtered by
+// ?category=.
 func (h *Handler) ListKeyPoints(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
+	var category *string
+	if c := r.URL.Query().Get("category"); c != "" {
+		category = &c
+	}
 	rows, err := h.pool.Query(r.Context(), `
-		SELECT id, farm_id, name, icon,
-		       ST_Y(location::geometry) AS lat,
-		       ST_X(location::geometry) AS lng,
-		       is_active
-		FROM key_points WHERE farm_id=$1 ORDER BY name`, farmID)
+		SELECT `+keyPointColumns+`
+		FROM key_points WHERE farm_id=$1 AND ($2::text IS NULL OR category=$2)
+		ORDER BY name`, farmID, category)
 	if err != nil {
 		response.InternalError(w)
 		return
@@ -312,8 +590,8 @@ func (h *Handler) ListKeyPoints(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 	kps := []KeyPoint{}
 	for rows.Next() {
-		var kp KeyPoint
-		if err := rows.Scan(&kp.ID, &kp.FarmID, &kp.Name, &kp.Icon, &kp.Lat, &kp.Lng, &kp.IsActive); err != nil {
+		kp, err := scanKeyPoint(rows)
+		if err != nil {
 			response.InternalError(w)
 			return
 		}
@@ -322,30 +600,69 @@ func (h *Handler) ListKeyPoints(w http.ResponseWriter, r *http.Request) {
 	response.Ok(w, kps)
 }
 
+type KeyPointRequest struct {
+	Name     string   `json:"name"`
+	Icon     string   `json:"icon"`
+	Category string   `json:"category"`
+	RadiusM  *float64 `json:"radius_m"`
+	Lat      float64  `json:"lat"`
+	Lng      float64  `json:"lng"`
+	IsActive *bool    `json:"is_active"`
+	Notes    *string  `json:"notes"`
+}
+
+// validate fills defaults: a category from the icon when it names one
+// (other otherwise), the icon from the category, a 30 m radius and active.
+func (req *KeyPointRequest) validate() string {
+	if strings.TrimSpace(req.Name) == "" {
+		return "name, lat, lng required"
+	}
+	if req.Lat < -90 || req.Lat > 90 || req.Lng < -180 || req.Lng > 180 || (req.Lat == 0 && req.Lng == 0) {
+		return "lat and lng must be a valid position"
+	}
+	if req.Category == "" {
+		req.Category = "other"
+		if slices.Contains(KeyPointCategories, req.Icon) {
+			req.Category = req.Icon
+		}
+	}
+	if !slices.Contains(KeyPointCategories, req.Category) {
+		return "category must be one of " + strings.Join(KeyPointCategories, ", ")
+	}
+	if req.Icon == "" {
+		req.Icon = req.Category
+	}
+	if req.RadiusM == nil {
+		r := 30.0
+		req.RadiusM = &r
+	} else if *req.RadiusM <= 0 || *req.RadiusM > 5000 {
+		return "radius_m must be between 0 and 5000"
+	}
+	if req.IsActive == nil {
+		active := true
+		req.IsActive = &active
+	}
+	return ""
+}
+
 func (h *Handler) CreateKeyPoint(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
-	var req struct {
-		Name string  `json:"name"`
-		Icon string  `json:"icon"`
-		Lat  float64 `json:"lat"`
-		Lng  float64 `json:"lng"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+	var req KeyPointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		response.BadRequest(w, "name, lat, lng required")
 		return
 	}
-	if req.Icon == "" {
-		req.Icon = "water"
+	if msg := req.validate(); msg != "" {
+		response.BadRequest(w, msg)
+		return
 	}
 	point := fmt.Sprintf("POINT(%f %f)", req.Lng, req.Lat)
-	var kp KeyPoint
-	err := h.pool.QueryRow(r.Context(), `
-		INSERT INTO key_points (id, farm_id, name, icon, location)
-		VALUES ($1,$2,$3,$4,ST_GeogFromText($5))
-		RETURNING id, farm_id, name, icon,
-		  ST_Y(location::geometry), ST_X(location::geometry), is_active`,
-		uuid.New(), farmID, req.Name, req.Icon, point,
-	).Scan(&kp.ID, &kp.FarmID, &kp.Name, &kp.Icon, &kp.Lat, &kp.Lng, &kp.IsActive)
+	kp, err := scanKeyPoint(h.pool.QueryRow(r.Context(), `
+		INSERT INTO key_points (id, farm_id, name, icon, category, radius_m, location, is_active, notes)
+		VALUES ($1,$2,$3,$4,$5,$6,ST_GeogFromText($7),$8,$9)
+		RETURNING `+keyPointColumns,
+		uuid.New(), farmID, req.Name, req.Icon, req.Category, *req.RadiusM, point, *req.IsActive, req.Notes,
+	))
 	if err != nil {
 		response.InternalError(w)
 		return
@@ -353,6 +670,59 @@ func (h *Handler) CreateKeyPoint(w http.ResponseWriter, r *http.Request) {
 	response.Created(w, kp)
 }
 
+// UpdateKeyPoint replaces a key point's name, category, icon, radius,
+// position, active flag and notes.
+func (h *Handler) UpdateKeyPoint(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid key point id")
+		return
+	}
+	var req KeyPointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		response.BadRequest(w, msg)
+		return
+	}
+	point := fmt.Sprintf("POINT(%f %f)", req.Lng, req.Lat)
+	kp, err := scanKeyPoint(h.pool.QueryRow(r.Context(), `
+		UPDATE key_points SET name=$3, icon=$4, category=$5, radius_m=$6,
+		       location=ST_GeogFromText($7), is_active=$8, notes=$9, updated_at=NOW()
+		WHERE id=$1 AND farm_id=$2
+		RETURNING `+keyPointColumns,
+		id, farmID, req.Name, req.Icon, req.Category, *req.RadiusM, point, *req.IsActive, req.Notes,
+	))
+	if err != nil {
+		response.NotFound(w, "key point not found")
+		return
+	}
+	response.Ok(w, kp)
+}
+
+func (h *Handler) DeleteKeyPoint(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid key point id")
+		return
+	}
+	tag, err := h.pool.Exec(r.Context(),
+		`DELETE FROM key_points WHERE id=$1 AND farm_id=$2`, id, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.NotFound(w, "key point not found")
+		return
+	}
+	response.NoContent(w)
+}
+
 // =============================================
 // PERIMETERS
 // =============================================
@@ -362,12 +732,16 @@ type Perimeter struct {
 	FarmID uuid.UUID `json:"farm_id"`
 	Name   string    `json:"name"`
 	AreaHa float64   `json:"area_ha"`
+	// Geometry is the perimeter polygon as GeoJSON.
+	Geometry json.RawMessage `json:"geometry"`
+	Repairs  []GeometryIssue `json:"repairs,omitempty"`
 }
 
 func (h *Handler) ListPerimeters(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
 	rows, err := h.pool.Query(r.Context(),
-		`SELECT id, farm_id, name, area_ha FROM perimeters WHERE farm_id=$1 ORDER BY name`, farmID)
+		`SELECT id, farm_id, name, area_ha, ST_AsGeoJSON(geometry)::json
+		 FROM perimeters WHERE farm_id=$1 ORDER BY name`, farmID)
 	if err != nil {
 		response.InternalError(w)
 		return
@@ -376,7 +750,7 @@ func (h *Handler) ListPerimeters(w http.ResponseWriter, r *http.Request) {
 	ps := []Perimeter{}
 	for rows.Next() {
 		var p Perimeter
-		if err := rows.Scan(&p.ID, &p.FarmID, &p.Name, &p.AreaHa); err != nil {
+		if err := rows.Scan(&p.ID, &p.FarmID, &p.Name, &p.AreaHa, &p.Geometry); err != nil {
 			response.InternalError(w)
 			return
 		}
@@ -388,24 +762,96 @@ func (h *Handler) ListPerimeters(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) CreatePerimeter(w http.ResponseWriter, r *http.Request) {
 	farmID := middleware.FarmIDFromCtx(r.Context())
 	var req struct {
-		Name    string  `json:"name"`
-		GeoJSON string  `json:"geojson"`
-		AreaHa  float64 `json:"area_ha"`
+		Name    string          `json:"name"`
+		GeoJSON json.RawMessage `json:"geojson"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
 		response.BadRequest(w, "name and geojson required")
 		return
 	}
+	geom, err := PrepareGeometry(r.Context(), h.pool, req.GeoJSON)
+	if err != nil {
+		writeGeometryError(w, err)
+		return
+	}
 	var p Perimeter
-	err := h.pool.QueryRow(r.Context(), `
+	err = h.pool.QueryRow(r.Context(), `
 		INSERT INTO perimeters (id, farm_id, name, geometry, area_ha)
 		VALUES ($1,$2,$3,ST_GeogFromGeoJSON($4),$5)
-		RETURNING id, farm_id, name, area_ha`,
-		uuid.New(), farmID, req.Name, req.GeoJSON, req.AreaHa,
-	).Scan(&p.ID, &p.FarmID, &p.Name, &p.AreaHa)
+		RETURNING id, farm_id, name, area_ha, ST_AsGeoJSON(geometry)::json`,
+		uuid.New(), farmID, req.Name, geom.GeoJSON, geom.AreaHa,
+	).Scan(&p.ID, &p.FarmID, &p.Name, &p.AreaHa, &p.Geometry)
 	if err != nil {
 		response.InternalError(w)
 		return
 	}
+	p.Repairs = geom.Repairs
 	response.Created(w, p)
 }
+
+// UpdatePerimeter renames a perimeter and, when geojson is given, replaces
+// its boundary.
+func (h *Handler) UpdatePerimeter(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	perimeterID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid perimeter id")
+		return
+	}
+	var req struct {
+		Name    string          `json:"name"`
+		GeoJSON json.RawMessage `json:"geojson"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		response.BadRequest(w, "name required")
+		return
+	}
+	var geom Geometry
+	if len(req.GeoJSON) > 0 && string(req.GeoJSON) != "null" {
+		if geom, err = PrepareGeometry(r.Context(), h.pool, req.GeoJSON); err != nil {
+			writeGeometryError(w, err)
+			return
+		}
+	}
+	var geojson *string
+	if geom.GeoJSON != "" {
+		geojson = &geom.GeoJSON
+	}
+	var p Perimeter
+	err = h.pool.QueryRow(r.Context(), `
+		UPDATE perimeters SET name=$3,
+		       geometry = COALESCE(ST_GeogFromGeoJSON($4), geometry),
+		       area_ha = CASE WHEN $4::text IS NULL THEN area_ha ELSE $5 END,
+		       updated_at=NOW()
+		WHERE id=$1 AND farm_id=$2
+		RETURNING id, farm_id, name, area_ha, ST_AsGeoJSON(geometry)::json`,
+		perimeterID, farmID, req.Name, geojson, geom.AreaHa,
+	).Scan(&p.ID, &p.FarmID, &p.Name, &p.AreaHa, &p.Geometry)
+	if err != nil {
+		response.NotFound(w, "perimeter not found")
+		return
+	}
+	p.Repairs = geom.Repairs
+	response.Ok(w, p)
+}
+
+// DeletePerimeter removes a perimeter. A farm without perimeters has no
+// outer geofence, so breach detection stops.
+func (h *Handler) DeletePerimeter(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	perimeterID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid perimeter id")
+		return
+	}
+	tag, err := h.pool.Exec(r.Context(), `DELETE FROM perimeters WHERE id=$1 AND farm_id=$2`, perimeterID, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		response.NotFound(w, "perimeter not found")
+		return
+	}
+	response.NoContent(w)
+}
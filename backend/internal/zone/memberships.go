@@ -0,0 +1,42 @@
+package zone
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+)
+
+// =============================================
+// HERD MEMBERSHIPS
+// =============================================
+
+// SyncHerdMemberships reconciles herd_memberships with the current herd_id
+// and status of the given animals: stale open periods are closed and animals
+// that joined a herd get a new open period. Like SyncUsage, call it in the
+// same transaction as any change to animals.herd_id or animals.status.
+func SyncHerdMemberships(ctx context.Context, q db.DBTX, farmID uuid.UUID, animalIDs []uuid.UUID) error {
+	_, err := q.Exec(ctx, `
+		UPDATE herd_memberships m SET left_at = NOW()
+		FROM animals a
+		WHERE m.animal_id = a.id AND m.left_at IS NULL
+		  AND a.id = ANY($1) AND a.farm_id = $2
+		  AND (a.herd_id IS DISTINCT FROM m.herd_id OR a.status <> 'active')`,
+		animalIDs, farmID)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, `
+		INSERT INTO herd_memberships (farm_id, herd_id, animal_id, joined_at)
+		SELECT a.farm_id, a.herd_id, a.id, NOW()
+		FROM animals a
+		WHERE a.id = ANY($1) AND a.farm_id = $2
+		  AND a.herd_id IS NOT NULL AND a.status = 'active'
+		  AND NOT EXISTS (
+		      SELECT 1 FROM herd_memberships m
+		      WHERE m.animal_id = a.id AND m.left_at IS NULL
+		  )`,
+		animalIDs, farmID)
+	return err
+}
@@ -0,0 +1,503 @@
+package zone
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// SPLIT AND MERGE
+// =============================================
+
+// mergeToleranceDeg closes gaps and slivers (about 1 m) between zones drawn
+// separately against the same fence, so neighbours merge into one polygon.
+const mergeToleranceDeg = 0.00001
+
+// RetiredZone is a zone replaced by a split or merge. It is kept inactive
+// with its history.
+type RetiredZone struct {
+	ID     uuid.UUID `json:"id"`
+	Name   string    `json:"name"`
+	AreaHa float64   `json:"area_ha"`
+}
+
+// ReshapeResult reports a split or merge: the retired zones, the zones that
+// replaced them with their areas and animals, and what was carried over.
+type ReshapeResult struct {
+	Operation          string        `json:"operation"`
+	Retired            []RetiredZone `json:"retired"`
+	Zones              []Zone        `json:"zones"`
+	AreaBeforeHa       float64       `json:"area_before_ha"`
+	AreaAfterHa        float64       `json:"area_after_ha"`
+	AnimalsMoved       int64         `json:"animals_moved"`
+	HerdsMoved         int64         `json:"herds_moved"`
+	UsagePeriodsCopied int64         `json:"usage_periods_copied"`
+	// Overstocked lists new zones left over their stocking limit.
+	Overstocked []Overstock `json:"overstocked"`
+}
+
+// parentZone is a zone about to be retired, locked for the operation.
+type parentZone struct {
+	RetiredZone
+	groupID     *uuid.UUID
+	grassType   *string
+	limit       *float64
+	isIsolation bool
+}
+
+func lockParent(ctx context.Context, q db.DBTX, farmID, zoneID uuid.UUID) (parentZone, error) {
+	p := parentZone{RetiredZone: RetiredZone{ID: zoneID}}
+	err := q.QueryRow(ctx, `
+		SELECT name, area_ha::float8, group_id, grass_type, ugm_ha_limit::float8, is_isolation
+		FROM zones WHERE id=$1 AND farm_id=$2 AND is_active
+		FOR UPDATE`, zoneID, farmID,
+	).Scan(&p.Name, &p.AreaHa, &p.groupID, &p.grassType, &p.limit, &p.isIsolation)
+	return p, err
+}
+
+// cutLine extracts the LineString of a split request, accepting a Feature
+// wrapping one or a JSON string.
+func cutLine(raw json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		raw = json.RawMessage(text)
+	}
+	var obj geoJSONObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return "", invalidGeometry("not_line", "line is not valid GeoJSON")
+	}
+	if obj.Type == "Feature" && obj.Geometry != nil {
+		obj = *obj.Geometry
+	}
+	if obj.Type != "LineString" {
+		return "", invalidGeometry("not_line", "line must be a LineString, got %q", obj.Type)
+	}
+	var coords [][]float64
+	if err := json.Unmarshal(obj.Coordinates, &coords); err != nil || len(coords) < 2 {
+		return "", invalidGeometry("not_line", "line needs at least 2 points")
+	}
+	for _, c := range coords {
+		if len(c) < 2 || c[0] < -180 || c[0] > 180 || c[1] < -90 || c[1] > 90 {
+			return "", invalidGeometry("invalid_coordinates", "line has coordinates outside longitude/latitude range")
+		}
+	}
+	out, _ := json.Marshal(map[string]any{"type": "LineString", "coordinates": coords})
+	return string(out), nil
+}
+
+// insertChild creates a zone replacing retired ones, with the attributes
+// of the given parent.
+func insertChild(ctx context.Context, q db.DBTX, farmID uuid.UUID, name string, geom Geometry, from parentZone) (uuid.UUID, error) {
+	id := uuid.New()
+	_, err := q.Exec(ctx, `
+		INSERT INTO zones (id, farm_id, group_id, name, geometry, area_ha, grass_type, ugm_ha_limit, is_active, is_isolation)
+		VALUES ($1,$2,$3,$4,ST_GeogFromGeoJSON($5),$6,$7,$8,TRUE,$9)`,
+		id, farmID, from.groupID, name, geom.GeoJSON, geom.AreaHa, from.grassType, from.limit, from.isIsolation)
+	return id, err
+}
+
+// replaceZones retires parents in favour of children, the first child being
+// the main one. Each animal goes to the child nearest its last GPS position
+// (the main child when it has none), herds follow most of their animals,
+// quarantine references move to the main child, the parents' closed usage
+// periods are copied to every child and the lineage is recorded. Copied
+// head counts are scaled by each child's share of the new area, so a split
+// does not multiply the parent's animal-days.
+func replaceZones(ctx context.Context, q db.DBTX, farmID, userID uuid.UUID, op string,
+	parents []parentZone, children []uuid.UUID, res *ReshapeResult) error {
+	parentIDs := make([]uuid.UUID, len(parents))
+	for i, p := range parents {
+		parentIDs[i] = p.ID
+		res.Retired = append(res.Retired, p.RetiredZone)
+		res.AreaBeforeHa += p.AreaHa
+	}
+	main := children[0]
+
+	if _, err := q.Exec(ctx,
+		`UPDATE zones SET is_active=FALSE, updated_at=NOW() WHERE id=ANY($1) AND farm_id=$2`,
+		parentIDs, farmID); err != nil {
+		return err
+	}
+	tag, err := q.Exec(ctx, `
+		UPDATE animals a SET zone_id = COALESCE((
+		    SELECT c.id FROM zones c
+		    WHERE c.id = ANY($3) AND a.last_location IS NOT NULL
+		    ORDER BY ST_Distance(c.geometry, a.last_location), c.area_ha DESC
+		    LIMIT 1), $4), updated_at = NOW()
+		WHERE a.zone_id = ANY($1) AND a.farm_id = $2`,
+		parentIDs, farmID, children, main)
+	if err != nil {
+		return err
+	}
+	res.AnimalsMoved = tag.RowsAffected()
+
+	rows, err := q.Query(ctx, `
+		UPDATE herds h SET zone_id = COALESCE((
+		    SELECT a.zone_id FROM animals a
+		    WHERE a.herd_id = h.id AND a.status = 'active' AND a.zone_id = ANY($3)
+		    GROUP BY a.zone_id ORDER BY COUNT(*) DESC LIMIT 1), $4), updated_at = NOW()
+		WHERE h.zone_id = ANY($1) AND h.farm_id = $2
+		RETURNING h.id`, parentIDs, farmID, children, main)
+	if err != nil {
+		return err
+	}
+	herds := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		herds = append(herds, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	res.HerdsMoved = int64(len(herds))
+	if len(herds) > 0 {
+		note := "Piquete dividido"
+		if op == "merge" {
+			note = "Piquetes unidos"
+		}
+		if _, err := q.Exec(ctx, `
+			UPDATE herd_locations SET ended_at = NOW()
+			WHERE herd_id = ANY($1) AND ended_at IS NULL`, herds); err != nil {
+			return err
+		}
+		if _, err := q.Exec(ctx, `
+			INSERT INTO herd_locations (farm_id, herd_id, zone_id, started_at, animal_count, notes)
+			SELECT h.farm_id, h.id, h.zone_id, NOW(),
+			       (SELECT COUNT(*) FROM animals a WHERE a.herd_id = h.id AND a.status = 'active'),
+			       $2
+			FROM herds h WHERE h.id = ANY($1)`, herds, note); err != nil {
+			return err
+		}
+	}
+
+	if _, err := q.Exec(ctx, `
+		UPDATE disease_cases SET isolation_zone_id=$2, updated_at=NOW()
+		WHERE isolation_zone_id = ANY($1)`, parentIDs, main); err != nil {
+		return err
+	}
+	if _, err := q.Exec(ctx, `
+		UPDATE disease_case_animals SET previous_zone_id=$2
+		WHERE previous_zone_id = ANY($1)`, parentIDs, main); err != nil {
+		return err
+	}
+
+	if err := SyncUsage(ctx, q, farmID); err != nil {
+		return err
+	}
+	tag, err = q.Exec(ctx, `
+		INSERT INTO zone_usages (zone_id, started_at, ended_at, animal_count, animal_units, ugm_ha, notes, inherited_from)
+		SELECT c.id, u.started_at, u.ended_at,
+		       CASE WHEN u.animal_count > 0
+		            THEN GREATEST(1, ROUND(u.animal_count * c.share))::int ELSE 0 END,
+		       ROUND(u.animal_units * c.share, 2), u.ugm_ha, u.notes, u.zone_id
+		FROM zone_usages u
+		CROSS JOIN (
+		    SELECT id, COALESCE(area_ha / NULLIF(SUM(area_ha) OVER (), 0), 1)::numeric AS share
+		    FROM zones WHERE id = ANY($2)
+		) c
+		WHERE u.zone_id = ANY($1) AND u.ended_at IS NOT NULL`, parentIDs, children)
+	if err != nil {
+		return err
+	}
+	res.UsagePeriodsCopied = tag.RowsAffected()
+
+	if _, err := q.Exec(ctx, `
+		INSERT INTO zone_lineage (farm_id, operation, parent_zone_id, child_zone_id, created_by)
+		SELECT $1, $2, p, c, $5
+		FROM unnest($3::uuid[]) AS p, unnest($4::uuid[]) AS c`,
+		farmID, op, parentIDs, children, userID); err != nil {
+		return err
+	}
+
+	for _, id := range children {
+		z, err := loadZone(ctx, q, farmID, id)
+		if err != nil {
+			return err
+		}
+		res.AreaAfterHa += z.AreaHa
+		res.Zones = append(res.Zones, z)
+	}
+	res.AreaBeforeHa, res.AreaAfterHa = round(res.AreaBeforeHa, 2), round(res.AreaAfterHa, 2)
+	return nil
+}
+
+type SplitRequest struct {
+	// Line is a GeoJSON LineString, usually the new fence, crossing the
+	// zone from edge to edge.
+	Line json.RawMessage `json:"line"`
+	// Names of the new zones, largest first. Missing names default to the
+	// zone's name with a number.
+	Names []string `json:"names"`
+	// Force splits even if a part's stocking limit is exceeded under the
+	// reject overstock policy.
+	Force bool `json:"force"`
+}
+
+// Split cuts a zone by a line into new zones that keep its attributes and
+// usage history. The zone is retired.
+func (h *Handler) Split(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	userID := middleware.UserIDFromCtx(r.Context())
+	zoneID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid zone id")
+		return
+	}
+	var req SplitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Line) == 0 {
+		response.BadRequest(w, "line is required")
+		return
+	}
+	line, err := cutLine(req.Line)
+	if err != nil {
+		writeGeometryError(w, err)
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	parent, err := lockParent(r.Context(), tx, farmID, zoneID)
+	if err != nil {
+		response.NotFound(w, "zone not found")
+		return
+	}
+
+	rows, err := tx.Query(r.Context(), `
+		SELECT ST_AsGeoJSON(ST_ForcePolygonCCW(d.geom))
+		FROM zones z,
+		     LATERAL ST_Dump(ST_CollectionExtract(
+		         ST_Split(z.geometry::geometry, ST_SetSRID(ST_GeomFromGeoJSON($2), 4326)), 3)) d
+		WHERE z.id = $1
+		ORDER BY ST_Area(d.geom::geography) DESC`, zoneID, line)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	var parts []string
+	for rows.Next() {
+		var part string
+		if err := rows.Scan(&part); err != nil {
+			rows.Close()
+			response.InternalError(w)
+			return
+		}
+		parts = append(parts, part)
+	}
+	rows.Close()
+	if len(parts) < 2 {
+		writeGeometryError(w, invalidGeometry("no_split",
+			"line does not divide %s; it must cross the zone from edge to edge", parent.Name))
+		return
+	}
+
+	res := ReshapeResult{Operation: "split", Retired: []RetiredZone{}, Zones: []Zone{}}
+	children := make([]uuid.UUID, 0, len(parts))
+	for i, part := range parts {
+		geom, err := PrepareGeometry(r.Context(), tx, json.RawMessage(part))
+		if err != nil {
+			writeGeometryError(w, err)
+			return
+		}
+		if geom.AreaHa < minOverlapHa {
+			writeGeometryError(w, invalidGeometry("sliver",
+				"line leaves a part of only %.4f ha; move it so every part is a usable zone", geom.AreaHa))
+			return
+		}
+		name := fmt.Sprintf("%s %d", parent.Name, i+1)
+		if i < len(req.Names) && strings.TrimSpace(req.Names[i]) != "" {
+			name = strings.TrimSpace(req.Names[i])
+		}
+		id, err := insertChild(r.Context(), tx, farmID, name, geom, parent)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		children = append(children, id)
+	}
+	if err := replaceZones(r.Context(), tx, farmID, userID, "split", []parentZone{parent}, children, &res); err != nil {
+		response.InternalError(w)
+		return
+	}
+	over, ok := GuardCapacity(w, r, tx, farmID, children, req.Force)
+	if !ok {
+		return
+	}
+	res.Overstocked = over
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Created(w, res)
+}
+
+type MergeRequest struct {
+	ZoneIDs []uuid.UUID `json:"zone_ids"`
+	// Name of the merged zone; defaults to the largest zone's name. The
+	// other attributes also come from the largest zone.
+	Name string `json:"name"`
+	// Force merges even if the merged zone's stocking limit is exceeded
+	// under the reject overstock policy.
+	Force bool `json:"force"`
+}
+
+// Merge unions adjacent zones into one that keeps their usage history.
+// The merged zones are retired.
+func (h *Handler) Merge(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	userID := middleware.UserIDFromCtx(r.Context())
+	var req MergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	seen := map[uuid.UUID]bool{}
+	ids := []uuid.UUID{}
+	for _, id := range req.ZoneIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) < 2 {
+		response.BadRequest(w, "zone_ids must list at least two zones")
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	parents := make([]parentZone, 0, len(ids))
+	largest := 0
+	for _, id := range ids {
+		p, err := lockParent(r.Context(), tx, farmID, id)
+		if err != nil {
+			response.NotFound(w, "zone "+id.String()+" not found or inactive")
+			return
+		}
+		if len(parents) > 0 && p.AreaHa > parents[largest].AreaHa {
+			largest = len(parents)
+		}
+		parents = append(parents, p)
+	}
+
+	var parts int
+	var merged *string
+	err = tx.QueryRow(r.Context(), `
+		SELECT ST_NumGeometries(u),
+		       CASE WHEN ST_NumGeometries(u) = 1
+		            THEN ST_AsGeoJSON(ST_ForcePolygonCCW(ST_GeometryN(u, 1))) END
+		FROM (
+		    SELECT ST_CollectionExtract(ST_Buffer(ST_Buffer(
+		               ST_Union(geometry::geometry), $2, 'join=mitre'), -$2, 'join=mitre'), 3) AS u
+		    FROM zones WHERE id = ANY($1)
+		) s`, ids, mergeToleranceDeg,
+	).Scan(&parts, &merged)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	if merged == nil {
+		writeGeometryError(w, invalidGeometry("not_adjacent",
+			"zones are not adjacent; merging them would produce %d separate polygons", parts))
+		return
+	}
+	geom, err := PrepareGeometry(r.Context(), tx, json.RawMessage(*merged))
+	if err != nil {
+		writeGeometryError(w, err)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = parents[largest].Name
+	}
+	id, err := insertChild(r.Context(), tx, farmID, name, geom, parents[largest])
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	res := ReshapeResult{Operation: "merge", Retired: []RetiredZone{}, Zones: []Zone{}}
+	if err := replaceZones(r.Context(), tx, farmID, userID, "merge", parents, []uuid.UUID{id}, &res); err != nil {
+		response.InternalError(w)
+		return
+	}
+	over, ok := GuardCapacity(w, r, tx, farmID, []uuid.UUID{id}, req.Force)
+	if !ok {
+		return
+	}
+	res.Overstocked = over
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Created(w, res)
+}
+
+type LineageLink struct {
+	ZoneID    uuid.UUID `json:"zone_id"`
+	Name      string    `json:"name"`
+	AreaHa    float64   `json:"area_ha"`
+	IsActive  bool      `json:"is_active"`
+	Operation string    `json:"operation"`
+	At        time.Time `json:"at"`
+}
+
+// Lineage returns the zones a zone was split or merged from and the zones
+// that replaced it.
+func (h *Handler) Lineage(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	zoneID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid zone id")
+		return
+	}
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT l.child_zone_id = $1, z.id, z.name, z.area_ha::float8, z.is_active, l.operation, l.created_at
+		FROM zone_lineage l
+		JOIN zones z ON z.id = CASE WHEN l.child_zone_id = $1 THEN l.parent_zone_id ELSE l.child_zone_id END
+		WHERE l.farm_id = $2 AND (l.child_zone_id = $1 OR l.parent_zone_id = $1)
+		ORDER BY l.created_at, z.name`, zoneID, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+	parents, children := []LineageLink{}, []LineageLink{}
+	for rows.Next() {
+		var isParent bool
+		var l LineageLink
+		if err := rows.Scan(&isParent, &l.ZoneID, &l.Name, &l.AreaHa, &l.IsActive, &l.Operation, &l.At); err != nil {
+			response.InternalError(w)
+			return
+		}
+		if isParent {
+			parents = append(parents, l)
+		} else {
+			children = append(children, l)
+		}
+	}
+	response.Ok(w, map[string]any{"parents": parents, "children": children})
+}
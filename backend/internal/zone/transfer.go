@@ -0,0 +1,427 @@
+package zone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/geoio"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// MAP IMPORT / EXPORT
+// =============================================
+
+// maxImportBytes caps uploaded map files; farm maps are a few hundred KB.
+const maxImportBytes = 20 << 20
+
+// ImportItem is the outcome for one polygon of an imported file. Status is
+// "ok", "warning" (siting issues; imported only with force), "error"
+// (geometry rejected) or "skipped" (not a polygon, or left out by include).
+type ImportItem struct {
+	Index    int             `json:"index"`
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	AreaHa   float64         `json:"area_ha,omitempty"`
+	Geometry json.RawMessage `json:"geometry,omitempty"`
+	Issues   []GeometryIssue `json:"issues,omitempty"`
+	Repairs  []GeometryIssue `json:"repairs,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	// ID is set once the item has been stored.
+	ID *uuid.UUID `json:"id,omitempty"`
+}
+
+type ImportResult struct {
+	Format     string       `json:"format"`
+	SourceSRID int          `json:"source_srid"`
+	Target     string       `json:"target"`
+	Preview    bool         `json:"preview"`
+	Imported   int          `json:"imported"`
+	Items      []ImportItem `json:"items"`
+}
+
+// importFormat picks the reader from ?format= or the file extension.
+func importFormat(r *http.Request, filename string) string {
+	if f := strings.ToLower(r.URL.Query().Get("format")); f != "" {
+		return f
+	}
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".kml":
+		return "kml"
+	case ".kmz":
+		return "kmz"
+	case ".zip", ".shz":
+		return "shp"
+	case ".gpx":
+		return "gpx"
+	case ".geojson", ".json":
+		return "geojson"
+	}
+	return ""
+}
+
+var (
+	prjAuthority = regexp.MustCompile(`AUTHORITY\["EPSG",\s*"?(\d+)"?\]\]\s*$`)
+	prjName      = regexp.MustCompile(`^\s*(?:PROJCS|GEOGCS)\["([^"]+)"`)
+	nonAlnum     = regexp.MustCompile(`[^a-z0-9]`)
+)
+
+// normalizeCRSName reduces ESRI and EPSG spellings of a coordinate system
+// name to a common key: "GCS_SIRGAS_2000" and "SIRGAS 2000" both become
+// "sirgas2000", "WGS_1984_UTM_Zone_23S" becomes "wgs84utmzone23s".
+func normalizeCRSName(name string) string {
+	n := nonAlnum.ReplaceAllString(strings.ToLower(name), "")
+	n = strings.TrimPrefix(n, "gcs")
+	n = strings.ReplaceAll(n, "wgs1984", "wgs84")
+	n = strings.ReplaceAll(n, "sad1969", "sad69")
+	return n
+}
+
+// sridFromPRJ finds the EPSG code of a shapefile's .prj, from its
+// AUTHORITY clause when present or by matching its name against
+// spatial_ref_sys. Zero means unknown.
+func sridFromPRJ(ctx context.Context, q db.DBTX, prj string) (int, error) {
+	if m := prjAuthority.FindStringSubmatch(prj); m != nil {
+		return strconv.Atoi(m[1])
+	}
+	m := prjName.FindStringSubmatch(prj)
+	if m == nil {
+		return 0, nil
+	}
+	var srid int
+	err := q.QueryRow(ctx, `
+		SELECT COALESCE(MIN(srid), 0) FROM spatial_ref_sys
+		WHERE auth_name = 'EPSG'
+		  AND regexp_replace(regexp_replace(regexp_replace(lower(split_part(srtext, '"', 2)),
+		      '[^a-z0-9]', '', 'g'), 'wgs1984', 'wgs84'), 'sad1969', 'sad69') = $1`,
+		normalizeCRSName(m[1])).Scan(&srid)
+	return srid, err
+}
+
+// readMapFile parses an uploaded file and returns its features with the
+// SRID its coordinates are declared in (zero when the file does not say).
+func readMapFile(ctx context.Context, q db.DBTX, format string, data []byte) ([]geoio.Feature, int, error) {
+	switch format {
+	case "kml":
+		f, err := geoio.ReadKML(data)
+		return f, 4326, err
+	case "kmz":
+		f, err := geoio.ReadKMZ(data)
+		return f, 4326, err
+	case "gpx":
+		f, err := geoio.ReadGPX(data)
+		return f, 4326, err
+	case "geojson":
+		return geoio.ReadGeoJSON(data)
+	case "shp":
+		f, prj, err := geoio.ReadShapefileZip(data)
+		if err != nil || prj == "" {
+			return f, 0, err
+		}
+		srid, err := sridFromPRJ(ctx, q, prj)
+		return f, srid, err
+	}
+	return nil, 0, fmt.Errorf("unsupported format %q; use kml, kmz, shp, geojson or gpx", format)
+}
+
+// Import reads a map file uploaded as multipart "file" (KML, KMZ, zipped
+// Shapefile, GeoJSON or GPX) and turns its polygons into zones, or into
+// perimeters with ?target=perimeter. Coordinates are reprojected to
+// EPSG:4326 from the CRS the file declares, or from ?srid= when it declares
+// none. Each polygon goes through the same validation as a drawn zone.
+//
+// ?preview=true only reports what would be imported. ?include= takes the
+// comma separated item indexes to keep after a preview, and ?force=true also
+// imports zones with siting warnings.
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	query := r.URL.Query()
+	preview := query.Get("preview") == "true"
+	force := query.Get("force") == "true"
+	target := query.Get("target")
+	if target == "" {
+		target = "zone"
+	}
+	if target != "zone" && target != "perimeter" {
+		response.BadRequest(w, "target must be zone or perimeter")
+		return
+	}
+	var include map[int]bool
+	if v := query.Get("include"); v != "" {
+		include = map[int]bool{}
+		for _, s := range strings.Split(v, ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				response.BadRequest(w, "include must be a comma separated list of item indexes")
+				return
+			}
+			include[i] = true
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		response.BadRequest(w, "multipart field \"file\" is required (max 20 MB)")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		response.BadRequest(w, "could not read uploaded file")
+		return
+	}
+	format := importFormat(r, header.Filename)
+
+	features, srid, err := readMapFile(r.Context(), h.pool, format, data)
+	if err != nil {
+		response.JSON(w, http.StatusUnprocessableEntity, response.Response{Error: err.Error()})
+		return
+	}
+	if v := query.Get("srid"); v != "" && srid == 0 {
+		if srid, err = strconv.Atoi(v); err != nil {
+			response.BadRequest(w, "invalid srid")
+			return
+		}
+	}
+	if srid == 0 && geoio.LooksGeographic(features) {
+		srid = 4326
+	}
+	if srid == 0 {
+		response.JSON(w, http.StatusUnprocessableEntity, response.Response{
+			Error: "the file does not declare a known coordinate system; pass ?srid= with its EPSG code (e.g. 31983 for SIRGAS 2000 / UTM 23S)",
+		})
+		return
+	}
+	var known bool
+	if err := h.pool.QueryRow(r.Context(),
+		`SELECT EXISTS (SELECT 1 FROM spatial_ref_sys WHERE srid = $1)`, srid).Scan(&known); err != nil {
+		response.InternalError(w)
+		return
+	}
+	if !known {
+		response.JSON(w, http.StatusUnprocessableEntity, response.Response{
+			Error: fmt.Sprintf("unknown coordinate system EPSG:%d", srid),
+		})
+		return
+	}
+
+	result := ImportResult{Format: format, SourceSRID: srid, Target: target, Preview: preview, Items: []ImportItem{}}
+	var prepared []Geometry
+	for i, f := range features {
+		item := ImportItem{Index: i, Name: f.Name}
+		var geom Geometry
+		switch {
+		case len(f.Polygon) == 0:
+			item.Status, item.Error = "skipped", "not a polygon"
+		case include != nil && !include[i]:
+			item.Status = "skipped"
+		default:
+			geom, err = h.prepareImported(r.Context(), farmID, target, srid, f.Polygon, &item)
+			if err != nil {
+				response.InternalError(w)
+				return
+			}
+		}
+		result.Items = append(result.Items, item)
+		prepared = append(prepared, geom)
+	}
+	if preview {
+		response.Ok(w, result)
+		return
+	}
+
+	tx, err := h.pool.Begin(r.Context())
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	for i := range result.Items {
+		item := &result.Items[i]
+		if item.Status != "ok" && !(item.Status == "warning" && force) {
+			continue
+		}
+		id := uuid.New()
+		geom := prepared[i]
+		if target == "perimeter" {
+			_, err = tx.Exec(r.Context(), `
+				INSERT INTO perimeters (id, farm_id, name, geometry, area_ha)
+				VALUES ($1,$2,$3,ST_GeogFromGeoJSON($4),$5)`,
+				id, farmID, item.Name, geom.GeoJSON, geom.AreaHa)
+		} else {
+			_, err = tx.Exec(r.Context(), `
+				INSERT INTO zones (id, farm_id, name, geometry, area_ha, is_active, geometry_issues)
+				VALUES ($1,$2,$3,ST_GeogFromGeoJSON($4),$5,TRUE,$6)`,
+				id, farmID, item.Name, geom.GeoJSON, geom.AreaHa, item.Issues)
+		}
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		item.ID = &id
+		result.Imported++
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Created(w, result)
+}
+
+// prepareImported reprojects one polygon to WGS84 and validates it, filling
+// the item's status. Only database failures are returned as errors.
+func (h *Handler) prepareImported(ctx context.Context, farmID uuid.UUID, target string, srid int,
+	poly geoio.Polygon, item *ImportItem) (Geometry, error) {
+	geojson := string(poly.GeoJSON())
+	if srid != 4326 {
+		if err := h.pool.QueryRow(ctx, `
+			SELECT ST_AsGeoJSON(ST_Transform(ST_SetSRID(ST_GeomFromGeoJSON($1), $2::int), 4326), 8)`,
+			geojson, srid).Scan(&geojson); err != nil {
+			item.Status, item.Error = "error", "could not reproject polygon: "+err.Error()
+			return Geometry{}, nil
+		}
+	}
+	geom, err := PrepareGeometry(ctx, h.pool, json.RawMessage(geojson))
+	var ge *GeometryError
+	if errors.As(err, &ge) {
+		item.Status, item.Error, item.Issues = "error", ge.Error(), ge.Issues
+		return geom, nil
+	}
+	if err != nil {
+		return geom, err
+	}
+	item.AreaHa, item.Geometry, item.Repairs = geom.AreaHa, json.RawMessage(geom.GeoJSON), geom.Repairs
+	item.Status = "ok"
+	if target == "zone" {
+		issues, err := SitingIssues(ctx, h.pool, farmID, nil, geom.GeoJSON)
+		if err != nil {
+			return geom, err
+		}
+		if len(issues) > 0 {
+			item.Status, item.Issues = "warning", issues
+		}
+	}
+	return geom, nil
+}
+
+// exportFolders orders the feature kinds in exported files.
+var exportFolders = []struct{ kind, title, layer string }{
+	{"perimeter", "Perimeters", "perimeters"},
+	{"zone", "Zones", "zones"},
+	{"key_point", "Key points", "key_points"},
+	{"antenna", "Antennas", "antennas"},
+}
+
+// toGeoIO converts a map feature for the file writers. Properties go
+// through JSON so ids and nullable numbers become plain values.
+func toGeoIO(f Feature) ([]geoio.Feature, error) {
+	var props map[string]any
+	raw, _ := json.Marshal(f.Properties)
+	if err := json.Unmarshal(raw, &props); err != nil {
+		return nil, err
+	}
+	name, _ := props["name"].(string)
+	delete(props, "name")
+	delete(props, "kind")
+	props["id"] = f.ID
+	if len(f.Geometry) == 0 || string(f.Geometry) == "null" {
+		return nil, nil
+	}
+	polys, point, err := geoio.ParseGeometry(f.Geometry)
+	if err != nil {
+		return nil, err
+	}
+	if point != nil {
+		return []geoio.Feature{{Name: name, Point: point, Properties: props}}, nil
+	}
+	out := make([]geoio.Feature, 0, len(polys))
+	for _, p := range polys {
+		out = append(out, geoio.Feature{Name: name, Polygon: p, Properties: props})
+	}
+	return out, nil
+}
+
+// Export downloads the farm map for GIS tools and agronomists as
+// ?format=kml, geojson, shp (zipped, one layer per kind) or gpx. ?kinds=
+// limits the features like on GET /zones/geojson.
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "geojson"
+	}
+	var contentType, ext string
+	switch format {
+	case "geojson":
+		contentType, ext = "application/geo+json", "geojson"
+	case "kml":
+		contentType, ext = "application/vnd.google-earth.kml+xml", "kml"
+	case "shp":
+		contentType, ext = "application/zip", "zip"
+	case "gpx":
+		contentType, ext = "application/gpx+xml", "gpx"
+	default:
+		response.BadRequest(w, "format must be kml, geojson, shp or gpx")
+		return
+	}
+
+	fc, err := h.farmMap(r.Context(), farmID, parseKinds(r))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	groups := map[string][]geoio.Feature{}
+	var all []geoio.Feature
+	for _, f := range fc.Features {
+		converted, err := toGeoIO(f)
+		if err != nil {
+			response.InternalError(w)
+			return
+		}
+		kind, _ := f.Properties["kind"].(string)
+		groups[kind] = append(groups[kind], converted...)
+		all = append(all, converted...)
+	}
+
+	name := "farm-map-" + time.Now().Format("2006-01-02")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, ext))
+	switch format {
+	case "geojson":
+		_ = json.NewEncoder(w).Encode(fc)
+	case "kml":
+		var folders []string
+		byTitle := map[string][]geoio.Feature{}
+		for _, f := range exportFolders {
+			if len(groups[f.kind]) > 0 {
+				folders = append(folders, f.title)
+				byTitle[f.title] = groups[f.kind]
+			}
+		}
+		_ = geoio.WriteKML(w, name, folders, byTitle)
+	case "shp":
+		var layers []geoio.Layer
+		for _, f := range exportFolders {
+			if len(groups[f.kind]) > 0 {
+				layers = append(layers, geoio.Layer{Name: f.layer, Features: groups[f.kind]})
+			}
+		}
+		_ = geoio.WriteShapefileZip(w, layers)
+	case "gpx":
+		_ = geoio.WriteGPX(w, name, all)
+	}
+}
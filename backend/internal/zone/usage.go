@@ -0,0 +1,354 @@
+package zone
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// ZONE USAGE HISTORY
+// =============================================
+
+// SyncUsage reconciles zone_usages with the animals currently in each zone
+// of a farm: zones whose head count changed get their open period closed
+// and, when still occupied, a new one opened with the current count and
+// stocking in animal units. Call it in the same transaction as any change to
+// animals.zone_id or animals.status.
+//
+// The farm row is locked first so concurrent moves in the same farm sync
+// one after the other instead of both opening a period for the same zone.
+func SyncUsage(ctx context.Context, q db.DBTX, farmID uuid.UUID) error {
+	if _, err := q.Exec(ctx, `SELECT 1 FROM farms WHERE id = $1 FOR UPDATE`, farmID); err != nil {
+		return err
+	}
+	// Close first: the open period and its replacement would collide on
+	// idx_zone_usages_open if both happened in one statement
+	if _, err := q.Exec(ctx, usageCounts+`
+		UPDATE zone_usages u SET ended_at = NOW()
+		FROM counts c
+		WHERE u.zone_id = c.zone_id AND u.ended_at IS NULL AND u.animal_count <> c.n`, farmID); err != nil {
+		return err
+	}
+	_, err := q.Exec(ctx, usageCounts+`
+		INSERT INTO zone_usages (zone_id, started_at, animal_count, animal_units, ugm_ha)
+		SELECT c.zone_id, NOW(), c.n, ROUND(c.ua::numeric, 2),
+		       CASE WHEN c.area_ha > 0 THEN LEAST(ROUND((c.ua / c.area_ha)::numeric, 2), 9999.99) END
+		FROM counts c
+		WHERE c.n > 0 AND NOT EXISTS (
+		    SELECT 1 FROM zone_usages u WHERE u.zone_id = c.zone_id AND u.ended_at IS NULL
+		)`, farmID)
+	return err
+}
+
+// usageCounts is the head count and animal units of every zone of farm $1.
+const usageCounts = `
+	WITH counts AS (
+		SELECT z.id AS zone_id, z.area_ha, COUNT(au.animal_id)::int AS n,
+		       COALESCE(SUM(au.ua), 0) AS ua
+		FROM zones z
+		LEFT JOIN animal_units au ON au.zone_id = z.id
+		WHERE z.farm_id = $1
+		GROUP BY z.id
+	)`
+
+type Usage struct {
+	ID          uuid.UUID  `json:"id"`
+	StartedAt   time.Time  `json:"started_at"`
+	EndedAt     *time.Time `json:"ended_at"`
+	AnimalCount int        `json:"animal_count"`
+	AnimalUnits *float64   `json:"animal_units"`
+	UGMHa       *float64   `json:"ugm_ha"`
+	Notes       *string    `json:"notes"`
+	// InheritedFrom is the retired zone this period was copied from when
+	// the zone was created by a split or merge.
+	InheritedFrom *uuid.UUID `json:"inherited_from"`
+}
+
+// Occupation is a continuous stretch of time with animals in a zone; it may
+// span several usage periods when the head count changed along the way.
+type Occupation struct {
+	StartedAt   time.Time  `json:"started_at"`
+	EndedAt     *time.Time `json:"ended_at"`
+	Days        float64    `json:"days"`
+	PeakAnimals int        `json:"peak_animals"`
+	PeakUGMHa   *float64   `json:"peak_ugm_ha"`
+	AnimalDays  float64    `json:"animal_days"`
+	AvgAnimals  float64    `json:"avg_animals"`
+}
+
+// UsageStats summarises the occupations of a zone within a window.
+// RestDays is the current rest (time since the last occupation ended) and
+// is null while the zone is occupied or was never grazed.
+type UsageStats struct {
+	ZoneID            uuid.UUID  `json:"zone_id"`
+	ZoneName          string     `json:"zone_name"`
+	From              string     `json:"from"`
+	To                string     `json:"to"`
+	Occupied          bool       `json:"occupied"`
+	OccupiedSince     *time.Time `json:"occupied_since"`
+	CurrentOccupation *float64   `json:"current_occupation_days"`
+	RestDays          *float64   `json:"rest_days"`
+	LastGrazedAt      *time.Time `json:"last_grazed_at"`
+	Grazings          int        `json:"grazings"`
+	OccupiedDays      float64    `json:"occupied_days"`
+	RestedDays        float64    `json:"rested_days"`
+	OccupancyPct      float64    `json:"occupancy_pct"`
+	AvgOccupationDays *float64   `json:"avg_occupation_days"`
+	AvgRestDays       *float64   `json:"avg_rest_days"`
+	AnimalDays        float64    `json:"animal_days"`
+}
+
+func days(d time.Duration) float64 { return math.Round(d.Hours()/24*10) / 10 }
+
+// Occupations groups the occupied usage periods of a farm's zones (one zone
+// when zoneID is set) into continuous stretches, oldest first. Periods may
+// overlap when a merged zone inherits the history of several zones.
+func Occupations(ctx context.Context, q db.DBTX, farmID uuid.UUID, zoneID *uuid.UUID) (map[uuid.UUID][]Occupation, error) {
+	rows, err := q.Query(ctx, `
+		WITH u AS (
+			SELECT u.zone_id, u.started_at, u.ended_at, u.animal_count, u.ugm_ha::float8 AS ugm_ha,
+			       EXTRACT(EPOCH FROM COALESCE(u.ended_at, NOW()) - u.started_at) / 86400 * u.animal_count AS animal_days,
+			       CASE WHEN u.started_at <= MAX(COALESCE(u.ended_at, NOW()))
+			                 OVER (PARTITION BY u.zone_id ORDER BY u.started_at
+			                       ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING)
+			            THEN 0 ELSE 1 END AS new_stretch
+			FROM zone_usages u
+			JOIN zones z ON z.id = u.zone_id
+			WHERE z.farm_id = $1 AND ($2::uuid IS NULL OR z.id = $2)
+			  AND u.animal_count > 0
+		),
+		s AS (
+			SELECT *, SUM(new_stretch) OVER (PARTITION BY zone_id ORDER BY started_at) AS stretch
+			FROM u
+		)
+		SELECT zone_id, MIN(started_at),
+		       CASE WHEN bool_or(ended_at IS NULL) THEN NULL ELSE MAX(ended_at) END,
+		       MAX(animal_count), MAX(ugm_ha), SUM(animal_days)::float8
+		FROM s
+		GROUP BY zone_id, stretch
+		ORDER BY zone_id, MIN(started_at)`, farmID, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := time.Now()
+	out := map[uuid.UUID][]Occupation{}
+	for rows.Next() {
+		var id uuid.UUID
+		var o Occupation
+		if err := rows.Scan(&id, &o.StartedAt, &o.EndedAt, &o.PeakAnimals, &o.PeakUGMHa, &o.AnimalDays); err != nil {
+			return nil, err
+		}
+		end := now
+		if o.EndedAt != nil {
+			end = *o.EndedAt
+		}
+		o.Days = days(end.Sub(o.StartedAt))
+		if d := end.Sub(o.StartedAt).Hours() / 24; d > 0 {
+			o.AvgAnimals = math.Round(o.AnimalDays/d*10) / 10
+		}
+		o.AnimalDays = math.Round(o.AnimalDays*10) / 10
+		out[id] = append(out[id], o)
+	}
+	return out, rows.Err()
+}
+
+// usageStats computes occupation and rest figures of one zone's
+// occupations, clipped to [from, to).
+func usageStats(occ []Occupation, from, to time.Time) UsageStats {
+	s := UsageStats{From: from.Format("2006-01-02"), To: to.AddDate(0, 0, -1).Format("2006-01-02")}
+	now := time.Now()
+	if n := len(occ); n > 0 {
+		last := occ[n-1]
+		if last.EndedAt == nil {
+			s.Occupied = true
+			s.OccupiedSince = &last.StartedAt
+			d := days(now.Sub(last.StartedAt))
+			s.CurrentOccupation = &d
+		} else {
+			s.LastGrazedAt = last.EndedAt
+			d := days(now.Sub(*last.EndedAt))
+			s.RestDays = &d
+		}
+	}
+
+	var occupied time.Duration
+	var rests []time.Duration
+	var prevEnd *time.Time
+	for _, o := range occ {
+		end := now
+		if o.EndedAt != nil {
+			end = *o.EndedAt
+		}
+		start := o.StartedAt
+		if prevEnd != nil && start.After(from) {
+			rests = append(rests, start.Sub(*prevEnd))
+		}
+		prevEnd = &end
+		if end.Before(from) || !start.Before(to) {
+			continue
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		occupied += end.Sub(start)
+		s.Grazings++
+		s.AnimalDays += o.AnimalDays
+	}
+
+	window := to
+	if window.After(now) {
+		window = now
+	}
+	s.OccupiedDays = days(occupied)
+	if total := window.Sub(from); total > 0 {
+		s.RestedDays = days(total - occupied)
+		s.OccupancyPct = math.Round(occupied.Hours()/total.Hours()*1000) / 10
+	}
+	s.AnimalDays = math.Round(s.AnimalDays*10) / 10
+	if s.Grazings > 0 {
+		avg := math.Round(occupied.Hours()/24/float64(s.Grazings)*10) / 10
+		s.AvgOccupationDays = &avg
+	}
+	if len(rests) > 0 {
+		var sum time.Duration
+		for _, r := range rests {
+			sum += r
+		}
+		avg := days(sum / time.Duration(len(rests)))
+		s.AvgRestDays = &avg
+	}
+	return s
+}
+
+// usageWindow reads ?from= and ?to= (inclusive dates), defaulting to the
+// last 365 days. The returned end is exclusive.
+func usageWindow(r *http.Request) (time.Time, time.Time, bool) {
+	to := time.Now().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	from := to.AddDate(-1, 0, 0)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, false
+		}
+		from = t
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return from, to, false
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	return from, to, from.Before(to)
+}
+
+// Usage returns a zone's usage periods within the window, newest first,
+// grouped into occupations with occupation and rest statistics.
+func (h *Handler) Usage(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	zoneID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		response.BadRequest(w, "invalid zone id")
+		return
+	}
+	from, to, ok := usageWindow(r)
+	if !ok {
+		response.BadRequest(w, "from and to must be dates (YYYY-MM-DD), from before to")
+		return
+	}
+	var name string
+	if err := h.pool.QueryRow(r.Context(),
+		`SELECT name FROM zones WHERE id=$1 AND farm_id=$2`, zoneID, farmID).Scan(&name); err != nil {
+		response.NotFound(w, "zone not found")
+		return
+	}
+
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT id, started_at, ended_at, animal_count, animal_units::float8, ugm_ha::float8, notes, inherited_from
+		FROM zone_usages
+		WHERE zone_id = $1 AND started_at < $3 AND COALESCE(ended_at, NOW()) >= $2
+		ORDER BY started_at DESC`, zoneID, from, to)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+	usages := []Usage{}
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.ID, &u.StartedAt, &u.EndedAt, &u.AnimalCount, &u.AnimalUnits, &u.UGMHa, &u.Notes, &u.InheritedFrom); err != nil {
+			response.InternalError(w)
+			return
+		}
+		usages = append(usages, u)
+	}
+
+	occ, err := Occupations(r.Context(), h.pool, farmID, &zoneID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	stats := usageStats(occ[zoneID], from, to)
+	stats.ZoneID, stats.ZoneName = zoneID, name
+	inWindow := []Occupation{}
+	for i := len(occ[zoneID]) - 1; i >= 0; i-- {
+		o := occ[zoneID][i]
+		if o.StartedAt.Before(to) && (o.EndedAt == nil || !o.EndedAt.Before(from)) {
+			inWindow = append(inWindow, o)
+		}
+	}
+	response.Ok(w, map[string]any{
+		"stats":       stats,
+		"occupations": inWindow,
+		"periods":     usages,
+	})
+}
+
+// UsageStats returns occupation and rest statistics for every active zone
+// of the farm within the window.
+func (h *Handler) UsageStats(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	from, to, ok := usageWindow(r)
+	if !ok {
+		response.BadRequest(w, "from and to must be dates (YYYY-MM-DD), from before to")
+		return
+	}
+	occ, err := Occupations(r.Context(), h.pool, farmID, nil)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT id, name FROM zones WHERE farm_id=$1 AND is_active ORDER BY name`, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	defer rows.Close()
+	out := []UsageStats{}
+	for rows.Next() {
+		var id uuid.UUID
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			response.InternalError(w)
+			return
+		}
+		s := usageStats(occ[id], from, to)
+		s.ZoneID, s.ZoneName = id, name
+		out = append(out, s)
+	}
+	response.Ok(w, out)
+}
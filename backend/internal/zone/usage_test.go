@@ -0,0 +1,252 @@
+package zone
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrielrondon/cowpro/internal/db/dbtest"
+)
+
+func day(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func closedOcc(start, end string, animalDays float64) Occupation {
+	return Occupation{StartedAt: day(start), EndedAt: ptr(day(end)), AnimalDays: animalDays}
+}
+
+func TestUsageStats(t *testing.T) {
+	from, to := day("2025-01-01"), day("2025-02-01") // 31 days
+	tests := []struct {
+		name         string
+		occ          []Occupation
+		grazings     int
+		occupiedDays float64
+		restedDays   float64
+		occupancyPct float64
+		animalDays   float64
+		avgOcc       *float64
+		avgRest      *float64
+		lastGrazed   *time.Time
+	}{
+		{
+			name:       "never grazed",
+			restedDays: 31,
+		},
+		{
+			name: "two occupations inside the window",
+			occ: []Occupation{
+				closedOcc("2025-01-01", "2025-01-11", 100),
+				closedOcc("2025-01-21", "2025-01-26", 50.04),
+			},
+			grazings: 2, occupiedDays: 15, restedDays: 16, occupancyPct: 48.4,
+			animalDays: 150, avgOcc: ptr(7.5), avgRest: ptr(10.0),
+			lastGrazed: ptr(day("2025-01-26")),
+		},
+		{
+			name: "occupations clipped to the window",
+			occ: []Occupation{
+				closedOcc("2024-12-20", "2025-01-05", 160),
+				closedOcc("2025-01-25", "2025-02-10", 160),
+			},
+			grazings: 2, occupiedDays: 11, restedDays: 20, occupancyPct: 35.5,
+			animalDays: 320, avgOcc: ptr(5.5), avgRest: ptr(20.0),
+			lastGrazed: ptr(day("2025-02-10")),
+		},
+		{
+			name: "rest before the window is not averaged",
+			occ: []Occupation{
+				closedOcc("2024-11-01", "2024-11-10", 10),
+				closedOcc("2024-12-01", "2024-12-10", 10),
+			},
+			restedDays: 31,
+			lastGrazed: ptr(day("2024-12-10")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := usageStats(tt.occ, from, to)
+			if s.From != "2025-01-01" || s.To != "2025-01-31" {
+				t.Errorf("window = %s..%s, want 2025-01-01..2025-01-31", s.From, s.To)
+			}
+			if s.Occupied {
+				t.Error("occupied = true, want false")
+			}
+			if s.Grazings != tt.grazings {
+				t.Errorf("grazings = %d, want %d", s.Grazings, tt.grazings)
+			}
+			if s.OccupiedDays != tt.occupiedDays || s.RestedDays != tt.restedDays {
+				t.Errorf("occupied/rested = %g/%g days, want %g/%g",
+					s.OccupiedDays, s.RestedDays, tt.occupiedDays, tt.restedDays)
+			}
+			if s.OccupancyPct != tt.occupancyPct {
+				t.Errorf("occupancy = %g%%, want %g%%", s.OccupancyPct, tt.occupancyPct)
+			}
+			if s.AnimalDays != tt.animalDays {
+				t.Errorf("animal days = %g, want %g", s.AnimalDays, tt.animalDays)
+			}
+			if !equalPtr(s.AvgOccupationDays, tt.avgOcc) {
+				t.Errorf("avg occupation = %v, want %v", deref(s.AvgOccupationDays), deref(tt.avgOcc))
+			}
+			if !equalPtr(s.AvgRestDays, tt.avgRest) {
+				t.Errorf("avg rest = %v, want %v", deref(s.AvgRestDays), deref(tt.avgRest))
+			}
+			if !equalPtr(s.LastGrazedAt, tt.lastGrazed) {
+				t.Errorf("last grazed = %v, want %v", s.LastGrazedAt, tt.lastGrazed)
+			}
+			if (tt.lastGrazed == nil) != (s.RestDays == nil) {
+				t.Errorf("rest days = %v with last grazed %v", deref(s.RestDays), tt.lastGrazed)
+			}
+		})
+	}
+}
+
+func TestUsageStatsOngoing(t *testing.T) {
+	now := time.Now()
+	occ := []Occupation{{StartedAt: now.Add(-72 * time.Hour), AnimalDays: 30}}
+	s := usageStats(occ, now.Add(-240*time.Hour), now.Add(24*time.Hour))
+	if !s.Occupied || s.OccupiedSince == nil || s.RestDays != nil {
+		t.Fatalf("occupied = %v since %v, rest %v; want occupied with no rest", s.Occupied, s.OccupiedSince, s.RestDays)
+	}
+	if s.CurrentOccupation == nil || *s.CurrentOccupation != 3 {
+		t.Errorf("current occupation = %v, want 3", deref(s.CurrentOccupation))
+	}
+	// The window ends now, not at its nominal end
+	if s.OccupiedDays != 3 || s.RestedDays != 7 || s.OccupancyPct != 30 {
+		t.Errorf("occupied/rested/pct = %g/%g/%g, want 3/7/30", s.OccupiedDays, s.RestedDays, s.OccupancyPct)
+	}
+}
+
+func TestUsageWindow(t *testing.T) {
+	tests := []struct {
+		query    string
+		from, to string
+		ok       bool
+	}{
+		{"?from=2025-01-01&to=2025-01-31", "2025-01-01", "2025-02-01", true},
+		{"?from=2025-01-31&to=2025-01-31", "2025-01-31", "2025-02-01", true},
+		{"?from=2025-02-01&to=2025-01-31", "", "", false},
+		{"?from=01/02/2025", "", "", false},
+		{"?to=tomorrow", "", "", false},
+	}
+	for _, tt := range tests {
+		from, to, ok := usageWindow(httptest.NewRequest("GET", "/zones/usage-stats"+tt.query, nil))
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.query, ok, tt.ok)
+			continue
+		}
+		if ok && (!from.Equal(day(tt.from)) || !to.Equal(day(tt.to))) {
+			t.Errorf("%s: window = %s..%s, want %s..%s", tt.query, from, to, tt.from, tt.to)
+		}
+	}
+
+	from, to, ok := usageWindow(httptest.NewRequest("GET", "/zones/usage-stats", nil))
+	if !ok || to.Sub(from) < 365*24*time.Hour || to.Sub(from) > 366*24*time.Hour {
+		t.Errorf("default window = %s..%s (ok %v), want the last year", from, to, ok)
+	}
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func deref[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// TestSyncUsageOccupations records moves through SyncUsage and checks that
+// head count changes split usage periods but not occupations.
+func TestSyncUsageOccupations(t *testing.T) {
+	pool := dbtest.Pool(t)
+	farmID := dbtest.Farm(t, pool)
+	ctx := context.Background()
+
+	zoneID := dbtest.Zone(t, pool, farmID, "Retiro")
+	dbtest.Exec(t, pool, `UPDATE zones SET area_ha = 10 WHERE id = $1`, zoneID)
+	// An earlier grazing, ten days with five animals
+	dbtest.Exec(t, pool, `
+		INSERT INTO zone_usages (zone_id, started_at, ended_at, animal_count)
+		VALUES ($1, '2026-01-01', '2026-01-11', 5)`, zoneID)
+	a1 := dbtest.Animal(t, pool, farmID, "001", nil, &zoneID)
+	a2 := dbtest.Animal(t, pool, farmID, "002", nil, &zoneID)
+
+	type usage struct {
+		count  int
+		ugmHa  *float64
+		closed bool
+	}
+	steps := []struct {
+		name   string
+		move   *uuid.UUID // animal taken out of the zone before syncing
+		usages []usage
+	}{
+		{"animals arrive", nil, []usage{{5, nil, true}, {2, ptr(0.2), false}}},
+		{"nothing changed", nil, []usage{{5, nil, true}, {2, ptr(0.2), false}}},
+		{"one leaves", &a2, []usage{{5, nil, true}, {2, ptr(0.2), true}, {1, ptr(0.1), false}}},
+		{"zone emptied", &a1, []usage{{5, nil, true}, {2, ptr(0.2), true}, {1, ptr(0.1), true}}},
+	}
+	for _, st := range steps {
+		if st.move != nil {
+			dbtest.Exec(t, pool, `UPDATE animals SET zone_id = NULL WHERE id = $1`, *st.move)
+		}
+		if err := SyncUsage(ctx, pool, farmID); err != nil {
+			t.Fatalf("%s: %v", st.name, err)
+		}
+		rows, err := pool.Query(ctx, `
+			SELECT animal_count, ugm_ha::float8, ended_at IS NOT NULL
+			FROM zone_usages WHERE zone_id = $1 ORDER BY started_at, ended_at NULLS LAST`, zoneID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []usage{}
+		for rows.Next() {
+			var u usage
+			if err := rows.Scan(&u.count, &u.ugmHa, &u.closed); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, u)
+		}
+		rows.Close()
+		if len(got) != len(st.usages) {
+			t.Fatalf("%s: %d usage periods, want %d", st.name, len(got), len(st.usages))
+		}
+		for i := range got {
+			if got[i].count != st.usages[i].count || got[i].closed != st.usages[i].closed ||
+				!equalPtr(got[i].ugmHa, st.usages[i].ugmHa) {
+				t.Errorf("%s: period %d = %d animals, %v UGM/ha, closed %v; want %+v", st.name, i,
+					got[i].count, deref(got[i].ugmHa), got[i].closed, st.usages[i])
+			}
+		}
+	}
+
+	occ, err := Occupations(ctx, pool, farmID, &zoneID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := occ[zoneID]
+	if len(list) != 2 {
+		t.Fatalf("%d occupations, want the earlier grazing and the one just recorded", len(list))
+	}
+	if o := list[0]; o.Days != 10 || o.PeakAnimals != 5 || o.AnimalDays != 50 || o.AvgAnimals != 5 {
+		t.Errorf("earlier grazing = %+v", o)
+	}
+	if o := list[1]; o.EndedAt == nil || o.PeakAnimals != 2 || o.PeakUGMHa == nil || *o.PeakUGMHa != 0.2 {
+		t.Errorf("recorded grazing = %+v, want one closed stretch peaking at 2 animals", o)
+	}
+}
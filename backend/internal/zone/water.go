@@ -0,0 +1,464 @@
+package zone
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/gabrielrondon/cowpro/internal/db"
+	"github.com/gabrielrondon/cowpro/internal/iot"
+	"github.com/gabrielrondon/cowpro/internal/middleware"
+	"github.com/gabrielrondon/cowpro/pkg/response"
+)
+
+// =============================================
+// WATER-POINT VISITS
+// =============================================
+
+// visitGap splits two visits to the same water point: fixes inside its
+// radius further apart than this belong to separate visits.
+const visitGap = 30 * time.Minute
+
+// maxWaterWindowDays bounds the GPS history read for visit analytics.
+const maxWaterWindowDays = 92
+
+// WaterSettings configure no_water alerts: an animal still tracked that has
+// not visited a water point for AlertHours while the hottest collar reading
+// in that time reached MinTempC. A null MinTempC alerts in any weather.
+type WaterSettings struct {
+	AlertHours int      `json:"alert_hours"`
+	MinTempC   *float64 `json:"min_temp_c"`
+}
+
+func loadWaterSettings(ctx context.Context, q db.DBTX, farmID uuid.UUID) (WaterSettings, error) {
+	var s WaterSettings
+	err := q.QueryRow(ctx, `
+		SELECT water_alert_hours, water_alert_min_temp_c::float8 FROM farms WHERE id=$1`, farmID,
+	).Scan(&s.AlertHours, &s.MinTempC)
+	return s, err
+}
+
+// WaterVisit is a stretch of consecutive GPS fixes of an animal within the
+// radius of a water point.
+type WaterVisit struct {
+	AnimalID     uuid.UUID `json:"animal_id"`
+	KeyPointID   uuid.UUID `json:"key_point_id"`
+	KeyPointName string    `json:"key_point_name"`
+	Date         string    `json:"date"`
+	StartedAt    time.Time `json:"started_at"`
+	EndedAt      time.Time `json:"ended_at"`
+	Minutes      float64   `json:"minutes"`
+	Fixes        int       `json:"fixes"`
+}
+
+// waterVisits reads the visits of a farm's animals (one animal when
+// animalID is set) to its active water points within [from, to), oldest
+// first. Dates are in the farm's timezone.
+func waterVisits(ctx context.Context, q db.DBTX, farmID uuid.UUID, from, to time.Time, animalID *uuid.UUID) ([]WaterVisit, error) {
+	rows, err := q.Query(ctx, `
+		WITH t AS (
+			SELECT g.animal_id, g.recorded_at,
+			       (SELECT k.id FROM key_points k
+			        WHERE k.farm_id = $1 AND k.is_active AND k.category = ANY($5)
+			          AND ST_DWithin(k.location, g.location, k.radius_m::float8)
+			        ORDER BY ST_Distance(k.location, g.location)
+			        LIMIT 1) AS kp
+			FROM gps_tracks g
+			WHERE g.farm_id = $1 AND g.recorded_at >= $2 AND g.recorded_at < $3
+			  AND ($4::uuid IS NULL OR g.animal_id = $4)
+		),
+		s AS (
+			SELECT *,
+			       CASE WHEN kp IS NOT NULL
+			             AND LAG(kp) OVER w = kp
+			             AND recorded_at - LAG(recorded_at) OVER w <= $6::interval
+			            THEN 0 ELSE 1 END AS new_visit
+			FROM t
+			WINDOW w AS (PARTITION BY animal_id ORDER BY recorded_at)
+		),
+		v AS (
+			SELECT *, SUM(new_visit) OVER (PARTITION BY animal_id ORDER BY recorded_at) AS visit
+			FROM s
+		)
+		SELECT v.animal_id, v.kp, k.name,
+		       to_char(MIN(v.recorded_at) AT TIME ZONE f.timezone, 'YYYY-MM-DD'),
+		       MIN(v.recorded_at), MAX(v.recorded_at), COUNT(*)::int
+		FROM v
+		JOIN key_points k ON k.id = v.kp
+		JOIN farms f ON f.id = $1
+		WHERE v.kp IS NOT NULL
+		GROUP BY v.animal_id, v.kp, k.name, f.timezone, v.visit
+		ORDER BY v.animal_id, MIN(v.recorded_at)`,
+		farmID, from, to, animalID, waterCategories, fmt.Sprintf("%d seconds", int(visitGap.Seconds())))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	visits := []WaterVisit{}
+	for rows.Next() {
+		var v WaterVisit
+		if err := rows.Scan(&v.AnimalID, &v.KeyPointID, &v.KeyPointName, &v.Date,
+			&v.StartedAt, &v.EndedAt, &v.Fixes); err != nil {
+			return nil, err
+		}
+		v.Minutes = math.Round(v.EndedAt.Sub(v.StartedAt).Minutes()*10) / 10
+		visits = append(visits, v)
+	}
+	return visits, rows.Err()
+}
+
+type WaterDay struct {
+	Date   string `json:"date"`
+	Visits int    `json:"visits"`
+}
+
+// AnimalWater summarises an animal's water-point visits. Hours between
+// visits run from the end of one visit to the start of the next.
+type AnimalWater struct {
+	AnimalID            uuid.UUID  `json:"animal_id"`
+	EarTag              string     `json:"ear_tag"`
+	Name                *string    `json:"name"`
+	Visits              int        `json:"visits"`
+	VisitsPerDay        float64    `json:"visits_per_day"`
+	Days                []WaterDay `json:"days"`
+	AvgHoursBetween     *float64   `json:"avg_hours_between"`
+	MaxHoursBetween     *float64   `json:"max_hours_between"`
+	LastVisitAt         *time.Time `json:"last_visit_at"`
+	HoursSinceLastVisit *float64   `json:"hours_since_last_visit"`
+	LastFixAt           time.Time  `json:"last_fix_at"`
+}
+
+type KeyPointWater struct {
+	KeyPointID uuid.UUID `json:"key_point_id"`
+	Name       string    `json:"name"`
+	Visits     int       `json:"visits"`
+	Animals    int       `json:"animals"`
+}
+
+func hours(d time.Duration) float64 { return math.Round(d.Hours()*10) / 10 }
+
+// WaterVisits analyses GPS tracks within ?from= and ?to= (default the last
+// 7 days, at most 92) for visits to water points: per animal the visits per
+// day, the time between visits and since the last one, and per water point
+// the visits and animals. ?animal_id= restricts it to one animal.
+func (h *Handler) WaterVisits(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	to := time.Now().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	from := to.AddDate(0, 0, -7)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			response.BadRequest(w, "from must be a date (YYYY-MM-DD)")
+			return
+		}
+		from = t
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			response.BadRequest(w, "to must be a date (YYYY-MM-DD)")
+			return
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	if !from.Before(to) || to.Sub(from) > maxWaterWindowDays*24*time.Hour {
+		response.BadRequest(w, fmt.Sprintf("from must be before to, at most %d days apart", maxWaterWindowDays))
+		return
+	}
+	var animalID *uuid.UUID
+	if v := r.URL.Query().Get("animal_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			response.BadRequest(w, "invalid animal_id")
+			return
+		}
+		animalID = &id
+	}
+
+	settings, err := loadWaterSettings(r.Context(), h.pool, farmID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	visits, err := waterVisits(r.Context(), h.pool, farmID, from, to, animalID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+
+	// Every animal tracked in the window, including those never at water.
+	rows, err := h.pool.Query(r.Context(), `
+		SELECT a.id, a.ear_tag, a.name, MAX(g.recorded_at)
+		FROM gps_tracks g
+		JOIN animals a ON a.id = g.animal_id
+		WHERE g.farm_id = $1 AND g.recorded_at >= $2 AND g.recorded_at < $3
+		  AND ($4::uuid IS NULL OR g.animal_id = $4)
+		GROUP BY a.id
+		ORDER BY a.ear_tag`, farmID, from, to, animalID)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	animals := []AnimalWater{}
+	index := map[uuid.UUID]int{}
+	for rows.Next() {
+		var a AnimalWater
+		if err := rows.Scan(&a.AnimalID, &a.EarTag, &a.Name, &a.LastFixAt); err != nil {
+			rows.Close()
+			response.InternalError(w)
+			return
+		}
+		a.Days = []WaterDay{}
+		index[a.AnimalID] = len(animals)
+		animals = append(animals, a)
+	}
+	rows.Close()
+
+	byPoint := map[uuid.UUID]*KeyPointWater{}
+	pointAnimals := map[uuid.UUID]map[uuid.UUID]bool{}
+	gaps := map[uuid.UUID][]time.Duration{}
+	for _, v := range visits {
+		i, ok := index[v.AnimalID]
+		if !ok {
+			continue
+		}
+		a := &animals[i]
+		if a.LastVisitAt != nil {
+			gaps[a.AnimalID] = append(gaps[a.AnimalID], v.StartedAt.Sub(*a.LastVisitAt))
+		}
+		end := v.EndedAt
+		a.LastVisitAt = &end
+		a.Visits++
+		if n := len(a.Days); n > 0 && a.Days[n-1].Date == v.Date {
+			a.Days[n-1].Visits++
+		} else {
+			a.Days = append(a.Days, WaterDay{Date: v.Date, Visits: 1})
+		}
+
+		p := byPoint[v.KeyPointID]
+		if p == nil {
+			p = &KeyPointWater{KeyPointID: v.KeyPointID, Name: v.KeyPointName}
+			byPoint[v.KeyPointID] = p
+			pointAnimals[v.KeyPointID] = map[uuid.UUID]bool{}
+		}
+		p.Visits++
+		pointAnimals[v.KeyPointID][v.AnimalID] = true
+	}
+
+	windowDays := to.Sub(from).Hours() / 24
+	now := time.Now()
+	for i := range animals {
+		a := &animals[i]
+		a.VisitsPerDay = math.Round(float64(a.Visits)/windowDays*10) / 10
+		if g := gaps[a.AnimalID]; len(g) > 0 {
+			var sum, longest time.Duration
+			for _, d := range g {
+				sum += d
+				longest = max(longest, d)
+			}
+			avg, mx := hours(sum/time.Duration(len(g))), hours(longest)
+			a.AvgHoursBetween, a.MaxHoursBetween = &avg, &mx
+		}
+		if a.LastVisitAt != nil {
+			since := hours(now.Sub(*a.LastVisitAt))
+			a.HoursSinceLastVisit = &since
+		}
+	}
+	points := make([]KeyPointWater, 0, len(byPoint))
+	for id, p := range byPoint {
+		p.Animals = len(pointAnimals[id])
+		points = append(points, *p)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Visits > points[j].Visits })
+
+	response.Ok(w, map[string]any{
+		"from":       from.Format("2006-01-02"),
+		"to":         to.AddDate(0, 0, -1).Format("2006-01-02"),
+		"settings":   settings,
+		"animals":    animals,
+		"key_points": points,
+		"visits":     visits,
+	})
+}
+
+// GetWaterSettings returns the farm's no_water alert settings.
+func (h *Handler) GetWaterSettings(w http.ResponseWriter, r *http.Request) {
+	s, err := loadWaterSettings(r.Context(), h.pool, middleware.FarmIDFromCtx(r.Context()))
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, s)
+}
+
+// SaveWaterSettings replaces the farm's no_water alert settings.
+func (h *Handler) SaveWaterSettings(w http.ResponseWriter, r *http.Request) {
+	farmID := middleware.FarmIDFromCtx(r.Context())
+	var req WaterSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.BadRequest(w, "invalid body")
+		return
+	}
+	if req.AlertHours < 1 || req.AlertHours > 168 {
+		response.BadRequest(w, "alert_hours must be between 1 and 168")
+		return
+	}
+	if req.MinTempC != nil && (*req.MinTempC < -20 || *req.MinTempC > 60) {
+		response.BadRequest(w, "min_temp_c must be between -20 and 60")
+		return
+	}
+	_, err := h.pool.Exec(r.Context(), `
+		UPDATE farms SET water_alert_hours=$2, water_alert_min_temp_c=$3, updated_at=NOW()
+		WHERE id=$1`, farmID, req.AlertHours, req.MinTempC)
+	if err != nil {
+		response.InternalError(w)
+		return
+	}
+	response.Ok(w, req)
+}
+
+// CheckWater raises a no_water alert, pushed to the farm's clients, for
+// each active animal tracked for at least the farm's alert_hours that has
+// not been within the radius of a water point in that time, when the
+// weather is hot enough. Each dry stretch is reported once.
+func CheckWater(ctx context.Context, q db.DBTX, hub *iot.Hub, farmID uuid.UUID) error {
+	s, err := loadWaterSettings(ctx, q, farmID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	since := now.Add(-time.Duration(s.AlertHours) * time.Hour)
+	var points int
+	var hottest *float64
+	err = q.QueryRow(ctx, `
+		SELECT (SELECT COUNT(*)::int FROM key_points
+		        WHERE farm_id = $1 AND is_active AND category = ANY($3)),
+		       (SELECT MAX(temperature_c)::float8 FROM gps_tracks
+		        WHERE farm_id = $1 AND recorded_at >= $2)`,
+		farmID, since, waterCategories).Scan(&points, &hottest)
+	if err != nil {
+		return err
+	}
+	if points == 0 || (s.MinTempC != nil && (hottest == nil || *hottest < *s.MinTempC)) {
+		return nil
+	}
+
+	// Look back twice the alert window for the last visit, so the dedup
+	// point of an ongoing dry stretch stays stable between checks.
+	lookback := now.Add(-2 * time.Duration(s.AlertHours) * time.Hour)
+	rows, err := q.Query(ctx, `
+		SELECT a.id, a.ear_tag, a.zone_id,
+		       (SELECT MAX(g.recorded_at) FROM gps_tracks g
+		        WHERE g.animal_id = a.id AND g.recorded_at >= $3
+		          AND EXISTS (
+		              SELECT 1 FROM key_points k
+		              WHERE k.farm_id = $1 AND k.is_active AND k.category = ANY($4)
+		                AND ST_DWithin(k.location, g.location, k.radius_m::float8)))
+		FROM animals a
+		WHERE a.farm_id = $1 AND a.status = 'active' AND a.last_seen_at >= $2
+		  AND EXISTS (
+		      SELECT 1 FROM gps_tracks g
+		      WHERE g.animal_id = a.id AND g.recorded_at >= $3 AND g.recorded_at <= $2)`,
+		farmID, since, lookback, waterCategories)
+	if err != nil {
+		return err
+	}
+	type dry struct {
+		id      uuid.UUID
+		earTag  string
+		zoneID  *uuid.UUID
+		lastAt  *time.Time
+		fromRef time.Time
+	}
+	var list []dry
+	for rows.Next() {
+		var d dry
+		if err := rows.Scan(&d.id, &d.earTag, &d.zoneID, &d.lastAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if d.lastAt != nil && d.lastAt.After(since) {
+			continue
+		}
+		d.fromRef = lookback
+		if d.lastAt != nil {
+			d.fromRef = *d.lastAt
+		}
+		list = append(list, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range list {
+		msg := fmt.Sprintf("Animal %s sem visitar bebedouro há mais de %d horas", d.earTag, s.AlertHours)
+		if d.lastAt != nil {
+			msg = fmt.Sprintf("Animal %s sem visitar bebedouro há %d horas", d.earTag, int(now.Sub(*d.lastAt).Hours()))
+		}
+		if hottest != nil {
+			msg += fmt.Sprintf(" (máxima de %.1f °C)", *hottest)
+		}
+		tag, err := q.Exec(ctx, `
+			INSERT INTO alerts (id, farm_id, animal_id, zone_id, type, severity, message)
+			SELECT $1, $2, $3, $4, 'no_water', 'warning', $5
+			WHERE NOT EXISTS (
+				SELECT 1 FROM alerts
+				WHERE animal_id=$3 AND type='no_water' AND created_at >= $6
+			)`, uuid.New(), farmID, d.id, d.zoneID, msg, d.fromRef)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() > 0 && hub != nil {
+			hub.BroadcastAlert(farmID, "no_water", msg)
+		}
+	}
+	return nil
+}
+
+// RunWaterMonitor checks every farm with water points and recent GPS fixes
+// on each tick until ctx is canceled.
+func RunWaterMonitor(ctx context.Context, pool *pgxpool.Pool, hub *iot.Hub, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rows, err := pool.Query(ctx, `
+			SELECT DISTINCT k.farm_id FROM key_points k
+			WHERE k.is_active AND k.category = ANY($1)
+			  AND EXISTS (
+			      SELECT 1 FROM animals a
+			      WHERE a.farm_id = k.farm_id AND a.last_seen_at >= NOW() - INTERVAL '1 day')`,
+			waterCategories)
+		if err == nil {
+			farms := []uuid.UUID{}
+			for rows.Next() {
+				var id uuid.UUID
+				if rows.Scan(&id) == nil {
+					farms = append(farms, id)
+				}
+			}
+			rows.Close()
+			for _, id := range farms {
+				if err := CheckWater(ctx, pool, hub, id); err != nil {
+					slog.Error("water check failed", "farm_id", id, "err", err)
+				}
+			}
+		} else if ctx.Err() == nil {
+			slog.Error("water monitor query failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
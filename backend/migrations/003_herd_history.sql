@@ -0,0 +1,48 @@
+-- Migration 003: Herd membership and location history
+
+CREATE TABLE IF NOT EXISTS herd_memberships (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id    UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    herd_id    UUID NOT NULL REFERENCES herds(id) ON DELETE CASCADE,
+    animal_id  UUID NOT NULL REFERENCES animals(id) ON DELETE CASCADE,
+    joined_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    left_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_herd_memberships_herd ON herd_memberships(herd_id, joined_at DESC);
+-- An animal belongs to at most one herd at a time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_herd_memberships_open
+    ON herd_memberships(animal_id) WHERE left_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS herd_locations (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id      UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    herd_id      UUID NOT NULL REFERENCES herds(id) ON DELETE CASCADE,
+    zone_id      UUID REFERENCES zones(id) ON DELETE SET NULL,
+    started_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ended_at     TIMESTAMPTZ,
+    animal_count INT NOT NULL DEFAULT 0,
+    notes        TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_herd_locations_herd ON herd_locations(herd_id, started_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_herd_locations_open
+    ON herd_locations(herd_id) WHERE ended_at IS NULL;
+
+-- Backfill the current state so history starts from what is already recorded
+INSERT INTO herd_memberships (farm_id, herd_id, animal_id, joined_at)
+SELECT a.farm_id, a.herd_id, a.id, a.created_at
+FROM animals a
+WHERE a.herd_id IS NOT NULL AND a.status = 'active'
+  AND NOT EXISTS (
+      SELECT 1 FROM herd_memberships m WHERE m.animal_id = a.id AND m.left_at IS NULL
+  );
+
+INSERT INTO herd_locations (farm_id, herd_id, zone_id, started_at, animal_count)
+SELECT h.farm_id, h.id, h.zone_id, h.updated_at,
+       (SELECT COUNT(*) FROM animals a WHERE a.herd_id = h.id AND a.status = 'active')
+FROM herds h
+WHERE h.zone_id IS NOT NULL
+  AND NOT EXISTS (
+      SELECT 1 FROM herd_locations l WHERE l.herd_id = h.id AND l.ended_at IS NULL
+  );
@@ -0,0 +1,43 @@
+-- Migration 004: Farm work orders (tasks)
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id                  UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id             UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    title               TEXT NOT NULL,
+    description         TEXT,
+    kind                TEXT NOT NULL DEFAULT 'general', -- general | health | weighing | maintenance
+    status              TEXT NOT NULL DEFAULT 'open',    -- open | in_progress | done | canceled
+    assignee_id         UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_by          UUID REFERENCES users(id) ON DELETE SET NULL,
+    due_date            DATE,
+    recurrence          TEXT,                            -- daily | weekly | monthly | yearly
+    recurrence_interval INT NOT NULL DEFAULT 1,
+    recurrence_until    DATE,
+    previous_task_id    UUID REFERENCES tasks(id) ON DELETE SET NULL, -- prior occurrence
+    health_event_type   TEXT,                            -- event_type recorded when a health task is completed
+    completed_at        TIMESTAMPTZ,
+    completed_by        UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_tasks_farm_due ON tasks(farm_id, due_date);
+CREATE INDEX IF NOT EXISTS idx_tasks_assignee ON tasks(assignee_id, status);
+
+CREATE TABLE IF NOT EXISTS task_animals (
+    task_id   UUID NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    animal_id UUID NOT NULL REFERENCES animals(id) ON DELETE CASCADE,
+    PRIMARY KEY (task_id, animal_id)
+);
+
+CREATE TABLE IF NOT EXISTS task_herds (
+    task_id UUID NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    herd_id UUID NOT NULL REFERENCES herds(id) ON DELETE CASCADE,
+    PRIMARY KEY (task_id, herd_id)
+);
+
+CREATE TABLE IF NOT EXISTS task_zones (
+    task_id UUID NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    zone_id UUID NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    PRIMARY KEY (task_id, zone_id)
+);
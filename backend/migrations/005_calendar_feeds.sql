@@ -0,0 +1,10 @@
+-- Migration 005: Per-user secret iCalendar feed tokens
+
+CREATE TABLE IF NOT EXISTS calendar_feeds (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id    UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token      TEXT UNIQUE NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE(farm_id, user_id)
+);
@@ -0,0 +1,65 @@
+-- Migration 006: Sanitary protocol templates (vaccination, deworming, boosters)
+
+CREATE TABLE IF NOT EXISTS health_protocols (
+    id            UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id       UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    name          TEXT NOT NULL,                    -- e.g. "Aftosa", "Brucelose B19"
+    event_type    TEXT NOT NULL DEFAULT 'vaccine',  -- health_events.event_type of executions
+    product       TEXT,
+    dose          NUMERIC(10,2),
+    dose_unit     TEXT,                             -- ml | ml/kg | mg/kg | ...
+    target_sex    TEXT,                             -- male | female | NULL = both
+    min_age_days  INT,
+    max_age_days  INT,                              -- only checked for the first dose
+    booster_days  INT[] NOT NULL DEFAULT '{}',      -- days after the first dose
+    interval_days INT,                              -- repeat after the last dose; NULL = no repeat
+    starts_on     DATE NOT NULL DEFAULT CURRENT_DATE,
+    active        BOOLEAN NOT NULL DEFAULT TRUE,
+    notes         TEXT,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_protocols_farm ON health_protocols(farm_id);
+
+ALTER TABLE health_events
+    ADD COLUMN IF NOT EXISTS protocol_id UUID REFERENCES health_protocols(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_health_events_protocol ON health_events(protocol_id, animal_id);
+
+-- Next dose due per active protocol and eligible active animal:
+--   no dose yet       -> when the animal reaches min_age_days (not before starts_on)
+--   boosters pending  -> first dose + booster_days[n]
+--   series complete   -> last dose + interval_days, if the protocol repeats
+CREATE OR REPLACE VIEW protocol_due AS
+WITH doses AS (
+    SELECT he.protocol_id, he.animal_id,
+           COUNT(*)::int       AS doses,
+           MIN(he.started_at)  AS first_dose,
+           MAX(he.started_at)  AS last_dose
+    FROM health_events he
+    WHERE he.protocol_id IS NOT NULL AND he.animal_id IS NOT NULL
+    GROUP BY he.protocol_id, he.animal_id
+),
+candidates AS (
+    SELECT p.id AS protocol_id, p.farm_id, a.id AS animal_id,
+           COALESCE(d.doses, 0) AS doses, d.last_dose,
+           CASE
+             WHEN d.doses IS NULL THEN
+                  GREATEST(COALESCE(a.birth_date + p.min_age_days, p.starts_on), p.starts_on)
+             WHEN d.doses <= COALESCE(array_length(p.booster_days, 1), 0) THEN
+                  d.first_dose + p.booster_days[d.doses]
+             WHEN p.interval_days IS NOT NULL THEN
+                  d.last_dose + p.interval_days
+           END AS due_date
+    FROM health_protocols p
+    JOIN animals a ON a.farm_id = p.farm_id AND a.status = 'active'
+    LEFT JOIN doses d ON d.protocol_id = p.id AND d.animal_id = a.id
+    WHERE p.active
+      AND (p.target_sex IS NULL OR a.sex = p.target_sex)
+      AND (d.doses IS NOT NULL OR p.max_age_days IS NULL OR a.birth_date IS NULL
+           OR a.birth_date + p.max_age_days >= CURRENT_DATE)
+)
+SELECT protocol_id, farm_id, animal_id, doses + 1 AS dose_number, last_dose, due_date
+FROM candidates
+WHERE due_date IS NOT NULL;
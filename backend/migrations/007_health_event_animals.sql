@@ -0,0 +1,67 @@
+-- Migration 007: Animals treated by each health event
+
+ALTER TABLE health_events
+    ADD COLUMN IF NOT EXISTS zone_id UUID REFERENCES zones(id) ON DELETE SET NULL;
+
+-- One row per animal covered by an event. Individual events have a single
+-- row; herd and zone treatments record the animals actually treated.
+CREATE TABLE IF NOT EXISTS health_event_animals (
+    event_id  UUID NOT NULL REFERENCES health_events(id) ON DELETE CASCADE,
+    animal_id UUID NOT NULL REFERENCES animals(id) ON DELETE CASCADE,
+    PRIMARY KEY (event_id, animal_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_event_animals_animal ON health_event_animals(animal_id);
+
+-- Backfill individual events
+INSERT INTO health_event_animals (event_id, animal_id)
+SELECT he.id, he.animal_id
+FROM health_events he
+WHERE he.animal_id IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+-- Backfill herd events from the membership history on the event date
+INSERT INTO health_event_animals (event_id, animal_id)
+SELECT he.id, m.animal_id
+FROM health_events he
+JOIN herd_memberships m ON m.herd_id = he.herd_id
+ AND m.joined_at::date <= he.started_at
+ AND (m.left_at IS NULL OR m.left_at::date >= he.started_at)
+WHERE he.animal_id IS NULL AND he.herd_id IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM health_event_animals x WHERE x.event_id = he.id)
+ON CONFLICT DO NOTHING;
+
+-- Protocol doses count every treated animal, including group treatments
+CREATE OR REPLACE VIEW protocol_due AS
+WITH doses AS (
+    SELECT he.protocol_id, hea.animal_id,
+           COUNT(*)::int       AS doses,
+           MIN(he.started_at)  AS first_dose,
+           MAX(he.started_at)  AS last_dose
+    FROM health_events he
+    JOIN health_event_animals hea ON hea.event_id = he.id
+    WHERE he.protocol_id IS NOT NULL
+    GROUP BY he.protocol_id, hea.animal_id
+),
+candidates AS (
+    SELECT p.id AS protocol_id, p.farm_id, a.id AS animal_id,
+           COALESCE(d.doses, 0) AS doses, d.last_dose,
+           CASE
+             WHEN d.doses IS NULL THEN
+                  GREATEST(COALESCE(a.birth_date + p.min_age_days, p.starts_on), p.starts_on)
+             WHEN d.doses <= COALESCE(array_length(p.booster_days, 1), 0) THEN
+                  d.first_dose + p.booster_days[d.doses]
+             WHEN p.interval_days IS NOT NULL THEN
+                  d.last_dose + p.interval_days
+           END AS due_date
+    FROM health_protocols p
+    JOIN animals a ON a.farm_id = p.farm_id AND a.status = 'active'
+    LEFT JOIN doses d ON d.protocol_id = p.id AND d.animal_id = a.id
+    WHERE p.active
+      AND (p.target_sex IS NULL OR a.sex = p.target_sex)
+      AND (d.doses IS NOT NULL OR p.max_age_days IS NULL OR a.birth_date IS NULL
+           OR a.birth_date + p.max_age_days >= CURRENT_DATE)
+)
+SELECT protocol_id, farm_id, animal_id, doses + 1 AS dose_number, last_dose, due_date
+FROM candidates
+WHERE due_date IS NOT NULL;
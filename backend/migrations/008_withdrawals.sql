@@ -0,0 +1,37 @@
+-- Migration 008: Health products and withdrawal periods (carência)
+
+CREATE TABLE IF NOT EXISTS health_products (
+    id                   UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id              UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    name                 TEXT NOT NULL,
+    kind                 TEXT NOT NULL DEFAULT 'other', -- vaccine | antibiotic | antiparasitic | anti_inflammatory | other
+    active_ingredient    TEXT,
+    unit                 TEXT,                          -- ml | g | dose ...
+    meat_withdrawal_days INT NOT NULL DEFAULT 0,
+    milk_withdrawal_days INT NOT NULL DEFAULT 0,
+    notes                TEXT,
+    created_at           TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at           TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE(farm_id, name)
+);
+
+ALTER TABLE health_events
+    ADD COLUMN IF NOT EXISTS product_id UUID REFERENCES health_products(id) ON DELETE SET NULL;
+ALTER TABLE health_protocols
+    ADD COLUMN IF NOT EXISTS product_id UUID REFERENCES health_products(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_health_events_product ON health_events(product_id);
+
+-- One row per treated animal and event with a withdrawal period. Clear dates
+-- are the first day meat or milk may be sold; the period runs from the last
+-- day of treatment.
+CREATE OR REPLACE VIEW animal_withdrawals AS
+SELECT hea.animal_id, he.farm_id, he.id AS event_id, he.name AS event_name,
+       p.id AS product_id, p.name AS product_name,
+       COALESCE(he.ended_at, he.started_at) AS last_treatment,
+       COALESCE(he.ended_at, he.started_at) + p.meat_withdrawal_days AS meat_clear_date,
+       COALESCE(he.ended_at, he.started_at) + p.milk_withdrawal_days AS milk_clear_date
+FROM health_events he
+JOIN health_event_animals hea ON hea.event_id = he.id
+JOIN health_products p ON p.id = he.product_id AND p.farm_id = he.farm_id
+WHERE p.meat_withdrawal_days > 0 OR p.milk_withdrawal_days > 0;
@@ -0,0 +1,52 @@
+-- Migration 009: Veterinary pharmacy inventory (lots and stock movements)
+
+ALTER TABLE health_products
+    ADD COLUMN IF NOT EXISTS min_stock         NUMERIC(12,3),         -- low stock alert threshold
+    ADD COLUMN IF NOT EXISTS expiry_alert_days INT NOT NULL DEFAULT 30;
+
+CREATE TABLE IF NOT EXISTS product_lots (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id            UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    product_id         UUID NOT NULL REFERENCES health_products(id) ON DELETE CASCADE,
+    lot_number         TEXT,
+    expires_on         DATE,
+    quantity_initial   NUMERIC(12,3) NOT NULL,
+    quantity_remaining NUMERIC(12,3) NOT NULL,
+    unit_cost_cents    NUMERIC(12,2) NOT NULL DEFAULT 0,
+    currency           TEXT NOT NULL DEFAULT 'BRL',
+    supplier           TEXT,
+    received_at        DATE NOT NULL DEFAULT CURRENT_DATE,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (quantity_remaining >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_lots_product ON product_lots(product_id, expires_on);
+
+-- Ledger of every stock change; the sum per lot equals its remaining quantity
+CREATE TABLE IF NOT EXISTS stock_movements (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id         UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    product_id      UUID NOT NULL REFERENCES health_products(id) ON DELETE CASCADE,
+    lot_id          UUID NOT NULL REFERENCES product_lots(id) ON DELETE CASCADE,
+    kind            TEXT NOT NULL,          -- purchase | consumption | adjustment | discard
+    quantity        NUMERIC(12,3) NOT NULL, -- positive in, negative out
+    unit_cost_cents NUMERIC(12,2) NOT NULL DEFAULT 0,
+    health_event_id UUID REFERENCES health_events(id) ON DELETE CASCADE,
+    notes           TEXT,
+    created_by      UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_movements_product ON stock_movements(product_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_stock_movements_event ON stock_movements(health_event_id);
+
+-- Quantity of product used by a health event, in the product's unit
+ALTER TABLE health_events
+    ADD COLUMN IF NOT EXISTS product_quantity NUMERIC(12,3);
+
+-- Product and lot a stock alert refers to, so one unread alert is kept per
+-- product (low_stock) or lot (product_expiring) whatever its message says
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS product_id UUID REFERENCES health_products(id) ON DELETE CASCADE;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS lot_id UUID REFERENCES product_lots(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_alerts_product ON alerts(product_id, type) WHERE product_id IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Migration 010: Label concentration and dose of health products
+
+ALTER TABLE health_products
+    ADD COLUMN IF NOT EXISTS concentration_mg_ml NUMERIC(10,3), -- active ingredient per ml
+    ADD COLUMN IF NOT EXISTS dose_mg_kg          NUMERIC(10,3); -- label dose
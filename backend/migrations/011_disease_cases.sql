@@ -0,0 +1,38 @@
+-- Migration 011: Disease cases, affected animals and quarantine
+
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS is_isolation BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS disease_cases (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id           UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    disease           TEXT NOT NULL,
+    diagnosis         TEXT,
+    symptoms          TEXT[] NOT NULL DEFAULT '{}',
+    diagnosed_at      DATE NOT NULL DEFAULT CURRENT_DATE,
+    contagious        BOOLEAN NOT NULL DEFAULT FALSE,
+    isolation_zone_id UUID REFERENCES zones(id) ON DELETE SET NULL,
+    treatment_plan    TEXT,
+    status            TEXT NOT NULL DEFAULT 'open', -- open | closed
+    closed_at         DATE,
+    notes             TEXT,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_disease_cases_farm ON disease_cases(farm_id, diagnosed_at);
+
+-- Animals affected by a case. previous_zone_id is where a quarantined
+-- animal returns when released.
+CREATE TABLE IF NOT EXISTS disease_case_animals (
+    case_id          UUID NOT NULL REFERENCES disease_cases(id) ON DELETE CASCADE,
+    animal_id        UUID NOT NULL REFERENCES animals(id) ON DELETE CASCADE,
+    added_at         DATE NOT NULL DEFAULT CURRENT_DATE,
+    outcome          TEXT,          -- recovered | died | culled
+    outcome_at       DATE,
+    previous_zone_id UUID REFERENCES zones(id) ON DELETE SET NULL,
+    quarantined_at   TIMESTAMPTZ,
+    released_at      TIMESTAMPTZ,
+    PRIMARY KEY (case_id, animal_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_disease_case_animals_animal ON disease_case_animals(animal_id);
@@ -0,0 +1,11 @@
+-- Migration 012: Server-side zone area and geometry issues
+
+-- Siting problems (outside the perimeter, overlaps) accepted with force=true
+ALTER TABLE zones ADD COLUMN IF NOT EXISTS geometry_issues JSONB NOT NULL DEFAULT '[]';
+
+-- Areas used to be sent by the client; recompute them from the geography
+UPDATE zones SET area_ha = ROUND((ST_Area(geometry) / 10000)::numeric, 2)
+WHERE area_ha IS DISTINCT FROM ROUND((ST_Area(geometry) / 10000)::numeric, 2);
+
+UPDATE perimeters SET area_ha = ROUND((ST_Area(geometry) / 10000)::numeric, 2)
+WHERE area_ha IS DISTINCT FROM ROUND((ST_Area(geometry) / 10000)::numeric, 2);
@@ -0,0 +1,19 @@
+-- Migration 013: Zone occupancy history
+
+CREATE INDEX IF NOT EXISTS idx_zone_usages_zone
+    ON zone_usages(zone_id, started_at DESC);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_zone_usages_open
+    ON zone_usages(zone_id) WHERE ended_at IS NULL;
+
+-- Backfill an open period for zones that already hold animals, starting at
+-- the latest move into the zone
+INSERT INTO zone_usages (zone_id, started_at, animal_count, ugm_ha)
+SELECT z.id, MAX(a.updated_at), COUNT(a.id)::int,
+       CASE WHEN z.area_ha > 0 THEN LEAST(ROUND(COUNT(a.id) / z.area_ha, 2), 9999.99) END
+FROM zones z
+JOIN animals a ON a.zone_id = z.id AND a.status = 'active'
+WHERE NOT EXISTS (
+    SELECT 1 FROM zone_usages u WHERE u.zone_id = z.id AND u.ended_at IS NULL
+)
+GROUP BY z.id;
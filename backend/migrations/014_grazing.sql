@@ -0,0 +1,20 @@
+-- Migration 014: Rotational grazing planner
+
+-- Farm overrides of the built-in rest and occupation periods per grass type.
+-- grass_type is stored normalised (lower case, no accents).
+CREATE TABLE IF NOT EXISTS grazing_parameters (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id         UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    grass_type      TEXT NOT NULL,
+    rest_days       INT NOT NULL CHECK (rest_days > 0),
+    occupation_days INT NOT NULL CHECK (occupation_days > 0),
+    notes           TEXT,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (farm_id, grass_type)
+);
+
+-- Zone the alert refers to (grazing, stocking and forage alerts)
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS zone_id UUID REFERENCES zones(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_alerts_zone ON alerts(zone_id, type) WHERE zone_id IS NOT NULL;
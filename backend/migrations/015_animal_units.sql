@@ -0,0 +1,44 @@
+-- Migration 015: Stocking in animal units (UA)
+
+CREATE INDEX IF NOT EXISTS idx_weight_records_animal
+    ON weight_records(animal_id, recorded_at DESC);
+
+-- One row per active animal with its animal units: the latest weight within
+-- 180 days divided by 450 kg (1 UA), or a default for its age and sex when
+-- it has not been weighed recently.
+CREATE OR REPLACE VIEW animal_units AS
+SELECT a.id AS animal_id, a.farm_id, a.zone_id, a.herd_id,
+       c.category,
+       w.weight_kg::float8 AS weight_kg,
+       w.recorded_at AS weighed_on,
+       CASE WHEN w.weight_kg IS NOT NULL THEN 'weight' ELSE 'category' END AS source,
+       COALESCE(w.weight_kg::float8 / 450,
+                CASE c.category
+                    WHEN 'calf'       THEN 0.25
+                    WHEN 'yearling'   THEN 0.50
+                    WHEN 'young'      THEN 0.75
+                    WHEN 'bull'       THEN 1.25
+                    ELSE 1.0
+                END) AS ua
+FROM animals a
+CROSS JOIN LATERAL (
+    SELECT CASE
+        WHEN a.birth_date IS NULL THEN CASE WHEN a.sex = 'male' THEN 'adult_male' ELSE 'cow' END
+        WHEN a.birth_date > CURRENT_DATE - INTERVAL '12 months' THEN 'calf'
+        WHEN a.birth_date > CURRENT_DATE - INTERVAL '24 months' THEN 'yearling'
+        WHEN a.birth_date > CURRENT_DATE - INTERVAL '36 months' THEN 'young'
+        WHEN a.sex = 'male' THEN 'bull'
+        ELSE 'cow'
+    END AS category
+) c
+LEFT JOIN LATERAL (
+    SELECT wr.weight_kg, wr.recorded_at
+    FROM weight_records wr
+    WHERE wr.animal_id = a.id AND wr.recorded_at >= CURRENT_DATE - 180
+    ORDER BY wr.recorded_at DESC, wr.created_at DESC
+    LIMIT 1
+) w ON TRUE
+WHERE a.status = 'active';
+
+-- Animal units in the zone during each usage period; ugm_ha is now UA/ha
+ALTER TABLE zone_usages ADD COLUMN IF NOT EXISTS animal_units NUMERIC(8,2);
@@ -0,0 +1,6 @@
+-- Migration 016: Zone capacity enforcement
+
+-- What happens when a move takes a zone over its ugm_ha_limit:
+-- off (allowed silently) | warn (allowed and reported) | reject (409 unless overridden)
+ALTER TABLE farms ADD COLUMN IF NOT EXISTS overstock_policy TEXT NOT NULL DEFAULT 'warn'
+    CHECK (overstock_policy IN ('off', 'warn', 'reject'));
@@ -0,0 +1,23 @@
+-- Migration 017: Pasture forage assessments
+
+-- A forage measurement of a zone: sward height, estimated dry matter,
+-- ground cover and a 1-5 quality score, with photo URLs.
+CREATE TABLE IF NOT EXISTS forage_assessments (
+    id               UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id          UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    zone_id          UUID NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    assessed_on      DATE NOT NULL DEFAULT CURRENT_DATE,
+    height_cm        NUMERIC(6,1) CHECK (height_cm >= 0),
+    dry_matter_kg_ha NUMERIC(8,1) CHECK (dry_matter_kg_ha >= 0),
+    cover_pct        NUMERIC(5,1) CHECK (cover_pct BETWEEN 0 AND 100),
+    quality_score    SMALLINT CHECK (quality_score BETWEEN 1 AND 5),
+    photos           TEXT[] NOT NULL DEFAULT '{}',
+    notes            TEXT,
+    created_by       UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_forage_assessments_zone
+    ON forage_assessments(zone_id, assessed_on DESC);
+CREATE INDEX IF NOT EXISTS idx_forage_assessments_farm
+    ON forage_assessments(farm_id, assessed_on DESC);
@@ -0,0 +1,20 @@
+-- Migration 018: Zone split and merge
+
+-- Zones produced by a split or merge and the retired zones they replaced.
+-- Retired zones are kept inactive so their history stays queryable.
+CREATE TABLE IF NOT EXISTS zone_lineage (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    farm_id        UUID NOT NULL REFERENCES farms(id) ON DELETE CASCADE,
+    operation      TEXT NOT NULL CHECK (operation IN ('split', 'merge')),
+    parent_zone_id UUID NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    child_zone_id  UUID NOT NULL REFERENCES zones(id) ON DELETE CASCADE,
+    created_by     UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (parent_zone_id, child_zone_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_zone_lineage_child ON zone_lineage(child_zone_id);
+
+-- Usage periods copied to a new zone from the zone it was split from or
+-- merged out of, so its occupation and rest history carries over
+ALTER TABLE zone_usages ADD COLUMN IF NOT EXISTS inherited_from UUID REFERENCES zones(id) ON DELETE SET NULL;
@@ -0,0 +1,34 @@
+-- Migration 019: Key point categories and water-point visits
+
+-- category: water | trough | salt | feeder | shade | gate | corral | barn | vet | other
+-- water and trough are water points. icon stays a free display choice.
+-- Key points created before categories used the icon as one; the backfill
+-- runs only when the column is added, so a later choice of other is kept.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'key_points' AND column_name = 'category'
+    ) THEN
+        ALTER TABLE key_points ADD COLUMN category TEXT NOT NULL DEFAULT 'other';
+        UPDATE key_points SET category = icon
+        WHERE icon IN ('water', 'trough', 'salt', 'feeder', 'shade', 'gate', 'corral', 'barn', 'vet');
+    END IF;
+END $$;
+
+ALTER TABLE key_points ADD COLUMN IF NOT EXISTS radius_m NUMERIC(6,1) NOT NULL DEFAULT 30 CHECK (radius_m > 0);
+ALTER TABLE key_points ADD COLUMN IF NOT EXISTS notes TEXT;
+ALTER TABLE key_points ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idx_key_points_location ON key_points USING GIST(location);
+
+-- Ambient temperature reported by collars with a thermometer
+ALTER TABLE gps_tracks ADD COLUMN IF NOT EXISTS temperature_c NUMERIC(4,1);
+
+CREATE INDEX IF NOT EXISTS idx_gps_tracks_farm_time ON gps_tracks(farm_id, recorded_at DESC);
+
+-- no_water alerts: raised when a tracked animal has not visited a water
+-- point for water_alert_hours while the hottest collar reading in that time
+-- reached water_alert_min_temp_c (NULL alerts in any weather)
+ALTER TABLE farms ADD COLUMN IF NOT EXISTS water_alert_hours INT NOT NULL DEFAULT 12 CHECK (water_alert_hours > 0);
+ALTER TABLE farms ADD COLUMN IF NOT EXISTS water_alert_min_temp_c NUMERIC(4,1) DEFAULT 30;